@@ -23,6 +23,7 @@ type Client interface {
 	v1.GroupServiceClient
 	v1.RoleServiceClient
 	v1.ApplicationServiceClient
+	v1.EmailTemplateServiceClient
 	io.Closer
 }
 
@@ -43,6 +44,7 @@ type client struct {
 	v1.GroupServiceClient
 	v1.RoleServiceClient
 	v1.ApplicationServiceClient
+	v1.EmailTemplateServiceClient
 }
 
 func NewClient(port string) (Client, error) {
@@ -67,6 +69,7 @@ func NewClient(port string) (Client, error) {
 		GroupServiceClient:         v1.NewGroupServiceClient(conn),
 		RoleServiceClient:          v1.NewRoleServiceClient(conn),
 		ApplicationServiceClient:   v1.NewApplicationServiceClient(conn),
+		EmailTemplateServiceClient: v1.NewEmailTemplateServiceClient(conn),
 	}, nil
 }
 
@@ -28,6 +28,8 @@ func init() {
 	userCommand.AddCommand(logoutUserCommand())
 	userCommand.AddCommand(forgotPasswordCommand())
 	userCommand.AddCommand(resetPasswordCommand())
+	userCommand.AddCommand(verifyEmailCommand())
+	userCommand.AddCommand(resendVerificationCommand())
 	userCommand.AddCommand(changePasswordCommand())
 	userCommand.AddCommand(revokeUserSessionsCommand())
 	userCommand.AddCommand(listUserCommand())
@@ -35,6 +37,10 @@ func init() {
 	userCommand.AddCommand(deleteUserCommand())
 	userCommand.AddCommand(enableUserCommand())
 	userCommand.AddCommand(disableUserCommand())
+	userCommand.AddCommand(getUserLockoutCommand())
+	userCommand.AddCommand(unlockUserCommand())
+	userCommand.AddCommand(importUserCommand())
+	userCommand.AddCommand(exportUserCommand())
 	userCommand.AddCommand(listUserSessionsCommand())
 	userCommand.AddCommand(listActiveSessionsCommand())
 
@@ -435,6 +441,7 @@ func loginUserCommand() *cobra.Command {
 	var clientID string
 	var email string
 	var password string
+	var code string
 
 	command := &cobra.Command{
 		Use:   "login",
@@ -472,6 +479,25 @@ func loginUserCommand() *cobra.Command {
 				return
 			}
 
+			if res.MfaToken != "" {
+				if res.MfaEnrollmentRequired {
+					logrus.Errorf("the pool requires mfa, enroll a totp factor with the mfa token: %v", res.MfaToken)
+					return
+				}
+				if code == "" {
+					logrus.Errorf("missing required flag: --code")
+					return
+				}
+				res, err = client.VerifyMfa(context.Background(), &v1.VerifyMfaRequest{
+					MfaToken: res.MfaToken,
+					Code:     code,
+				})
+				if err != nil {
+					logrus.Errorf("failed to verify mfa code: %v", err)
+					return
+				}
+			}
+
 			cmd.Printf("Name: %v\n", res.Account.VisibleName)
 			cmd.Printf("Email: %v\n", res.Account.Email)
 			cmd.Printf("AccessToken: %v\n", res.Token.AccessToken)
@@ -483,6 +509,7 @@ func loginUserCommand() *cobra.Command {
 	command.Flags().StringVarP(&clientID, "client-id", "c", "", "client id")
 	command.Flags().StringVarP(&email, "email", "e", "", "email")
 	command.Flags().StringVarP(&password, "password", "p", "", "password")
+	command.Flags().StringVar(&code, "code", "", "totp code of accounts with mfa")
 
 	return command
 }
@@ -558,6 +585,87 @@ func forgotPasswordCommand() *cobra.Command {
 		},
 	}
 
+	command.Flags().StringVarP(&clientID, "client-id", "c", "", "client id")
+	command.Flags().StringVarP(&email, "email", "e", "", "email")
+
+	return command
+}
+
+func verifyEmailCommand() *cobra.Command {
+	var code string
+
+	command := &cobra.Command{
+		Use:   "verify",
+		Short: "verify the email with the emailed code",
+		Run: func(cmd *cobra.Command, args []string) {
+			if code == "" {
+				logrus.Errorf("missing required flag: --code")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			_, err = client.VerifyEmail(context.Background(), &v1.VerifyEmailRequest{
+				Code: code,
+			})
+			if err != nil {
+				logrus.Errorf("failed to verify email: %v", err)
+				return
+			}
+
+			logrus.Infof("email verified successfully")
+		},
+	}
+
+	command.Flags().StringVarP(&code, "code", "c", "", "verification code")
+
+	return command
+}
+
+func resendVerificationCommand() *cobra.Command {
+	var clientID string
+	var email string
+
+	command := &cobra.Command{
+		Use:   "resend-verification",
+		Short: "resend the email verification code",
+		Run: func(cmd *cobra.Command, args []string) {
+			if clientID == "" {
+				logrus.Errorf("missing required flag: --client-id")
+				return
+			}
+
+			if email == "" {
+				logrus.Errorf("missing required flag: --email")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			_, err = client.ResendVerificationEmail(context.Background(), &v1.ResendVerificationEmailRequest{
+				ClientId: clientID,
+				Email:    email,
+			})
+			if err != nil {
+				logrus.Errorf("failed to resend verification email: %v", err)
+				return
+			}
+
+			logrus.Infof("verification email sent successfully")
+		},
+	}
+
+	command.Flags().StringVarP(&clientID, "client-id", "c", "", "client id")
 	command.Flags().StringVarP(&email, "email", "e", "", "email")
 
 	return command
@@ -655,6 +763,8 @@ func changePasswordCommand() *cobra.Command {
 
 func revokeUserSessionsCommand() *cobra.Command {
 	var userID string
+	var sessionIDs []string
+	var keepCurrent bool
 
 	command := &cobra.Command{
 		Use:   "revoke",
@@ -667,8 +777,8 @@ func revokeUserSessionsCommand() *cobra.Command {
 				return
 			}
 
-			if userID == "" {
-				logrus.Errorf("missing required flag: --user-id")
+			if userID == "" && len(sessionIDs) == 0 {
+				logrus.Errorf("missing required flag: --user-id or --session-id")
 				return
 			}
 
@@ -679,21 +789,37 @@ func revokeUserSessionsCommand() *cobra.Command {
 			}
 			defer client.Close()
 
-			_, err = client.DeleteAllSessions(tokenContext(), &v1.DeleteAllSessionsRequest{
-				AccountId: userID,
-			})
-			if err != nil {
-				logrus.Errorf("failed to revoke user sessions: %v", err)
-				return
+			var count uint64
+			if len(sessionIDs) > 0 {
+				res, err := client.DeleteSessions(tokenContext(), &v1.DeleteSessionsRequest{
+					SessionIds: sessionIDs,
+				})
+				if err != nil {
+					logrus.Errorf("failed to revoke user sessions: %v", err)
+					return
+				}
+				count = res.SessionCount
+			} else {
+				res, err := client.DeleteAllSessions(tokenContext(), &v1.DeleteAllSessionsRequest{
+					AccountId:   userID,
+					KeepCurrent: keepCurrent,
+				})
+				if err != nil {
+					logrus.Errorf("failed to revoke user sessions: %v", err)
+					return
+				}
+				count = res.SessionCount
 			}
 
-			logrus.Infof("user sessions revoked successfully")
+			logrus.Infof("%d user sessions revoked successfully", count)
 		},
 	}
 
 	bindContextFlags(command)
 
-	command.Flags().StringVarP(&userID, "user-id", "u", "", "user id")
+	command.Flags().StringVarP(&userID, "user-id", "u", "", "user id, - for the logged in account")
+	command.Flags().StringSliceVarP(&sessionIDs, "session-id", "s", nil, "revoke only the given sessions")
+	command.Flags().BoolVar(&keepCurrent, "keep-current", false, "keep the session of the current token")
 
 	return command
 }
@@ -723,12 +849,39 @@ func listUserSessionsCommand() *cobra.Command {
 				return
 			}
 			defer client.Close()
+
+			res, err := client.ListAccountSession(tokenContext(), &v1.ListAccountSessionRequest{
+				AccountId: userID,
+			})
+			if err != nil {
+				logrus.Errorf("failed to list user sessions: %v", err)
+				return
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"#", "Session ID", "Client ID", "IP Address", "User Agent", "Created At", "Last Active At", "Current"})
+			for i, session := range res.Sessions {
+				table.Append([]string{
+					strconv.Itoa(i + 1),
+					session.Id,
+					session.ClientId,
+					session.IpAddress,
+					session.UserAgent,
+					session.CreatedAt.AsTime().Format("2006-01-02 15:04:05"),
+					session.LastActiveAt.AsTime().Format("2006-01-02 15:04:05"),
+					strconv.FormatBool(session.Current),
+				})
+			}
+
+			table.Render()
+
+			fmt.Printf("Sessions: page: %v, showing: %v, total: %v\n", res.Meta.Page, len(res.Sessions), res.Meta.Total)
 		},
 	}
 
 	bindContextFlags(command)
 
-	command.Flags().StringVarP(&userID, "user-id", "u", "", "user id")
+	command.Flags().StringVarP(&userID, "user-id", "u", "", "user id, - for the logged in account")
 
 	return command
 }
@@ -875,6 +1028,105 @@ func disableUserCommand() *cobra.Command {
 	return command
 }
 
+func getUserLockoutCommand() *cobra.Command {
+	var userID string
+
+	command := &cobra.Command{
+		Use:   "lockout",
+		Short: "show the failed login attempts of the user",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if Token == "" {
+				logrus.Errorf("missing required flags: --token")
+				return
+			}
+
+			if userID == "" {
+				logrus.Errorf("missing required flag: --user-id")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			res, err := client.GetAccountLockout(tokenContext(), &v1.GetAccountLockoutRequest{
+				AccountId: userID,
+			})
+			if err != nil {
+				logrus.Errorf("failed to get lockout: %v", err)
+				return
+			}
+
+			lockedUntil, retryAfter := "", ""
+			if res.Lockout.LockedUntil != nil {
+				lockedUntil = res.Lockout.LockedUntil.AsTime().Format("2006-01-02 15:04:05")
+			}
+			if res.Lockout.RetryAfter != nil {
+				retryAfter = res.Lockout.RetryAfter.AsTime().Format("2006-01-02 15:04:05")
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Failed Attempts", "Locked", "Locked Until", "Retry After"})
+			table.Append([]string{strconv.Itoa(int(res.Lockout.FailedAttempts)), strconv.FormatBool(res.Lockout.Locked), lockedUntil, retryAfter})
+			table.Render()
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&userID, "user-id", "u", "", "user id")
+
+	return command
+}
+
+func unlockUserCommand() *cobra.Command {
+	var userID string
+
+	command := &cobra.Command{
+		Use:   "unlock",
+		Short: "unlock a user locked out by failed logins",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if Token == "" {
+				logrus.Errorf("missing required flags: --token")
+				return
+			}
+
+			if userID == "" {
+				logrus.Errorf("missing required flag: --user-id")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			_, err = client.UnlockAccount(tokenContext(), &v1.UnlockAccountRequest{
+				AccountId: userID,
+			})
+			if err != nil {
+				logrus.Errorf("failed to unlock user: %v", err)
+				return
+			}
+
+			logrus.Infof("user unlocked successfully")
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&userID, "user-id", "u", "", "user id")
+
+	return command
+}
+
 func getUserPermissionsCommand() *cobra.Command {
 	var accountID string
 
@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emrgen/authbase"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/x"
+	"github.com/olekukonko/tablewriter"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// accountColumns are the csv columns of the import and export files, the groups are separated by ;
+var accountColumns = []string{"email", "username", "visible_name", "verified", "disabled", "password_hash", "groups"}
+
+// accountRow is a row of the import and export files, the jsonl lines use the same keys.
+// The password salt is only read for the firebase scrypt hashes, they keep the salt apart from the hash.
+type accountRow struct {
+	Email        string   `json:"email"`
+	Username     string   `json:"username,omitempty"`
+	VisibleName  string   `json:"visible_name,omitempty"`
+	Verified     bool     `json:"verified,omitempty"`
+	Disabled     bool     `json:"disabled,omitempty"`
+	PasswordHash string   `json:"password_hash,omitempty"`
+	PasswordSalt string   `json:"password_salt,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+}
+
+// firebaseHashConfig are the password hash parameters of a firebase project
+type firebaseHashConfig struct {
+	signerKey     string
+	saltSeparator string
+	rounds        int
+	memCost       int
+}
+
+func importUserCommand() *cobra.Command {
+	var poolID string
+	var file string
+	var format string
+	var hashFormat string
+	var firebase firebaseHashConfig
+
+	command := &cobra.Command{
+		Use:   "import",
+		Short: "import accounts with their password hashes from a csv or jsonl file",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if poolID == "" {
+				logrus.Errorf("missing required flag: --pool-id")
+				return
+			}
+
+			if file == "" {
+				logrus.Errorf("missing required flag: --file")
+				return
+			}
+
+			format, err := accountFileFormat(file, format)
+			if err != nil {
+				logrus.Errorf("%v", err)
+				return
+			}
+
+			switch hashFormat {
+			case "":
+			case "firebase-scrypt":
+				if firebase.signerKey == "" || firebase.saltSeparator == "" {
+					logrus.Errorf("missing required flags: --firebase-signer-key and --firebase-salt-separator")
+					return
+				}
+			default:
+				logrus.Errorf("unsupported hash format: %s", hashFormat)
+				return
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				logrus.Errorf("failed to open file: %v", err)
+				return
+			}
+			defer f.Close()
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			stream, err := client.ImportAccounts(tokenContext())
+			if err != nil {
+				logrus.Errorf("failed to import accounts: %v", err)
+				return
+			}
+
+			err = readAccountRows(f, format, func(row int, account *accountRow) error {
+				record := account.record()
+				if hashFormat == "firebase-scrypt" && record.PasswordHash != "" {
+					record.PasswordHash = x.FirebaseScryptHash(record.PasswordHash, account.PasswordSalt,
+						firebase.saltSeparator, firebase.signerKey, firebase.rounds, firebase.memCost)
+				}
+
+				request := &v1.ImportAccountsRequest{Account: record, Row: int64(row)}
+				if row == 1 {
+					request.PoolId = poolID
+				}
+				return stream.Send(request)
+			})
+			if err != nil {
+				logrus.Errorf("failed to import accounts: %v", err)
+				return
+			}
+
+			res, err := stream.CloseAndRecv()
+			if err != nil {
+				logrus.Errorf("failed to import accounts: %v", err)
+				return
+			}
+
+			if len(res.Errors) > 0 {
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetHeader([]string{"Row", "Email", "Error"})
+				for _, e := range res.Errors {
+					table.Append([]string{strconv.FormatInt(e.Row, 10), e.Email, e.Message})
+				}
+				table.Render()
+			}
+
+			fmt.Printf("Accounts: created: %v, failed: %v\n", res.Created, res.Failed)
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&poolID, "pool-id", "p", "", "pool id")
+	command.Flags().StringVarP(&file, "file", "f", "", "csv or jsonl file")
+	command.Flags().StringVar(&format, "format", "", "file format, csv or jsonl (default from the file extension)")
+	command.Flags().StringVar(&hashFormat, "hash-format", "", "password hash format of the file, firebase-scrypt for firebase auth exports")
+	command.Flags().StringVar(&firebase.signerKey, "firebase-signer-key", "", "base64 signer key of the firebase project")
+	command.Flags().StringVar(&firebase.saltSeparator, "firebase-salt-separator", "", "base64 salt separator of the firebase project")
+	command.Flags().IntVar(&firebase.rounds, "firebase-rounds", 8, "scrypt rounds of the firebase project")
+	command.Flags().IntVar(&firebase.memCost, "firebase-mem-cost", 14, "scrypt memory cost of the firebase project")
+
+	return command
+}
+
+func exportUserCommand() *cobra.Command {
+	var poolID string
+	var file string
+	var format string
+
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "export the accounts of a pool with their password hashes to a csv or jsonl file",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if poolID == "" {
+				logrus.Errorf("missing required flag: --pool-id")
+				return
+			}
+
+			// without a file the accounts are written to the stdout
+			out := os.Stdout
+			if file != "" {
+				var err error
+				format, err = accountFileFormat(file, format)
+				if err != nil {
+					logrus.Errorf("%v", err)
+					return
+				}
+
+				out, err = os.Create(file)
+				if err != nil {
+					logrus.Errorf("failed to create file: %v", err)
+					return
+				}
+				defer out.Close()
+			} else if format == "" {
+				format = "csv"
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			stream, err := client.ExportAccounts(tokenContext(), &v1.ExportAccountsRequest{PoolId: poolID})
+			if err != nil {
+				logrus.Errorf("failed to export accounts: %v", err)
+				return
+			}
+
+			writer := newAccountWriter(out, format)
+			count := 0
+			for {
+				res, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					logrus.Errorf("failed to export accounts: %v", err)
+					return
+				}
+
+				if err := writer.Write(res.Account); err != nil {
+					logrus.Errorf("failed to write account: %v", err)
+					return
+				}
+				count++
+			}
+
+			if err := writer.Flush(); err != nil {
+				logrus.Errorf("failed to write accounts: %v", err)
+				return
+			}
+
+			if file != "" {
+				fmt.Printf("Accounts: exported: %v\n", count)
+			}
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&poolID, "pool-id", "p", "", "pool id")
+	command.Flags().StringVarP(&file, "file", "f", "", "csv or jsonl file (default stdout)")
+	command.Flags().StringVar(&format, "format", "", "file format, csv or jsonl (default from the file extension)")
+
+	return command
+}
+
+// accountFileFormat returns the format of the file, from the flag or the file extension
+func accountFileFormat(file, format string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		}
+	}
+
+	switch format {
+	case "csv", "jsonl":
+		return format, nil
+	case "":
+		return "", fmt.Errorf("unknown format of %s, use --format csv or jsonl", file)
+	default:
+		return "", fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// readAccountRows reads the rows of the file and calls fn with each row and its number starting from 1
+func readAccountRows(r io.Reader, format string, fn func(row int, account *accountRow) error) error {
+	if format == "jsonl" {
+		decoder := json.NewDecoder(r)
+		for row := 1; ; row++ {
+			account := &accountRow{}
+			err := decoder.Decode(account)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("row %d: %w", row, err)
+			}
+			if err := fn(row, account); err != nil {
+				return err
+			}
+		}
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return errors.New("missing csv column: email")
+	}
+
+	for row := 1; ; row++ {
+		values, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		value := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(values) {
+				return strings.TrimSpace(values[i])
+			}
+			return ""
+		}
+		account := &accountRow{
+			Email:        value("email"),
+			Username:     value("username"),
+			VisibleName:  value("visible_name"),
+			PasswordHash: value("password_hash"),
+			PasswordSalt: value("password_salt"),
+		}
+		for _, flag := range []struct {
+			name  string
+			value *bool
+		}{{"verified", &account.Verified}, {"disabled", &account.Disabled}} {
+			if v := value(flag.name); v != "" {
+				if *flag.value, err = strconv.ParseBool(v); err != nil {
+					return fmt.Errorf("row %d: invalid %s: %s", row, flag.name, v)
+				}
+			}
+		}
+		if groups := value("groups"); groups != "" {
+			account.Groups = strings.Split(groups, ";")
+		}
+
+		if err := fn(row, account); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *accountRow) record() *v1.AccountRecord {
+	return &v1.AccountRecord{
+		Email:        a.Email,
+		Username:     a.Username,
+		VisibleName:  a.VisibleName,
+		Verified:     a.Verified,
+		Disabled:     a.Disabled,
+		PasswordHash: a.PasswordHash,
+		Groups:       a.Groups,
+	}
+}
+
+// accountWriter writes the exported accounts in the import format
+type accountWriter struct {
+	csv    *csv.Writer
+	json   *json.Encoder
+	header bool
+}
+
+func newAccountWriter(w io.Writer, format string) *accountWriter {
+	if format == "jsonl" {
+		return &accountWriter{json: json.NewEncoder(w)}
+	}
+
+	return &accountWriter{csv: csv.NewWriter(w)}
+}
+
+func (w *accountWriter) Write(record *v1.AccountRecord) error {
+	if w.json != nil {
+		return w.json.Encode(&accountRow{
+			Email:        record.Email,
+			Username:     record.Username,
+			VisibleName:  record.VisibleName,
+			Verified:     record.Verified,
+			Disabled:     record.Disabled,
+			PasswordHash: record.PasswordHash,
+			Groups:       record.Groups,
+		})
+	}
+
+	if !w.header {
+		w.header = true
+		if err := w.csv.Write(accountColumns); err != nil {
+			return err
+		}
+	}
+
+	return w.csv.Write([]string{
+		record.Email,
+		record.Username,
+		record.VisibleName,
+		strconv.FormatBool(record.Verified),
+		strconv.FormatBool(record.Disabled),
+		record.PasswordHash,
+		strings.Join(record.Groups, ";"),
+	})
+}
+
+func (w *accountWriter) Flush() error {
+	if w.json != nil {
+		return nil
+	}
+
+	// an empty export still has the header
+	if !w.header {
+		w.header = true
+		if err := w.csv.Write(accountColumns); err != nil {
+			return err
+		}
+	}
+	w.csv.Flush()
+
+	return w.csv.Error()
+}
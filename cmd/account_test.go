@@ -1 +1,55 @@
 package cmd
+
+import (
+	"bytes"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// TestAccountRows function to test that the exported accounts read back the same in both formats
+func TestAccountRows(t *testing.T) {
+	records := []*v1.AccountRecord{
+		{Email: "alice@example.com", Username: "alice", Verified: true, PasswordHash: "$2a$10$hash", Groups: []string{"admin", "dev"}},
+		{Email: "bob@example.com", Disabled: true},
+	}
+
+	for _, format := range []string{"csv", "jsonl"} {
+		var buf bytes.Buffer
+		writer := newAccountWriter(&buf, format)
+		for _, record := range records {
+			assert.NoError(t, writer.Write(record))
+		}
+		assert.NoError(t, writer.Flush())
+
+		var read []*v1.AccountRecord
+		err := readAccountRows(&buf, format, func(row int, account *accountRow) error {
+			assert.Equal(t, len(read)+1, row)
+			read = append(read, account.record())
+			return nil
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, read, 2, format) {
+			assert.Equal(t, records[0].Groups, read[0].Groups, format)
+			assert.Equal(t, records[0].PasswordHash, read[0].PasswordHash, format)
+			assert.True(t, read[0].Verified, format)
+			assert.True(t, read[1].Disabled, format)
+		}
+	}
+
+	// the csv columns are matched by name, the missing ones are empty
+	csv := "password_hash,email,password_salt\nabc,carol@example.com,c2FsdA==\n"
+	err := readAccountRows(strings.NewReader(csv), "csv", func(row int, account *accountRow) error {
+		assert.Equal(t, "carol@example.com", account.Email)
+		assert.Equal(t, "c2FsdA==", account.PasswordSalt)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	format, err := accountFileFormat("users.ndjson", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "jsonl", format)
+	_, err = accountFileFormat("users.txt", "")
+	assert.Error(t, err)
+}
@@ -23,6 +23,10 @@ func init() {
 func clientCreateCmd() *cobra.Command {
 	var poolID string
 	var name string
+	var redirectURIs []string
+	var grantTypes []string
+	var scopes []string
+	var confidential bool
 
 	command := &cobra.Command{
 		Use:   "create",
@@ -45,8 +49,12 @@ func clientCreateCmd() *cobra.Command {
 			}
 
 			res, err := client.CreateClient(tokenContext(), &v1.CreateClientRequest{
-				PoolId: poolID,
-				Name:   name,
+				PoolId:       poolID,
+				Name:         name,
+				RedirectUris: redirectURIs,
+				GrantTypes:   grantTypes,
+				Scopes:       scopes,
+				Confidential: confidential,
 			})
 			if err != nil {
 				logrus.Error(err)
@@ -58,11 +66,19 @@ func clientCreateCmd() *cobra.Command {
 			table.Append([]string{res.Client.PoolId, res.Client.Id, res.Client.Name, res.Client.CreatedByUser.VisibleName})
 			table.Render()
 
+			if res.ClientSecret != nil {
+				cmd.Printf("Client secret: %s\n", res.GetClientSecret())
+				cmd.Println("Store the client secret now, it can not be shown again.")
+			}
 		},
 	}
 
 	command.Flags().StringVarP(&poolID, "pool-id", "p", "", "Pool ID")
 	command.Flags().StringVarP(&name, "name", "n", "", "Name")
+	command.Flags().StringSliceVarP(&redirectURIs, "redirect-uri", "r", nil, "Allowed OAuth2 redirect URIs")
+	command.Flags().StringSliceVarP(&grantTypes, "grant-type", "g", nil, "Allowed OAuth2 grant types")
+	command.Flags().StringSliceVarP(&scopes, "scope", "s", nil, "Scopes the client may request with the client credentials grant")
+	command.Flags().BoolVar(&confidential, "confidential", false, "Create a confidential client with a client secret")
 
 	return command
 }
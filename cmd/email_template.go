@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/emrgen/authbase"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/olekukonko/tablewriter"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var templateCommand = &cobra.Command{
+	Use:   "template",
+	Short: "email template commands",
+}
+
+func init() {
+	templateCommand.AddCommand(listTemplateCommand())
+	templateCommand.AddCommand(getTemplateCommand())
+	templateCommand.AddCommand(uploadTemplateCommand())
+	templateCommand.AddCommand(previewTemplateCommand())
+	templateCommand.AddCommand(resetTemplateCommand())
+}
+
+// templateFiles are the files of a template, the subject, the html and the text of the kind in a directory
+type templateFiles struct {
+	subject string
+	html    string
+	text    string
+}
+
+func (f *templateFiles) bind(command *cobra.Command) {
+	command.Flags().StringVar(&f.subject, "subject", "", "subject template")
+	command.Flags().StringVar(&f.html, "html", "", "html template file")
+	command.Flags().StringVar(&f.text, "text", "", "text template file")
+}
+
+// read returns the subject and the contents of the html and the text files, the files not given are empty
+func (f *templateFiles) read() (string, string, string, error) {
+	var contents [2]string
+	for i, file := range []string{f.html, f.text} {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", "", err
+		}
+		contents[i] = string(data)
+	}
+
+	return f.subject, contents[0], contents[1], nil
+}
+
+func listTemplateCommand() *cobra.Command {
+	var projectID string
+
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "list the uploaded email templates of a project",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if projectID == "" {
+				logrus.Errorf("missing required flag: --project-id")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			res, err := client.ListEmailTemplates(tokenContext(), &v1.ListEmailTemplatesRequest{ProjectId: projectID})
+			if err != nil {
+				logrus.Errorf("failed to list email templates: %v", err)
+				return
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Kind", "Locale", "Subject", "HTML", "Text", "Updated At"})
+			for _, template := range res.Templates {
+				table.Append([]string{
+					template.Kind,
+					template.Locale,
+					template.Subject,
+					strconv.FormatBool(template.Html != ""),
+					strconv.FormatBool(template.Text != ""),
+					template.UpdatedAt.AsTime().Format("2006-01-02 15:04:05"),
+				})
+			}
+			table.Render()
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&projectID, "project-id", "r", "", "project id")
+
+	return command
+}
+
+func getTemplateCommand() *cobra.Command {
+	var projectID string
+	var kind string
+	var locale string
+	var dir string
+
+	command := &cobra.Command{
+		Use:   "get",
+		Short: "get the email template a project uses in a locale, the default template when none is uploaded",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if projectID == "" || kind == "" {
+				logrus.Errorf("missing required flags: --project-id and --kind")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			res, err := client.GetEmailTemplate(tokenContext(), &v1.GetEmailTemplateRequest{
+				ProjectId: projectID,
+				Kind:      kind,
+				Locale:    locale,
+			})
+			if err != nil {
+				logrus.Errorf("failed to get email template: %v", err)
+				return
+			}
+			template := res.Template
+
+			// the files of the directory are the input of the upload command
+			if dir != "" {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					logrus.Errorf("failed to create directory: %v", err)
+					return
+				}
+				for ext, content := range map[string]string{".subject": template.Subject, ".html": template.Html, ".txt": template.Text} {
+					if content == "" {
+						continue
+					}
+					if err := os.WriteFile(filepath.Join(dir, template.Kind+ext), []byte(content), 0o644); err != nil {
+						logrus.Errorf("failed to write template: %v", err)
+						return
+					}
+				}
+			}
+
+			fmt.Printf("Kind: %s\nLocale: %s\nCustom: %v\nSubject: %s\n", template.Kind, template.Locale, template.Custom, template.Subject)
+			if dir == "" {
+				fmt.Printf("\n%s\n%s\n", template.Html, template.Text)
+			}
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&projectID, "project-id", "r", "", "project id")
+	command.Flags().StringVarP(&kind, "kind", "k", "", "template kind, verification, reset, magic-link or invitation")
+	command.Flags().StringVarP(&locale, "locale", "l", "en", "template locale")
+	command.Flags().StringVarP(&dir, "dir", "d", "", "directory to write the subject, html and text files to")
+
+	return command
+}
+
+func uploadTemplateCommand() *cobra.Command {
+	var projectID string
+	var kind string
+	var locale string
+	var files templateFiles
+
+	command := &cobra.Command{
+		Use:   "upload",
+		Short: "upload the email template of a project in a locale",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if projectID == "" || kind == "" || locale == "" {
+				logrus.Errorf("missing required flags: --project-id, --kind and --locale")
+				return
+			}
+
+			if files.html == "" && files.text == "" {
+				logrus.Errorf("missing required flags: --html or --text")
+				return
+			}
+
+			subject, html, text, err := files.read()
+			if err != nil {
+				logrus.Errorf("failed to read template: %v", err)
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			res, err := client.UploadEmailTemplate(tokenContext(), &v1.UploadEmailTemplateRequest{
+				ProjectId: projectID,
+				Kind:      kind,
+				Locale:    locale,
+				Subject:   subject,
+				Html:      html,
+				Text:      text,
+			})
+			if err != nil {
+				logrus.Errorf("failed to upload email template: %v", err)
+				return
+			}
+
+			fmt.Printf("Email template uploaded: %s %s\n", res.Template.Kind, res.Template.Locale)
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&projectID, "project-id", "r", "", "project id")
+	command.Flags().StringVarP(&kind, "kind", "k", "", "template kind, verification, reset, magic-link or invitation")
+	command.Flags().StringVarP(&locale, "locale", "l", "", "template locale, like en or pt-BR")
+	files.bind(command)
+
+	return command
+}
+
+func previewTemplateCommand() *cobra.Command {
+	var projectID string
+	var kind string
+	var locale string
+	var files templateFiles
+	var out string
+
+	command := &cobra.Command{
+		Use:   "preview",
+		Short: "render an email template with sample data, the template in use without the template flags",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if projectID == "" || kind == "" {
+				logrus.Errorf("missing required flags: --project-id and --kind")
+				return
+			}
+
+			request := &v1.PreviewEmailTemplateRequest{ProjectId: projectID, Kind: kind, Locale: locale}
+			if files.subject != "" || files.html != "" || files.text != "" {
+				subject, html, text, err := files.read()
+				if err != nil {
+					logrus.Errorf("failed to read template: %v", err)
+					return
+				}
+				request.Subject = &subject
+				request.Html = &html
+				request.Text = &text
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			res, err := client.PreviewEmailTemplate(tokenContext(), request)
+			if err != nil {
+				logrus.Errorf("failed to preview email template: %v", err)
+				return
+			}
+
+			// the html is written to a file to open it in a browser
+			if out != "" {
+				if err := os.WriteFile(out, []byte(res.Html), 0o644); err != nil {
+					logrus.Errorf("failed to write preview: %v", err)
+					return
+				}
+			}
+
+			fmt.Printf("Locale: %s\nSubject: %s\n\n%s\n", res.Locale, res.Subject, res.Text)
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&projectID, "project-id", "r", "", "project id")
+	command.Flags().StringVarP(&kind, "kind", "k", "", "template kind, verification, reset, magic-link or invitation")
+	command.Flags().StringVarP(&locale, "locale", "l", "en", "template locale")
+	command.Flags().StringVarP(&out, "out", "o", "", "file to write the rendered html to")
+	files.bind(command)
+
+	return command
+}
+
+func resetTemplateCommand() *cobra.Command {
+	var projectID string
+	var kind string
+	var locale string
+
+	command := &cobra.Command{
+		Use:   "reset",
+		Short: "delete the uploaded email templates of a kind, the project goes back to the default templates",
+		Run: func(cmd *cobra.Command, args []string) {
+			loadToken()
+
+			if projectID == "" || kind == "" {
+				logrus.Errorf("missing required flags: --project-id and --kind")
+				return
+			}
+
+			client, err := authbase.NewClient(":4000")
+			if err != nil {
+				logrus.Errorf("failed to create client: %v", err)
+				return
+			}
+			defer client.Close()
+
+			res, err := client.ResetEmailTemplate(tokenContext(), &v1.ResetEmailTemplateRequest{
+				ProjectId: projectID,
+				Kind:      kind,
+				Locale:    locale,
+			})
+			if err != nil {
+				logrus.Errorf("failed to reset email template: %v", err)
+				return
+			}
+
+			fmt.Println(res.Message)
+		},
+	}
+
+	bindContextFlags(command)
+	command.Flags().StringVarP(&projectID, "project-id", "r", "", "project id")
+	command.Flags().StringVarP(&kind, "kind", "k", "", "template kind, verification, reset, magic-link or invitation")
+	command.Flags().StringVarP(&locale, "locale", "l", "", "template locale, all the locales of the kind when empty")
+
+	return command
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
+	"time"
 )
 
 var poolCommand = &cobra.Command{
@@ -153,6 +154,14 @@ func listPoolCommand() *cobra.Command {
 func updatePoolCommand() *cobra.Command {
 	var poolName string
 	var poolID string
+	var mfaRequired bool
+	var webauthnRPID string
+	var webauthnOrigins []string
+	var tokenPolicy *v1.TokenPolicy
+	var maxSessions int32
+	var evictOldest bool
+	var maxAttempts, ipMaxAttempts int32
+	var lockoutDuration, backoffDelay time.Duration
 
 	command := &cobra.Command{
 		Use:   "update",
@@ -163,8 +172,9 @@ func updatePoolCommand() *cobra.Command {
 				return
 			}
 
-			if poolName == "" {
-				logrus.Error("missing required flags: --name")
+			if poolName == "" && !cmd.Flags().Changed("mfa-required") && !cmd.Flags().Changed("webauthn-rp-id") && len(webauthnOrigins) == 0 && tokenPolicy == nil &&
+				!cmd.Flags().Changed("max-sessions") && !cmd.Flags().Changed("evict-oldest-session") && !lockoutPolicyChanged(cmd) {
+				logrus.Error("missing required flags: --name, --mfa-required, --webauthn-rp-id, --webauthn-origin, --max-sessions, --evict-oldest-session, a token policy or a lockout policy flag")
 				return
 			}
 
@@ -175,10 +185,37 @@ func updatePoolCommand() *cobra.Command {
 			}
 			defer client.Close()
 
-			res, err := client.UpdatePool(tokenContext(), &v1.UpdatePoolRequest{
-				PoolId: poolID,
-				Name:   poolName,
-			})
+			request := &v1.UpdatePoolRequest{
+				PoolId:          poolID,
+				Name:            poolName,
+				WebauthnOrigins: webauthnOrigins,
+				TokenPolicy:     tokenPolicy,
+			}
+			if cmd.Flags().Changed("mfa-required") {
+				request.MfaRequired = &mfaRequired
+			}
+			if cmd.Flags().Changed("webauthn-rp-id") {
+				request.WebauthnRpId = &webauthnRPID
+			}
+			if cmd.Flags().Changed("max-sessions") {
+				request.MaxSessions = &maxSessions
+			}
+			if cmd.Flags().Changed("evict-oldest-session") {
+				strategy := v1.SessionLimitStrategy_SESSION_LIMIT_STRATEGY_REJECT
+				if evictOldest {
+					strategy = v1.SessionLimitStrategy_SESSION_LIMIT_STRATEGY_EVICT_OLDEST
+				}
+				request.SessionLimitStrategy = &strategy
+			}
+			if lockoutPolicyChanged(cmd) {
+				request.LockoutPolicy = &v1.LockoutPolicy{
+					MaxAttempts:     maxAttempts,
+					IpMaxAttempts:   ipMaxAttempts,
+					LockoutDuration: int64(lockoutDuration / time.Second),
+					BackoffDelay:    int64(backoffDelay / time.Second),
+				}
+			}
+			res, err := client.UpdatePool(tokenContext(), request)
 			if err != nil {
 				logrus.Errorf("error updating pool: %v", err)
 				return
@@ -193,6 +230,16 @@ func updatePoolCommand() *cobra.Command {
 
 	command.Flags().StringVarP(&poolID, "pool-id", "p", "", "id of the pool")
 	command.Flags().StringVarP(&poolName, "name", "n", "", "name of the pool")
+	command.Flags().BoolVar(&mfaRequired, "mfa-required", false, "require a second factor on password login")
+	command.Flags().StringVar(&webauthnRPID, "webauthn-rp-id", "", "passkey relying party id, defaults to the public url host")
+	command.Flags().StringSliceVar(&webauthnOrigins, "webauthn-origin", nil, "allowed passkey origins, defaults to the public url")
+	command.Flags().Int32Var(&maxSessions, "max-sessions", 0, "concurrent sessions allowed per account, 0 is unlimited")
+	command.Flags().BoolVar(&evictOldest, "evict-oldest-session", false, "end the oldest session on a login over the limit instead of rejecting the login")
+	command.Flags().Int32Var(&maxAttempts, "max-failed-attempts", 0, "failed password attempts locking an account out")
+	command.Flags().Int32Var(&ipMaxAttempts, "ip-max-failed-attempts", 0, "failed password attempts from an address blocking the address")
+	command.Flags().DurationVar(&lockoutDuration, "lockout-duration", 0, "how long a locked account waits")
+	command.Flags().DurationVar(&backoffDelay, "backoff-delay", 0, "wait after the first failed attempt, doubled with every failure")
+	tokenPolicyFlags(command, &tokenPolicy)
 
 	return command
 
@@ -233,3 +280,39 @@ func deletePoolCommand() *cobra.Command {
 
 	return command
 }
+
+// lockoutPolicyChanged reports whether any of the lockout policy flags is given
+func lockoutPolicyChanged(cmd *cobra.Command) bool {
+	for _, name := range []string{"max-failed-attempts", "ip-max-failed-attempts", "lockout-duration", "backoff-delay"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenPolicyFlags adds the token lifetime flags to the command, the policy is set before the run when any of them is given
+func tokenPolicyFlags(command *cobra.Command, policy **v1.TokenPolicy) {
+	var accessTokenTTL, refreshTokenTTL, sessionLifetime, sessionIdleTimeout, accessKeyMaxTTL time.Duration
+	command.Flags().DurationVar(&accessTokenTTL, "access-token-ttl", 0, "lifetime of the access tokens")
+	command.Flags().DurationVar(&refreshTokenTTL, "refresh-token-ttl", 0, "lifetime of the refresh tokens")
+	command.Flags().DurationVar(&sessionLifetime, "session-lifetime", 0, "absolute lifetime of the login sessions")
+	command.Flags().DurationVar(&sessionIdleTimeout, "session-idle-timeout", 0, "sessions not refreshed for longer end")
+	command.Flags().DurationVar(&accessKeyMaxTTL, "access-key-max-ttl", 0, "longest lifetime of the access keys")
+
+	command.PreRun = func(cmd *cobra.Command, args []string) {
+		for _, name := range []string{"access-token-ttl", "refresh-token-ttl", "session-lifetime", "session-idle-timeout", "access-key-max-ttl"} {
+			if cmd.Flags().Changed(name) {
+				*policy = &v1.TokenPolicy{
+					AccessTokenTtl:     int64(accessTokenTTL / time.Second),
+					RefreshTokenTtl:    int64(refreshTokenTTL / time.Second),
+					SessionLifetime:    int64(sessionLifetime / time.Second),
+					SessionIdleTimeout: int64(sessionIdleTimeout / time.Second),
+					AccessKeyMaxTtl:    int64(accessKeyMaxTTL / time.Second),
+				}
+				return
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"strings"
 )
 
 var idpCommand = &cobra.Command{
@@ -25,6 +26,11 @@ func idpCreateCommand() *cobra.Command {
 	var name string
 	var clientID string
 	var clientSecret string
+	var scopes string
+	var issuer string
+	var emailClaim string
+	var nameClaim string
+	var groupsClaim string
 
 	command := &cobra.Command{
 		Use:   "create",
@@ -41,6 +47,17 @@ func idpCreateCommand() *cobra.Command {
 				return
 			}
 
+			idp, ok := v1.Idp_value["IDP_"+strings.ToUpper(name)]
+			if !ok {
+				logrus.Infof("unknown idp: %v", name)
+				return
+			}
+
+			if idp == int32(v1.Idp_IDP_OIDC) && issuer == "" {
+				logrus.Infof("missing required flag: --issuer")
+				return
+			}
+
 			client, err := authbase.NewClient(":4000")
 			if err != nil {
 				logrus.Errorf("failed to create client: %v", err)
@@ -55,10 +72,15 @@ func idpCreateCommand() *cobra.Command {
 			_, err = client.AddOauthProvider(tokenContext(), &v1.AddOauthProviderRequest{
 				PoolId: poolID,
 				Provider: &v1.OAuthProvider{
-					Provider:     v1.Idp_IDP_GOOGLE,
+					Provider:     v1.Idp(idp),
 					ClientId:     clientID,
 					ClientSecret: clientSecret,
 					RedirectUris: nil,
+					Scopes:       scopes,
+					Issuer:       issuer,
+					EmailClaim:   emailClaim,
+					NameClaim:    nameClaim,
+					GroupsClaim:  groupsClaim,
 				},
 			})
 			if err != nil {
@@ -70,7 +92,15 @@ func idpCreateCommand() *cobra.Command {
 		},
 	}
 
-	command.Flags().StringVarP(&name, "name", "n", "", "name")
+	command.Flags().StringVarP(&name, "name", "n", "", "idp name (google, github, oidc)")
+	command.Flags().StringVarP(&poolID, "pool-id", "p", "", "pool id")
+	command.Flags().StringVarP(&clientID, "client-id", "c", "", "client id at the idp")
+	command.Flags().StringVarP(&clientSecret, "client-secret", "s", "", "client secret at the idp")
+	command.Flags().StringVar(&scopes, "scopes", "", "space separated scopes requested from the idp")
+	command.Flags().StringVar(&issuer, "issuer", "", "oidc issuer url")
+	command.Flags().StringVar(&emailClaim, "email-claim", "", "oidc claim of the account email")
+	command.Flags().StringVar(&nameClaim, "name-claim", "", "oidc claim of the account name")
+	command.Flags().StringVar(&groupsClaim, "groups-claim", "", "oidc claim of the account groups")
 
 	return command
 }
@@ -93,9 +123,9 @@ func idpListCommand() *cobra.Command {
 			}
 
 			table := tablewriter.NewWriter(cmd.OutOrStdout())
-			table.SetHeader([]string{"ID", "Provider", "Pool ID"})
+			table.SetHeader([]string{"ID", "Provider", "Pool ID", "Issuer"})
 			for _, idp := range res.Providers {
-				table.Append([]string{idp.Id, idp.Provider.String(), idp.PoolId})
+				table.Append([]string{idp.Id, idp.Provider.String(), idp.PoolId, idp.Issuer})
 			}
 			table.Render()
 		},
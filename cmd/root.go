@@ -40,6 +40,7 @@ func init() {
 	rootCmd.AddCommand(tokenCommand)
 	rootCmd.AddCommand(idpCommand)
 	rootCmd.AddCommand(applicationCommand)
+	rootCmd.AddCommand(templateCommand)
 
 	ctx := readContext()
 	if ctx.Token != "" {
@@ -19,6 +19,7 @@ func init() {
 
 func refreshTokenCommand() *cobra.Command {
 	var refreshToken string
+	var clientSecret string
 
 	command := &cobra.Command{
 		Use:   "refresh",
@@ -38,6 +39,7 @@ func refreshTokenCommand() *cobra.Command {
 
 			res, err := client.Refresh(context.TODO(), &v1.RefreshRequest{
 				RefreshToken: refreshToken,
+				ClientSecret: clientSecret,
 			})
 			if err != nil {
 				logrus.Error(err)
@@ -51,6 +53,7 @@ func refreshTokenCommand() *cobra.Command {
 	}
 
 	command.Flags().StringVarP(&refreshToken, "refresh-token", "r", "", "refresh token")
+	command.Flags().StringVarP(&clientSecret, "client-secret", "s", "", "secret of the confidential client the token was issued to")
 
 	return command
 }
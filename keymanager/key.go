@@ -2,21 +2,31 @@ package keymanager
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"errors"
 	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
 	"github.com/emrgen/authbase/pkg/store"
 	"github.com/emrgen/authbase/x"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"sync"
 	"time"
 )
 
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+)
+
+// reloadInterval is the least time between two loads of a pool keyring for unknown key ids,
+// the tokens with made up key ids do not query the store on every request
+const reloadInterval = 5 * time.Second
+
 // PublicKey struct to store public key with expiration time
 type PublicKey struct {
-	key      *rsa.PrivateKey
-	ExpireAt time.Time
+	key       crypto.PublicKey
+	Algorithm string
+	ExpireAt  time.Time
 }
 
 // PublicRegistry struct to store public key and manage it
@@ -47,22 +57,24 @@ func (r *PublicRegistry) GetSignKey(id string) (*PublicKey, error) {
 	if err != nil {
 		return nil, err
 	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(res.Public.Key))
+	key, err := x.DecodeVerifyKey([]byte(res.Public.Key))
 	if err != nil {
 		return nil, err
 	}
 	r.keys[id] = &PublicKey{
-		key:      key,
-		ExpireAt: res.ExpireAt.AsTime(),
+		key:       key,
+		Algorithm: res.Public.Algorithm,
+		ExpireAt:  res.ExpireAt.AsTime(),
 	}
 
 	return r.keys[id], nil
 }
 
-func (r *PublicRegistry) AddKey(id string, key *rsa.PrivateKey) {
+func (r *PublicRegistry) AddKey(id, algorithm string, key crypto.PublicKey) {
 	r.keys[id] = &PublicKey{
-		key:      key,
-		ExpireAt: time.Now().Add(time.Hour),
+		key:       key,
+		Algorithm: algorithm,
+		ExpireAt:  time.Now().Add(time.Hour),
 	}
 }
 
@@ -108,7 +120,7 @@ func (r *PublicRegistry) refresh(id string, public *PublicKey) {
 		return
 	}
 
-	publicKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(res.Public.Key))
+	publicKey, err := x.DecodeVerifyKey([]byte(res.Public.Key))
 	if err != nil {
 		logrus.Errorf("Failed to parse public key: %v", err)
 		// schedule exponential backoff
@@ -116,126 +128,338 @@ func (r *PublicRegistry) refresh(id string, public *PublicKey) {
 	}
 
 	r.keys[id] = &PublicKey{
-		key:      publicKey,
-		ExpireAt: res.ExpireAt.AsTime(),
+		key:       publicKey,
+		Algorithm: res.Public.Algorithm,
+		ExpireAt:  res.ExpireAt.AsTime(),
 	}
 }
 
+// KeyPair is a signing key of a pool keyring
 type KeyPair struct {
-	private  *rsa.PrivateKey
-	public   *rsa.PublicKey
-	ExpireAt time.Time
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+	public    crypto.PublicKey
+	ExpireAt  time.Time // the key stops signing new tokens
+	RetireAt  time.Time // the key stops verifying tokens
 }
 
-// PrivateRegistry struct to store private key pair and manage it
-// When the key pair is expired, it will be removed and generate new key pair
+// PrivateRegistry struct to store the pool keyrings and manage them
+// Every pool has a keyring persisted in the store. The newest unexpired key signs new tokens,
+// older keys keep verifying the tokens they signed until they retire.
 type PrivateRegistry struct {
-	keys  map[string]*KeyPair
-	mu    sync.Mutex
-	store store.Provider // save key pair to the store
+	keys      map[string][]*KeyPair // pool id to keys, newest first
+	loadedAt  map[string]time.Time  // pool id to the last load of its keyring
+	mu        sync.RWMutex
+	store     store.Provider // save key pair to the store
+	algorithm string
+	rotation  time.Duration // how long a key signs new tokens
+	retention time.Duration // how long a key verifies tokens after it stops signing
 }
 
+var _ x.JWTSignerVerifierProvider = (*PrivateRegistry)(nil)
+
 // NewPrivateRegistry function to create a new private key registry
-func NewPrivateRegistry(store store.Provider) *PrivateRegistry {
+func NewPrivateRegistry(store store.Provider, algorithm string, rotation time.Duration) *PrivateRegistry {
 	return &PrivateRegistry{
-		keys:  make(map[string]*KeyPair),
-		mu:    sync.Mutex{},
-		store: store,
+		keys:      make(map[string][]*KeyPair),
+		loadedAt:  make(map[string]time.Time),
+		mu:        sync.RWMutex{},
+		store:     store,
+		algorithm: algorithm,
+		rotation:  rotation,
+		// the longest living token signed by a key is the refresh token, the token policies can extend it up to the max
+		retention: x.MaxRefreshTokenDuration,
 	}
 }
 
-// GetSignKey function to get private key for signing
-func (r *PrivateRegistry) GetSignKey(id string) (*rsa.PrivateKey, error) {
-	pair, err := r.GetKey(id)
+// GetSigner returns a signer using the current signing key of the pool
+func (r *PrivateRegistry) GetSigner(poolID string) (x.JWTSigner, error) {
+	key, err := r.GetSignKey(poolID)
 	if err != nil {
-		return nil, errors.New("failed to get private pair")
+		return nil, err
 	}
 
-	return pair.private, nil
+	return x.NewKeySigner(key.ID, key.Algorithm, key.private)
 }
 
-// GetKey function to get key pair
-func (r *PrivateRegistry) GetKey(id string) (*KeyPair, error) {
-	key, ok := r.keys[id]
-	if ok {
+// GetVerifier returns a verifier that checks tokens against the unretired keys of the pool
+func (r *PrivateRegistry) GetVerifier(poolID string) (x.JWTVerifier, error) {
+	return x.NewKeySetVerifier(func(kid string) (crypto.PublicKey, string, error) {
+		key, err := r.GetKey(poolID, kid)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return key.public, key.Algorithm, nil
+	}), nil
+}
+
+// PublicKeys function to get the unretired keys of the pool, including keys generated by other server instances
+func (r *PrivateRegistry) PublicKeys(poolID string) ([]*KeyPair, error) {
+	return r.load(poolID)
+}
+
+// PublicKey returns the verification key of the key pair
+func (k *KeyPair) PublicKey() crypto.PublicKey {
+	return k.public
+}
+
+// GetSignKey function to get the current signing key of the pool, a new key is generated when none is usable
+func (r *PrivateRegistry) GetSignKey(poolID string) (*KeyPair, error) {
+	keys, err := r.keyring(poolID)
+	if err != nil {
+		return nil, err
+	}
+	if key := signKey(keys); key != nil {
+		return key, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// another request may have rotated the keyring while waiting for the lock
+	if key := signKey(r.keys[poolID]); key != nil {
 		return key, nil
 	}
 
-	err := r.GenerateKeyPair(id)
+	return r.rotate(poolID)
+}
+
+// GetKey function to get an unretired key of the pool by its id
+func (r *PrivateRegistry) GetKey(poolID, kid string) (*KeyPair, error) {
+	keys, err := r.keyring(poolID)
 	if err != nil {
 		return nil, err
 	}
+	if key := findKey(keys, kid); key != nil {
+		return key, nil
+	}
 
-	return r.keys[id], nil
-}
+	// the key may have been generated by another server instance since the keyring was loaded,
+	// an empty keyring is not cached so it was just read from the store
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if _, err := uuid.Parse(kid); err != nil || !r.claimReload(poolID) {
+		return nil, ErrKeyNotFound
+	}
+	keys, err = r.load(poolID)
+	if err != nil {
+		return nil, err
+	}
+	if key := findKey(keys, kid); key != nil {
+		return key, nil
+	}
 
-// AddKey function to add key pair to the registry
-func (r *PrivateRegistry) AddKey(id string, key *KeyPair) {
-	r.keys[id] = key
+	return nil, ErrKeyNotFound
 }
 
-// RemoveKey function to remove key pair from the registry
-func (r *PrivateRegistry) RemoveKey(id string) {
-	delete(r.keys, id)
+// RemoveKey function to remove a pool keyring from the registry, it is reloaded from the store on next use
+func (r *PrivateRegistry) RemoveKey(poolID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, poolID)
+	delete(r.loadedAt, poolID)
 }
 
-// Reset function to reset the registry, removing all key pairs
+// Reset function to reset the registry, removing all keyrings
 func (r *PrivateRegistry) Reset() {
-	r.keys = make(map[string]*KeyPair)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = make(map[string][]*KeyPair)
+	r.loadedAt = make(map[string]time.Time)
 }
 
-// Size function to get the size of the registry
+// Size function to get the number of keyrings in the registry
 func (r *PrivateRegistry) Size() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return len(r.keys)
 }
 
-// GenerateKeyPair function to generate a new key pair and add it to the registry
-func (r *PrivateRegistry) GenerateKeyPair(id string) error {
-	private, public, err := x.GenerateKeyPair(2048)
-	if err != nil {
-		return err
-	}
-
-	r.AddKey(id, &KeyPair{
-		private: private,
-		public:  public,
-	})
-
-	return nil
-}
-
-// Run function to run the registry, checking for expired keys and generating new ones
+// Run function to run the registry, rotating expired signing keys and dropping retired keys
 func (r *PrivateRegistry) Run() {
 	ticker := time.NewTicker(time.Minute)
 	for {
 		select {
 		case <-ticker.C:
-			for id, key := range r.keys {
-				if key.ExpireAt.Before(time.Now()) {
-					delete(r.keys, id)
-					err := r.GenerateKeyPair(id)
-					if err != nil {
-						continue
-					}
-				}
+			r.refresh()
+		}
+	}
+}
+
+// refresh rotates the keyrings without a usable signing key and removes the retired keys
+func (r *PrivateRegistry) refresh() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for poolID, keys := range r.keys {
+		active := make([]*KeyPair, 0, len(keys))
+		for _, key := range keys {
+			if key.RetireAt.After(now) {
+				active = append(active, key)
 			}
 		}
+		r.keys[poolID] = active
+
+		// only keyrings that signed before are rotated, the empty ones are dropped
+		if len(keys) > 0 && signKey(active) == nil {
+			if _, err := r.rotate(poolID); err != nil {
+				logrus.Errorf("Failed to rotate key pair for pool %s: %v", poolID, err)
+			}
+		}
+		if len(r.keys[poolID]) == 0 {
+			delete(r.keys, poolID)
+			delete(r.loadedAt, poolID)
+		}
+	}
+
+	if err := r.store.Default().DeleteRetiredKeypairs(context.TODO()); err != nil {
+		logrus.Errorf("Failed to delete retired key pairs: %v", err)
 	}
 }
 
-// refresh checks if the token has expired and if so, it will remove the key from the registry
-func (r *PrivateRegistry) refresh(id string, key *KeyPair) {
-	if key.ExpireAt.After(time.Now()) {
-		return
+// keyring returns the cached keyring of the pool, loading it from the store on first use
+func (r *PrivateRegistry) keyring(poolID string) ([]*KeyPair, error) {
+	r.mu.RLock()
+	keys, ok := r.keys[poolID]
+	r.mu.RUnlock()
+	if ok {
+		return keys, nil
 	}
 
+	return r.load(poolID)
+}
+
+// claimReload reports whether the keyring of the pool was loaded more than the reload interval ago,
+// the caller that gets true does the reload
+func (r *PrivateRegistry) claimReload(poolID string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	delete(r.keys, id)
-	err := r.GenerateKeyPair(id)
+	if time.Since(r.loadedAt[poolID]) < reloadInterval {
+		return false
+	}
+	r.loadedAt[poolID] = time.Now()
+
+	return true
+}
+
+// load reads the unretired keys of the pool from the store and caches them. The pool id comes from unverified
+// token claims, so a pool without keys is not cached and made up pool ids do not grow the registry.
+func (r *PrivateRegistry) load(poolID string) ([]*KeyPair, error) {
+	poolUUID, err := uuid.Parse(poolID)
 	if err != nil {
-		logrus.Errorf("Failed to generate key pair: %v", err)
-		// schedule exponential backoff
+		return nil, err
+	}
+
+	keypairs, err := r.store.Default().ListPoolKeypairs(context.TODO(), poolUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*KeyPair, 0, len(keypairs))
+	for _, keypair := range keypairs {
+		private, err := x.DecodeSigningKey([]byte(keypair.PrivateKey))
+		if err != nil {
+			logrus.Errorf("Failed to decode key pair %s: %v", keypair.ID, err)
+			continue
+		}
+
+		keys = append(keys, &KeyPair{
+			ID:        keypair.ID,
+			Algorithm: keypair.Algorithm,
+			private:   private,
+			public:    private.Public(),
+			ExpireAt:  keypair.ExpiresAt,
+			RetireAt:  keypair.RetiresAt,
+		})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(keys) == 0 {
+		delete(r.keys, poolID)
+		delete(r.loadedAt, poolID)
+		return keys, nil
+	}
+	r.keys[poolID] = keys
+	r.loadedAt[poolID] = time.Now()
+
+	return keys, nil
+}
+
+// rotate generates a new signing key for the pool and persists it, the caller must hold the lock
+func (r *PrivateRegistry) rotate(poolID string) (*KeyPair, error) {
+	private, err := x.GenerateSigningKey(r.algorithm)
+	if err != nil {
+		return nil, err
 	}
+
+	privatePem, err := x.EncodeSigningKeyToPEM(private)
+	if err != nil {
+		return nil, err
+	}
+
+	publicPem, err := x.EncodeVerifyKeyToPEM(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	key := &KeyPair{
+		ID:        uuid.New().String(),
+		Algorithm: r.algorithm,
+		private:   private,
+		public:    private.Public(),
+		ExpireAt:  now.Add(r.rotation),
+		RetireAt:  now.Add(r.rotation + r.retention),
+	}
+
+	err = r.store.Default().CreateKeypair(context.TODO(), &model.Keypair{
+		ID:         key.ID,
+		PoolID:     poolID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: string(privatePem),
+		PublicKey:  string(publicPem),
+		ExpiresAt:  key.ExpireAt,
+		RetiresAt:  key.RetireAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.keys[poolID] = append([]*KeyPair{key}, r.keys[poolID]...)
+	// the first key of a pool caches its keyring, which was just read from the store
+	if _, ok := r.loadedAt[poolID]; !ok {
+		r.loadedAt[poolID] = now
+	}
+
+	return key, nil
+}
+
+// signKey returns the newest key that is still allowed to sign
+func signKey(keys []*KeyPair) *KeyPair {
+	now := time.Now()
+	for _, key := range keys {
+		if key.ExpireAt.After(now) {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// findKey returns the unretired key with the given id
+func findKey(keys []*KeyPair, kid string) *KeyPair {
+	now := time.Now()
+	for _, key := range keys {
+		if key.ID == kid && key.RetireAt.After(now) {
+			return key
+		}
+	}
+
+	return nil
 }
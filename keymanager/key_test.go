@@ -0,0 +1,116 @@
+package keymanager
+
+import (
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestKeyRetention function to test a rotated key keeps verifying the refresh tokens of the longest token policy
+func TestKeyRetention(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "authbase.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, model.Migrate(db))
+
+	day := 24 * time.Hour
+	registry := NewPrivateRegistry(store.NewDefaultProvider(store.NewGormStore(db)), "ES256", day)
+	poolID := uuid.New().String()
+
+	now := time.Now()
+	signer, err := registry.GetSigner(poolID)
+	assert.NoError(t, err)
+	refreshToken, err := signer.Sign(jwt.MapClaims{
+		"iat": now.Add(-10 * day).Unix(),
+		"exp": now.Add(80 * day).Unix(),
+	})
+	assert.NoError(t, err)
+
+	// the key signed the refresh token ten days ago and was rotated nine days ago
+	key := registry.keys[poolID][0]
+	retire := func(expireAt, retireAt time.Time) {
+		key.ExpireAt, key.RetireAt = expireAt, retireAt
+		err := db.Model(&model.Keypair{}).Where("id = ?", key.ID).
+			Updates(map[string]any{"expires_at": expireAt, "retires_at": retireAt}).Error
+		assert.NoError(t, err)
+	}
+	retire(now.Add(-9*day), now.Add(-9*day).Add(registry.retention))
+	next, err := registry.GetSignKey(poolID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key.ID, next.ID)
+
+	verifier, err := registry.GetVerifier(poolID)
+	assert.NoError(t, err)
+	_, err = verifier.Verify(refreshToken)
+	assert.NoError(t, err)
+
+	// the retired keys verify nothing
+	retire(now.Add(-9*day), now.Add(-time.Minute))
+	_, err = verifier.Verify(refreshToken)
+	assert.Error(t, err)
+}
+
+// TestUnknownKeyReload function to test the unknown key ids reload the keyring at most once per reload interval
+func TestUnknownKeyReload(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "authbase.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, model.Migrate(db))
+
+	provider := store.NewDefaultProvider(store.NewGormStore(db))
+	registry := NewPrivateRegistry(provider, "ES256", time.Hour)
+	other := NewPrivateRegistry(provider, "ES256", time.Hour)
+	poolID := uuid.New().String()
+
+	_, err = registry.GetSignKey(poolID)
+	assert.NoError(t, err)
+
+	// a key of another server instance is not seen until the reload interval passed
+	other.mu.Lock()
+	key, err := other.rotate(poolID)
+	other.mu.Unlock()
+	assert.NoError(t, err)
+	_, err = registry.GetKey(poolID, key.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	registry.loadedAt[poolID] = time.Now().Add(-reloadInterval)
+	found, err := registry.GetKey(poolID, key.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+
+	// the made up key ids right after do not reload it
+	registry.keys[poolID] = nil
+	_, err = registry.GetKey(poolID, uuid.New().String())
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = registry.GetKey(poolID, key.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+// TestUnknownPoolKeyring function to test the made up pool ids of unverified tokens are not cached
+func TestUnknownPoolKeyring(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "authbase.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, model.Migrate(db))
+
+	registry := NewPrivateRegistry(store.NewDefaultProvider(store.NewGormStore(db)), "ES256", time.Hour)
+	for i := 0; i < 10; i++ {
+		_, err := registry.GetKey(uuid.New().String(), uuid.New().String())
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	assert.Equal(t, 0, registry.Size())
+	assert.Empty(t, registry.loadedAt)
+
+	// a new pool still gets a signing key, then its keyring is cached
+	poolID := uuid.New().String()
+	key, err := registry.GetSignKey(poolID)
+	assert.NoError(t, err)
+	found, err := registry.GetKey(poolID, key.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+	assert.Equal(t, 1, registry.Size())
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	redis "github.com/go-redis/redis/v8"
 	"time"
 )
@@ -31,6 +32,71 @@ func (r *Redis) Del(key string) error {
 	return r.client.Del(r.client.Context(), key).Err()
 }
 
+// Incr increments the counter and restarts its expiration, returns the new value
+func (r *Redis) Incr(key string, expiration time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := r.client.TxPipelined(r.client.Context(), func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(r.client.Context(), key)
+		pipe.Expire(r.client.Context(), key, expiration)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// takeTokenScript refills the token bucket of the key for the time passed and takes one token from it.
+// It returns 1 and no wait when a token was taken, else 0 and the milliseconds until the next token.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1]) / tonumber(ARGV[2])
+local burst = tonumber(ARGV[1])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], tonumber(ARGV[2]))
+return {allowed, wait}
+`)
+
+// TakeToken takes a token from the bucket of the key holding up to requests tokens refilled over the period.
+// When the bucket is empty it returns false with the wait until the next token.
+func (r *Redis) TakeToken(key string, requests int, period time.Duration) (bool, time.Duration, error) {
+	result, err := takeTokenScript.Run(r.client.Context(), r.client, []string{key}, requests, period.Milliseconds(), time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// MGet returns the values of the keys in one round trip, missing keys have an empty value
+func (r *Redis) MGet(keys ...string) ([]string, error) {
+	values, err := r.client.MGet(r.client.Context(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(values))
+	for i, value := range values {
+		if value, ok := value.(string); ok {
+			result[i] = value
+		}
+	}
+
+	return result, nil
+}
+
 func (r *Redis) SExists(key string, member string) (bool, error) {
 	cmd := r.client.SIsMember(r.client.Context(), key, member)
 	return cmd.Val(), cmd.Err()
@@ -40,6 +106,16 @@ func (r *Redis) SAdd(key string, members ...string) error {
 	return r.client.SAdd(r.client.Context(), key, members).Err()
 }
 
+// GetDel returns the value of the key and deletes it in one step, a missing key has an empty value
+func (r *Redis) GetDel(key string) (string, error) {
+	value, err := r.client.GetDel(r.client.Context(), key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+
+	return value, err
+}
+
 func (r *Redis) Close() error {
 	return r.client.Close()
 }
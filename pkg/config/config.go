@@ -1,7 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // config package is used to load the configuration from the environment variables
@@ -30,6 +35,160 @@ type Config struct {
 	AdminOrg    *AdminProjectConfig
 	Mode        AppMode
 	AppKey      string
+	JWT         *JWTConfig
+	// PublicURL is the externally reachable base url of the rest gateway, used as the token issuer
+	PublicURL string
+	// LoginURL is the login page that handles the OAuth2 login and consent hand-off
+	LoginURL string
+	// RateLimit holds the request quotas of the grpc and rest apis
+	RateLimit *RateLimitConfig
+	// TrustedProxies are the networks of the proxies whose forwarded addresses are honoured,
+	// the loopback is trusted by default as the rest gateway forwards to the grpc server through it
+	TrustedProxies []*net.IPNet
+	// Mail holds the default mail transport and sender of the emails
+	Mail *MailConfig
+}
+
+// MailConfig holds the mail transport and the default sender, the projects may set their own sender and smtp server
+type MailConfig struct {
+	// Transport delivers the emails, smtp, file to write them to a maildir for development, or memory to keep them
+	Transport string
+	From      string
+	ReplyTo   string
+	SMTP      SMTPConfig
+	// Dir is the maildir of the file transport
+	Dir string
+}
+
+// SMTPConfig holds the smtp server settings
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS is starttls to upgrade the connection, tls for an implicit tls connection, or none
+	TLS string
+}
+
+// JWTConfig holds the token signing settings
+type JWTConfig struct {
+	// Algorithm is the signing algorithm of the pool keys, RS256 or ES256
+	Algorithm string
+	// KeyRotation is how long a pool key signs new tokens before a new key replaces it
+	KeyRotation time.Duration
+}
+
+// RateLimitConfig holds the request quotas, every method has its own quota for each caller
+type RateLimitConfig struct {
+	// Backend keeps the request counts, memory for a single replica, redis when shared by the replicas, or off
+	Backend string
+	// Default is the quota of the methods without their own, a zero quota does not limit them
+	Default RateQuota
+	// Methods are the quotas by method: the full grpc method name, the Service/Method suffix, the http route
+	// like /oauth2/token or a prefix ending with a slash like /auth/idp/. The method names win over the prefixes,
+	// the longest prefix wins over the shorter ones.
+	Methods []MethodQuota
+}
+
+// MethodQuota is the quota of the methods matching the name
+type MethodQuota struct {
+	Name  string
+	Quota RateQuota
+}
+
+// RateQuota allows Requests per Period, all of them can be used at once
+type RateQuota struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Quota returns the quota of the grpc method or the http route, false when it is not limited.
+// The most specific quota is used, the first one configured when two are as specific.
+func (c *RateLimitConfig) Quota(method string) (RateQuota, bool) {
+	best, match := 0, -1
+	for i, quota := range c.Methods {
+		if specificity := quota.specificity(method); specificity > best {
+			best, match = specificity, i
+		}
+	}
+	if match >= 0 {
+		return c.Methods[match].Quota, true
+	}
+
+	return c.Default, c.Default.Requests > 0
+}
+
+// specificity tells how closely the quota matches the method, zero when it does not match.
+// The method names match more closely than the route prefixes, then the longer names.
+func (q MethodQuota) specificity(method string) int {
+	if method == q.Name || strings.HasSuffix(method, "."+q.Name) {
+		return 1<<16 + len(q.Name)
+	}
+	if strings.HasPrefix(q.Name, "/") && strings.HasSuffix(q.Name, "/") && strings.HasPrefix(method, q.Name) {
+		return len(q.Name)
+	}
+
+	return 0
+}
+
+// ParseRateQuota parses a quota like 100/s, 1000/m or 10/30s
+func ParseRateQuota(value string) (RateQuota, error) {
+	count, per, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return RateQuota{}, fmt.Errorf("invalid rate quota %q, expected requests/period", value)
+	}
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests <= 0 {
+		return RateQuota{}, fmt.Errorf("invalid rate quota %q, requests must be a positive number", value)
+	}
+	if per == "s" || per == "m" || per == "h" {
+		per = "1" + per
+	}
+	period, err := time.ParseDuration(per)
+	if err != nil || period < time.Millisecond {
+		return RateQuota{}, fmt.Errorf("invalid rate quota %q, period must be a duration", value)
+	}
+
+	return RateQuota{Requests: requests, Period: period}, nil
+}
+
+// ParseTrustedProxies parses a comma separated list of networks like 10.0.0.0/8, a single address is a network of its own
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// ValidateSMTPTLS accepts the smtp tls modes, empty is starttls
+func ValidateSMTPTLS(mode string) error {
+	switch mode {
+	case "", "starttls", "tls", "none":
+		return nil
+	default:
+		return fmt.Errorf("unsupported smtp tls mode %q, expected starttls, tls or none", mode)
+	}
 }
 
 type DBConfig struct {
@@ -64,17 +223,132 @@ func FromEnv() (*Config, error) {
 	adminOrgConfig.ClientId = os.Getenv("SUPER_ADMIN_CLIENT_ID")
 	adminOrgConfig.ClientSecret = os.Getenv("SUPER_ADMIN_CLIENT_SECRET")
 
+	jwtConfig := &JWTConfig{
+		Algorithm:   os.Getenv("JWT_ALGORITHM"),
+		KeyRotation: 30 * 24 * time.Hour,
+	}
+	if jwtConfig.Algorithm == "" {
+		jwtConfig.Algorithm = "RS256"
+	}
+	if jwtConfig.Algorithm != "RS256" && jwtConfig.Algorithm != "ES256" {
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM: %s", jwtConfig.Algorithm)
+	}
+	if rotation := os.Getenv("JWT_KEY_ROTATION"); rotation != "" {
+		duration, err := time.ParseDuration(rotation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION: %w", err)
+		}
+		jwtConfig.KeyRotation = duration
+	}
+
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = "http://localhost:4001"
+	}
+
+	loginURL := os.Getenv("OAUTH2_LOGIN_URL")
+	if loginURL == "" {
+		loginURL = publicURL + "/login"
+	}
+
+	rateLimit := &RateLimitConfig{
+		Backend: os.Getenv("RATE_LIMIT_BACKEND"),
+	}
+	if rateLimit.Backend == "" {
+		rateLimit.Backend = "memory"
+	}
+	if rateLimit.Backend != "memory" && rateLimit.Backend != "redis" && rateLimit.Backend != "off" {
+		return nil, fmt.Errorf("unsupported RATE_LIMIT_BACKEND: %s", rateLimit.Backend)
+	}
+	if value := os.Getenv("RATE_LIMIT_DEFAULT"); value != "" {
+		quota, err := ParseRateQuota(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_DEFAULT: %w", err)
+		}
+		rateLimit.Default = quota
+	}
+	// RATE_LIMIT_METHODS is a comma separated list of method=quota, e.g. AccountService/ListAccounts=10/s,/oauth2/token=5/s
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMIT_METHODS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		method, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_METHODS entry %q, expected method=quota", entry)
+		}
+		quota, err := ParseRateQuota(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_METHODS: %w", err)
+		}
+		rateLimit.Methods = append(rateLimit.Methods, MethodQuota{Name: strings.TrimSpace(method), Quota: quota})
+	}
+
+	// TRUSTED_PROXIES is a comma separated list of the load balancer and proxy networks in front of the server
+	trustedProxies := os.Getenv("TRUSTED_PROXIES")
+	if trustedProxies == "" {
+		trustedProxies = "127.0.0.0/8,::1"
+	}
+	proxies, err := ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	mail := &MailConfig{
+		Transport: os.Getenv("MAIL_TRANSPORT"),
+		From:      os.Getenv("EMAIL_FROM"),
+		ReplyTo:   os.Getenv("EMAIL_REPLY_TO"),
+		SMTP: SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     587,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			TLS:      os.Getenv("SMTP_TLS"),
+		},
+		Dir: os.Getenv("MAIL_DIR"),
+	}
+	// without a smtp server the emails are written to the maildir
+	if mail.Transport == "" && mail.SMTP.Host != "" {
+		mail.Transport = "smtp"
+	} else if mail.Transport == "" {
+		mail.Transport = "file"
+	}
+	if mail.Transport != "smtp" && mail.Transport != "file" && mail.Transport != "memory" {
+		return nil, fmt.Errorf("unsupported MAIL_TRANSPORT: %s", mail.Transport)
+	}
+	if mail.Transport == "smtp" && mail.SMTP.Host == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required by the smtp MAIL_TRANSPORT")
+	}
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		value, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		mail.SMTP.Port = value
+	}
+	if err := ValidateSMTPTLS(mail.SMTP.TLS); err != nil {
+		return nil, err
+	}
+	if mail.Dir == "" {
+		mail.Dir = "./.tmp/mail"
+	}
+
 	mode := os.Getenv("APP_MODE")
 	if mode == "" {
 		mode = "singlestore"
 	}
 
 	config := &Config{
-		Environment: Environment(env),
-		DB:          dbConfig,
-		AppKey:      appKey,
-		AdminOrg:    adminOrgConfig,
-		Mode:        AppMode(mode),
+		Environment:    Environment(env),
+		DB:             dbConfig,
+		AppKey:         appKey,
+		AdminOrg:       adminOrgConfig,
+		Mode:           AppMode(mode),
+		JWT:            jwtConfig,
+		PublicURL:      publicURL,
+		LoginURL:       loginURL,
+		RateLimit:      rateLimit,
+		Mail:           mail,
+		TrustedProxies: proxies,
 	}
 
 	return config, nil
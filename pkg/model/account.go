@@ -28,8 +28,25 @@ type Account struct {
 	Recovered     bool      `gorm:"not null;default:false"`
 	RecoveredAt   time.Time `gorm:"default:null"`
 	RecoveredBy   string    `gorm:"uuid;"`
+
+	// PasswordChangedAt starts the max age of the project password policy
+	PasswordChangedAt time.Time `gorm:"default:null"`
 }
 
 func (Account) TableName() string {
 	return tableName("accounts")
 }
+
+// PasswordHistory is a replaced password of an account, the project password policy rejects reusing it
+type PasswordHistory struct {
+	gorm.Model
+	ID           string   `gorm:"primaryKey;uuid"`
+	AccountID    string   `gorm:"uuid;not null;index"`
+	Account      *Account `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	PasswordHash string   `gorm:"not null"`
+	Salt         string   `gorm:"not null"`
+}
+
+func (PasswordHistory) TableName() string {
+	return tableName("password_histories")
+}
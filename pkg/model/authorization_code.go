@@ -0,0 +1,29 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// AuthorizationCode is a short-lived, single use OAuth2 authorization code.
+// Only the hash of the code is stored, the code itself is handed to the client once.
+type AuthorizationCode struct {
+	gorm.Model
+	ID                  string    `gorm:"primaryKey;uuid"`
+	CodeHash            string    `gorm:"uniqueIndex;not null"`
+	ClientID            string    `gorm:"uuid;not null"`
+	AccountID           string    `gorm:"uuid;not null"`
+	PoolID              string    `gorm:"uuid;not null"`
+	ProjectID           string    `gorm:"uuid;not null"`
+	RedirectURI         string    // the redirect uri of the authorization request, empty when it was omitted
+	Scopes              string    // space separated granted scopes
+	CodeChallenge       string    `gorm:"not null"`
+	CodeChallengeMethod string    `gorm:"not null"`
+	Nonce               string    // the nonce of the openid request, copied to the id token
+	AuthTime            time.Time // when the user authenticated to approve the request
+	ExpiresAt           time.Time `gorm:"index"`
+}
+
+func (AuthorizationCode) TableName() string {
+	return tableName("authorization_codes")
+}
@@ -14,6 +14,18 @@ type Client struct {
 	CreatedByID      string   `gorm:"uuid"`
 	CreatedByAccount *Account `gorm:"foreignKey:CreatedByID"`
 	Default          bool     `gorm:"default:false"`
+	// RedirectURIs is the space separated list of the registered authorization redirect uris
+	RedirectURIs string
+	// GrantTypes is the space separated list of the allowed OAuth2 grant types
+	GrantTypes string
+	// Scopes is the space separated list of the scopes the client may request for itself
+	Scopes string
+	// SecretHash is the hash of the client secret, public clients have no secret
+	SecretHash string
+	// SecretSalt salts the secrets hashed before the PHC encoded hashes, the new hashes carry their salt
+	SecretSalt string
+	// TokenPolicy overrides the pool token policy for the client
+	TokenPolicy TokenPolicy `gorm:"embedded;embeddedPrefix:token_policy_"`
 }
 
 func (c *Client) TableName() string {
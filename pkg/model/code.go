@@ -16,8 +16,23 @@ type VerificationCode struct {
 	ExpiresAt   time.Time
 	Medium      string
 	CallbackURL string
+	// Email is the address the code was sent to, login codes may be sent before the account exists
+	Email   string `gorm:"index"`
+	Purpose string `gorm:"index"`
 }
 
+// VerificationPurposeLogin marks the passwordless login codes, their Code is the hash of the sent code
+const VerificationPurposeLogin = "login"
+
+// VerificationPurposeVerifyEmail marks the email verification codes, their Code is the hash of the sent code
+const VerificationPurposeVerifyEmail = "verify_email"
+
+// VerificationPurposeResetPassword marks the password reset codes, their Code is the hash of the sent code
+const VerificationPurposeResetPassword = "reset_password"
+
+// VerificationMediumEmail is the medium of the emailed codes
+const VerificationMediumEmail = "email"
+
 func (VerificationCode) TableName() string {
 	return tableName("verification_codes")
 }
@@ -11,6 +11,10 @@ func Migrate(db *gorm.DB) error {
 		return err
 	}
 
+	if err := db.AutoMigrate(&PasswordHistory{}); err != nil {
+		return err
+	}
+
 	if err := db.AutoMigrate(&RefreshToken{}); err != nil {
 		return err
 	}
@@ -23,6 +27,26 @@ func Migrate(db *gorm.DB) error {
 		return err
 	}
 
+	if err := db.AutoMigrate(&Identity{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&SamlProvider{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&TotpFactor{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&RecoveryCode{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&Passkey{}); err != nil {
+		return err
+	}
+
 	if err := db.AutoMigrate(&Project{}); err != nil {
 		return err
 	}
@@ -67,6 +91,10 @@ func Migrate(db *gorm.DB) error {
 		return err
 	}
 
+	if err := db.AutoMigrate(&GroupMemberClient{}); err != nil {
+		return err
+	}
+
 	if err := db.AutoMigrate(&Role{}); err != nil {
 		return err
 	}
@@ -79,6 +107,14 @@ func Migrate(db *gorm.DB) error {
 		return err
 	}
 
+	if err := db.AutoMigrate(&AuthorizationCode{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&EmailTemplate{}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,21 @@
+package model
+
+import "gorm.io/gorm"
+
+// EmailTemplate is an uploaded email template of a project, it replaces the default template of the kind in the locale.
+// The subject and the text are text templates, the html is a html template.
+type EmailTemplate struct {
+	gorm.Model
+	ID        string   `gorm:"primaryKey;uuid"`
+	ProjectID string   `gorm:"uuid;not null;uniqueIndex:idx_email_template_project_kind_locale"`
+	Project   *Project `gorm:"foreignKey:ProjectID;constraint:OnDelete:CASCADE"`
+	Kind      string   `gorm:"not null;uniqueIndex:idx_email_template_project_kind_locale"`
+	Locale    string   `gorm:"not null;uniqueIndex:idx_email_template_project_kind_locale"`
+	Subject   string   `gorm:"not null;default:''"`
+	HTML      string   `gorm:"not null;default:''"`
+	Text      string   `gorm:"not null;default:''"`
+}
+
+func (EmailTemplate) TableName() string {
+	return tableName("email_templates")
+}
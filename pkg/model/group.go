@@ -19,6 +19,8 @@ type Group struct {
 type GroupMemberAccount struct {
 	GroupID   string `gorm:"uuid;not null;primaryKey"`
 	AccountID string `gorm:"uuid;not null;primaryKey"`
+	// Provider is the identity provider that synced the membership, empty for memberships added in authbase
+	Provider string `gorm:"not null;default:''"`
 
 	Group   *Group   `gorm:"foreignKey:GroupID;OnDelete:CASCADE"`
 	Account *Account `gorm:"foreignKey:AccountID;OnDelete:CASCADE"`
@@ -31,3 +33,13 @@ type GroupMemberAccessKey struct {
 	Group     *Group     `gorm:"foreignKey:GroupID;OnDelete:CASCADE"`
 	AccessKey *AccessKey `gorm:"foreignKey:AccessKeyID;OnDelete:CASCADE"`
 }
+
+// GroupMemberClient represents a client member of a group.
+// Client credentials tokens carry the roles of the client groups.
+type GroupMemberClient struct {
+	GroupID  string `gorm:"uuid;not null;primaryKey"`
+	ClientID string `gorm:"uuid;not null;primaryKey"`
+
+	Group  *Group  `gorm:"foreignKey:GroupID;OnDelete:CASCADE"`
+	Client *Client `gorm:"foreignKey:ClientID;OnDelete:CASCADE"`
+}
@@ -0,0 +1,20 @@
+package model
+
+import "gorm.io/gorm"
+
+// Identity links an account to its user at an external identity provider.
+// One account can have several identities besides its password.
+type Identity struct {
+	gorm.Model
+	ID        string   `gorm:"primaryKey;uuid"`
+	PoolID    string   `gorm:"uuid;not null;uniqueIndex:idx_identity_pool_provider_subject"`
+	Provider  string   `gorm:"not null;uniqueIndex:idx_identity_pool_provider_subject"`
+	Subject   string   `gorm:"not null;uniqueIndex:idx_identity_pool_provider_subject"` // the user id at the provider
+	AccountID string   `gorm:"uuid;not null;index"`
+	Account   *Account `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	Email     string   // the email at the provider when the identity was linked
+}
+
+func (Identity) TableName() string {
+	return tableName("identities")
+}
@@ -5,11 +5,21 @@ import (
 	"time"
 )
 
-// Keypair represents a keypair used for token generation and verification
+// Keypair represents a pool signing key used for token generation and verification.
+// The newest unexpired keypair of a pool signs new tokens, older keypairs keep
+// verifying the tokens they signed until they retire.
 type Keypair struct {
 	gorm.Model
-	ClientID   string    `gorm:"uuid;primaryKey"`
+	ID         string    `gorm:"primaryKey;uuid"` // stamped as the kid header of the token
+	PoolID     string    `gorm:"uuid;not null;index"`
+	Pool       *Pool     `gorm:"foreignKey:PoolID;constraint:OnDelete:CASCADE"`
+	Algorithm  string    `gorm:"not null"` // RS256 or ES256
 	PrivateKey string    // used for token generation
 	PublicKey  string    // used for token verification
-	ExpiresAt  time.Time `gorm:"index"`
+	ExpiresAt  time.Time `gorm:"index"` // the keypair stops signing new tokens
+	RetiresAt  time.Time `gorm:"index"` // the keypair stops verifying tokens
+}
+
+func (Keypair) TableName() string {
+	return tableName("keypairs")
 }
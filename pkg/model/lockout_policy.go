@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// DefaultLockoutPolicy is used for the values a pool policy does not set
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxAttempts:     5,
+	IPMaxAttempts:   50,
+	LockoutDuration: 15 * 60,
+	BackoffDelay:    1,
+}
+
+// LockoutPolicy protects the password logins against brute force, the durations are in seconds.
+// Every failure doubles the wait before the next attempt, MaxAttempts failures lock the account out.
+type LockoutPolicy struct {
+	MaxAttempts     int   `json:"max_attempts"`
+	IPMaxAttempts   int   `json:"ip_max_attempts"` // failures from an address on any account
+	LockoutDuration int64 `json:"lockout_duration"`
+	BackoffDelay    int64 `json:"backoff_delay"`
+}
+
+// WithDefaults returns the policy with the unset values taken from DefaultLockoutPolicy
+func (p LockoutPolicy) WithDefaults() LockoutPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultLockoutPolicy.MaxAttempts
+	}
+	if p.IPMaxAttempts <= 0 {
+		p.IPMaxAttempts = DefaultLockoutPolicy.IPMaxAttempts
+	}
+	if p.LockoutDuration <= 0 {
+		p.LockoutDuration = DefaultLockoutPolicy.LockoutDuration
+	}
+	if p.BackoffDelay <= 0 {
+		p.BackoffDelay = DefaultLockoutPolicy.BackoffDelay
+	}
+
+	return p
+}
+
+// Backoff returns the wait after the given number of failures, it never exceeds the lockout duration
+func (p LockoutPolicy) Backoff(failures int) time.Duration {
+	lockout := time.Duration(p.LockoutDuration) * time.Second
+	delay := time.Duration(p.BackoffDelay) * time.Second
+	for i := 1; i < failures && delay < lockout; i++ {
+		delay *= 2
+	}
+
+	return min(delay, lockout)
+}
@@ -0,0 +1,13 @@
+package model
+
+// MailSettings are the sender and the smtp server of the project emails, empty values use the server defaults.
+// The smtp password is sealed with the app key.
+type MailSettings struct {
+	From         string `gorm:"not null;default:''"`
+	ReplyTo      string `gorm:"not null;default:''"`
+	SMTPHost     string `gorm:"not null;default:''"`
+	SMTPPort     int    `gorm:"not null;default:0"`
+	SMTPUsername string `gorm:"not null;default:''"`
+	SMTPPassword string `gorm:"not null;default:''"`
+	SMTPTLS      string `gorm:"not null;default:''"`
+}
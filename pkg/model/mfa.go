@@ -0,0 +1,37 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// TotpFactor is the TOTP second factor of an account.
+// The factor is pending until the account confirms it with a first code.
+type TotpFactor struct {
+	gorm.Model
+	ID          string    `gorm:"primaryKey;uuid"`
+	AccountID   string    `gorm:"uuid;not null;uniqueIndex"`
+	Account     *Account  `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	Secret      string    `gorm:"not null"` // sealed with the application key
+	Confirmed   bool      `gorm:"not null;default:false"`
+	ConfirmedAt time.Time `gorm:"default:null"`
+	LastStep    int64     // the time step of the last accepted code, codes can be used only once
+}
+
+func (TotpFactor) TableName() string {
+	return tableName("totp_factors")
+}
+
+// RecoveryCode is a one-time code that replaces the TOTP code when the account lost its authenticator
+type RecoveryCode struct {
+	gorm.Model
+	ID        string    `gorm:"primaryKey;uuid"`
+	AccountID string    `gorm:"uuid;not null;index"`
+	Account   *Account  `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	CodeHash  string    `gorm:"not null;index"`
+	UsedAt    time.Time `gorm:"default:null"`
+}
+
+func (RecoveryCode) TableName() string {
+	return tableName("recovery_codes")
+}
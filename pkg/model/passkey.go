@@ -0,0 +1,29 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by an account
+type Passkey struct {
+	gorm.Model
+	ID              string    `gorm:"primaryKey;uuid"`
+	AccountID       string    `gorm:"uuid;not null;index"`
+	Account         *Account  `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE"`
+	PoolID          string    `gorm:"uuid;not null;index"`
+	CredentialID    string    `gorm:"not null;uniqueIndex"` // base64url encoded credential id
+	PublicKey       []byte    `gorm:"not null"`             // COSE encoded public key
+	AttestationType string    // attestation format verified at registration
+	AAGUID          []byte    // authenticator model
+	SignCount       uint32    // signature counter of the authenticator, used to detect cloned keys
+	Transports      string    // space separated transports hinted by the authenticator
+	BackupEligible  bool      `gorm:"not null;default:false"`
+	BackupState     bool      `gorm:"not null;default:false"`
+	Name            string    // name given by the account owner
+	LastUsedAt      time.Time `gorm:"default:null"`
+}
+
+func (Passkey) TableName() string {
+	return tableName("passkeys")
+}
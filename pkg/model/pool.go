@@ -10,8 +10,27 @@ type Pool struct {
 	Name      string `gorm:"not null;index:idx_name_project_id,unique;"` // Name of the pool
 	ProjectID string `gorm:"not null;index:idx_name_project_id,unique;"` // Project ID
 	Default   bool   `gorm:"not null;default:false;"`                    // Default pool
+	// MfaRequired makes the accounts of the pool pass a second factor on password login
+	MfaRequired bool `gorm:"not null;default:false;"`
+	// WebauthnRPID and WebauthnOrigins configure the passkey relying party, they default to the public url
+	WebauthnRPID    string
+	WebauthnOrigins string // space separated
+	// TokenPolicy overrides the project token policy for the pool
+	TokenPolicy TokenPolicy `gorm:"embedded;embeddedPrefix:token_policy_"`
+	// MaxSessions limits the concurrent sessions of an account, zero is unlimited
+	MaxSessions          int    `gorm:"not null;default:0;"`
+	SessionLimitStrategy string `gorm:"not null;default:'reject';"`
+	// LockoutPolicy protects the password logins of the pool accounts against brute force
+	LockoutPolicy LockoutPolicy `gorm:"embedded;embeddedPrefix:lockout_"`
 }
 
+const (
+	// SessionLimitReject rejects the logins over the session limit
+	SessionLimitReject = "reject"
+	// SessionLimitEvictOldest ends the oldest sessions to make room for a new login
+	SessionLimitEvictOldest = "evict_oldest"
+)
+
 func (Pool) TableName() string {
 	return tableName("pools")
 }
@@ -1,15 +1,62 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"unicode"
+	"unicode/utf8"
+)
 
-// PasswordPolicy represents a password policy for an project
+// PasswordPolicy represents a password policy for an project, zero values do not restrict the passwords
 type PasswordPolicy struct {
-	MinLength int `json:"min_length"`
-	MaxLength int `json:"max_length"`
-	MinUpper  int `json:"min_upper"`
-	MinLower  int `json:"min_lower"`
-	MinDigit  int `json:"min_digit"`
-	MinSymbol int `json:"min_symbol"`
+	MinLength   int   `json:"min_length"`
+	MaxLength   int   `json:"max_length"`
+	MinUpper    int   `json:"min_upper"`
+	MinLower    int   `json:"min_lower"`
+	MinDigit    int   `json:"min_digit"`
+	MinSymbol   int   `json:"min_symbol"`
+	HistorySize int   `json:"history_size"` // number of the last passwords that can not be reused
+	MaxAge      int64 `json:"max_age"`      // seconds after which the password must be changed on login
+}
+
+// Violations returns the rules of the policy the password breaks
+func (p PasswordPolicy) Violations(password string) []string {
+	var upper, lower, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsDigit(r):
+			digit++
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol++
+		}
+	}
+
+	var violations []string
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+	if upper < p.MinUpper {
+		violations = append(violations, fmt.Sprintf("must contain at least %d uppercase letters", p.MinUpper))
+	}
+	if lower < p.MinLower {
+		violations = append(violations, fmt.Sprintf("must contain at least %d lowercase letters", p.MinLower))
+	}
+	if digit < p.MinDigit {
+		violations = append(violations, fmt.Sprintf("must contain at least %d digits", p.MinDigit))
+	}
+	if symbol < p.MinSymbol {
+		violations = append(violations, fmt.Sprintf("must contain at least %d symbols", p.MinSymbol))
+	}
+
+	return violations
 }
 
 // Project represents an project
@@ -25,7 +72,11 @@ type Project struct {
 	Master            bool           `gorm:"not null;default:false"`
 	AllowedDomains    string         `gorm:"not null;default:''"`
 	EmailVerification bool           `gorm:"not null;default:false"`
+	BaseURL           string         `gorm:"not null;default:''"` // app address the email links point to
+	Locale            string         `gorm:"not null;default:''"` // locale of the emails when the request asks for none
 	PasswordPolicy    PasswordPolicy `gorm:"embedded;embeddedPrefix:password_policy_"`
+	TokenPolicy       TokenPolicy    `gorm:"embedded;embeddedPrefix:token_policy_"`
+	MailSettings      MailSettings   `gorm:"embedded;embeddedPrefix:mail_"`
 }
 
 // TableName returns the table name for the project model
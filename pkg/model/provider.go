@@ -7,6 +7,12 @@ type OAuthConfig struct {
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	Scopes       string `json:"scopes"`
+	// Issuer is the OpenID Connect issuer of generic oidc providers
+	Issuer string `json:"issuer"`
+	// EmailClaim, NameClaim and GroupsClaim are the id token claims mapped onto the account
+	EmailClaim  string `json:"email_claim"`
+	NameClaim   string `json:"name_claim"`
+	GroupsClaim string `json:"groups_claim"`
 }
 
 type OauthProvider struct {
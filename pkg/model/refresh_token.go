@@ -8,6 +8,7 @@ import (
 
 // RefreshToken represents a refresh token.
 // It is used to generate new access tokens when the access token expires.
+// Refresh tokens rotate, each refresh consumes the token and issues the next one of the same family.
 type RefreshToken struct {
 	gorm.Model
 	Token     string    `gorm:"primaryKey;not null"`
@@ -15,6 +16,14 @@ type RefreshToken struct {
 	AccountID string    `gorm:"not null"`
 	ExpireAt  time.Time `gorm:"not null"`
 	IssuedAt  time.Time `gorm:"not null"`
+	// FamilyID groups the tokens rotated from the same login, it is the session id
+	FamilyID   string    `gorm:"index"`
+	ConsumedAt time.Time `gorm:"default:null"`
+}
+
+// Consumed reports whether the token was already exchanged for a new one
+func (t *RefreshToken) Consumed() bool {
+	return !t.ConsumedAt.IsZero()
 }
 
 func (RefreshToken) TableName() string {
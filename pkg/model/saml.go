@@ -0,0 +1,23 @@
+package model
+
+import "gorm.io/gorm"
+
+// SamlProvider is the SAML identity provider of a pool, authbase is the service provider.
+// The service provider key signs the authentication requests and decrypts the assertions.
+type SamlProvider struct {
+	gorm.Model
+	ID              string `gorm:"primaryKey;uuid"`
+	PoolID          string `gorm:"uuid;not null;uniqueIndex"`
+	Pool            *Pool  `gorm:"foreignKey:PoolID;constraint:OnDelete:CASCADE"`
+	IdpEntityID     string `gorm:"not null"`
+	IdpMetadata     string `gorm:"not null"` // the uploaded metadata xml of the identity provider
+	PrivateKey      string `gorm:"not null"` // the service provider key pem
+	Certificate     string `gorm:"not null"` // the self signed service provider certificate pem
+	EmailAttribute  string
+	NameAttribute   string
+	GroupsAttribute string
+}
+
+func (SamlProvider) TableName() string {
+	return tableName("saml_providers")
+}
@@ -14,7 +14,17 @@ type Session struct {
 	PoolID    string   `gorm:"not null;index"`
 	Pool      *Pool    `gorm:"foreignKey:PoolID;OnDelete:CASCADE;"`
 	ProjectID string
+	ClientID  string
+	UserAgent string
+	IPAddress string
 	ExpiredAt time.Time `gorm:"default:null"`
+	// LastActiveAt is the time of the login or of the last refresh, it is checked against the idle timeout
+	LastActiveAt time.Time `gorm:"default:null"`
+}
+
+// Active reports whether the session was not expired
+func (s *Session) Active() bool {
+	return s.ExpiredAt.IsZero() || s.ExpiredAt.After(time.Now())
 }
 
 func (Session) TableName() string {
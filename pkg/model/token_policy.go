@@ -0,0 +1,32 @@
+package model
+
+// TokenPolicy sets the lifetimes of the issued tokens in seconds.
+// Zero values are unset, a client policy overrides its pool policy which overrides the project policy.
+type TokenPolicy struct {
+	AccessTokenTTL     int64 `json:"access_token_ttl"`
+	RefreshTokenTTL    int64 `json:"refresh_token_ttl"`
+	SessionLifetime    int64 `json:"session_lifetime"`     // absolute lifetime of a login session
+	SessionIdleTimeout int64 `json:"session_idle_timeout"` // a session not refreshed for longer ends
+	AccessKeyMaxTTL    int64 `json:"access_key_max_ttl"`
+}
+
+// Override returns the policy with the values set in the other policy
+func (p TokenPolicy) Override(other TokenPolicy) TokenPolicy {
+	if other.AccessTokenTTL != 0 {
+		p.AccessTokenTTL = other.AccessTokenTTL
+	}
+	if other.RefreshTokenTTL != 0 {
+		p.RefreshTokenTTL = other.RefreshTokenTTL
+	}
+	if other.SessionLifetime != 0 {
+		p.SessionLifetime = other.SessionLifetime
+	}
+	if other.SessionIdleTimeout != 0 {
+		p.SessionIdleTimeout = other.SessionIdleTimeout
+	}
+	if other.AccessKeyMaxTTL != 0 {
+		p.AccessKeyMaxTTL = other.AccessKeyMaxTTL
+	}
+
+	return p
+}
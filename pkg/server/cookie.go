@@ -2,62 +2,32 @@ package server
 
 import (
 	"context"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
 	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
 	"net/http"
+	"time"
+)
+
+const (
+	// stateCookieName is the cookie binding an identity provider login to the user agent
+	stateCookieName = "oauthstate"
+	// stateCookieDuration matches the time the user has to log in at the identity provider
+	stateCookieDuration = 10 * time.Minute
 )
 
 // InjectCookie injects a cookie into the response based on the message type
 func InjectCookie(store CookieStore) func(ctx context.Context, w http.ResponseWriter, m proto.Message) error {
 	return func(ctx context.Context, w http.ResponseWriter, m proto.Message) error {
-		logrus.Warnf("TODO: authbase: inject cookie: %T", m)
-		//m = m.ProtoReflect().Interface()
-		//switch m.(type) {
-		//case *v1.LoginResponse:
-		//	loginResponse := m.(*v1.LoginResponse)
-		//	// marshal the token
-		//	token := loginResponse.Token.String()
-		//	logrus.Info("Token: ", token)
-		//	cookie := http.Cookie{
-		//		Name:     "session",
-		//		HttpOnly: true,
-		//		Value:    token,
-		//		SameSite: http.SameSiteStrictMode, // Strict mode is the most secure option
-		//	}
-		//	http.SetCookie(w, &cookie)
-		//case *v1.OAuthLoginResponse:
-		//	state := x.GenerateCode()
-		//	// create the redirection URL
-		//	cookie := http.Cookie{
-		//		Name:     "oauthstate",
-		//		HttpOnly: true,
-		//		Value:    state,
-		//		SameSite: http.SameSiteStrictMode, // Strict mode is the most secure option
-		//	}
-		//	http.SetCookie(w, &cookie)
-		//
-		//	res := m.(*v1.OAuthLoginResponse)
-		//	provider := res.Provider
-		//
-		//	var googleOauthConfig = &oauth2.Config{
-		//		RedirectURL:  res.CallbackUrl,
-		//		ClientID:     provider.ClientId,
-		//		ClientSecret: provider.ClientSecret,
-		//		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
-		//		Endpoint:     google.Endpoint,
-		//	}
-		//
-		//	err := store.Set(ctx, state)
-		//	if err != nil {
-		//		return err
-		//	}
-		//
-		//	redirectURL := googleOauthConfig.AuthCodeURL(state)
-		//	w.Header().Set("Location", redirectURL)
-		//	w.WriteHeader(http.StatusFound)
-		//}
+		switch res := m.(type) {
+		case *v1.LoginUsingIdpResponse:
+			// the callback checks the cookie against the state to reject logins started by another user agent
+			return setStateCookie(ctx, w, &store, res.GetState())
+		}
 
 		return nil
 	}
@@ -67,7 +37,7 @@ func InjectCookie(store CookieStore) func(ctx context.Context, w http.ResponseWr
 func ExtractCookie(store CookieStore) func(ctx context.Context, r *http.Request) metadata.MD {
 	return func(ctx context.Context, r *http.Request) metadata.MD {
 		logrus.Infof("authbase: extract cookie: %T", r)
-		cookie, err := r.Cookie("oauthstate")
+		cookie, err := r.Cookie(stateCookieName)
 		if err != nil {
 			return metadata.Pairs()
 		}
@@ -85,6 +55,33 @@ func ExtractCookie(store CookieStore) func(ctx context.Context, r *http.Request)
 	}
 }
 
+// stateStore remembers the login states handed out in cookies until the login times out
+type stateStore interface {
+	Exists(ctx context.Context, state string) (bool, error)
+	Set(ctx context.Context, state string) error
+	// Take forgets the state and reports whether it existed, a state is used only once
+	Take(ctx context.Context, state string) (bool, error)
+}
+
+// setStateCookie stores the state and sets it as cookie, it must be lax to come back with the provider redirect
+func setStateCookie(ctx context.Context, w http.ResponseWriter, store stateStore, state string) error {
+	if err := store.Set(ctx, state); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(stateCookieDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
 // CookieStore stores sessions using secure cookies.
 type CookieStore struct {
 	redis *cache.Redis
@@ -95,9 +92,24 @@ func NewCookieStore(redis *cache.Redis) CookieStore {
 }
 
 func (s *CookieStore) Exists(ctx context.Context, state string) (bool, error) {
-	return s.redis.SExists("oauthstate", state)
+	value, err := s.redis.Get(stateKey(state))
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+
+	return value != "", err
 }
 
+// Set stores the state under its own key, it expires with the state cookie
 func (s *CookieStore) Set(ctx context.Context, state string) error {
-	return s.redis.SAdd("oauthstate", state)
+	return s.redis.Set(stateKey(state), "1", stateCookieDuration)
+}
+
+func (s *CookieStore) Take(ctx context.Context, state string) (bool, error) {
+	value, err := s.redis.GetDel(stateKey(state))
+	return value != "", err
+}
+
+func stateKey(state string) string {
+	return "oauthstate:" + state
 }
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestCookieStore function to test the login states are kept under their own key and taken only once
+func TestCookieStore(t *testing.T) {
+	redis := cache.NewRedisClient()
+	if err := redis.Set("authbase:test", "ok", time.Second); err != nil {
+		t.Skipf("redis is not available: %v", err)
+	}
+	store := NewCookieStore(redis)
+	ctx := context.Background()
+
+	assert.NoError(t, store.Set(ctx, "state-1"))
+	assert.NoError(t, store.Set(ctx, "state-2"))
+	exists, err := store.Exists(ctx, "state-1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	taken, err := store.Take(ctx, "state-1")
+	assert.NoError(t, err)
+	assert.True(t, taken)
+	taken, err = store.Take(ctx, "state-1")
+	assert.NoError(t, err)
+	assert.False(t, taken)
+	exists, err = store.Exists(ctx, "state-1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// the other states are not touched
+	exists, _ = store.Exists(ctx, "state-2")
+	assert.True(t, exists)
+	taken, _ = store.Take(ctx, "unknown")
+	assert.False(t, taken)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+)
+
+// IdpHandler drives the browser side of the identity provider login: the redirect to the provider
+// with a state cookie, and the provider callback that returns the authbase tokens.
+type IdpHandler struct {
+	auth   v1.AuthServiceServer
+	states stateStore
+}
+
+// NewIdpHandler creates a new identity provider login handler.
+func NewIdpHandler(auth v1.AuthServiceServer, states stateStore) *IdpHandler {
+	return &IdpHandler{auth: auth, states: states}
+}
+
+// Register registers the identity provider login routes on the mux.
+func (h *IdpHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/idp/{provider}/login", h.login)
+	mux.HandleFunc("GET /auth/idp/{provider}/callback", h.callback)
+}
+
+// login sends the user agent to the consent page of the provider
+func (h *IdpHandler) login(w http.ResponseWriter, r *http.Request) {
+	res, err := h.auth.LoginUsingIdp(incomingContext(r), &v1.LoginUsingIdpRequest{
+		Provider: r.PathValue("provider"),
+		ClientId: r.URL.Query().Get("client_id"),
+	})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	if err := setStateCookie(r.Context(), w, h.states, res.GetState()); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, res.GetAuthUrl(), http.StatusFound)
+}
+
+// callback checks the state against the cookie and finishes the login
+func (h *IdpHandler) callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "identity provider login failed: "+reason, http.StatusBadRequest)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil || state == "" || cookie.Value != state {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	// the state is taken in one step, two callbacks racing with the same state can not both pass
+	exists, err := h.states.Take(r.Context(), state)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	if !exists {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookieName, Path: "/", MaxAge: -1, HttpOnly: true, Secure: true})
+
+	res, err := h.auth.LoginUsingIdpCallback(incomingContext(r), &v1.LoginUsingIdpCallbackRequest{
+		Provider: r.PathValue("provider"),
+		Code:     query.Get("code"),
+		State:    state,
+	})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	writeProtoJSON(w, res)
+}
+
+// writeProtoJSON writes the login response, it carries tokens and must not be cached
+func writeProtoJSON(w http.ResponseWriter, res proto.Message) {
+	data, err := protojson.Marshal(res)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(data); err != nil {
+		logrus.Errorf("authbase: failed to write response: %v", err)
+	}
+}
+
+// writeStatusError writes a service error with the http status of its grpc code
+func writeStatusError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := runtime.HTTPStatusFromCode(st.Code())
+	if code == http.StatusInternalServerError {
+		logrus.Errorf("authbase: login: %v", err)
+	}
+	http.Error(w, st.Message(), code)
+}
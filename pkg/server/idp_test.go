@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubAuthService answers the identity provider login rpcs with fixed responses
+type stubAuthService struct {
+	v1.UnimplementedAuthServiceServer
+}
+
+func (s *stubAuthService) LoginUsingIdp(ctx context.Context, request *v1.LoginUsingIdpRequest) (*v1.LoginUsingIdpResponse, error) {
+	return &v1.LoginUsingIdpResponse{AuthUrl: "https://idp.test/authorize?state=xyz", State: "xyz"}, nil
+}
+
+func (s *stubAuthService) LoginUsingIdpCallback(ctx context.Context, request *v1.LoginUsingIdpCallbackRequest) (*v1.LoginUsingIdpCallbackResponse, error) {
+	return &v1.LoginUsingIdpCallbackResponse{Token: &v1.AuthToken{AccessToken: "access-" + request.GetProvider()}}, nil
+}
+
+// memoryStateStore keeps the login states in memory
+type memoryStateStore map[string]bool
+
+func (m memoryStateStore) Exists(ctx context.Context, state string) (bool, error) {
+	return m[state], nil
+}
+
+func (m memoryStateStore) Set(ctx context.Context, state string) error {
+	m[state] = true
+	return nil
+}
+
+func (m memoryStateStore) Take(ctx context.Context, state string) (bool, error) {
+	exists := m[state]
+	delete(m, state)
+	return exists, nil
+}
+
+func TestIdpLogin(t *testing.T) {
+	states := memoryStateStore{}
+	mux := http.NewServeMux()
+	NewIdpHandler(&stubAuthService{}, states).Register(mux)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/auth/idp/github/login?client_id=client", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://idp.test/authorize?state=xyz", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "xyz", cookies[0].Value)
+	assert.True(t, states["xyz"])
+
+	// a callback without the state cookie was not started by this user agent
+	w = serve(httptest.NewRequest(http.MethodGet, "/auth/idp/github/callback?code=code&state=xyz", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/idp/github/callback?code=code&state=xyz", nil)
+	r.AddCookie(cookies[0])
+	w = serve(r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "access-github")
+	assert.False(t, states["xyz"])
+
+	// the state is used only once
+	r = httptest.NewRequest(http.MethodGet, "/auth/idp/github/callback?code=code&state=xyz", nil)
+	r.AddCookie(cookies[0])
+	w = serve(r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,8 +2,16 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/emrgen/authbase/x"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -34,3 +42,46 @@ func UnaryRequestTimeInterceptor() grpc.UnaryClientInterceptor {
 		return err
 	}
 }
+
+// outgoingHeaderMatcher sends the retry-after of the rate limited requests as the http Retry-After header,
+// the other grpc headers keep the default Grpc-Metadata- prefix
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == "retry-after" {
+		return "Retry-After", true
+	}
+
+	return fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, key), true
+}
+
+// RateLimitHandler limits the plain http routes of the mux with the quotas of the grpc methods, a route is named
+// by its pattern without the http method, like /oauth2/token. The gateway routes are limited by the grpc interceptor.
+func RateLimitHandler(limiter x.RateLimiter, limits *config.RateLimitConfig, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		route := pattern
+		if _, path, ok := strings.Cut(pattern, " "); ok {
+			route = path
+		}
+		quota, ok := limits.Quota(route)
+		if route == "/" || !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, wait, err := limiter.Allow(route+":"+x.RateLimitKey(incomingContext(r)), quota)
+		if err != nil {
+			// an unavailable backend does not take the api down
+			logrus.Errorf("authbase: rate limit: %v", err)
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, fmt.Sprintf("rate limit exceeded, retry after %d seconds", seconds), http.StatusTooManyRequests)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/emrgen/authbase/x"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimitHandler function to test the plain http routes share the limiter of the grpc methods
+func TestRateLimitHandler(t *testing.T) {
+	limits := &config.RateLimitConfig{
+		Default: config.RateQuota{Requests: 100, Period: time.Minute},
+		Methods: []config.MethodQuota{
+			{Name: "/oauth2/token", Quota: config.RateQuota{Requests: 1, Period: time.Minute}},
+			{Name: "/auth/idp/", Quota: config.RateQuota{Requests: 1, Period: time.Minute}},
+		},
+	}
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc("/", ok)
+	mux.HandleFunc("POST /oauth2/token", ok)
+	mux.HandleFunc("GET /auth/idp/{provider}/callback", ok)
+	handler := RateLimitHandler(x.NewMemoryRateLimiter(), limits, mux)
+
+	serve := func(method, target, remote, forwarded string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/oauth2/token", "198.51.100.4:52100", "").Code)
+	res := serve(http.MethodPost, "/oauth2/token", "198.51.100.4:52100", "")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+
+	// a forged forwarded address from an untrusted peer does not get a new quota, another peer does
+	assert.Equal(t, http.StatusTooManyRequests, serve(http.MethodPost, "/oauth2/token", "198.51.100.4:52101", "203.0.113.9").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/oauth2/token", "198.51.100.5:52100", "").Code)
+
+	// the route prefixes limit all the providers together
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/auth/idp/github/callback", "198.51.100.4:52100", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(http.MethodGet, "/auth/idp/google/callback", "198.51.100.4:52100", "").Code)
+
+	// the gateway routes are limited by the grpc interceptor
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/v1/accounts", "198.51.100.4:52100", "").Code)
+	}
+}
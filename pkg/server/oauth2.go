@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/service"
+	"github.com/emrgen/authbase/x"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// OAuth2Handler adapts the OAuth2 service to the standard form encoded endpoints (RFC 6749),
+// so that off-the-shelf OAuth2 clients can use authbase without the REST gateway payloads.
+type OAuth2Handler struct {
+	oauth2      v1.OAuth2ServiceServer
+	keyProvider x.JWTSignerVerifierProvider
+	revocations x.RevocationList
+}
+
+// NewOAuth2Handler creates a new OAuth2 handler, the key provider and the revocation list verify the userinfo bearer tokens.
+func NewOAuth2Handler(oauth2 v1.OAuth2ServiceServer, keyProvider x.JWTSignerVerifierProvider, revocations x.RevocationList) *OAuth2Handler {
+	return &OAuth2Handler{oauth2: oauth2, keyProvider: keyProvider, revocations: revocations}
+}
+
+// Register registers the OAuth2 routes on the mux.
+func (h *OAuth2Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /oauth2/authorize", h.authorize)
+	mux.HandleFunc("POST /oauth2/token", h.token)
+	mux.HandleFunc("POST /oauth2/introspect", h.introspect)
+	mux.HandleFunc("POST /oauth2/revoke", h.revoke)
+	mux.HandleFunc("GET /oauth2/userinfo", h.userinfo)
+	mux.HandleFunc("POST /oauth2/userinfo", h.userinfo)
+}
+
+// authorize validates the authorization request and sends the user agent to the login page
+func (h *OAuth2Handler) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	res, err := h.oauth2.OAuth2Authorize(incomingContext(r), &v1.OAuth2AuthorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientId:            query.Get("client_id"),
+		RedirectUri:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Nonce:               query.Get("nonce"),
+	})
+	if err != nil {
+		reason, description, info := oauth2ErrorInfo(err)
+		// errors are sent back to the client only once its redirect uri is trusted
+		redirectURI := info.GetMetadata()["redirect_uri"]
+		if redirectURI == "" {
+			http.Error(w, reason+": "+description, http.StatusBadRequest)
+			return
+		}
+
+		u, err := url.Parse(redirectURI)
+		if err != nil {
+			http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+			return
+		}
+		params := u.Query()
+		params.Set("error", reason)
+		params.Set("error_description", description)
+		if state := info.GetMetadata()["state"]; state != "" {
+			params.Set("state", state)
+		}
+		u.RawQuery = params.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, res.GetLoginUrl(), http.StatusFound)
+}
+
+// token exchanges a grant for tokens, the response follows RFC 6749 5.1 and 5.2
+func (h *OAuth2Handler) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuth2Error(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	clientID, clientSecret, err := clientCredentials(r)
+	if err != nil {
+		writeOAuth2Error(w, http.StatusUnauthorized, "invalid_client", "malformed client credentials")
+		return
+	}
+
+	res, err := h.oauth2.OAuth2Token(incomingContext(r), &v1.OAuth2TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectUri:  r.PostForm.Get("redirect_uri"),
+		ClientId:     clientID,
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		ClientSecret: clientSecret,
+		Scope:        r.PostForm.Get("scope"),
+	})
+	if err != nil {
+		writeTokenEndpointError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	response := map[string]interface{}{
+		"access_token": res.GetAccessToken(),
+		"token_type":   res.GetTokenType(),
+		"expires_in":   res.GetExpiresIn(),
+	}
+	if res.GetRefreshToken() != "" {
+		response["refresh_token"] = res.GetRefreshToken()
+	}
+	if res.GetScope() != "" {
+		response["scope"] = res.GetScope()
+	}
+	if res.GetIdToken() != "" {
+		response["id_token"] = res.GetIdToken()
+	}
+	writeJSON(w, response)
+}
+
+// introspect writes the state of the token (RFC 7662 2.2), inactive tokens only carry active false
+func (h *OAuth2Handler) introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuth2Error(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	clientID, clientSecret, err := clientCredentials(r)
+	if err != nil {
+		writeOAuth2Error(w, http.StatusUnauthorized, "invalid_client", "malformed client credentials")
+		return
+	}
+
+	res, err := h.oauth2.OAuth2Introspect(incomingContext(r), &v1.OAuth2IntrospectRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		ClientId:      clientID,
+		ClientSecret:  clientSecret,
+	})
+	if err != nil {
+		writeTokenEndpointError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !res.GetActive() {
+		writeJSON(w, map[string]interface{}{"active": false})
+		return
+	}
+
+	response := map[string]interface{}{
+		"active":     true,
+		"token_type": res.GetTokenType(),
+		"exp":        res.GetExp(),
+		"iat":        res.GetIat(),
+		"sub":        res.GetSub(),
+	}
+	for key, value := range map[string]string{
+		"scope":     res.GetScope(),
+		"client_id": res.GetClientId(),
+		"username":  res.GetUsername(),
+		"iss":       res.GetIss(),
+		"jti":       res.GetJti(),
+	} {
+		if value != "" {
+			response[key] = value
+		}
+	}
+	writeJSON(w, response)
+}
+
+// revoke revokes the token (RFC 7009 2.2), invalid tokens are answered with success too
+func (h *OAuth2Handler) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuth2Error(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	clientID, clientSecret, err := clientCredentials(r)
+	if err != nil {
+		writeOAuth2Error(w, http.StatusUnauthorized, "invalid_client", "malformed client credentials")
+		return
+	}
+
+	_, err = h.oauth2.OAuth2Revoke(incomingContext(r), &v1.OAuth2RevokeRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		ClientId:      clientID,
+		ClientSecret:  clientSecret,
+	})
+	if err != nil {
+		writeTokenEndpointError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// userinfo writes the claims of the bearer token account (OpenID Connect Core 5.3), errors follow RFC 6750 3
+func (h *OAuth2Handler) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeBearerError(w, http.StatusUnauthorized, "invalid_request", "missing bearer token")
+		return
+	}
+
+	// the plain http routes skip the grpc interceptors, the token is verified here
+	ctx, _, err := x.VerifyJwtToken(incomingContext(r), h.keyProvider, h.revocations, token)
+	if err != nil {
+		writeBearerError(w, http.StatusUnauthorized, "invalid_token", "invalid access token")
+		return
+	}
+
+	res, err := h.oauth2.OAuth2UserInfo(ctx, &v1.OAuth2UserInfoRequest{})
+	if err != nil {
+		reason, description, _ := oauth2ErrorInfo(err)
+		switch reason {
+		case "insufficient_scope", "unauthorized_client":
+			writeBearerError(w, http.StatusForbidden, "insufficient_scope", description)
+		case "server_error":
+			writeOAuth2Error(w, http.StatusInternalServerError, reason, description)
+		default:
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", description)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, &x.UserInfo{
+		Subject:           res.GetSub(),
+		Name:              res.GetName(),
+		PreferredUsername: res.GetPreferredUsername(),
+		Email:             res.GetEmail(),
+		EmailVerified:     res.EmailVerified,
+	})
+}
+
+// clientCredentials reads the client credentials from HTTP Basic auth or from the form parameters (RFC 6749 2.3.1)
+func clientCredentials(r *http.Request) (string, string, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), nil
+	}
+
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", err
+	}
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return "", "", err
+	}
+
+	return clientID, clientSecret, nil
+}
+
+// writeTokenEndpointError writes the error of the client authenticated endpoints (RFC 6749 5.2)
+func writeTokenEndpointError(w http.ResponseWriter, err error) {
+	reason, description, _ := oauth2ErrorInfo(err)
+	code := http.StatusBadRequest
+	switch reason {
+	case "invalid_client":
+		code = http.StatusUnauthorized
+	case "server_error":
+		code = http.StatusInternalServerError
+	}
+	writeOAuth2Error(w, code, reason, description)
+}
+
+// incomingContext builds the service context of a plain http request, the services expect incoming grpc metadata
+func incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		md.Set("authorization", auth)
+	}
+	// the remote address is the peer of the request, the services trust the forwarded addresses by it
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		md.Set("x-forwarded-for", strings.Join(forwarded, ", "))
+	}
+	ctx := r.Context()
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)})
+	}
+
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// oauth2ErrorInfo extracts the RFC 6749 error code from a service error, unexpected errors become server_error
+func oauth2ErrorInfo(err error) (string, string, *errdetails.ErrorInfo) {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == service.OAuth2ErrorDomain {
+			return info.GetReason(), st.Message(), info
+		}
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument, codes.NotFound:
+		return "invalid_request", st.Message(), nil
+	case codes.Unauthenticated:
+		return "invalid_client", st.Message(), nil
+	case codes.PermissionDenied:
+		return "unauthorized_client", st.Message(), nil
+	}
+
+	logrus.Errorf("authbase: oauth2: %v", err)
+	return "server_error", "internal server error", nil
+}
+
+func writeOAuth2Error(w http.ResponseWriter, code int, reason, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(map[string]string{
+		"error":             reason,
+		"error_description": description,
+	})
+	if err != nil {
+		logrus.Errorf("authbase: failed to write response: %v", err)
+	}
+}
+
+// writeBearerError writes a bearer token error with the WWW-Authenticate challenge of RFC 6750 3
+func writeBearerError(w http.ResponseWriter, code int, reason, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, error_description=%q`, reason, description))
+	writeOAuth2Error(w, code, reason, description)
+}
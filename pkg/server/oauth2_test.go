@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/service"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubOAuth2Service answers the OAuth2 rpcs with fixed responses
+type stubOAuth2Service struct {
+	err   error
+	token *v1.OAuth2TokenRequest // the last token request
+	v1.UnimplementedOAuth2ServiceServer
+}
+
+func (s *stubOAuth2Service) OAuth2Authorize(ctx context.Context, request *v1.OAuth2AuthorizeRequest) (*v1.OAuth2AuthorizeResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &v1.OAuth2AuthorizeResponse{RequestId: "req", LoginUrl: "http://login.test/login?request_id=req"}, nil
+}
+
+func (s *stubOAuth2Service) OAuth2Introspect(ctx context.Context, request *v1.OAuth2IntrospectRequest) (*v1.OAuth2IntrospectResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if request.GetToken() != "active" {
+		return &v1.OAuth2IntrospectResponse{Active: false}, nil
+	}
+	return &v1.OAuth2IntrospectResponse{Active: true, TokenType: "access_token", Sub: "account", ClientId: request.GetClientId(), Exp: 100}, nil
+}
+
+func (s *stubOAuth2Service) OAuth2UserInfo(ctx context.Context, request *v1.OAuth2UserInfoRequest) (*v1.OAuth2UserInfoResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := "Jane Doe"
+	return &v1.OAuth2UserInfoResponse{Sub: accountID.String(), Name: &name}, nil
+}
+
+func (s *stubOAuth2Service) OAuth2Token(ctx context.Context, request *v1.OAuth2TokenRequest) (*v1.OAuth2TokenResponse, error) {
+	s.token = request
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &v1.OAuth2TokenResponse{AccessToken: "access", TokenType: "Bearer", ExpiresIn: 60, Scope: request.GetClientId()}, nil
+}
+
+func oauth2TestError(reason string, metadata map[string]string) error {
+	st, _ := status.New(codes.InvalidArgument, "failed").WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   service.OAuth2ErrorDomain,
+		Metadata: metadata,
+	})
+	return st.Err()
+}
+
+func serveOAuth2(svc v1.OAuth2ServiceServer, r *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	NewOAuth2Handler(svc, nil, nil).Register(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestOAuth2AuthorizeRedirects(t *testing.T) {
+	w := serveOAuth2(&stubOAuth2Service{}, httptest.NewRequest(http.MethodGet, "/oauth2/authorize?client_id=c", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://login.test/login?request_id=req", w.Header().Get("Location"))
+
+	// errors are redirected to a trusted redirect uri with the state
+	svc := &stubOAuth2Service{err: oauth2TestError("unsupported_response_type", map[string]string{"redirect_uri": "http://app.test/cb", "state": "xyz"})}
+	w = serveOAuth2(svc, httptest.NewRequest(http.MethodGet, "/oauth2/authorize", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app.test", location.Host)
+	assert.Equal(t, "unsupported_response_type", location.Query().Get("error"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+
+	// without a trusted redirect uri the error is shown to the user
+	svc = &stubOAuth2Service{err: oauth2TestError("invalid_request", nil)}
+	w = serveOAuth2(svc, httptest.NewRequest(http.MethodGet, "/oauth2/authorize", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOAuth2Token(t *testing.T) {
+	form := url.Values{"grant_type": {"authorization_code"}, "client_id": {"client"}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := serveOAuth2(&stubOAuth2Service{}, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "access", res["access_token"])
+	assert.Equal(t, "client", res["scope"])
+	assert.NotContains(t, res, "refresh_token")
+
+	r = httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = serveOAuth2(&stubOAuth2Service{err: oauth2TestError("invalid_grant", nil)}, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "invalid_grant", res["error"])
+}
+
+func TestOAuth2TokenClientCredentials(t *testing.T) {
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"read write"}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("client", url.QueryEscape("s3cr3t/+"))
+	svc := &stubOAuth2Service{}
+	w := serveOAuth2(svc, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client", svc.token.GetClientId())
+	assert.Equal(t, "s3cr3t/+", svc.token.GetClientSecret())
+	assert.Equal(t, "read write", svc.token.GetScope())
+
+	r = httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("client", "%zz")
+	w = serveOAuth2(svc, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// testKeyProvider signs and verifies the tokens of every pool with one key
+type testKeyProvider struct {
+	key crypto.Signer
+}
+
+func (p *testKeyProvider) GetSigner(id string) (x.JWTSigner, error) {
+	return x.NewKeySigner("kid-1", "ES256", p.key)
+}
+
+func (p *testKeyProvider) GetVerifier(id string) (x.JWTVerifier, error) {
+	return x.NewKeySetVerifier(func(kid string) (crypto.PublicKey, string, error) {
+		return p.key.Public(), "ES256", nil
+	}), nil
+}
+
+// testRevocationList is an in-memory revocation list of jti
+type testRevocationList map[string]bool
+
+func (l testRevocationList) RevokeToken(jti string, expireAt time.Time) error {
+	l[jti] = true
+	return nil
+}
+
+func (l testRevocationList) RevokeSession(sessionID string) error { return nil }
+
+func (l testRevocationList) RevokeAccount(accountID string) error { return nil }
+
+func (l testRevocationList) Revoked(claims *x.Claims) (bool, error) {
+	return l[claims.Jti], nil
+}
+
+func TestOAuth2UserInfo(t *testing.T) {
+	key, err := x.GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+	keys := &testKeyProvider{key: key}
+	signer, _ := keys.GetSigner("")
+	accountID := uuid.New().String()
+	jti := uuid.New().String()
+	token, err := x.GenerateAccessToken(&x.Claims{
+		Jti:       jti,
+		AccountID: accountID,
+		ProjectID: uuid.New().String(),
+		PoolID:    uuid.New().String(),
+		ExpireAt:  time.Now().Add(time.Minute),
+		Scopes:    []string{x.ScopeOpenID, x.ScopeProfile},
+	}, signer)
+	assert.NoError(t, err)
+
+	revocations := testRevocationList{}
+	serve := func(svc v1.OAuth2ServiceServer, token string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		NewOAuth2Handler(svc, keys, revocations).Register(mux)
+		r := httptest.NewRequest(http.MethodGet, "/oauth2/userinfo", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(&stubOAuth2Service{}, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, accountID, res["sub"])
+	assert.Equal(t, "Jane Doe", res["name"])
+	assert.NotContains(t, res, "email")
+
+	w = serve(&stubOAuth2Service{}, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+
+	w = serve(&stubOAuth2Service{}, token+"x")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	w = serve(&stubOAuth2Service{err: oauth2TestError("insufficient_scope", nil)}, token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// a refresh token is not an access token although it carries the same claims
+	refresh, err := x.GenerateJWTToken(&x.Claims{
+		Jti:       uuid.New().String(),
+		AccountID: accountID,
+		ProjectID: uuid.New().String(),
+		PoolID:    uuid.New().String(),
+		ExpireAt:  time.Now().Add(time.Minute),
+	}, signer, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	w = serve(&stubOAuth2Service{}, refresh.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// a revoked token is rejected although its signature is valid
+	assert.NoError(t, revocations.RevokeToken(jti, time.Now().Add(time.Minute)))
+	w = serve(&stubOAuth2Service{}, token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+func TestOAuth2Introspect(t *testing.T) {
+	introspect := func(svc v1.OAuth2ServiceServer, token string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}}
+		r := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth("client", "secret")
+		return serveOAuth2(svc, r)
+	}
+
+	w := introspect(&stubOAuth2Service{}, "active")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, true, res["active"])
+	assert.Equal(t, "client", res["client_id"])
+	assert.Equal(t, "access_token", res["token_type"])
+	assert.NotContains(t, res, "username")
+
+	// inactive tokens carry no other member
+	w = introspect(&stubOAuth2Service{}, "revoked")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"active":false}`, w.Body.String())
+
+	w = introspect(&stubOAuth2Service{err: oauth2TestError("invalid_client", nil)}, "active")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestIncomingContextIP function to test the http routes find the caller like the grpc services
+func TestIncomingContextIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/oauth2/token", nil)
+	r.RemoteAddr = "198.51.100.4:52100"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	assert.Equal(t, "198.51.100.4", x.RequestIP(incomingContext(r)))
+
+	// the loopback proxy is trusted, the client address it forwards is the caller
+	r.RemoteAddr = "127.0.0.1:52100"
+	assert.Equal(t, "203.0.113.9", x.RequestIP(incomingContext(r)))
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// SamlHandler serves the SAML service provider endpoints of the pools: the metadata,
+// the redirect to the identity provider and the assertion consumer service.
+type SamlHandler struct {
+	auth v1.AuthServiceServer
+	saml v1.SamlServiceServer
+}
+
+// NewSamlHandler creates a new SAML service provider handler.
+func NewSamlHandler(auth v1.AuthServiceServer, saml v1.SamlServiceServer) *SamlHandler {
+	return &SamlHandler{auth: auth, saml: saml}
+}
+
+// Register registers the SAML routes on the mux.
+func (h *SamlHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/saml/{pool}/metadata", h.metadata)
+	mux.HandleFunc("GET /auth/saml/login", h.login)
+	mux.HandleFunc("POST /auth/saml/{pool}/acs", h.acs)
+}
+
+// metadata writes the service provider metadata xml of the pool
+func (h *SamlHandler) metadata(w http.ResponseWriter, r *http.Request) {
+	res, err := h.saml.GetSamlMetadata(incomingContext(r), &v1.GetSamlMetadataRequest{PoolId: r.PathValue("pool")})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	if _, err := w.Write([]byte(res.GetMetadata())); err != nil {
+		logrus.Errorf("authbase: failed to write response: %v", err)
+	}
+}
+
+// login sends the user agent to the identity provider of the client pool
+func (h *SamlHandler) login(w http.ResponseWriter, r *http.Request) {
+	res, err := h.auth.LoginUsingSaml(incomingContext(r), &v1.LoginUsingSamlRequest{
+		ClientId: r.URL.Query().Get("client_id"),
+	})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, res.GetRedirectUrl(), http.StatusFound)
+}
+
+// acs consumes the SAML response posted by the identity provider.
+// The response is posted cross site, so the login is bound to the relay state and the request id instead of a cookie.
+func (h *SamlHandler) acs(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.auth.LoginUsingSamlCallback(incomingContext(r), &v1.LoginUsingSamlCallbackRequest{
+		PoolId:       r.PathValue("pool"),
+		SamlResponse: r.PostForm.Get("SAMLResponse"),
+		RelayState:   r.PostForm.Get("RelayState"),
+	})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	writeProtoJSON(w, res)
+}
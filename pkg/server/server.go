@@ -6,6 +6,7 @@ import (
 	"fmt"
 	gatewayfile "github.com/black-06/grpc-gateway-file"
 	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/keymanager"
 	"github.com/emrgen/authbase/pkg/cache"
 	"github.com/emrgen/authbase/pkg/config"
 	"github.com/emrgen/authbase/pkg/permission"
@@ -48,6 +49,12 @@ type Server struct {
 	rl              net.Listener
 	grpcServer      *grpc.Server
 	mux             *runtime.ServeMux
+	keys            *keymanager.PrivateRegistry
+	oauth2          v1.OAuth2ServiceServer
+	auth            v1.AuthServiceServer
+	saml            v1.SamlServiceServer
+	cookies         CookieStore
+	limiter         x.RateLimiter // shared by the grpc methods and the plain http routes, nil when off
 	httpPort        string
 	grpcPort        string
 	ready           chan struct{}
@@ -98,10 +105,14 @@ func (s *Server) init(grpcPort, httpPort string) error {
 	s.redis = cache.NewRedisClient()
 	//s.permission = permission.NewStoreBasedPermission(s.provider)
 	s.permission = permission.NewStoreBasedPermission(s.provider)
-	s.mailer = mail.NewMailerProvider("smtp.gmail.com", 587, "", "")
+	mailer, err := mail.NewMailerProvider(s.config.Mail, service.ProjectMailSenders(s.provider, s.config.AppKey))
+	if err != nil {
+		return err
+	}
+	s.mailer = mailer
 
 	// migrate the database
-	err := db.Migrate()
+	err = db.Migrate()
 	if err != nil {
 		return err
 	}
@@ -127,19 +138,40 @@ func (s *Server) init(grpcPort, httpPort string) error {
 // register the services with the grpc server
 func (s *Server) registerServices() error {
 	var err error
-	keyProvider := x.NewStaticKeyProvider(x.JWTSecretFromEnv())
-	verifier := x.NewStoreBasedTokenVerifier(s.provider, s.redis)
+	jwtConfig := s.config.JWT
+	keyProvider := keymanager.NewPrivateRegistry(s.provider, jwtConfig.Algorithm, jwtConfig.KeyRotation)
+	go keyProvider.Run()
+	s.keys = keyProvider
+	verifier := x.NewStoreBasedTokenVerifier(s.provider, s.redis, keyProvider)
+	revocations := x.NewCacheRevocationList(s.redis)
+
+	interceptors := []grpc.UnaryServerInterceptor{
+		grpcvalidator.UnaryServerInterceptor(),
+		x.AuthInterceptor(verifier, keyProvider, revocations, s.provider),
+	}
+	// the requests are limited after the authentication to count them per caller
+	switch s.config.RateLimit.Backend {
+	case "memory":
+		s.limiter = x.NewMemoryRateLimiter()
+	case "redis":
+		s.limiter = x.NewCacheRateLimiter(s.redis)
+	}
+	if s.limiter != nil {
+		interceptors = append(interceptors, x.RateLimitInterceptor(s.limiter, s.config.RateLimit))
+	}
+	interceptors = append(interceptors, UnaryGrpcRequestTimeInterceptor())
 
 	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(grpcmiddleware.ChainUnaryServer(
-			grpcvalidator.UnaryServerInterceptor(),
-			x.AuthInterceptor(verifier, keyProvider, s.provider),
-			UnaryGrpcRequestTimeInterceptor(),
+		grpc.UnaryInterceptor(grpcmiddleware.ChainUnaryServer(interceptors...)),
+		grpc.StreamInterceptor(grpcmiddleware.ChainStreamServer(
+			grpcvalidator.StreamServerInterceptor(),
+			x.AuthStreamInterceptor(verifier, keyProvider, revocations),
 		)),
 	)
 	s.grpcServer = grpcServer
 
 	cookieStore := NewCookieStore(s.redis)
+	s.cookies = cookieStore
 
 	// connect the rest gateway to the grpc server
 	s.mux = runtime.NewServeMux(
@@ -155,6 +187,7 @@ func (s *Server) registerServices() error {
 		}),
 		gatewayfile.WithHTTPBodyMarshaler(),
 		runtime.WithForwardResponseOption(InjectCookie(cookieStore)),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
 		//runtime.WithMetadata(ExtractCookie(cookieStore)),
 	)
 
@@ -171,9 +204,10 @@ func (s *Server) registerServices() error {
 
 	// Register the grpc services
 	v1.RegisterAdminProjectServiceServer(grpcServer, service.NewAdminProjectService(s.provider, redis))
-	v1.RegisterProjectServiceServer(grpcServer, service.NewProjectService(perm, s.provider, redis))
+	v1.RegisterProjectServiceServer(grpcServer, service.NewProjectService(perm, s.provider, redis, s.config.AppKey))
 	v1.RegisterClientServiceServer(grpcServer, service.NewClientService(perm, s.provider, secrets))
-	v1.RegisterAuthServiceServer(grpcServer, service.NewAuthService(s.provider, keyProvider, perm, s.mailer, redis, verifier))
+	s.auth = service.NewAuthService(s.provider, keyProvider, perm, s.mailer, redis, verifier, s.config.PublicURL, s.config.AppKey)
+	v1.RegisterAuthServiceServer(grpcServer, s.auth)
 	v1.RegisterAccountServiceServer(grpcServer, service.NewAccountService(perm, s.provider, redis))
 	v1.RegisterAccessKeyServiceServer(grpcServer, service.NewAccessKeyService(perm, s.provider, redis, keyProvider, verifier))
 	v1.RegisterPoolServiceServer(grpcServer, service.NewPoolService(s.provider, perm))
@@ -184,6 +218,13 @@ func (s *Server) registerServices() error {
 	v1.RegisterApplicationServiceServer(grpcServer, service.NewApplicationService(s.provider))
 	v1.RegisterProjectMemberServiceServer(grpcServer, service.NewProjectMemberService(perm, s.provider, redis))
 	v1.RegisterAdminAuthServiceServer(grpcServer, service.NewAdminAuthService(s.provider, s.config.AdminOrg, keyProvider, redis))
+	v1.RegisterPublicKeyServiceServer(grpcServer, service.NewPublicKeyService(s.provider))
+	v1.RegisterSessionServiceServer(grpcServer, service.NewSessionService(s.provider, perm, redis))
+	s.oauth2 = service.NewOAuth2Service(s.provider, keyProvider, redis, s.config.LoginURL, s.config.PublicURL)
+	v1.RegisterOAuth2ServiceServer(grpcServer, s.oauth2)
+	s.saml = service.NewSamlService(s.provider, s.config.PublicURL)
+	v1.RegisterSamlServiceServer(grpcServer, s.saml)
+	v1.RegisterEmailTemplateServiceServer(grpcServer, service.NewEmailTemplateService(perm, s.provider))
 
 	// Register the http gateway
 	if err = v1.RegisterAdminProjectServiceHandlerFromEndpoint(context.TODO(), s.mux, endpoint, opts); err != nil {
@@ -233,6 +274,26 @@ func (s *Server) registerServices() error {
 		return err
 	}
 
+	if err = v1.RegisterPublicKeyServiceHandlerFromEndpoint(context.TODO(), s.mux, endpoint, opts); err != nil {
+		return err
+	}
+
+	if err = v1.RegisterSessionServiceHandlerFromEndpoint(context.TODO(), s.mux, endpoint, opts); err != nil {
+		return err
+	}
+
+	if err = v1.RegisterOAuth2ServiceHandlerFromEndpoint(context.TODO(), s.mux, endpoint, opts); err != nil {
+		return err
+	}
+
+	if err = v1.RegisterSamlServiceHandlerFromEndpoint(context.TODO(), s.mux, endpoint, opts); err != nil {
+		return err
+	}
+
+	if err = v1.RegisterEmailTemplateServiceHandlerFromEndpoint(context.TODO(), s.mux, endpoint, opts); err != nil {
+		return err
+	}
+
 	return err
 }
 
@@ -243,6 +304,10 @@ func (s *Server) run() error {
 	openapiDocs := packr.NewBox("../../docs/v1")
 	apiMux.Handle(docsPath, http.StripPrefix(docsPath, http.FileServer(openapiDocs)))
 	apiMux.Handle("/", s.mux)
+	NewWellKnownHandler(s.keys, s.provider, s.config.PublicURL, s.config.JWT.Algorithm).Register(apiMux)
+	NewOAuth2Handler(s.oauth2, s.keys, x.NewCacheRevocationList(s.redis)).Register(apiMux)
+	NewIdpHandler(s.auth, &s.cookies).Register(apiMux)
+	NewSamlHandler(s.auth, s.saml).Register(apiMux)
 
 	grpclog.SetLoggerV2(grpclog.NewLoggerV2(io.Discard, io.Discard, io.Discard))
 
@@ -254,9 +319,15 @@ func (s *Server) run() error {
 		AllowCredentials: true,
 	})
 
+	// the plain http routes share the quotas of the grpc methods
+	var handler http.Handler = apiMux
+	if s.limiter != nil {
+		handler = RateLimitHandler(s.limiter, s.config.RateLimit, apiMux)
+	}
+
 	restServer := &http.Server{
 		Addr:    s.httpPort,
-		Handler: c.Handler(apiMux),
+		Handler: c.Handler(handler),
 	}
 
 	// make sure to wait for the servers to stop before exiting
@@ -320,6 +391,11 @@ func (s *Server) run() error {
 		os.Exit(0)
 	}
 
+	// remove the expired sessions and refresh tokens in the background
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go service.NewSessionSweeper(s.provider, x.ScheduleRefreshTokenExpiry).Run(sweepCtx)
+
 	go func() {
 		// wait for 1sec
 		time.Sleep(100 * time.Millisecond)
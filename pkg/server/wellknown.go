@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/emrgen/authbase/keymanager"
+	"github.com/emrgen/authbase/pkg/service"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// OpenIDConfiguration is the OpenID provider metadata of a pool (OpenID Connect Discovery 1.0)
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+}
+
+// WellKnownHandler serves the pool key sets and discovery documents, so that any JWT library can verify the tokens offline.
+type WellKnownHandler struct {
+	keys      *keymanager.PrivateRegistry
+	store     store.Provider
+	publicURL string
+	algorithm string
+}
+
+// NewWellKnownHandler creates a new well-known handler.
+func NewWellKnownHandler(keys *keymanager.PrivateRegistry, store store.Provider, publicURL, algorithm string) *WellKnownHandler {
+	return &WellKnownHandler{
+		keys:      keys,
+		store:     store,
+		publicURL: publicURL,
+		algorithm: algorithm,
+	}
+}
+
+// Register registers the well-known routes on the mux.
+// The project routes redirect to the documents of the project master pool.
+func (h *WellKnownHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /pools/{pool_id}/.well-known/jwks.json", h.jwks)
+	mux.HandleFunc("GET /pools/{pool_id}/.well-known/openid-configuration", h.openIDConfiguration)
+	mux.HandleFunc("GET /projects/{project_id}/.well-known/jwks.json", h.projectRedirect("/.well-known/jwks.json"))
+	mux.HandleFunc("GET /projects/{project_id}/.well-known/openid-configuration", h.projectRedirect("/.well-known/openid-configuration"))
+}
+
+// jwks writes the unretired keys of the pool, rotated keys stay in the set until they retire
+func (h *WellKnownHandler) jwks(w http.ResponseWriter, r *http.Request) {
+	poolID, err := h.poolID(r.Context(), r.PathValue("pool_id"))
+	if err != nil {
+		writeWellKnownError(w, err)
+		return
+	}
+
+	keys, err := h.keys.PublicKeys(poolID)
+	if err != nil {
+		writeWellKnownError(w, err)
+		return
+	}
+
+	set := &x.JWKS{Keys: make([]*x.JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := x.NewJWK(key.ID, key.Algorithm, key.PublicKey())
+		if err != nil {
+			logrus.Errorf("authbase: failed to encode key %s: %v", key.ID, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	// keep the cache short so that verifiers pick up a rotated key quickly
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, set)
+}
+
+// openIDConfiguration writes the discovery document of the pool
+func (h *WellKnownHandler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	poolID, err := h.poolID(r.Context(), r.PathValue("pool_id"))
+	if err != nil {
+		writeWellKnownError(w, err)
+		return
+	}
+
+	issuer := x.PoolIssuer(h.publicURL, poolID)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, &OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             h.publicURL + "/oauth2/authorize",
+		TokenEndpoint:                     h.publicURL + "/oauth2/token",
+		UserinfoEndpoint:                  h.publicURL + "/oauth2/userinfo",
+		IntrospectionEndpoint:             h.publicURL + "/oauth2/introspect",
+		RevocationEndpoint:                h.publicURL + "/oauth2/revoke",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{h.algorithm},
+		ScopesSupported:                   []string{x.ScopeOpenID, x.ScopeProfile, x.ScopeEmail},
+		GrantTypesSupported:               []string{service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken, service.GrantTypeClientCredentials},
+		CodeChallengeMethodsSupported:     []string{x.CodeChallengeMethodS256},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "name", "preferred_username", "email", "email_verified"},
+	})
+}
+
+// projectRedirect redirects a project scoped document to the document of the project master pool
+func (h *WellKnownHandler) projectRedirect(document string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projectID, err := uuid.Parse(r.PathValue("project_id"))
+		if err != nil {
+			writeWellKnownError(w, store.ErrProjectNotFound)
+			return
+		}
+
+		pool, err := h.store.Default().GetMasterPool(r.Context(), projectID)
+		if err != nil {
+			writeWellKnownError(w, store.ErrProjectNotFound)
+			return
+		}
+
+		http.Redirect(w, r, x.PoolIssuer(h.publicURL, pool.ID)+document, http.StatusFound)
+	}
+}
+
+// poolID checks that the pool exists and returns its normalized id
+func (h *WellKnownHandler) poolID(ctx context.Context, id string) (string, error) {
+	poolID, err := uuid.Parse(id)
+	if err != nil {
+		return "", errPoolNotFound
+	}
+
+	pool, err := h.store.Default().GetPoolByID(ctx, poolID)
+	if err != nil {
+		return "", errPoolNotFound
+	}
+
+	return pool.ID, nil
+}
+
+var errPoolNotFound = errors.New("pool not found")
+
+func writeWellKnownError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPoolNotFound) || errors.Is(err, store.ErrProjectNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logrus.Errorf("authbase: well-known: %v", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("authbase: failed to write response: %v", err)
+	}
+}
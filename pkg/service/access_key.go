@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -67,14 +68,35 @@ func (t *AccessKeyService) CreateAccessKey(ctx context.Context, request *v1.Crea
 		return nil, err
 	}
 
-	expireAfter := defaultAccessKeyExpireIn
-	if request.GetExpiresIn() != 0 {
-		// expiry time can not be more than 120 days
-		// TODO: this should be configurable in the future
-		if request.GetExpiresIn() > 60*24*60*60 {
-			return nil, errors.New("expires_in can not be more than 60 days")
+	// the email and password of the request are a login, they pass the checks of the password login.
+	// The accounts with a second factor log in first and create the key with their access token
+	if x.IsAuthbasePasswordAuth(ctx) {
+		account, err := as.GetAccountByID(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkEmailVerified(ctx, as, account); err != nil {
+			return nil, err
+		}
+		if err := checkPasswordAge(ctx, as, account); err != nil {
+			return nil, err
+		}
+		if err := checkMfaNotRequired(ctx, as, account); err != nil {
+			return nil, err
 		}
+	}
+
+	// the longest access key is set by the token policy of the pool
+	lifetimes, err := resolveTokenLifetimes(ctx, as, poolID.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	expireAfter := min(defaultAccessKeyExpireIn, lifetimes.accessKeyMax)
+	if request.GetExpiresIn() != 0 {
 		expireAfter = time.Second * time.Duration(request.GetExpiresIn())
+		if expireAfter > lifetimes.accessKeyMax {
+			return nil, fmt.Errorf("expires_in can not be more than %d seconds", int64(lifetimes.accessKeyMax/time.Second))
+		}
 	}
 
 	//perm, err := as.GetProjectMemberByID(ctx, clientID, accountID)
@@ -90,7 +112,7 @@ func (t *AccessKeyService) CreateAccessKey(ctx context.Context, request *v1.Crea
 	expireAt := time.Now().Add(expireAfter)
 	token := x.NewAccessKey()
 
-	project, err := as.GetPoolByID(ctx, poolID)
+	pool, err := as.GetPoolByID(ctx, poolID)
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +122,7 @@ func (t *AccessKeyService) CreateAccessKey(ctx context.Context, request *v1.Crea
 		ID:        token.ID.String(),
 		AccountID: accountID.String(),
 		PoolID:    poolID.String(),
-		ProjectID: project.ID,
+		ProjectID: pool.ProjectID,
 		Name:      request.GetName(),
 		Token:     token.Value,
 		Scopes:    strings.Join(scopes, ","),
@@ -122,7 +144,7 @@ func (t *AccessKeyService) CreateAccessKey(ctx context.Context, request *v1.Crea
 
 	// save the token into the database
 	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		err = t.cache.Set(token.ID.String(), accessKey.Token, defaultAccessKeyExpireIn)
+		err = t.cache.Set(token.ID.String(), accessKey.Token, expireAfter)
 		if err != nil {
 			return err
 		}
@@ -330,9 +352,18 @@ func (t *AccessKeyService) GetTokenFromAccessKey(ctx context.Context, request *v
 		return nil, err
 	}
 
+	as, err := store.GetProjectStore(ctx, t.store)
+	if err != nil {
+		return nil, err
+	}
+	lifetimes, err := resolveTokenLifetimes(ctx, as, claims.PoolID, "")
+	if err != nil {
+		return nil, err
+	}
+
 	claims.Jti = uuid.New().String()
 	claims.IssuedAt = time.Now()
-	claims.ExpireAt = time.Now().Add(x.AccessTokenDuration)
+	claims.ExpireAt = time.Now().Add(lifetimes.accessToken)
 
 	signer, err := t.keyProvider.GetSigner(claims.PoolID)
 	if err != nil {
@@ -340,7 +371,7 @@ func (t *AccessKeyService) GetTokenFromAccessKey(ctx context.Context, request *v
 	}
 
 	// generate the token from the claims
-	jwtToken, err := x.GenerateJWTToken(claims, signer)
+	jwtToken, err := x.GenerateJWTToken(claims, signer, time.Now().Add(lifetimes.refreshToken))
 	if err != nil {
 		return nil, err
 	}
@@ -14,6 +14,7 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"strings"
+	"time"
 )
 
 var _ v1.AccountServiceServer = new(AccountService)
@@ -22,12 +23,15 @@ type AccountService struct {
 	perm  permission.AuthBasePermission
 	store store.Provider
 	cache *cache.Redis
+	// revocations rejects the tokens of the disabled accounts
+	revocations x.RevocationList
+	guard       x.LoginGuard
 	v1.UnimplementedAccountServiceServer
 }
 
 // NewAccountService creates a new user service.
 func NewAccountService(perm permission.AuthBasePermission, store store.Provider, cache *cache.Redis) v1.AccountServiceServer {
-	return &AccountService{perm: perm, store: store, cache: cache}
+	return &AccountService{perm: perm, store: store, cache: cache, revocations: x.NewCacheRevocationList(cache), guard: x.NewCacheLoginGuard(cache)}
 }
 
 // CreateAccount creates a new user.
@@ -85,10 +89,9 @@ func (u *AccountService) CreateAccount(ctx context.Context, request *v1.CreateAc
 
 	password := request.GetPassword()
 	if password != "" {
-		salt := x.GenerateSalt()
-		hashedPassword := x.HashPassword(password, salt)
-		user.PasswordHash = string(hashedPassword)
-		user.Salt = salt
+		if err := setAccountPassword(ctx, as, &user, "password", password); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := as.CreateAccount(ctx, &user); err != nil {
@@ -431,12 +434,19 @@ func (u *AccountService) DisableAccount(ctx context.Context, request *v1.Disable
 	}
 
 	user.Disabled = true
+	user.DisabledAt = time.Now()
 
 	err = as.UpdateAccount(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
+	// the issued tokens are rejected right away instead of when they expire
+	err = u.revocations.RevokeAccount(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &v1.DisableAccountResponse{
 		Message: "Account disabled successfully.",
 	}, nil
@@ -474,3 +484,73 @@ func (u *AccountService) EnableAccount(ctx context.Context, request *v1.EnableAc
 		Message: "Account enabled successfully.",
 	}, nil
 }
+
+// GetAccountLockout returns the failed password attempts and the lockout of the account
+func (u *AccountService) GetAccountLockout(ctx context.Context, request *v1.GetAccountLockoutRequest) (*v1.GetAccountLockoutResponse, error) {
+	as, err := store.GetProjectStore(ctx, u.store)
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := uuid.Parse(request.GetAccountId())
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.perm.CheckProjectPermission(ctx, uuid.MustParse(account.ProjectID), "read")
+	if err != nil {
+		return nil, err
+	}
+
+	lockout, err := u.guard.Lockout(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutProto := &v1.AccountLockout{
+		FailedAttempts: uint32(lockout.FailedAttempts),
+		Locked:         lockout.Locked(),
+	}
+	if !lockout.LockedUntil.IsZero() {
+		lockoutProto.LockedUntil = timestamppb.New(lockout.LockedUntil)
+	}
+	if !lockout.RetryAfter.IsZero() {
+		lockoutProto.RetryAfter = timestamppb.New(lockout.RetryAfter)
+	}
+
+	return &v1.GetAccountLockoutResponse{Lockout: lockoutProto}, nil
+}
+
+// UnlockAccount clears the failed password attempts of the account, a locked account can log in again right away
+func (u *AccountService) UnlockAccount(ctx context.Context, request *v1.UnlockAccountRequest) (*v1.UnlockAccountResponse, error) {
+	as, err := store.GetProjectStore(ctx, u.store)
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := uuid.Parse(request.GetAccountId())
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.perm.CheckProjectPermission(ctx, uuid.MustParse(account.ProjectID), "write")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.guard.Unlock(account.ID); err != nil {
+		return nil, err
+	}
+
+	return &v1.UnlockAccountResponse{
+		Message: "Account unlocked successfully.",
+	}, nil
+}
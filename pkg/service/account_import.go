@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+	"time"
+)
+
+// importBatchSize is the number of accounts created in one transaction
+const importBatchSize = 500
+
+// exportPageSize is the number of accounts read from the store at a time
+const exportPageSize = 500
+
+// accountImporter validates the imported rows of a pool and creates them in batches
+type accountImporter struct {
+	as        store.AuthBaseStore
+	poolID    uuid.UUID
+	projectID string
+	// groups caches the pool groups by name
+	groups map[string]*model.Group
+	// emails are the emails of the file seen so far, the duplicates in the file fail
+	emails   map[string]bool
+	batch    []*v1.ImportAccountsRequest
+	response *v1.ImportAccountsResponse
+}
+
+// ImportAccounts creates the accounts streamed by the client in a pool. The password hashes are stored as they are,
+// the accounts log in with their old passwords and the hashes are upgraded on the first login.
+// A row that fails does not stop the import, its error is returned with the row number.
+func (u *AccountService) ImportAccounts(stream grpc.ClientStreamingServer[v1.ImportAccountsRequest, v1.ImportAccountsResponse]) error {
+	ctx := stream.Context()
+	as, err := store.GetProjectStore(ctx, u.store)
+	if err != nil {
+		return err
+	}
+
+	var importer *accountImporter
+	for {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// the first message selects the pool
+		if importer == nil {
+			importer, err = u.newAccountImporter(ctx, as, request.GetPoolId())
+			if err != nil {
+				return err
+			}
+		}
+
+		importer.batch = append(importer.batch, request)
+		if len(importer.batch) >= importBatchSize {
+			if err := importer.flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if importer == nil {
+		return stream.SendAndClose(&v1.ImportAccountsResponse{})
+	}
+	if err := importer.flush(ctx); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(importer.response)
+}
+
+func (u *AccountService) newAccountImporter(ctx context.Context, as store.AuthBaseStore, pool string) (*accountImporter, error) {
+	poolID, err := uuid.Parse(pool)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "pool_id is required in the first message")
+	}
+
+	p, err := as.GetPoolByID(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.perm.CheckProjectPermission(ctx, uuid.MustParse(p.ProjectID), "write")
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountImporter{
+		as:        as,
+		poolID:    poolID,
+		projectID: p.ProjectID,
+		groups:    make(map[string]*model.Group),
+		emails:    make(map[string]bool),
+		response:  &v1.ImportAccountsResponse{},
+	}, nil
+}
+
+// flush validates the rows of the batch and creates the valid accounts with their group memberships in one transaction,
+// when the transaction fails all the rows of the batch fail with its error
+func (i *accountImporter) flush(ctx context.Context) error {
+	batch := i.batch
+	i.batch = nil
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var rows []*v1.ImportAccountsRequest
+	var accounts []*model.Account
+	var members []*model.GroupMemberAccount
+	for _, request := range batch {
+		account, groups, err := i.account(ctx, request.GetAccount())
+		if err != nil {
+			i.fail(request, err.Error())
+			continue
+		}
+
+		rows = append(rows, request)
+		accounts = append(accounts, account)
+		for _, group := range groups {
+			members = append(members, &model.GroupMemberAccount{GroupID: group.ID, AccountID: account.ID})
+		}
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	err := i.as.Transaction(func(tx store.AuthBaseStore) error {
+		if err := tx.CreateAccounts(ctx, accounts); err != nil {
+			return err
+		}
+		for _, member := range members {
+			if err := tx.AddGroupMember(ctx, member); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		for _, request := range rows {
+			i.fail(request, err.Error())
+		}
+		return nil
+	}
+
+	i.response.Created += uint64(len(accounts))
+	return nil
+}
+
+// account validates a row and returns the account to create with its groups
+func (i *accountImporter) account(ctx context.Context, record *v1.AccountRecord) (*model.Account, []*model.Group, error) {
+	email := strings.TrimSpace(record.GetEmail())
+	if email == "" {
+		return nil, nil, errors.New("email is required")
+	}
+	if i.emails[strings.ToLower(email)] {
+		return nil, nil, errors.New("duplicate email in the import")
+	}
+	i.emails[strings.ToLower(email)] = true
+
+	hash := record.GetPasswordHash()
+	// the hashes of unsupported formats or above the cost limits could never be verified
+	if hash != "" {
+		if err := x.CheckPasswordHash(hash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	existing, err := i.as.GetAccountByEmail(ctx, i.poolID, email)
+	if err != nil {
+		return nil, nil, err
+	}
+	if existing.ID != "" {
+		return nil, nil, errors.New("account already exists")
+	}
+
+	var groups []*model.Group
+	for _, name := range record.GetGroups() {
+		group, err := i.group(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	username := record.GetUsername()
+	if username == "" {
+		username = strings.Split(email, "@")[0]
+	}
+	visibleName := record.GetVisibleName()
+	if visibleName == "" {
+		visibleName = username
+	}
+
+	now := time.Now()
+	account := &model.Account{
+		ID:          uuid.New().String(),
+		Username:    username,
+		Email:       email,
+		VisibleName: visibleName,
+		ProjectID:   i.projectID,
+		PoolID:      i.poolID.String(),
+		Verified:    record.GetVerified(),
+		Disabled:    record.GetDisabled(),
+	}
+	if hash != "" {
+		account.PasswordHash = hash
+		account.PasswordChangedAt = now
+	}
+	if account.Verified {
+		account.VerifiedAt = now
+	}
+	if account.Disabled {
+		account.DisabledAt = now
+	}
+
+	return account, groups, nil
+}
+
+func (i *accountImporter) group(ctx context.Context, name string) (*model.Group, error) {
+	if group, ok := i.groups[name]; ok {
+		return group, nil
+	}
+
+	group, err := i.as.GetGroupByName(ctx, i.poolID, name)
+	if errors.Is(err, store.ErrGroupNotFound) {
+		return nil, fmt.Errorf("group %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.groups[name] = group
+
+	return group, nil
+}
+
+func (i *accountImporter) fail(request *v1.ImportAccountsRequest, message string) {
+	i.response.Failed++
+	i.response.Errors = append(i.response.Errors, &v1.ImportAccountError{
+		Row:     request.GetRow(),
+		Email:   request.GetAccount().GetEmail(),
+		Message: message,
+	})
+}
+
+// ExportAccounts streams the accounts of a pool in the import format, with their password hashes
+func (u *AccountService) ExportAccounts(request *v1.ExportAccountsRequest, stream grpc.ServerStreamingServer[v1.ExportAccountsResponse]) error {
+	ctx := stream.Context()
+	as, err := store.GetProjectStore(ctx, u.store)
+	if err != nil {
+		return err
+	}
+
+	poolID, err := uuid.Parse(request.GetPoolId())
+	if err != nil {
+		return err
+	}
+
+	pool, err := as.GetPoolByID(ctx, poolID)
+	if err != nil {
+		return err
+	}
+
+	// the export carries the password hashes, reading it needs the write permission
+	err = u.perm.CheckProjectPermission(ctx, uuid.MustParse(pool.ProjectID), "write")
+	if err != nil {
+		return err
+	}
+
+	for page := 0; ; page++ {
+		accounts, _, err := as.ListPoolAccounts(ctx, false, poolID, page, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, account := range accounts {
+			members, err := as.ListGroupMemberByAccount(ctx, uuid.MustParse(account.ID))
+			if err != nil {
+				return err
+			}
+			var groups []string
+			for _, member := range members {
+				if member.Group != nil {
+					groups = append(groups, member.Group.Name)
+				}
+			}
+
+			err = stream.Send(&v1.ExportAccountsResponse{
+				Account: &v1.AccountRecord{
+					Email:        account.Email,
+					Username:     account.Username,
+					VisibleName:  account.VisibleName,
+					Verified:     account.Verified,
+					Disabled:     account.Disabled,
+					PasswordHash: x.EncodedPasswordHash(account.Salt, account.PasswordHash),
+					Groups:       groups,
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(accounts) < exportPageSize {
+			return nil
+		}
+	}
+}
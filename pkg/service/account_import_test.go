@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestImportPasswordHashCost function to test the imported rows with hashes above the cost limits fail
+func TestImportPasswordHashCost(t *testing.T) {
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	project, _, _ := createMfaAccount(t, as)
+	importer := &accountImporter{
+		as:        as,
+		poolID:    uuid.MustParse(project.PoolID),
+		projectID: project.ID,
+		groups:    make(map[string]*model.Group),
+		emails:    make(map[string]bool),
+		response:  &v1.ImportAccountsResponse{},
+	}
+
+	ctx := context.TODO()
+	account, _, err := importer.account(ctx, &v1.AccountRecord{Email: "john@authbase.test", PasswordHash: x.HashPassword("password")})
+	assert.NoError(t, err)
+	assert.NotNil(t, account)
+
+	_, _, err = importer.account(ctx, &v1.AccountRecord{
+		Email:        "joe@authbase.test",
+		PasswordHash: "$scrypt$ln=30,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+	})
+	assert.ErrorIs(t, err, x.ErrPasswordHashCost)
+
+	_, _, err = importer.account(ctx, &v1.AccountRecord{Email: "jim@authbase.test", PasswordHash: "md5$salt$hash"})
+	assert.ErrorIs(t, err, x.ErrUnsupportedPasswordHash)
+}
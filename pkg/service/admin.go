@@ -107,10 +107,7 @@ func (a *AdminProjectService) CreateAdminProject(ctx context.Context, request *v
 		//// if password is provided, clientSecretHash it and provider it
 		password := request.GetPassword()
 		if password != "" {
-			secret := x.Keygen()
-			hash := x.HashPassword(password, secret)
-			account.PasswordHash = string(hash)
-			account.Salt = secret
+			account.PasswordHash = x.HashPassword(password)
 		}
 
 		err = tx.CreateAccount(ctx, &account)
@@ -3,16 +3,13 @@ package service
 import (
 	"context"
 	"errors"
-	goset "github.com/deckarep/golang-set/v2"
 	v1 "github.com/emrgen/authbase/apis/v1"
 	"github.com/emrgen/authbase/pkg/cache"
 	"github.com/emrgen/authbase/pkg/config"
-	"github.com/emrgen/authbase/pkg/model"
 	"github.com/emrgen/authbase/pkg/store"
 	"github.com/emrgen/authbase/x"
 	"github.com/google/uuid"
 	"google.golang.org/protobuf/types/known/timestamppb"
-	"time"
 )
 
 type AdminAuthService struct {
@@ -43,10 +40,6 @@ func (a AdminAuthService) AdminLoginUsingPassword(ctx context.Context, request *
 		return nil, err
 	}
 
-	// TODO: this is very sensitive api
-	// rate limit and ip block should be implemented here
-	// more than 5 failed attempts should block the ip for 1 hour
-
 	email := request.GetEmail()
 	password := request.GetPassword()
 	projectName := request.GetProjectName()
@@ -71,89 +64,36 @@ func (a AdminAuthService) AdminLoginUsingPassword(ctx context.Context, request *
 		return nil, errors.New("admin account not found")
 	}
 
-	// validate the password
-	ok := x.CompareHashAndPassword(password, account.Salt, account.PasswordHash)
-	if !ok {
-		return nil, errors.New("incorrect password")
-	}
-
-	accountID := uuid.MustParse(account.ID)
-	memberships, err := as.ListGroupMemberByAccount(ctx, accountID)
+	pool, err := as.GetPoolByID(ctx, poolID)
 	if err != nil {
 		return nil, err
 	}
-	set := goset.NewSet[string]()
-	for _, member := range memberships {
-		for _, role := range member.Group.Roles {
-			set.Add(role.Name)
-		}
-	}
-
-	roleNames := set.ToSlice()
 
-	signer, err := a.keyProvider.GetSigner(poolID.String())
+	// validate the password, failed attempts back off and lock the admin out by the admin pool policy
+	err = x.VerifyPassword(ctx, x.NewCacheLoginGuard(a.cache), account, pool.LockoutPolicy, password)
 	if err != nil {
 		return nil, err
 	}
-
-	// generate tokens for the account
-	jti := uuid.New().String() // unique id for the token
-	token, err := x.GenerateJWTToken(&x.Claims{
-		Username:  account.Username,
-		Email:     account.Email,
-		ProjectID: account.ProjectID,
-		PoolID:    account.PoolID,
-		AccountID: account.ID,
-		Audience:  "", // TODO: the target website or app that will use the token
-		Jti:       jti,
-		ExpireAt:  time.Now().Add(x.AccessTokenDuration),
-		IssuedAt:  time.Now(),
-		Provider:  "authbase", // TODO: what should this be?
-		Scopes:    roleNames,  // internal roles
-		Roles:     roleNames,
-	}, signer)
-	if err != nil {
+	x.UpgradePasswordHash(ctx, as, account, password)
+	if err := checkPasswordAge(ctx, as, account); err != nil {
 		return nil, err
 	}
 
-	refreshExpireAt := time.Now().Add(x.RefreshTokenDuration)
-	// save refresh token to cache, it will be used to validate the refresh token request
-	// on cache miss, it will check the provider for the refresh token
-	err = a.cache.Set(jti, token.RefreshToken, x.RefreshTokenDuration)
+	// the admins with a second factor finish the login with VerifyMfa
+	required, enroll, err := mfaRequired(ctx, as, account)
 	if err != nil {
 		return nil, err
 	}
-
-	// save the token to the provider
-	// TODO: save the token to the provider in encrypted form
-	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		err = as.CreateRefreshToken(ctx, &model.RefreshToken{
-			Token:     token.RefreshToken,
-			ProjectID: account.ProjectID,
-			AccountID: account.ID,
-			ExpireAt:  refreshExpireAt,
-			IssuedAt:  token.IssuedAt,
-		})
-		if err != nil {
-			return err
-		}
-
-		// create a new session
-		// use the jti as the session id
-		// this will allow multiple sessions for a account at the same time
-		// TODO: should we limit the number of sessions?
-		err = as.CreateSession(ctx, &model.Session{
-			ID:        jti,
-			PoolID:    account.PoolID,
-			AccountID: account.ID,
-			ProjectID: account.ProjectID,
-		})
+	if required {
+		mfaToken, err := createMfaChallenge(a.cache, account, "", enroll)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return &v1.AdminLoginUsingPasswordResponse{MfaToken: mfaToken, MfaEnrollmentRequired: enroll}, nil
+	}
 
-		return nil
-	})
+	// the admin tokens follow the token policy of the admin pool
+	token, err := newTokenIssuer(a.keyProvider, a.cache).issue(ctx, as, account, "", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +116,7 @@ func (a AdminAuthService) AdminLoginUsingPassword(ctx context.Context, request *
 			RefreshToken:     token.RefreshToken,
 			ExpiresAt:        timestamppb.New(token.ExpireAt),
 			IssuedAt:         timestamppb.New(token.IssuedAt),
-			RefreshExpiresAt: timestamppb.New(refreshExpireAt),
+			RefreshExpiresAt: timestamppb.New(token.RefreshExpireAt),
 		},
 	}, nil
 }
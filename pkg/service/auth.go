@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"errors"
-	goset "github.com/deckarep/golang-set/v2"
 	v1 "github.com/emrgen/authbase/apis/v1"
 	"github.com/emrgen/authbase/pkg/cache"
 	"github.com/emrgen/authbase/pkg/model"
@@ -11,19 +10,18 @@ import (
 	"github.com/emrgen/authbase/pkg/store"
 	"github.com/emrgen/authbase/x"
 	"github.com/emrgen/authbase/x/mail"
-	"github.com/emrgen/authbase/x/oauth"
 	"github.com/google/uuid"
-	"github.com/sirupsen/logrus"
-	"golang.org/x/oauth2"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
 	"time"
 )
 
 // NewAuthService creates a new AuthService
-func NewAuthService(store store.Provider, keyProvider x.JWTSignerVerifierProvider, perm permission.AuthBasePermission, mailer mail.MailerProvider, cache *cache.Redis, verifier *x.StoreBasedUserVerifier) *AuthService {
-	return &AuthService{store: store, keyProvider: keyProvider, perm: perm, mailer: mailer, cache: cache, verifier: verifier}
+// publicURL is used to build the identity provider callback urls, appKey seals the TOTP secrets.
+func NewAuthService(store store.Provider, keyProvider x.JWTSignerVerifierProvider, perm permission.AuthBasePermission, mailer mail.MailerProvider, cache *cache.Redis, verifier *x.StoreBasedUserVerifier, publicURL, appKey string) *AuthService {
+	return &AuthService{store: store, keyProvider: keyProvider, perm: perm, mailer: mailer, cache: cache, verifier: verifier, issuer: newTokenIssuer(keyProvider, cache), revocations: x.NewCacheRevocationList(cache), guard: x.NewCacheLoginGuard(cache), publicURL: publicURL, appKey: appKey}
 }
 
 var _ v1.AuthServiceServer = new(AuthService)
@@ -36,6 +34,11 @@ type AuthService struct {
 	keyProvider x.JWTSignerVerifierProvider
 	perm        permission.AuthBasePermission
 	verifier    *x.StoreBasedUserVerifier
+	issuer      *tokenIssuer
+	revocations x.RevocationList
+	guard       x.LoginGuard
+	publicURL   string
+	appKey      string
 	v1.UnimplementedAuthServiceServer
 }
 
@@ -68,80 +71,6 @@ func (a *AuthService) AccountEmailExists(ctx context.Context, request *v1.Accoun
 	}, nil
 }
 
-// LoginUsingIdp redirects the user to the identity provider for login
-func (a *AuthService) LoginUsingIdp(ctx context.Context, request *v1.LoginUsingIdpRequest) (*v1.LoginUsingIdpResponse, error) {
-	// get the provider details and redirect to the provider
-	as, err := store.GetProjectStore(ctx, a.store)
-	if err != nil {
-		return nil, err
-	}
-
-	clientID, err := uuid.Parse(request.GetClientId())
-
-	provider, err := as.GetOauthProviderByName(ctx, clientID, request.Provider)
-	if err != nil {
-		return nil, err
-	}
-
-	// this payload will not reach the client
-	// TODO: grpc interceptors will handle the redirect with additional http.Cookie with the oauthstate
-	// ref: https://github.com/douglasmakey/oauth2-example
-	return &v1.LoginUsingIdpResponse{
-		Provider: &v1.OAuthProvider{
-			ClientId:     provider.Config.ClientID,
-			ClientSecret: provider.Config.ClientSecret,
-			RedirectUris: nil,
-		},
-	}, nil
-}
-
-// GetIdpToken gets the token from the identity provider code
-func (a *AuthService) GetIdpToken(ctx context.Context, request *v1.GetIdpTokenRequest) (*v1.GetIdpTokenResponse, error) {
-	as, err := store.GetProjectStore(ctx, a.store)
-	if err != nil {
-		return nil, err
-	}
-	// get the client id and secret from the request
-	clientID, err := uuid.Parse(request.GetClientId())
-	if err != nil {
-		return nil, err
-	}
-	client, err := as.GetClientByID(ctx, clientID)
-	if err != nil {
-		return nil, err
-	}
-	if client == nil {
-		return nil, errors.New("client not found")
-	}
-
-	// get the provider details
-	provider, err := as.GetOauthProviderByName(ctx, clientID, request.Provider)
-	if err != nil {
-		return nil, err
-	}
-
-	oauthProvider, err := oauth.GetProvider(provider.Provider, oauth2.Config{
-		ClientID:     provider.Config.ClientID,
-		ClientSecret: provider.Config.ClientSecret,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// get the token from the provider
-	token, err := oauthProvider.GetToken(ctx, request.Code)
-	if err != nil {
-		return nil, err
-	}
-
-	return &v1.GetIdpTokenResponse{
-		AccessToken:  token.AccessToken,
-		RefreshToken: token.RefreshToken,
-		ExpiresAt:    timestamppb.New(time.Now().Add(time.Duration(token.ExpiresIn))),
-		IssuedAt:     timestamppb.New(time.Now()),
-	}, nil
-}
-
 // RegisterUsingPassword registers a user using a username, email, and password
 func (a *AuthService) RegisterUsingPassword(ctx context.Context, request *v1.RegisterUsingPasswordRequest) (*v1.RegisterUsingPasswordResponse, error) {
 	email := request.GetEmail()
@@ -169,61 +98,41 @@ func (a *AuthService) RegisterUsingPassword(ctx context.Context, request *v1.Reg
 		}
 	}
 
-	salt := x.Keygen()
-	hashedPassword := x.HashPassword(password, salt)
+	project, err := as.GetProjectByID(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
 
 	user := &model.Account{
-		ID:           uuid.New().String(),
-		ProjectID:    orgID.String(),
-		Username:     username,
-		Email:        email,
-		PasswordHash: string(hashedPassword),
-		Salt:         salt,
-		Verified:     false,
+		ID:        uuid.New().String(),
+		ProjectID: orgID.String(),
+		PoolID:    project.PoolID,
+		Username:  username,
+		Email:     email,
+		Verified:  false,
+	}
+	if err := setAccountPassword(ctx, as, user, "password", password); err != nil {
+		return nil, err
 	}
 
-	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		err = as.CreateAccount(ctx, user)
-		if err != nil {
-			return err
-		}
-
-		// generate a verification code
-		code := x.GenerateVerificationCode()
-		expireAt := time.Now().Add(24 * time.Hour)
-
-		err = as.CreateVerificationCode(ctx, &model.VerificationCode{
-			ID:        uuid.New().String(),
-			Code:      code,
-			AccountID: user.ID,
-			ExpiresAt: expireAt,
-		})
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+	err = as.CreateAccount(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
-	// send email verification code to the user
-	go func() {
-		logrus.Infof("sending email to %s", email)
-		err = a.mailer.Provide(orgID).SendMail(email, email, "Verify your email", "verify-email")
-		if err != nil {
-			logrus.Errorf("failed to send email: %v", err)
-		}
-	}()
+	// send the email verification code and link to the user
+	err = a.sendVerificationEmail(ctx, as, user)
+	if err != nil {
+		return nil, err
+	}
 
 	return &v1.RegisterUsingPasswordResponse{
 		Message: "user registered",
 	}, nil
 }
 
-// LoginUsingPassword logs in a user and returns an access token and a refresh token
-// TODO: should be rate limited to prevent brute force attacks
+// LoginUsingPassword logs in a user and returns an access token and a refresh token.
+// Failed attempts back off and lock the account out by the lockout policy of the pool.
 // TODO: /admin/login should we separate the admin login url from the other user logins?
 func (a *AuthService) LoginUsingPassword(ctx context.Context, request *v1.LoginUsingPasswordRequest) (*v1.LoginUsingPasswordResponse, error) {
 	email := request.GetEmail()
@@ -260,95 +169,40 @@ func (a *AuthService) LoginUsingPassword(ctx context.Context, request *v1.LoginU
 		return nil, errors.New("account is disabled")
 	}
 
-	ok := x.CompareHashAndPassword(password, account.Salt, account.PasswordHash)
-	if !ok {
-		return nil, errors.New("incorrect password")
-	}
-
-	// get the account scopes from the group memberships
-	accountID := uuid.MustParse(account.ID)
-	memberships, err := as.ListGroupMemberByAccount(ctx, accountID)
-	if err != nil {
+	if err := x.VerifyPassword(ctx, a.guard, account, client.Pool.LockoutPolicy, password); err != nil {
 		return nil, err
 	}
-	set := goset.NewSet[string]()
-	for _, member := range memberships {
-		for _, role := range member.Group.Roles {
-			set.Add(role.Name)
-		}
-	}
-
-	roleNames := set.ToSlice()
-
-	signer, err := a.keyProvider.GetSigner(poolID.String())
-	if err != nil {
+	x.UpgradePasswordHash(ctx, as, account, password)
+	if err := checkEmailVerified(ctx, as, account); err != nil {
 		return nil, err
 	}
-
-	// generate tokens for the account
-	jti := uuid.New().String() // unique id for the token
-	token, err := x.GenerateJWTToken(&x.Claims{
-		Username:  account.Username,
-		Email:     account.Email,
-		ClientID:  clientID.String(),
-		ProjectID: account.ProjectID,
-		PoolID:    account.PoolID,
-		AccountID: account.ID,
-		Audience:  "", // TODO: the target website or app that will use the token
-		Jti:       jti,
-		ExpireAt:  time.Now().Add(x.AccessTokenDuration),
-		IssuedAt:  time.Now(),
-		Provider:  "authbase", // TODO: what should this be?
-		Scopes:    roleNames,  // internal roles
-		Roles:     roleNames,
-	}, signer)
-	if err != nil {
+	if err := checkPasswordAge(ctx, as, account); err != nil {
 		return nil, err
 	}
 
-	refreshExpireAt := time.Now().Add(x.RefreshTokenDuration)
-	// save refresh token to cache, it will be used to validate the refresh token request
-	// on cache miss, it will check the provider for the refresh token
-	err = a.cache.Set(jti, token.RefreshToken, x.RefreshTokenDuration)
+	// accounts with a second factor, or in pools requiring one, get a challenge instead of tokens
+	required, enroll, err := mfaRequired(ctx, as, account)
 	if err != nil {
 		return nil, err
 	}
-
-	// save the token to the provider
-	// TODO: save the token to the provider in encrypted form
-	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		err = as.CreateRefreshToken(ctx, &model.RefreshToken{
-			Token:     token.RefreshToken,
-			ProjectID: account.ProjectID,
-			AccountID: account.ID,
-			ExpireAt:  refreshExpireAt,
-			IssuedAt:  token.IssuedAt,
-		})
-		if err != nil {
-			return err
-		}
-
-		// create a new session
-		// use the jti as the session id
-		// this will allow multiple sessions for a account at the same time
-		// TODO: should we limit the number of sessions?
-		err = as.CreateSession(ctx, &model.Session{
-			ID:        jti,
-			PoolID:    account.PoolID,
-			AccountID: account.ID,
-			ProjectID: account.ProjectID,
-		})
+	if required {
+		mfaToken, err := createMfaChallenge(a.cache, account, clientID.String(), enroll)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return &v1.LoginUsingPasswordResponse{MfaToken: mfaToken, MfaEnrollmentRequired: enroll}, nil
+	}
 
-		return nil
-	})
+	token, err := a.issuer.issue(ctx, as, account, clientID.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// return tokens
+	return passwordLoginResponse(account, token), nil
+}
+
+// passwordLoginResponse returns the account and its tokens
+func passwordLoginResponse(account *model.Account, token *issuedToken) *v1.LoginUsingPasswordResponse {
 	return &v1.LoginUsingPasswordResponse{
 		Account: &v1.Account{
 			Id:        account.ID,
@@ -362,9 +216,9 @@ func (a *AuthService) LoginUsingPassword(ctx context.Context, request *v1.LoginU
 			RefreshToken:     token.RefreshToken,
 			ExpiresAt:        timestamppb.New(token.ExpireAt),
 			IssuedAt:         timestamppb.New(token.IssuedAt),
-			RefreshExpiresAt: timestamppb.New(refreshExpireAt),
+			RefreshExpiresAt: timestamppb.New(token.RefreshExpireAt),
 		},
-	}, nil
+	}
 }
 
 // Logout logs out a user by deleting the session from the provider, and the refresh tokens from the cache
@@ -388,26 +242,39 @@ func (a *AuthService) Logout(ctx context.Context, request *v1.LogoutRequest) (*v
 		return nil, err
 	}
 
-	jti := uuid.MustParse(claims.Jti)
+	sessionID, err := uuid.Parse(tokenSessionID(claims))
+	if err != nil {
+		return nil, err
+	}
 	as, err := store.GetProjectStore(ctx, a.store)
 	if err != nil {
 		return nil, err
 	}
 
+	// the access tokens stay valid until they expire, the revocation list rejects them from now on
+	err = a.revocations.RevokeToken(claims.Jti, claims.ExpireAt)
+	if err != nil {
+		return nil, err
+	}
+	err = a.revocations.RevokeSession(sessionID.String())
+	if err != nil {
+		return nil, err
+	}
+
 	// delete the token from the cache
 	err = a.cache.Del(claims.Jti)
 	if err != nil {
 		return nil, err
 	}
 
-	// delete the refresh token from the provider
-	err = as.DeleteRefreshToken(ctx, claims.Jti)
+	// delete the refresh tokens of the session from the provider
+	err = as.DeleteRefreshTokenFamily(ctx, sessionID.String())
 	if err != nil {
 		return nil, err
 	}
 
 	// delete the session from the provider
-	err = as.DeleteSession(ctx, jti)
+	err = as.DeleteSession(ctx, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -417,57 +284,40 @@ func (a *AuthService) Logout(ctx context.Context, request *v1.LogoutRequest) (*v
 
 // ForgotPassword sends a password reset link to the user's email or phone number to reset their password
 func (a *AuthService) ForgotPassword(ctx context.Context, request *v1.ForgotPasswordRequest) (*v1.ForgotPasswordResponse, error) {
-	poolID, err := x.GetAuthbaseProjectID(ctx)
+	as, err := store.GetProjectStore(ctx, a.store)
 	if err != nil {
 		return nil, err
 	}
-
-	as, err := store.GetProjectStore(ctx, a.store)
+	client, err := as.GetClientByID(ctx, uuid.MustParse(request.GetClientId()))
 	if err != nil {
 		return nil, err
 	}
 
-	mailer := a.mailer.Provide(poolID)
+	// the reset emails of an address are throttled whether the address has an account or not
 	email := request.GetEmail()
-
-	account, err := as.GetAccountByEmail(ctx, poolID, email)
+	ok, wait, err := a.cache.TakeToken("password:reset:"+client.PoolID+":"+strings.ToLower(email), 1, resendInterval)
 	if err != nil {
 		return nil, err
 	}
-
-	if account == nil {
-		return nil, errors.New("account not found")
+	if !ok {
+		return nil, x.RateLimitError(ctx, wait)
 	}
 
-	code := x.GenerateVerificationCode()
-	expireAt := time.Now().Add(24 * time.Hour)
-
-	err = a.cache.Set(code, account.ID, 24*time.Hour)
+	account, err := as.GetAccountByEmail(ctx, uuid.MustParse(client.PoolID), email)
 	if err != nil {
 		return nil, err
 	}
 
-	err = as.CreateVerificationCode(ctx, &model.VerificationCode{
-		ID:        uuid.New().String(),
-		Code:      code,
-		AccountID: account.ID,
-		ProjectID: account.ProjectID,
-		PoolID:    account.PoolID,
-		ExpiresAt: expireAt,
-	})
+	// the response does not tell whether the email has an account
+	if account.ID == "" || account.Disabled {
+		return &v1.ForgotPasswordResponse{Message: "password reset link sent"}, nil
+	}
+
+	err = a.sendResetPasswordEmail(ctx, as, account)
 	if err != nil {
 		return nil, err
 	}
 
-	// send email verification code to the user
-	go func() {
-		logrus.Infof("sending email to %s", email)
-		err = mailer.SendMail(email, email, "Reset your password", "reset-password")
-		if err != nil {
-			logrus.Errorf("failed to send email: %v", err)
-		}
-	}()
-
 	return &v1.ForgotPasswordResponse{Message: "password reset link sent"}, nil
 }
 
@@ -481,34 +331,24 @@ func (a *AuthService) ResetPassword(ctx context.Context, request *v1.ResetPasswo
 		return nil, err
 	}
 
+	var account *model.Account
 	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		code, err := tx.GetVerificationCode(ctx, code)
+		code, err := useEmailCode(ctx, tx, model.VerificationPurposeResetPassword, code)
 		if err != nil {
 			return err
 		}
 
-		if code.ExpiresAt.Before(time.Now()) {
-			return errors.New("verification code has expired")
-		}
-
-		account, err := tx.GetAccountByID(ctx, uuid.MustParse(code.AccountID))
+		account, err = tx.GetAccountByID(ctx, uuid.MustParse(code.AccountID))
 		if err != nil {
 			return err
 		}
 
-		salt := x.Keygen()
-		hashedPassword := x.HashPassword(password, salt)
-		account.PasswordHash = string(hashedPassword)
-		account.Salt = salt
-
-		// update the account with the new password
-		err = tx.UpdateAccount(ctx, account)
-		if err != nil {
+		if err := setAccountPassword(ctx, tx, account, "new_password", password); err != nil {
 			return err
 		}
 
-		// delete the verification code
-		err = tx.DeleteVerificationCode(ctx, code.ID)
+		// update the account with the new password
+		err = tx.UpdateAccount(ctx, account)
 		if err != nil {
 			return err
 		}
@@ -519,6 +359,19 @@ func (a *AuthService) ResetPassword(ctx context.Context, request *v1.ResetPasswo
 		return nil, err
 	}
 
+	// the password may have been reset because someone else knew it, the sessions and tokens
+	// of the old password are ended and the account logs in again
+	sessions, err := as.ListAccountSessions(ctx, uuid.MustParse(account.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := endSessions(ctx, as, a.revocations, a.cache, sessions); err != nil {
+		return nil, err
+	}
+	if err := a.revocations.RevokeAccount(account.ID); err != nil {
+		return nil, err
+	}
+
 	// TODO: should redirect to the login page
 	return &v1.ResetPasswordResponse{Message: "password reset"}, nil
 }
@@ -541,10 +394,9 @@ func (a *AuthService) ChangePassword(ctx context.Context, request *v1.ChangePass
 			return err
 		}
 
-		salt := x.Keygen()
-		hashedPassword := x.HashPassword(request.GetNewPassword(), salt)
-		account.PasswordHash = string(hashedPassword)
-		account.Salt = salt
+		if err := setAccountPassword(ctx, tx, account, "new_password", request.GetNewPassword()); err != nil {
+			return err
+		}
 
 		err = tx.UpdateAccount(ctx, account)
 		if err != nil {
@@ -557,106 +409,48 @@ func (a *AuthService) ChangePassword(ctx context.Context, request *v1.ChangePass
 		return nil, err
 	}
 
-	return &v1.ChangePasswordResponse{Message: "password changed"}, nil
-}
-
-// Refresh generates a new access token using the refresh token
-func (a *AuthService) Refresh(ctx context.Context, request *v1.RefreshRequest) (*v1.RefreshResponse, error) {
-	var foundToken bool
-	var accountID string
-	var projectID string
-
-	// check if the refresh token is still valid
-	refreshToken := request.GetRefreshToken()
-
-	oldClaims, err := x.GetTokenClaims(refreshToken)
+	// the tokens issued with the old password stop working, the account logs in again
+	err = a.revocations.RevokeAccount(accountID.String())
 	if err != nil {
 		return nil, err
 	}
 
-	verifier, err := a.keyProvider.GetVerifier(oldClaims.ClientID)
-
-	claims, err := x.VerifyJWTToken(refreshToken, verifier)
-	if err != nil {
-		return nil, err
-	}
+	return &v1.ChangePasswordResponse{Message: "password changed"}, nil
+}
 
-	// check if the token is in the cache
-	tokenStr, err := a.cache.Get(claims.Jti)
+// Refresh generates a new access token using the refresh token
+func (a *AuthService) Refresh(ctx context.Context, request *v1.RefreshRequest) (*v1.RefreshResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
 	if err != nil {
-		// if no value in cache check the provider
 		return nil, err
 	}
 
-	as, err := store.GetProjectStore(ctx, a.store)
+	// the tokens of a confidential client are refreshed with its secret, like the refresh_token grant of OAuth2Token
+	claims, err := x.GetTokenClaims(request.GetRefreshToken())
 	if err != nil {
 		return nil, err
 	}
-
-	if tokenStr != "" {
-		accountID = claims.AccountID
-		projectID = claims.ProjectID
-		foundToken = true
-	}
-
-	if !foundToken {
-		// check the provider
-		token, err := as.GetRefreshTokenByID(ctx, claims.Jti)
+	var clientID string
+	if claims.ClientID != "" {
+		client, err := authenticateClient(ctx, as, claims.ClientID, request.GetClientSecret())
 		if err != nil {
 			return nil, err
 		}
-
-		if token == nil {
-			return nil, errors.New("token not found, need to login again")
-		}
-
-		foundToken = true
-		accountID = token.AccountID
-		projectID = token.ProjectID
-	}
-
-	user, err := as.GetAccountByID(ctx, uuid.MustParse(accountID))
-	if err != nil {
-		return nil, err
-	}
-
-	jti := uuid.New().String()
-	claims = &x.Claims{
-		ProjectID: projectID,
-		PoolID:    claims.PoolID,
-		AccountID: user.ID,
-		Username:  claims.Username,
-		Email:     claims.Email,
-		Audience:  claims.Audience,
-		Jti:       jti,
-		ExpireAt:  time.Now().Add(15 * time.Minute),
-		IssuedAt:  time.Now(),
-		Provider:  "authbase",
-		Scopes:    claims.Scopes,
-	}
-
-	signer, err := a.keyProvider.GetSigner(claims.PoolID)
-	if err != nil {
-		return nil, err
+		clientID = client.ID
 	}
 
-	token, err := x.GenerateJWTToken(claims, signer)
-	if err != nil {
-		return nil, err
-	}
-	err = a.cache.Set(claims.Jti, token.RefreshToken, x.ScheduleRefreshTokenExpiry)
+	token, err := a.issuer.refresh(ctx, as, request.GetRefreshToken(), clientID)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: should intercept the response and delete old token from cookie
 	return &v1.RefreshResponse{
 		Tokens: &v1.Tokens{
 			AccessToken:      token.AccessToken,
-			RefreshToken:     refreshToken,
+			RefreshToken:     token.RefreshToken,
 			ExpiresAt:        timestamppb.New(token.ExpireAt),
 			IssuedAt:         timestamppb.New(token.IssuedAt),
-			RefreshExpiresAt: timestamppb.New(token.ExpireAt),
+			RefreshExpiresAt: timestamppb.New(token.RefreshExpireAt),
 		},
 	}, nil
 }
@@ -671,14 +465,12 @@ func (a *AuthService) VerifyEmail(ctx context.Context, request *v1.VerifyEmailRe
 
 	// check if the email is already verified
 	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		// TODO: use redis with TTL to store the verification code, for now we will use the provider (database)
-		code, err := tx.GetVerificationCode(ctx, request.GetCode())
+		code, err := useEmailCode(ctx, tx, model.VerificationPurposeVerifyEmail, request.GetCode())
 		if err != nil {
 			return err
 		}
-
-		if code.ExpiresAt.Before(time.Now()) {
-			return status.Errorf(codes.InvalidArgument, "verification code expired")
+		if request.GetProjectId() != "" && request.GetProjectId() != code.ProjectID {
+			return status.Error(codes.InvalidArgument, "invalid or expired code")
 		}
 
 		account, err := tx.GetAccountByID(ctx, uuid.MustParse(code.AccountID))
@@ -694,11 +486,6 @@ func (a *AuthService) VerifyEmail(ctx context.Context, request *v1.VerifyEmailRe
 			return err
 		}
 
-		err = tx.DeleteVerificationCode(ctx, request.GetCode())
-		if err != nil {
-			return err
-		}
-
 		return nil
 	})
 	if err != nil {
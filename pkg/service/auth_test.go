@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// TestRefreshConfidentialClient function to test the refresh tokens of a confidential client need its secret
+func TestRefreshConfidentialClient(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	as := store.NewGormStore(tester.TestDB())
+	project, _, account := createMfaAccount(t, as)
+	client := &model.Client{ID: uuid.New().String(), PoolID: project.PoolID, Name: "backend", SecretHash: x.HashPassword("s3cr3t")}
+	assert.NoError(t, as.CreateClient(ctx, client))
+	service := NewAuthService(store.NewDefaultProvider(as), x.NewStaticKeyProvider("secret"), nil, nil, redis, nil, "", "")
+
+	token, err := service.issuer.issue(ctx, as, account, client.ID, nil)
+	assert.NoError(t, err)
+
+	_, err = service.Refresh(ctx, &v1.RefreshRequest{RefreshToken: token.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = service.Refresh(ctx, &v1.RefreshRequest{RefreshToken: token.RefreshToken, ClientSecret: "wrong"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	res, err := service.Refresh(ctx, &v1.RefreshRequest{RefreshToken: token.RefreshToken, ClientSecret: "s3cr3t"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, res.Tokens.AccessToken)
+}
+
+// TestResetPasswordRevokes function to test a password reset ends the sessions and the tokens of the old password
+func TestResetPasswordRevokes(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	as := store.NewGormStore(tester.TestDB())
+	_, client, account := createMfaAccount(t, as)
+	service := NewAuthService(store.NewDefaultProvider(as), x.NewStaticKeyProvider("secret"), nil, nil, redis, nil, "", "")
+
+	token, err := service.issuer.issue(ctx, as, account, client.ID, nil)
+	assert.NoError(t, err)
+	claims, err := x.GetTokenClaims(token.AccessToken)
+	assert.NoError(t, err)
+
+	_, code, err := newEmailCode(ctx, as, account, model.VerificationPurposeResetPassword)
+	assert.NoError(t, err)
+	_, err = service.ResetPassword(ctx, &v1.ResetPasswordRequest{Code: code, NewPassword: "N3w-password!"})
+	assert.NoError(t, err)
+
+	revoked, err := service.revocations.Revoked(claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	sessions, err := as.ListAccountSessions(ctx, uuid.MustParse(account.ID))
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+	_, err = service.Refresh(ctx, &v1.RefreshRequest{RefreshToken: token.RefreshToken})
+	assert.Error(t, err)
+}
+
+// TestVerifyAccessKeyAccount function to test the access keys of a disabled or revoked account stop working
+func TestVerifyAccessKeyAccount(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := context.TODO()
+	as := store.NewGormStore(tester.TestDB())
+	_, _, account := createMfaAccount(t, as)
+	key := &model.AccessKey{
+		ID:        uuid.New().String(),
+		ProjectID: account.ProjectID,
+		AccountID: account.ID,
+		PoolID:    account.PoolID,
+		Token:     "k3y",
+		ExpireAt:  time.Now().Add(time.Hour),
+	}
+	key.CreatedAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, as.CreateAccessKey(ctx, key))
+	verifier := x.NewStoreBasedTokenVerifier(store.NewDefaultProvider(as), redis, x.NewStaticKeyProvider("secret"))
+
+	_, err := verifier.VerifyAccessKey(ctx, uuid.MustParse(key.ID), "k3y")
+	assert.NoError(t, err)
+
+	account.Disabled = true
+	assert.NoError(t, as.UpdateAccount(ctx, account))
+	_, err = verifier.VerifyAccessKey(ctx, uuid.MustParse(key.ID), "k3y")
+	assert.Error(t, err)
+
+	account.Disabled = false
+	assert.NoError(t, as.UpdateAccount(ctx, account))
+	assert.NoError(t, x.NewCacheRevocationList(redis).RevokeAccount(account.ID))
+	_, err = verifier.VerifyAccessKey(ctx, uuid.MustParse(key.ID), "k3y")
+	assert.Error(t, err)
+	assert.NoError(t, redis.Del("revoked:account:"+account.ID))
+}
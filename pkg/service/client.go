@@ -9,7 +9,12 @@ import (
 	"github.com/emrgen/authbase/pkg/store"
 	x "github.com/emrgen/authbase/x"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/url"
+	"slices"
+	"strings"
 )
 
 // NewClientService creates a new ClientService.
@@ -54,12 +59,38 @@ func (c *ClientService) CreateClient(ctx context.Context, request *v1.CreateClie
 		return nil, err
 	}
 
+	redirectURIs := request.GetRedirectUris()
+	if request.GetRedirectUri() != "" {
+		redirectURIs = append(redirectURIs, request.GetRedirectUri())
+	}
+	if err := validateRedirectURIs(redirectURIs); err != nil {
+		return nil, err
+	}
+	if err := validateGrantTypes(request.GetGrantTypes()); err != nil {
+		return nil, err
+	}
+
+	if err := validateScopes(request.GetScopes()); err != nil {
+		return nil, err
+	}
+
 	client := model.Client{
-		ID:          uuid.New().String(),
-		PoolID:      pool.ID,
-		Name:        request.GetName(),
-		CreatedByID: accountID.String(),
+		ID:           uuid.New().String(),
+		PoolID:       pool.ID,
+		Name:         request.GetName(),
+		CreatedByID:  accountID.String(),
+		RedirectURIs: strings.Join(redirectURIs, " "),
+		GrantTypes:   strings.Join(request.GetGrantTypes(), " "),
+		Scopes:       strings.Join(request.GetScopes(), " "),
+	}
+
+	// the secret is only returned here, the client keeps the hash
+	var clientSecret *string
+	if request.GetConfidential() {
+		secret := setClientSecret(&client)
+		clientSecret = &secret
 	}
+
 	err = as.CreateClient(ctx, &client)
 	if err != nil {
 		return nil, err
@@ -70,17 +101,22 @@ func (c *ClientService) CreateClient(ctx context.Context, request *v1.CreateClie
 		return nil, err
 	}
 
-	// TODO: should we return the client secret to the user?
 	return &v1.CreateClientResponse{
 		Client: &v1.Client{
-			Id:     client.ID,
-			PoolId: request.GetPoolId(),
-			Name:   client.Name,
+			Id:           client.ID,
+			PoolId:       request.GetPoolId(),
+			Name:         client.Name,
+			RedirectUris: clientRedirectURIs(&client),
+			GrantTypes:   clientGrantTypes(&client),
+			Scopes:       clientScopes(&client),
+			Confidential: client.SecretHash != "",
+			TokenPolicy:  tokenPolicyProto(client.TokenPolicy),
 			CreatedByUser: &v1.Account{
 				Id:          accountID.String(),
 				VisibleName: account.VisibleName,
 			},
 		},
+		ClientSecret: clientSecret,
 	}, nil
 }
 
@@ -102,9 +138,14 @@ func (c *ClientService) GetClient(ctx context.Context, request *v1.GetClientRequ
 
 	return &v1.GetClientResponse{
 		Client: &v1.Client{
-			Id:     client.ID,
-			PoolId: client.PoolID,
-			Name:   client.Name,
+			Id:           client.ID,
+			PoolId:       client.PoolID,
+			Name:         client.Name,
+			RedirectUris: clientRedirectURIs(client),
+			GrantTypes:   clientGrantTypes(client),
+			Scopes:       clientScopes(client),
+			Confidential: client.SecretHash != "",
+			TokenPolicy:  tokenPolicyProto(client.TokenPolicy),
 			CreatedByUser: &v1.Account{
 				Id:          client.CreatedByID,
 				VisibleName: client.CreatedByAccount.VisibleName,
@@ -134,10 +175,15 @@ func (c *ClientService) ListClients(ctx context.Context, request *v1.ListClients
 	var clientProtos []*v1.Client
 	for _, client := range clients {
 		clientProtos = append(clientProtos, &v1.Client{
-			Id:        client.ID,
-			PoolId:    client.PoolID,
-			Name:      client.Name,
-			CreatedAt: timestamppb.New(client.CreatedAt),
+			Id:           client.ID,
+			PoolId:       client.PoolID,
+			Name:         client.Name,
+			RedirectUris: clientRedirectURIs(client),
+			GrantTypes:   clientGrantTypes(client),
+			Scopes:       clientScopes(client),
+			Confidential: client.SecretHash != "",
+			TokenPolicy:  tokenPolicyProto(client.TokenPolicy),
+			CreatedAt:    timestamppb.New(client.CreatedAt),
 			CreatedByUser: &v1.Account{
 				Id:          client.CreatedByID,
 				VisibleName: client.CreatedByAccount.VisibleName,
@@ -156,55 +202,72 @@ func (c *ClientService) ListClients(ctx context.Context, request *v1.ListClients
 	}, nil
 }
 
+// UpdateClient updates the name, the redirect uris, the grant types and the scopes of a client.
 func (c *ClientService) UpdateClient(ctx context.Context, request *v1.UpdateClientRequest) (*v1.UpdateClientResponse, error) {
-	var err error
-	userID, err := uuid.Parse(request.GetClientId())
-	if err != nil {
-		return nil, err
-	}
-
 	as, err := store.GetProjectStore(ctx, c.store)
 	if err != nil {
 		return nil, err
 	}
 
-	member, err := as.GetAccountByID(ctx, userID)
+	accountID, err := x.GetAuthbaseAccountID(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	orgID, err := uuid.Parse(member.ProjectID)
+	err = c.perm.CheckProjectPermission(ctx, accountID, permission.ProjectPermissionWrite)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.perm.CheckProjectPermission(ctx, orgID, "write")
+	clientID, err := uuid.Parse(request.GetClientId())
 	if err != nil {
 		return nil, err
 	}
 
-	// update the member and the permission
+	var client *model.Client
 	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		perm, err := tx.GetProjectMemberByID(ctx, orgID, userID)
+		client, err = tx.GetClientByID(ctx, clientID)
 		if err != nil {
 			return err
 		}
 
-		if request.GetPermission() != v1.Permission_UNKNOWN {
-			perm.Permission = uint32(request.GetPermission())
+		if request.Name != nil {
+			client.Name = request.GetName()
 		}
 
-		err = tx.UpdateAccount(ctx, member)
-		if err != nil {
-			return err
+		redirectURIs := request.GetRedirectUris()
+		if request.RedirectUri != nil {
+			redirectURIs = append(redirectURIs, request.GetRedirectUri())
+		}
+		if len(redirectURIs) > 0 {
+			if err := validateRedirectURIs(redirectURIs); err != nil {
+				return err
+			}
+			client.RedirectURIs = strings.Join(redirectURIs, " ")
 		}
 
-		err = tx.UpdateProjectMember(ctx, perm)
-		if err != nil {
-			return err
+		if len(request.GetGrantTypes()) > 0 {
+			if err := validateGrantTypes(request.GetGrantTypes()); err != nil {
+				return err
+			}
+			client.GrantTypes = strings.Join(request.GetGrantTypes(), " ")
+		}
+
+		if len(request.GetScopes()) > 0 {
+			if err := validateScopes(request.GetScopes()); err != nil {
+				return err
+			}
+			client.Scopes = strings.Join(request.GetScopes(), " ")
+		}
+
+		if request.TokenPolicy != nil {
+			if err := validateTokenPolicy(request.GetTokenPolicy()); err != nil {
+				return err
+			}
+			client.TokenPolicy = tokenPolicyModel(request.GetTokenPolicy())
 		}
 
-		return nil
+		return tx.UpdateClient(ctx, client)
 	})
 	if err != nil {
 		return nil, err
@@ -212,11 +275,59 @@ func (c *ClientService) UpdateClient(ctx context.Context, request *v1.UpdateClie
 
 	return &v1.UpdateClientResponse{
 		Client: &v1.Client{
-			Id: member.ID,
+			Id:           client.ID,
+			PoolId:       client.PoolID,
+			Name:         client.Name,
+			RedirectUris: clientRedirectURIs(client),
+			GrantTypes:   clientGrantTypes(client),
+			Scopes:       clientScopes(client),
+			Confidential: client.SecretHash != "",
+			TokenPolicy:  tokenPolicyProto(client.TokenPolicy),
+			CreatedAt:    timestamppb.New(client.CreatedAt),
+			UpdatedAt:    timestamppb.New(client.UpdatedAt),
 		},
 	}, nil
 }
 
+// RotateClientSecret replaces the secret of a client, a public client becomes confidential.
+func (c *ClientService) RotateClientSecret(ctx context.Context, request *v1.RotateClientSecretRequest) (*v1.RotateClientSecretResponse, error) {
+	as, err := store.GetProjectStore(ctx, c.store)
+	if err != nil {
+		return nil, err
+	}
+
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.perm.CheckProjectPermission(ctx, accountID, permission.ProjectPermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := uuid.Parse(request.GetClientId())
+	if err != nil {
+		return nil, err
+	}
+
+	var secret string
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		client, err := tx.GetClientByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+
+		secret = setClientSecret(client)
+		return tx.UpdateClient(ctx, client)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RotateClientSecretResponse{ClientSecret: secret}, nil
+}
+
 func (c *ClientService) DeleteClient(ctx context.Context, request *v1.DeleteClientRequest) (*v1.DeleteClientResponse, error) {
 	as, err := store.GetProjectStore(ctx, c.store)
 	if err != nil {
@@ -236,3 +347,46 @@ func (c *ClientService) DeleteClient(ctx context.Context, request *v1.DeleteClie
 	return &v1.DeleteClientResponse{}, nil
 
 }
+
+// validateRedirectURIs checks that the redirect uris are absolute and carry no fragment (RFC 6749 3.1.2)
+func validateRedirectURIs(uris []string) error {
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return status.Errorf(codes.InvalidArgument, "invalid redirect uri: %s", uri)
+		}
+	}
+
+	return nil
+}
+
+// validateGrantTypes checks that the grant types are supported by the token endpoint
+func validateGrantTypes(grantTypes []string) error {
+	for _, grantType := range grantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return status.Errorf(codes.InvalidArgument, "unsupported grant type: %s", grantType)
+		}
+	}
+
+	return nil
+}
+
+// validateScopes checks that the scopes are valid scope tokens (RFC 6749 3.3)
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n\"\\") {
+			return status.Errorf(codes.InvalidArgument, "invalid scope: %q", scope)
+		}
+	}
+
+	return nil
+}
+
+// setClientSecret generates a new secret for the client and stores its hash, the plain secret is returned
+func setClientSecret(client *model.Client) string {
+	secret := x.GenerateClientSecret()
+	client.SecretSalt = ""
+	client.SecretHash = x.HashPassword(secret)
+
+	return secret
+}
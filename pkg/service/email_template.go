@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/permission"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxEmailTemplateSize is the largest subject, html or text of an uploaded template
+const maxEmailTemplateSize = 256 << 10
+
+var _ v1.EmailTemplateServiceServer = new(EmailTemplateService)
+
+// NewEmailTemplateService creates a new email template service.
+func NewEmailTemplateService(perm permission.MemberPermission, store store.Provider) *EmailTemplateService {
+	return &EmailTemplateService{perm: perm, store: store}
+}
+
+// EmailTemplateService manages the email templates of the projects, the projects without one use the embedded templates
+type EmailTemplateService struct {
+	perm  permission.MemberPermission
+	store store.Provider
+	v1.UnimplementedEmailTemplateServiceServer
+}
+
+// UploadEmailTemplate creates or replaces the template of the project kind in the locale.
+// The template must render the sample data of the kind, the broken templates are rejected before any email uses them.
+func (e *EmailTemplateService) UploadEmailTemplate(ctx context.Context, request *v1.UploadEmailTemplateRequest) (*v1.UploadEmailTemplateResponse, error) {
+	projectID, kind, locale, err := emailTemplateKey(request.GetProjectId(), request.GetKind(), request.GetLocale())
+	if err != nil {
+		return nil, err
+	}
+	if err := e.perm.CheckProjectPermission(ctx, projectID, "write"); err != nil {
+		return nil, err
+	}
+
+	if request.GetHtml() == "" && request.GetText() == "" {
+		return nil, status.Error(codes.InvalidArgument, "html or text is required")
+	}
+	for _, part := range []string{request.GetSubject(), request.GetHtml(), request.GetText()} {
+		if len(part) > maxEmailTemplateSize {
+			return nil, status.Errorf(codes.InvalidArgument, "template parts can not be larger than %d bytes", maxEmailTemplateSize)
+		}
+	}
+
+	template := &model.EmailTemplate{
+		ID:        uuid.New().String(),
+		ProjectID: projectID.String(),
+		Kind:      kind,
+		Locale:    locale,
+		Subject:   request.GetSubject(),
+		HTML:      request.GetHtml(),
+		Text:      request.GetText(),
+	}
+	_, _, err = previewEmailTemplate(kind, locale, []*mail.Template{emailTemplateDefinition(template)}, mail.SampleData(kind))
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, e.store)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := as.GetProjectByID(ctx, projectID); err != nil {
+		return nil, err
+	}
+	if err := as.SaveEmailTemplate(ctx, template); err != nil {
+		return nil, err
+	}
+
+	return &v1.UploadEmailTemplateResponse{Template: emailTemplateProto(template)}, nil
+}
+
+// GetEmailTemplate returns the template the emails of the kind use in the locale, it is an embedded template
+// or a template of a fallback locale when the project has none in the locale
+func (e *EmailTemplateService) GetEmailTemplate(ctx context.Context, request *v1.GetEmailTemplateRequest) (*v1.GetEmailTemplateResponse, error) {
+	projectID, kind, locale, err := emailTemplateKey(request.GetProjectId(), request.GetKind(), request.GetLocale())
+	if err != nil {
+		return nil, err
+	}
+	if err := e.perm.CheckProjectPermission(ctx, projectID, "read"); err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, e.store)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := as.ListEmailTemplates(ctx, projectID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, locale := range mail.FallbackLocales(locale) {
+		for _, template := range templates {
+			if template.Locale == locale {
+				return &v1.GetEmailTemplateResponse{Template: emailTemplateProto(template)}, nil
+			}
+		}
+		template, err := mail.DefaultTemplate(kind, locale)
+		if errors.Is(err, mail.ErrTemplateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &v1.GetEmailTemplateResponse{Template: &v1.EmailTemplate{
+			ProjectId: projectID.String(),
+			Kind:      template.Kind,
+			Locale:    template.Locale,
+			Subject:   template.Subject,
+			Html:      template.HTML,
+			Text:      template.Text,
+		}}, nil
+	}
+
+	return nil, status.Error(codes.NotFound, "email template not found")
+}
+
+// ListEmailTemplates returns the uploaded templates of the project
+func (e *EmailTemplateService) ListEmailTemplates(ctx context.Context, request *v1.ListEmailTemplatesRequest) (*v1.ListEmailTemplatesResponse, error) {
+	projectID, err := uuid.Parse(request.GetProjectId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid project id")
+	}
+	if err := e.perm.CheckProjectPermission(ctx, projectID, "read"); err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, e.store)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := as.ListEmailTemplates(ctx, projectID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*v1.EmailTemplate
+	for _, template := range templates {
+		res = append(res, emailTemplateProto(template))
+	}
+
+	return &v1.ListEmailTemplatesResponse{Templates: res}, nil
+}
+
+// PreviewEmailTemplate renders the template with the sample data of the kind and the project name.
+// The subject, html and text of the request are previewed in place of the template in use when any of them is set.
+func (e *EmailTemplateService) PreviewEmailTemplate(ctx context.Context, request *v1.PreviewEmailTemplateRequest) (*v1.PreviewEmailTemplateResponse, error) {
+	projectID, kind, locale, err := emailTemplateKey(request.GetProjectId(), request.GetKind(), request.GetLocale())
+	if err != nil {
+		return nil, err
+	}
+	if err := e.perm.CheckProjectPermission(ctx, projectID, "read"); err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, e.store)
+	if err != nil {
+		return nil, err
+	}
+	project, err := as.GetProjectByID(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []*mail.Template
+	if request.Subject != nil || request.Html != nil || request.Text != nil {
+		templates = append(templates, &mail.Template{
+			Kind:    kind,
+			Locale:  locale,
+			Subject: request.GetSubject(),
+			HTML:    request.GetHtml(),
+			Text:    request.GetText(),
+		})
+	} else {
+		stored, err := as.ListEmailTemplates(ctx, projectID, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, template := range stored {
+			templates = append(templates, emailTemplateDefinition(template))
+		}
+	}
+
+	data := mail.SampleData(kind)
+	data.Project = project.Name
+	template, message, err := previewEmailTemplate(kind, locale, templates, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.PreviewEmailTemplateResponse{
+		Locale:  template.Locale,
+		Subject: message.Subject,
+		Html:    message.HTML,
+		Text:    message.Text,
+	}, nil
+}
+
+// ResetEmailTemplate deletes the uploaded templates of the kind, the emails use the embedded templates again
+func (e *EmailTemplateService) ResetEmailTemplate(ctx context.Context, request *v1.ResetEmailTemplateRequest) (*v1.ResetEmailTemplateResponse, error) {
+	projectID, err := uuid.Parse(request.GetProjectId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid project id")
+	}
+	if !mail.ValidKind(request.GetKind()) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown email template kind: %s", request.GetKind())
+	}
+	var locale string
+	if request.GetLocale() != "" {
+		locale, err = mail.ParseLocale(request.GetLocale())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid locale: %s", request.GetLocale())
+		}
+	}
+	if err := e.perm.CheckProjectPermission(ctx, projectID, "write"); err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, e.store)
+	if err != nil {
+		return nil, err
+	}
+	err = as.DeleteEmailTemplates(ctx, projectID, request.GetKind(), locale)
+	if errors.Is(err, store.ErrEmailTemplateNotFound) {
+		return nil, status.Error(codes.NotFound, "email template not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ResetEmailTemplateResponse{Message: "email template reset"}, nil
+}
+
+// renderEmail renders the email of the kind with the project template in the locale of the request.
+// The locales of the Accept-Language header come first, then the project locale. A project template
+// failing to render is logged and the embedded template is used, the email is still sent.
+func renderEmail(ctx context.Context, as store.AuthBaseStore, project *model.Project, kind string, data mail.LinkData) (*mail.Message, error) {
+	locales := mail.AcceptLocales(x.RequestLanguage(ctx))
+	if project.Locale != "" {
+		locales = append(locales, project.Locale)
+	}
+	data.Project = project.Name
+
+	stored, err := as.ListEmailTemplates(ctx, uuid.MustParse(project.ID), kind)
+	if err != nil {
+		return nil, err
+	}
+	var templates []*mail.Template
+	for _, template := range stored {
+		templates = append(templates, emailTemplateDefinition(template))
+	}
+
+	template, err := mail.Resolve(kind, locales, templates)
+	if err != nil {
+		return nil, err
+	}
+	message, err := mail.Render(template, data)
+	if err == nil || len(templates) == 0 {
+		return message, err
+	}
+
+	logrus.Errorf("failed to render the %s email template of project %s: %v", kind, project.ID, err)
+	template, err = mail.Resolve(kind, locales, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return mail.Render(template, data)
+}
+
+// previewEmailTemplate resolves the template of the kind in the locale and renders it,
+// the render errors are the mistakes of the template author
+func previewEmailTemplate(kind, locale string, templates []*mail.Template, data mail.LinkData) (*mail.Template, *mail.Message, error) {
+	template, err := mail.Resolve(kind, []string{locale}, templates)
+	if err != nil {
+		return nil, nil, err
+	}
+	message, err := mail.Render(template, data)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid email template: %v", err)
+	}
+
+	return template, message, nil
+}
+
+// emailTemplateKey parses the project, the kind and the canonical locale of a template
+func emailTemplateKey(projectID, kind, locale string) (uuid.UUID, string, string, error) {
+	id, err := uuid.Parse(projectID)
+	if err != nil {
+		return uuid.Nil, "", "", status.Error(codes.InvalidArgument, "invalid project id")
+	}
+	if !mail.ValidKind(kind) {
+		return uuid.Nil, "", "", status.Errorf(codes.InvalidArgument, "unknown email template kind: %s", kind)
+	}
+	canonical, err := mail.ParseLocale(locale)
+	if err != nil {
+		return uuid.Nil, "", "", status.Errorf(codes.InvalidArgument, "invalid locale: %s", locale)
+	}
+
+	return id, kind, canonical, nil
+}
+
+func emailTemplateDefinition(template *model.EmailTemplate) *mail.Template {
+	return &mail.Template{
+		Kind:    template.Kind,
+		Locale:  template.Locale,
+		Subject: template.Subject,
+		HTML:    template.HTML,
+		Text:    template.Text,
+	}
+}
+
+func emailTemplateProto(template *model.EmailTemplate) *v1.EmailTemplate {
+	return &v1.EmailTemplate{
+		ProjectId: template.ProjectID,
+		Kind:      template.Kind,
+		Locale:    template.Locale,
+		Subject:   template.Subject,
+		Html:      template.HTML,
+		Text:      template.Text,
+		Custom:    true,
+		CreatedAt: timestamppb.New(template.CreatedAt),
+		UpdatedAt: timestamppb.New(template.UpdatedAt),
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"testing"
+	"time"
+)
+
+// TestRenderEmail function to test the project templates are used in the locale of the request
+func TestRenderEmail(t *testing.T) {
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := context.TODO()
+	as := store.NewGormStore(tester.TestDB())
+	project := &model.Project{ID: uuid.New().String(), Name: "acme", OwnerID: uuid.New().String()}
+	assert.NoError(t, as.CreateProject(ctx, project))
+
+	for _, template := range []*model.EmailTemplate{
+		{ID: uuid.New().String(), ProjectID: project.ID, Kind: mail.KindReset, Locale: "pt", Subject: "Redefina a senha do {{ .Project }}", Text: "{{ .Code }}"},
+		{ID: uuid.New().String(), ProjectID: project.ID, Kind: mail.KindReset, Locale: "de", Subject: "Passwort zurücksetzen", Text: "{{ .Code }}"},
+	} {
+		assert.NoError(t, as.SaveEmailTemplate(ctx, template))
+	}
+	// the upload replaces the template of the locale
+	assert.NoError(t, as.SaveEmailTemplate(ctx, &model.EmailTemplate{ID: uuid.New().String(), ProjectID: project.ID, Kind: mail.KindReset, Locale: "de", Subject: "Neues Passwort", Text: "{{ .Code }}"}))
+	templates, err := as.ListEmailTemplates(ctx, uuid.MustParse(project.ID), mail.KindReset)
+	assert.NoError(t, err)
+	assert.Len(t, templates, 2)
+
+	data := mail.NewLinkData("jane@authbase.test", "c0de", "https://app.test/reset-password?code=c0de", time.Hour)
+	requestCtx := metadata.NewIncomingContext(ctx, metadata.Pairs("accept-language", "pt-BR,pt;q=0.9"))
+	message, err := renderEmail(requestCtx, as, project, mail.KindReset, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Redefina a senha do acme", message.Subject)
+	assert.Equal(t, "c0de", message.Text)
+	assert.Empty(t, message.HTML)
+
+	// without a requested locale the project locale is used, then the embedded template
+	project.Locale = "de"
+	message, err = renderEmail(ctx, as, project, mail.KindReset, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Neues Passwort", message.Subject)
+
+	message, err = renderEmail(ctx, as, project, mail.KindVerification, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Verify your email", message.Subject)
+
+	assert.NoError(t, as.DeleteEmailTemplates(ctx, uuid.MustParse(project.ID), mail.KindReset, ""))
+	assert.ErrorIs(t, as.DeleteEmailTemplates(ctx, uuid.MustParse(project.ID), mail.KindReset, "de"), store.ErrEmailTemplateNotFound)
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// emailCodeDuration is how long the verification and password reset codes are valid
+	emailCodeDuration = 24 * time.Hour
+	// resendInterval is the least time between two verification emails of an address
+	resendInterval = time.Minute
+)
+
+// ResendVerificationEmail sends a new verification email to an unverified account of the client pool.
+// The response is the same whether the account exists or not, the emails of an address are throttled.
+func (a *AuthService) ResendVerificationEmail(ctx context.Context, request *v1.ResendVerificationEmailRequest) (*v1.ResendVerificationEmailResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	client, err := as.GetClientByID(ctx, uuid.MustParse(request.GetClientId()))
+	if err != nil {
+		return nil, err
+	}
+
+	email := request.GetEmail()
+	ok, wait, err := a.cache.TakeToken("verify:email:resend:"+client.PoolID+":"+strings.ToLower(email), 1, resendInterval)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, x.RateLimitError(ctx, wait)
+	}
+
+	response := &v1.ResendVerificationEmailResponse{Message: "verification email sent"}
+	account, err := as.GetAccountByEmail(ctx, uuid.MustParse(client.PoolID), email)
+	if err != nil {
+		return nil, err
+	}
+	if account.ID == "" || account.Verified || account.Disabled {
+		return response, nil
+	}
+
+	if err := a.sendVerificationEmail(ctx, as, account); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// sendVerificationEmail replaces the pending verification codes of the account and emails the new one
+func (a *AuthService) sendVerificationEmail(ctx context.Context, as store.AuthBaseStore, account *model.Account) error {
+	project, code, err := newEmailCode(ctx, as, account, model.VerificationPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	link := emailLink(a.baseURL(project), "/verify-email", url.Values{"code": {code}, "project_id": {project.ID}})
+	message, err := renderEmail(ctx, as, project, mail.KindVerification, mail.NewLinkData(account.Email, code, link, emailCodeDuration))
+	if err != nil {
+		return err
+	}
+	a.sendMessage(project, message)
+
+	return nil
+}
+
+// sendResetPasswordEmail replaces the pending password reset codes of the account and emails the new one
+func (a *AuthService) sendResetPasswordEmail(ctx context.Context, as store.AuthBaseStore, account *model.Account) error {
+	project, code, err := newEmailCode(ctx, as, account, model.VerificationPurposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	link := emailLink(a.baseURL(project), "/reset-password", url.Values{"code": {code}})
+	message, err := renderEmail(ctx, as, project, mail.KindReset, mail.NewLinkData(account.Email, code, link, emailCodeDuration))
+	if err != nil {
+		return err
+	}
+	a.sendMessage(project, message)
+
+	return nil
+}
+
+// newEmailCode creates a code of the purpose for the account in place of its pending ones, the store keeps the hash of the code
+func newEmailCode(ctx context.Context, as store.AuthBaseStore, account *model.Account, purpose string) (*model.Project, string, error) {
+	project, err := as.GetProjectByID(ctx, uuid.MustParse(account.ProjectID))
+	if err != nil {
+		return nil, "", err
+	}
+
+	code := x.GenerateVerificationCode()
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if err := tx.DeletePurposeCodes(ctx, uuid.MustParse(account.ID), purpose); err != nil {
+			return err
+		}
+		return tx.CreateVerificationCode(ctx, &model.VerificationCode{
+			ID:        uuid.New().String(),
+			AccountID: account.ID,
+			PoolID:    account.PoolID,
+			ProjectID: account.ProjectID,
+			Code:      x.HashToken(code),
+			ExpiresAt: time.Now().Add(emailCodeDuration),
+			Medium:    model.VerificationMediumEmail,
+			Email:     account.Email,
+			Purpose:   purpose,
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return project, code, nil
+}
+
+// useEmailCode consumes a code of the purpose and returns it, the expired and the used codes are not found
+func useEmailCode(ctx context.Context, tx store.AuthBaseStore, purpose, code string) (*model.VerificationCode, error) {
+	vc, err := tx.GetPurposeCode(ctx, purpose, x.HashToken(strings.TrimSpace(code)))
+	if errors.Is(err, store.ErrVerificationCodeNotFound) {
+		return nil, status.Error(codes.InvalidArgument, "invalid or expired code")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.UseVerificationCode(ctx, uuid.MustParse(vc.ID)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid or expired code")
+	}
+	if vc.ExpiresAt.Before(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid or expired code")
+	}
+
+	return vc, nil
+}
+
+// sendMessage sends the email with the project mailer in the background, the failures are logged
+func (a *AuthService) sendMessage(project *model.Project, message *mail.Message) {
+	mailer := a.mailer.Provide(uuid.MustParse(project.ID))
+	go func() {
+		logrus.Infof("sending email to %s", message.To)
+		if err := mailer.Send(message); err != nil {
+			logrus.Errorf("failed to send email: %v", err)
+		}
+	}()
+}
+
+// baseURL returns the address the email links of the project point to, the server public url without a project base url
+func (a *AuthService) baseURL(project *model.Project) string {
+	if project.BaseURL != "" {
+		return project.BaseURL
+	}
+	return a.publicURL
+}
+
+// emailLink joins the path to the base url and sets the query parameters
+func emailLink(baseURL, path string, query url.Values) string {
+	link, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	link.Path = strings.TrimRight(link.Path, "/") + path
+	values := link.Query()
+	for key, value := range query {
+		values[key] = value
+	}
+	link.RawQuery = values.Encode()
+
+	return link.String()
+}
+
+// checkEmailVerified rejects the login of an unverified account when the project requires email verification,
+// the account verifies the email with the emailed link or asks for a new one with ResendVerificationEmail
+func checkEmailVerified(ctx context.Context, as store.AuthBaseStore, account *model.Account) error {
+	if account.Verified {
+		return nil
+	}
+	project, err := as.GetProjectByID(ctx, uuid.MustParse(account.ProjectID))
+	if err != nil {
+		return err
+	}
+	if !project.EmailVerification {
+		return nil
+	}
+
+	st := status.New(codes.FailedPrecondition, "email not verified, verify the email to log in")
+	detailed, err := st.WithDetails(&errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        "EMAIL_NOT_VERIFIED",
+			Subject:     account.Email,
+			Description: "the project requires a verified email to log in",
+		}},
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// validateBaseURL accepts an absolute http or https url
+func validateBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	link, err := url.Parse(baseURL)
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		return status.Error(codes.InvalidArgument, "base_url must be an absolute http or https url")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// TestEmailLink function to test the email links keep the base url path and query
+func TestEmailLink(t *testing.T) {
+	link := emailLink("https://app.test/auth/", "/verify-email", map[string][]string{"code": {"c0de"}})
+	assert.Equal(t, "https://app.test/auth/verify-email?code=c0de", link)
+
+	link = emailLink("https://app.test?tenant=a", "/reset-password", map[string][]string{"code": {"c0de"}})
+	assert.Equal(t, "https://app.test/reset-password?code=c0de&tenant=a", link)
+
+	assert.NoError(t, validateBaseURL(""))
+	assert.NoError(t, validateBaseURL("https://app.test/auth"))
+	assert.Error(t, validateBaseURL("app.test"))
+	assert.Error(t, validateBaseURL("javascript:alert(1)"))
+}
+
+// TestAccessKeyEmailVerified function to test the access keys created with the password need a verified email
+func TestAccessKeyEmailVerified(t *testing.T) {
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := context.TODO()
+	as := store.NewGormStore(tester.TestDB())
+	project, _, account := createMfaAccount(t, as)
+	project.EmailVerification = true
+	assert.NoError(t, as.UpdateProject(ctx, project))
+	account.Verified = false
+	assert.NoError(t, as.UpdateAccount(ctx, account))
+	service := NewAccessKeyService(nil, store.NewDefaultProvider(as), nil, nil, nil)
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.MD{})
+	ctx = context.WithValue(ctx, x.AccountIDKey, uuid.MustParse(account.ID))
+	ctx = context.WithValue(ctx, x.PoolIDKey, uuid.MustParse(account.PoolID))
+	ctx = context.WithValue(ctx, x.PasswordAuthKey, true)
+
+	_, err := service.CreateAccessKey(ctx, &v1.CreateAccessKeyRequest{Email: account.Email, Password: testMfaPassword})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "email not verified")
+}
+
+// TestForgotPasswordThrottle function to test the password reset emails of an address are sent once a minute
+func TestForgotPasswordThrottle(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	_, client, account := createMfaAccount(t, as)
+	mailer, err := mail.NewMailerProvider(&config.MailConfig{Transport: "memory"}, nil)
+	assert.NoError(t, err)
+	transport := mailer.Transport().(*mail.MemoryMailer)
+	service := NewAuthService(store.NewDefaultProvider(as), x.NewUnverifiedKeyProvider(), nil, mailer, redis, nil, "", "")
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	_, err = service.ForgotPassword(ctx, &v1.ForgotPasswordRequest{ClientId: client.ID, Email: account.Email})
+	assert.NoError(t, err)
+	_, err = service.ForgotPassword(ctx, &v1.ForgotPasswordRequest{ClientId: client.ID, Email: "JANE@authbase.test"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the addresses without an account are throttled the same way
+	_, err = service.ForgotPassword(ctx, &v1.ForgotPasswordRequest{ClientId: client.ID, Email: "john@authbase.test"})
+	assert.NoError(t, err)
+	_, err = service.ForgotPassword(ctx, &v1.ForgotPasswordRequest{ClientId: client.ID, Email: "john@authbase.test"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	assert.Eventually(t, func() bool { return len(transport.Messages()) == 1 }, time.Second, 10*time.Millisecond)
+}
@@ -264,6 +264,46 @@ func (g *GroupService) RemoveGroupMember(ctx context.Context, request *v1.Remove
 	return &v1.RemoveGroupMemberResponse{}, nil
 }
 
+// AddGroupClient adds a client to a group, client credentials tokens carry the roles of the client groups.
+func (g *GroupService) AddGroupClient(ctx context.Context, request *v1.AddGroupClientRequest) (*v1.AddGroupClientResponse, error) {
+	as, err := store.GetProjectStore(ctx, g.store)
+	if err != nil {
+		return nil, err
+	}
+
+	groupID := uuid.MustParse(request.GetGroupId())
+	clientID := uuid.MustParse(request.GetClientId())
+	groupMember := &model.GroupMemberClient{
+		GroupID:  groupID.String(),
+		ClientID: clientID.String(),
+	}
+
+	err = as.AddGroupMemberClient(ctx, groupMember)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.AddGroupClientResponse{}, nil
+}
+
+// RemoveGroupClient removes a client from a group.
+func (g *GroupService) RemoveGroupClient(ctx context.Context, request *v1.RemoveGroupClientRequest) (*v1.RemoveGroupClientResponse, error) {
+	as, err := store.GetProjectStore(ctx, g.store)
+	if err != nil {
+		return nil, err
+	}
+
+	groupID := uuid.MustParse(request.GetGroupId())
+	clientID := uuid.MustParse(request.GetClientId())
+
+	err = as.RemoveGroupMemberClient(ctx, groupID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RemoveGroupClientResponse{}, nil
+}
+
 func (g *GroupService) ListGroupMembers(ctx context.Context, request *v1.ListGroupMembersRequest) (*v1.ListGroupMembersResponse, error) {
 	as, err := store.GetProjectStore(ctx, g.store)
 	if err != nil {
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/oauth"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"time"
+)
+
+// idpStateDuration is how long the user has to log in at the identity provider
+const idpStateDuration = 10 * time.Minute
+
+// idpLoginRequest is a pending identity provider login, it is stored under the state
+type idpLoginRequest struct {
+	Provider string `json:"provider"`
+	ClientID string `json:"client_id"`
+	Verifier string `json:"verifier"` // the PKCE code verifier sent to the provider
+}
+
+// LoginUsingIdp starts the identity provider login of the client pool and returns the consent page url of the provider
+func (a *AuthService) LoginUsingIdp(ctx context.Context, request *v1.LoginUsingIdpRequest) (*v1.LoginUsingIdpResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := uuid.Parse(request.GetClientId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid client id")
+	}
+	client, err := as.GetClientByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := a.idpProvider(ctx, as, client, request.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+
+	state := x.Keygen()
+	verifier := oauth2.GenerateVerifier()
+	data, err := json.Marshal(&idpLoginRequest{Provider: provider.GetName(), ClientID: client.ID, Verifier: verifier})
+	if err != nil {
+		return nil, err
+	}
+	err = a.cache.Set(idpStateKey(state), string(data), idpStateDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.LoginUsingIdpResponse{
+		AuthUrl: provider.AuthCodeURL(state, verifier),
+		State:   state,
+	}, nil
+}
+
+// LoginUsingIdpCallback exchanges the provider code, finds or creates the account of the provider user and issues tokens.
+// The state can be used only once.
+func (a *AuthService) LoginUsingIdpCallback(ctx context.Context, request *v1.LoginUsingIdpCallbackRequest) (*v1.LoginUsingIdpCallbackResponse, error) {
+	key := idpStateKey(request.GetState())
+	data, err := a.cache.Get(key)
+	if err != nil || data == "" {
+		return nil, status.Error(codes.InvalidArgument, "login state not found or expired")
+	}
+	if err := a.cache.Del(key); err != nil {
+		return nil, err
+	}
+
+	var loginRequest idpLoginRequest
+	if err := json.Unmarshal([]byte(data), &loginRequest); err != nil {
+		return nil, err
+	}
+	if loginRequest.Provider != request.GetProvider() {
+		return nil, status.Error(codes.InvalidArgument, "login state belongs to another provider")
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := as.GetClientByID(ctx, uuid.MustParse(loginRequest.ClientID))
+	if err != nil {
+		return nil, err
+	}
+	provider, err := a.idpProvider(ctx, as, client, loginRequest.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	idpToken, err := provider.GetToken(ctx, request.GetCode(), loginRequest.Verifier)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "failed to exchange the %s code: %v", provider.GetName(), err)
+	}
+	user, err := provider.GetUser(ctx, idpToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to fetch the %s user: %v", provider.GetName(), err)
+	}
+
+	return a.idpLogin(ctx, as, client, provider.GetName(), user)
+}
+
+// GetIdpToken gets the token from the identity provider code
+func (a *AuthService) GetIdpToken(ctx context.Context, request *v1.GetIdpTokenRequest) (*v1.GetIdpTokenResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	// get the client id and secret from the request
+	clientID, err := uuid.Parse(request.GetClientId())
+	if err != nil {
+		return nil, err
+	}
+	client, err := as.GetClientByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := a.idpProvider(ctx, as, client, request.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+
+	// get the token from the provider
+	token, err := provider.GetToken(ctx, request.Code, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetIdpTokenResponse{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    timestamppb.New(token.Expiry),
+		IssuedAt:     timestamppb.New(time.Now()),
+	}, nil
+}
+
+// idpLogin finds or creates the account of the identity provider user, syncs its groups and issues tokens.
+// The accounts with a second factor get an mfa token instead, it is exchanged with VerifyMfa.
+func (a *AuthService) idpLogin(ctx context.Context, as store.AuthBaseStore, client *model.Client, provider string, user *oauth.User) (*v1.LoginUsingIdpCallbackResponse, error) {
+	account, created, err := idpAccount(ctx, as, client, provider, user)
+	if err != nil {
+		return nil, err
+	}
+	if account.Disabled {
+		return nil, status.Error(codes.PermissionDenied, "account is disabled")
+	}
+	// the group memberships are synced before issuing the token, the token roles follow the upstream groups
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		return syncIdpGroups(ctx, tx, account, provider, user.Groups)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &v1.LoginUsingIdpCallbackResponse{
+		Account: &v1.Account{
+			Id:          account.ID,
+			Username:    account.Username,
+			Email:       account.Email,
+			VisibleName: account.VisibleName,
+			CreatedAt:   timestamppb.New(account.CreatedAt),
+			UpdatedAt:   timestamppb.New(account.UpdatedAt),
+		},
+		Created: created,
+	}
+
+	// the upstream login does not replace the second factor of the account
+	required, enroll, err := mfaRequired(ctx, as, account)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		res.MfaToken, err = createMfaChallenge(a.cache, account, client.ID, enroll)
+		if err != nil {
+			return nil, err
+		}
+		res.MfaEnrollmentRequired = enroll
+		return res, nil
+	}
+
+	token, err := a.issuer.issue(ctx, as, account, client.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Token = &v1.AuthToken{
+		AccessToken:      token.AccessToken,
+		RefreshToken:     token.RefreshToken,
+		ExpiresAt:        timestamppb.New(token.ExpireAt),
+		IssuedAt:         timestamppb.New(token.IssuedAt),
+		RefreshExpiresAt: timestamppb.New(token.RefreshExpireAt),
+	}
+
+	return res, nil
+}
+
+// idpProvider returns the configured identity provider of the client pool, name is the lower case provider name
+func (a *AuthService) idpProvider(ctx context.Context, as store.AuthBaseStore, client *model.Client, name string) (oauth.Provider, error) {
+	provider, err := as.GetOauthProviderByName(ctx, uuid.MustParse(client.PoolID), idpStoreName(name))
+	if errors.Is(err, store.ErrOauthProviderNotFound) {
+		return nil, status.Errorf(codes.NotFound, "identity provider %s is not configured for the pool", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	callbackURL := provider.Config.CallbackURL
+	if callbackURL == "" {
+		callbackURL = a.publicURL + "/auth/idp/" + name + "/callback"
+	}
+
+	config := oauth2.Config{
+		ClientID:     provider.Config.ClientID,
+		ClientSecret: provider.Config.ClientSecret,
+		RedirectURL:  callbackURL,
+		Scopes:       strings.Fields(provider.Config.Scopes),
+	}
+	// generic oidc providers are configured from the discovery document of their issuer
+	if name == "oidc" {
+		oauthProvider, err := oauth.NewOIDCProvider(ctx, config, oauth.OIDCConfig{
+			Issuer:      provider.Config.Issuer,
+			EmailClaim:  provider.Config.EmailClaim,
+			NameClaim:   provider.Config.NameClaim,
+			GroupsClaim: provider.Config.GroupsClaim,
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to configure the oidc provider: %v", err)
+		}
+		return oauthProvider, nil
+	}
+
+	oauthProvider, err := oauth.GetProvider(name, config)
+	if errors.Is(err, oauth.ErrUnsupportedProvider) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported identity provider %s", name)
+	}
+
+	return oauthProvider, err
+}
+
+// idpAccount finds the account linked to the provider user, or links the pool account of the same verified email,
+// or creates a new account in the client pool. It reports whether the account was created.
+func idpAccount(ctx context.Context, as store.AuthBaseStore, client *model.Client, provider string, user *oauth.User) (*model.Account, bool, error) {
+	poolID := uuid.MustParse(client.PoolID)
+	identity, err := as.GetIdentity(ctx, poolID, provider, user.ID)
+	if err == nil {
+		account, err := as.GetAccountByID(ctx, uuid.MustParse(identity.AccountID))
+		return account, false, err
+	}
+	if !errors.Is(err, store.ErrIdentityNotFound) {
+		return nil, false, err
+	}
+
+	var account *model.Account
+	if user.Email != "" {
+		account, err = as.GetAccountByEmail(ctx, poolID, user.Email)
+		if err != nil {
+			return nil, false, err
+		}
+		if account.ID == "" {
+			account = nil
+		}
+	}
+	// an unverified provider email could be used to take over the account
+	if account != nil && !user.EmailVerified {
+		return nil, false, status.Errorf(codes.AlreadyExists, "an account with the email exists, login with it to link %s", provider)
+	}
+
+	created := account == nil
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if created {
+			account = &model.Account{
+				ID:          uuid.New().String(),
+				PoolID:      client.PoolID,
+				ProjectID:   client.Pool.ProjectID,
+				Username:    idpUsername(user),
+				Email:       user.Email,
+				VisibleName: user.Name,
+				Verified:    user.EmailVerified,
+			}
+			if user.EmailVerified {
+				account.VerifiedAt = time.Now()
+			}
+			if err := tx.CreateAccount(ctx, account); err != nil {
+				return err
+			}
+		}
+
+		return tx.CreateIdentity(ctx, &model.Identity{
+			ID:        uuid.New().String(),
+			PoolID:    client.PoolID,
+			Provider:  provider,
+			Subject:   user.ID,
+			AccountID: account.ID,
+			Email:     user.Email,
+		})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return account, created, nil
+}
+
+// syncIdpGroups syncs the account memberships of the pool groups named as the upstream groups.
+// Upstream groups without a pool group are ignored, and only the memberships synced from the provider are removed.
+func syncIdpGroups(ctx context.Context, as store.AuthBaseStore, account *model.Account, provider string, groups []string) error {
+	accountID := uuid.MustParse(account.ID)
+	members, err := as.ListGroupMemberByAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range groups {
+		wanted[name] = true
+	}
+	for _, member := range members {
+		if member.Group == nil {
+			continue
+		}
+		if wanted[member.Group.Name] {
+			delete(wanted, member.Group.Name)
+			continue
+		}
+		if member.Provider == provider {
+			if err := as.RemoveGroupMember(ctx, uuid.MustParse(member.GroupID), accountID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name := range wanted {
+		group, err := as.GetGroupByName(ctx, uuid.MustParse(account.PoolID), name)
+		if errors.Is(err, store.ErrGroupNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = as.AddGroupMember(ctx, &model.GroupMemberAccount{
+			GroupID:   group.ID,
+			AccountID: account.ID,
+			Provider:  provider,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// idpUsername picks the username of a new account, the provider login or the email name.
+// Accounts are unique by username and email in the pool, the provider user id is appended when there is no email.
+func idpUsername(user *oauth.User) string {
+	name := user.Username
+	if name == "" {
+		name, _, _ = strings.Cut(user.Email, "@")
+	}
+	if name == "" {
+		name = "user"
+	}
+	if user.Email == "" {
+		name += "-" + user.ID
+	}
+
+	return name
+}
+
+// idpStoreName returns the stored provider name, providers are stored with their Idp enum name
+func idpStoreName(name string) string {
+	return "IDP_" + strings.ToUpper(name)
+}
+
+func idpStateKey(state string) string {
+	return "idp:state:" + state
+}
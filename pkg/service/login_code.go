@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// loginCodeDuration is how long an emailed login code or magic link is valid
+	loginCodeDuration = 10 * time.Minute
+	// loginCodeMaxAttempts is the number of wrong codes after which the pending codes of the email are dropped
+	// and the email can not log in with a code until loginCodeAttemptWindow passed without a wrong code
+	loginCodeMaxAttempts   = 5
+	loginCodeAttemptWindow = time.Hour
+	// loginCodeRequestInterval is the least time between two login codes of an address
+	loginCodeRequestInterval = time.Minute
+)
+
+// RequestLoginCode emails a one-time code or a magic link to the address.
+// The response is the same whether the account exists or not, the account is created by the first login.
+func (a *AuthService) RequestLoginCode(ctx context.Context, request *v1.RequestLoginCodeRequest) (*v1.RequestLoginCodeResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	client, err := as.GetClientByID(ctx, uuid.MustParse(request.GetClientId()))
+	if err != nil {
+		return nil, err
+	}
+
+	link := request.GetMedium() == v1.LoginCodeMedium_LOGIN_CODE_MEDIUM_LINK
+	if link && !slices.Contains(clientRedirectURIs(client), request.GetRedirectUri()) {
+		return nil, status.Error(codes.InvalidArgument, "redirect_uri is not registered for the client")
+	}
+
+	poolID := uuid.MustParse(client.PoolID)
+	email := request.GetEmail()
+	ok, wait, err := a.cache.TakeToken("login:code:request:"+client.PoolID+":"+strings.ToLower(email), 1, loginCodeRequestInterval)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, x.RateLimitError(ctx, wait)
+	}
+
+	account, err := as.GetAccountByEmail(ctx, poolID, email)
+	if err != nil {
+		return nil, err
+	}
+	response := &v1.RequestLoginCodeResponse{Message: "login code sent"}
+	if account.Disabled {
+		return response, nil
+	}
+
+	// the links carry a long token, the typed codes are short and protected by the attempt limit
+	code := x.Keygen()
+	if !link {
+		code, err = x.GenerateLoginCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	verificationCode := &model.VerificationCode{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		PoolID:    client.PoolID,
+		ProjectID: client.Pool.ProjectID,
+		Code:      x.HashToken(code),
+		ExpiresAt: time.Now().Add(loginCodeDuration),
+		Medium:    model.VerificationMediumEmail,
+		Email:     email,
+		Purpose:   model.VerificationPurposeLogin,
+	}
+	if link {
+		verificationCode.CallbackURL = request.GetRedirectUri()
+	}
+
+	// a new code replaces the pending ones, the wrong codes counted for the email are kept
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if err := tx.DeleteLoginCodes(ctx, poolID, email); err != nil {
+			return err
+		}
+		return tx.CreateVerificationCode(ctx, verificationCode)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := as.GetProjectByID(ctx, uuid.MustParse(client.Pool.ProjectID))
+	if err != nil {
+		return nil, err
+	}
+	data := mail.NewLinkData(email, code, loginCodeLink(code, verificationCode.CallbackURL, email), loginCodeDuration)
+	message, err := renderEmail(ctx, as, project, mail.KindMagicLink, data)
+	if err != nil {
+		return nil, err
+	}
+	a.sendMessage(project, message)
+
+	return response, nil
+}
+
+// LoginUsingCode exchanges an emailed code for tokens. The code proves the email, so the account is verified,
+// and created when the email has no account yet.
+func (a *AuthService) LoginUsingCode(ctx context.Context, request *v1.LoginUsingCodeRequest) (*v1.LoginUsingPasswordResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	client, err := as.GetClientByID(ctx, uuid.MustParse(request.GetClientId()))
+	if err != nil {
+		return nil, err
+	}
+	poolID := uuid.MustParse(client.PoolID)
+	email := request.GetEmail()
+
+	// the wrong codes of all the codes sent to the email count together
+	value, _ := a.cache.Get(loginCodeAttemptsKey(poolID, email))
+	if attempts, _ := strconv.Atoi(value); attempts >= loginCodeMaxAttempts {
+		return nil, status.Error(codes.ResourceExhausted, "too many invalid codes, retry later")
+	}
+
+	var account *model.Account
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		code, err := tx.GetLoginCode(ctx, poolID, email, x.HashToken(strings.TrimSpace(request.GetCode())))
+		if err != nil {
+			return err
+		}
+		if code.ExpiresAt.Before(time.Now()) {
+			return store.ErrVerificationCodeNotFound
+		}
+		if err := tx.UseVerificationCode(ctx, uuid.MustParse(code.ID)); err != nil {
+			return err
+		}
+
+		account, err = tx.GetAccountByEmail(ctx, poolID, email)
+		if err != nil {
+			return err
+		}
+		if account.ID == "" {
+			username, _, _ := strings.Cut(email, "@")
+			account = &model.Account{
+				ID:         uuid.New().String(),
+				PoolID:     client.PoolID,
+				ProjectID:  client.Pool.ProjectID,
+				Username:   username,
+				Email:      email,
+				Verified:   true,
+				VerifiedAt: time.Now(),
+			}
+			return tx.CreateAccount(ctx, account)
+		}
+		if !account.Verified {
+			account.Verified = true
+			account.VerifiedAt = time.Now()
+			return tx.UpdateAccount(ctx, account)
+		}
+
+		return nil
+	})
+	if errors.Is(err, store.ErrVerificationCodeNotFound) {
+		return nil, a.failLoginCode(ctx, as, poolID, email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := a.cache.Del(loginCodeAttemptsKey(poolID, email)); err != nil {
+		return nil, err
+	}
+
+	if account.Disabled {
+		return nil, status.Error(codes.PermissionDenied, "account is disabled")
+	}
+
+	// the code replaces the password, the second factor is still required
+	required, enroll, err := mfaRequired(ctx, as, account)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		mfaToken, err := createMfaChallenge(a.cache, account, client.ID, enroll)
+		if err != nil {
+			return nil, err
+		}
+		return &v1.LoginUsingPasswordResponse{MfaToken: mfaToken, MfaEnrollmentRequired: enroll}, nil
+	}
+
+	token, err := a.issuer.issue(ctx, as, account, client.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return passwordLoginResponse(account, token), nil
+}
+
+// failLoginCode counts a wrong code of the email, the pending codes are dropped after loginCodeMaxAttempts
+func (a *AuthService) failLoginCode(ctx context.Context, as store.AuthBaseStore, poolID uuid.UUID, email string) error {
+	attempts, err := a.cache.Incr(loginCodeAttemptsKey(poolID, email), loginCodeAttemptWindow)
+	if err != nil {
+		return err
+	}
+	if attempts >= loginCodeMaxAttempts {
+		if err := as.DeleteLoginCodes(ctx, poolID, email); err != nil {
+			return err
+		}
+		return status.Error(codes.Unauthenticated, "too many invalid codes, retry later")
+	}
+
+	return status.Error(codes.Unauthenticated, "invalid or expired code")
+}
+
+// loginCodeLink returns the magic link with the email and the code as query parameters, no link for the typed codes
+func loginCodeLink(code, callbackURL, email string) string {
+	if callbackURL == "" {
+		return ""
+	}
+
+	link, err := url.Parse(callbackURL)
+	if err != nil {
+		return ""
+	}
+	query := link.Query()
+	query.Set("email", email)
+	query.Set("code", code)
+	link.RawQuery = query.Encode()
+
+	return link.String()
+}
+
+func loginCodeAttemptsKey(poolID uuid.UUID, email string) string {
+	return "login:code:attempts:" + poolID.String() + ":" + strings.ToLower(email)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoginCodeMail function to test the login code and magic link emails
+func TestLoginCodeMail(t *testing.T) {
+	template, err := mail.DefaultTemplate(mail.KindMagicLink, mail.DefaultLocale)
+	assert.NoError(t, err)
+
+	message, err := mail.Render(template, mail.NewLinkData("jane@authbase.test", "042517", loginCodeLink("042517", "", "jane@authbase.test"), loginCodeDuration))
+	assert.NoError(t, err)
+	assert.Equal(t, "Your login code", message.Subject)
+	assert.Contains(t, message.HTML, "042517")
+	assert.Contains(t, message.Text, "042517")
+
+	link := loginCodeLink("token", "https://app.test/login?next=%2Fhome", "jane+1@authbase.test")
+	message, err = mail.Render(template, mail.NewLinkData("jane+1@authbase.test", "token", link, loginCodeDuration))
+	assert.NoError(t, err)
+	assert.Equal(t, "Your login link", message.Subject)
+	assert.True(t, strings.Contains(message.HTML, `href="https://app.test/login?code=token&amp;email=jane%2B1%40authbase.test&amp;next=%2Fhome"`), message.HTML)
+	assert.Contains(t, message.Text, "10 minutes")
+}
+
+// TestLoginCodeAttempts function to test a new code neither resets the wrong attempts nor is sent more than once a minute
+func TestLoginCodeAttempts(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	_, client, account := createMfaAccount(t, as)
+	mailer, err := mail.NewMailerProvider(&config.MailConfig{Transport: "memory"}, nil)
+	assert.NoError(t, err)
+	transport := mailer.Transport().(*mail.MemoryMailer)
+	service := NewAuthService(store.NewDefaultProvider(as), x.NewUnverifiedKeyProvider(), nil, mailer, redis, nil, "", "")
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	request := &v1.RequestLoginCodeRequest{ClientId: client.ID, Email: account.Email}
+	_, err = service.RequestLoginCode(ctx, request)
+	assert.NoError(t, err)
+	_, err = service.RequestLoginCode(ctx, request)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	for i := 0; i < loginCodeMaxAttempts; i++ {
+		_, err = service.LoginUsingCode(ctx, &v1.LoginUsingCodeRequest{ClientId: client.ID, Email: account.Email, Code: "invalid"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	// the code sent after the wrong attempts is locked out too
+	assert.NoError(t, redis.Del("login:code:request:"+client.PoolID+":"+account.Email))
+	_, err = service.RequestLoginCode(ctx, request)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(transport.Messages()) == 2 }, time.Second, 10*time.Millisecond)
+	code := regexp.MustCompile(`\b\d{6}\b`).FindString(transport.Messages()[1].Text)
+	assert.NotEmpty(t, code)
+	_, err = service.LoginUsingCode(ctx, &v1.LoginUsingCodeRequest{ClientId: client.ID, Email: account.Email, Code: code})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the code works once the wrong attempts expired
+	assert.NoError(t, redis.Del(loginCodeAttemptsKey(uuid.MustParse(client.PoolID), account.Email)))
+	_, err = service.LoginUsingCode(ctx, &v1.LoginUsingCodeRequest{ClientId: client.ID, Email: account.Email, Code: code})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	netmail "net/mail"
+)
+
+// ProjectMailSenders returns the loader of the project mail senders for the mailer provider
+func ProjectMailSenders(provider store.Provider, appKey string) mail.SenderLoader {
+	return func(projectID uuid.UUID) (*mail.Sender, error) {
+		as, err := provider.Provide(projectID)
+		if err != nil {
+			return nil, err
+		}
+		project, err := as.GetProjectByID(context.Background(), projectID)
+		if err != nil {
+			return nil, err
+		}
+
+		settings := project.MailSettings
+		sender := &mail.Sender{From: settings.From, ReplyTo: settings.ReplyTo}
+		if settings.SMTPHost != "" {
+			password := ""
+			if settings.SMTPPassword != "" {
+				password, err = x.OpenSecret(appKey, settings.SMTPPassword)
+				if err != nil {
+					return nil, err
+				}
+			}
+			sender.SMTP = &config.SMTPConfig{
+				Host:     settings.SMTPHost,
+				Port:     settings.SMTPPort,
+				Username: settings.SMTPUsername,
+				Password: password,
+				TLS:      settings.SMTPTLS,
+			}
+			if sender.SMTP.Port == 0 {
+				sender.SMTP.Port = 587
+			}
+		}
+
+		return sender, nil
+	}
+}
+
+// validateMailSettings rejects the invalid addresses, ports and tls modes
+func validateMailSettings(settings *v1.MailSettings) error {
+	if settings == nil {
+		return nil
+	}
+	for field, address := range map[string]string{"from": settings.GetFrom(), "reply_to": settings.GetReplyTo()} {
+		if address == "" {
+			continue
+		}
+		if _, err := netmail.ParseAddress(address); err != nil {
+			return status.Errorf(codes.InvalidArgument, "mail_settings.%s is not a valid address", field)
+		}
+	}
+	if settings.GetSmtpPort() < 0 || settings.GetSmtpPort() > 65535 {
+		return status.Error(codes.InvalidArgument, "mail_settings.smtp_port is not a valid port")
+	}
+	if err := config.ValidateSMTPTLS(settings.GetSmtpTls()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "mail_settings.smtp_tls: %v", err)
+	}
+
+	return nil
+}
+
+// mailSettingsModel returns the settings with the password sealed, an empty password keeps the current one of the same host
+func mailSettingsModel(settings *v1.MailSettings, current model.MailSettings, appKey string) (model.MailSettings, error) {
+	var password string
+	if settings.GetSmtpPassword() != "" {
+		sealed, err := x.SealSecret(appKey, settings.GetSmtpPassword())
+		if err != nil {
+			return current, err
+		}
+		password = sealed
+	} else if settings.GetSmtpHost() != "" && settings.GetSmtpHost() == current.SMTPHost {
+		password = current.SMTPPassword
+	}
+
+	return model.MailSettings{
+		From:         settings.GetFrom(),
+		ReplyTo:      settings.GetReplyTo(),
+		SMTPHost:     settings.GetSmtpHost(),
+		SMTPPort:     int(settings.GetSmtpPort()),
+		SMTPUsername: settings.GetSmtpUsername(),
+		SMTPPassword: password,
+		SMTPTLS:      settings.GetSmtpTls(),
+	}, nil
+}
+
+// mailSettingsProto returns the settings without the smtp password
+func mailSettingsProto(settings model.MailSettings) *v1.MailSettings {
+	return &v1.MailSettings{
+		From:         settings.From,
+		ReplyTo:      settings.ReplyTo,
+		SmtpHost:     settings.SMTPHost,
+		SmtpPort:     int32(settings.SMTPPort),
+		SmtpUsername: settings.SMTPUsername,
+		SmtpTls:      settings.SMTPTLS,
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/x"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestMailSettingsModel function to test the smtp password is sealed and kept only for the same host
+func TestMailSettingsModel(t *testing.T) {
+	appKey := "test-app-key"
+	settings, err := mailSettingsModel(&v1.MailSettings{From: "hello@tenant.test", SmtpHost: "smtp.tenant.test", SmtpPassword: "secret"}, model.MailSettings{}, appKey)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "secret", settings.SMTPPassword)
+	password, err := x.OpenSecret(appKey, settings.SMTPPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", password)
+	assert.Empty(t, mailSettingsProto(settings).GetSmtpPassword())
+
+	kept, err := mailSettingsModel(&v1.MailSettings{SmtpHost: "smtp.tenant.test", SmtpPort: 465}, settings, appKey)
+	assert.NoError(t, err)
+	assert.Equal(t, settings.SMTPPassword, kept.SMTPPassword)
+
+	moved, err := mailSettingsModel(&v1.MailSettings{SmtpHost: "smtp.other.test"}, settings, appKey)
+	assert.NoError(t, err)
+	assert.Empty(t, moved.SMTPPassword)
+
+	assert.NoError(t, validateMailSettings(&v1.MailSettings{From: "Tenant <hello@tenant.test>", SmtpTls: "tls"}))
+	assert.Error(t, validateMailSettings(&v1.MailSettings{From: "not an address"}))
+	assert.Error(t, validateMailSettings(&v1.MailSettings{SmtpTls: "ssl"}))
+}
@@ -0,0 +1,468 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+const (
+	// mfaChallengeDuration is how long the account has to pass the second factor after the password
+	mfaChallengeDuration = 5 * time.Minute
+	// mfaMaxAttempts is the number of codes checked with a challenge, it is dropped after them
+	mfaMaxAttempts = 5
+	// recoveryCodeCount is the number of recovery codes generated with a factor
+	recoveryCodeCount = 10
+)
+
+// mfaChallenge is a password login waiting for its second factor, it is stored under the mfa token
+type mfaChallenge struct {
+	AccountID string    `json:"account_id"`
+	ClientID  string    `json:"client_id"`
+	Enroll    bool      `json:"enroll"` // the account must enroll a factor to finish the login
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// VerifyMfa exchanges the mfa token and a TOTP or recovery code for tokens
+func (a *AuthService) VerifyMfa(ctx context.Context, request *v1.VerifyMfaRequest) (*v1.LoginUsingPasswordResponse, error) {
+	challenge, err := a.getMfaChallenge(request.GetMfaToken())
+	if err != nil {
+		return nil, err
+	}
+	if challenge.Enroll {
+		return nil, status.Error(codes.FailedPrecondition, "the account must enroll a second factor")
+	}
+	if err := a.takeMfaAttempt(request.GetMfaToken()); err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	accountID := uuid.MustParse(challenge.AccountID)
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := a.checkSecondFactor(ctx, as, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if request.GetRecoveryCode() != "" {
+			return tx.UseRecoveryCode(ctx, accountID, x.HashToken(normalizeRecoveryCode(request.GetRecoveryCode())))
+		}
+		return a.checkTotp(ctx, tx, accountID, request.GetCode(), true)
+	})
+	if errors.Is(err, store.ErrRecoveryCodeNotFound) || errors.Is(err, errInvalidTotpCode) {
+		if err := a.guard.FailSecondFactor(ctx, account.ID, policy); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid code")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := a.dropMfaChallenge(request.GetMfaToken()); err != nil {
+		return nil, err
+	}
+
+	if account.Disabled {
+		return nil, status.Error(codes.PermissionDenied, "account is disabled")
+	}
+
+	token, err := a.issuer.issue(ctx, as, account, challenge.ClientID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return passwordLoginResponse(account, token), nil
+}
+
+// EnrollTotp creates a pending TOTP factor, a pending factor is replaced by a new enrollment.
+// The account is the token account, or the account of an mfa token that requires enrollment.
+func (a *AuthService) EnrollTotp(ctx context.Context, request *v1.EnrollTotpRequest) (*v1.EnrollTotpResponse, error) {
+	accountID, _, err := a.mfaAccount(ctx, request.GetMfaToken())
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(account.PoolID))
+	if err != nil {
+		return nil, err
+	}
+
+	factor, err := as.GetTotpFactor(ctx, accountID)
+	if errors.Is(err, store.ErrTotpFactorNotFound) {
+		factor = &model.TotpFactor{ID: uuid.New().String(), AccountID: account.ID}
+	} else if err != nil {
+		return nil, err
+	} else if factor.Confirmed {
+		return nil, status.Error(codes.AlreadyExists, "totp is already enabled, disable it first")
+	}
+
+	secret, err := x.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	factor.Secret, err = x.SealSecret(a.appKey, secret)
+	if errors.Is(err, x.ErrMissingAppKey) {
+		return nil, status.Error(codes.FailedPrecondition, "mfa requires the APP_KEY to be set")
+	}
+	if err != nil {
+		return nil, err
+	}
+	factor.LastStep = 0
+	if err := as.SaveTotpFactor(ctx, factor); err != nil {
+		return nil, err
+	}
+
+	return &v1.EnrollTotpResponse{
+		Secret: secret,
+		Uri:    x.TOTPURI(pool.Name, account.Email, secret),
+	}, nil
+}
+
+// ConfirmTotp activates the pending factor with its first code and generates the recovery codes.
+// An enrollment with an mfa token finishes the login and returns the tokens.
+func (a *AuthService) ConfirmTotp(ctx context.Context, request *v1.ConfirmTotpRequest) (*v1.ConfirmTotpResponse, error) {
+	accountID, challenge, err := a.mfaAccount(ctx, request.GetMfaToken())
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+
+	// the codes of an mfa token are a login, they are limited like the codes of VerifyMfa
+	var policy model.LockoutPolicy
+	if challenge != nil {
+		if err := a.takeMfaAttempt(request.GetMfaToken()); err != nil {
+			return nil, err
+		}
+		account, err := as.GetAccountByID(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+		if policy, err = a.checkSecondFactor(ctx, as, account); err != nil {
+			return nil, err
+		}
+	}
+
+	var recoveryCodes []string
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		factor, err := tx.GetTotpFactor(ctx, accountID)
+		if errors.Is(err, store.ErrTotpFactorNotFound) {
+			return status.Error(codes.FailedPrecondition, "totp is not enrolled")
+		}
+		if err != nil {
+			return err
+		}
+		if factor.Confirmed {
+			return status.Error(codes.AlreadyExists, "totp is already enabled")
+		}
+		if err := a.checkTotp(ctx, tx, accountID, request.GetCode(), false); err != nil {
+			return err
+		}
+
+		recoveryCodes, err = replaceRecoveryCodes(ctx, tx, accountID)
+		return err
+	})
+	if errors.Is(err, errInvalidTotpCode) {
+		if challenge != nil {
+			if err := a.guard.FailSecondFactor(ctx, accountID.String(), policy); err != nil {
+				return nil, err
+			}
+			return nil, status.Error(codes.Unauthenticated, "invalid code")
+		}
+		return nil, status.Error(codes.InvalidArgument, "invalid totp code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.ConfirmTotpResponse{RecoveryCodes: recoveryCodes}
+	if challenge == nil {
+		return response, nil
+	}
+
+	if err := a.dropMfaChallenge(request.GetMfaToken()); err != nil {
+		return nil, err
+	}
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := a.issuer.issue(ctx, as, account, challenge.ClientID, nil)
+	if err != nil {
+		return nil, err
+	}
+	response.Token = passwordLoginResponse(account, token).Token
+
+	return response, nil
+}
+
+// DisableTotp removes the factor of the token account, the current code is required
+func (a *AuthService) DisableTotp(ctx context.Context, request *v1.DisableTotpRequest) (*v1.DisableTotpResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := a.checkSecondFactor(ctx, as, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if err := a.checkTotp(ctx, tx, accountID, request.GetCode(), true); err != nil {
+			return err
+		}
+		return tx.DeleteTotpFactor(ctx, accountID)
+	})
+	if errors.Is(err, errInvalidTotpCode) {
+		if err := a.guard.FailSecondFactor(ctx, account.ID, policy); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.InvalidArgument, "invalid totp code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.DisableTotpResponse{}, nil
+}
+
+// errInvalidTotpCode is returned by checkTotp when the code does not match
+var errInvalidTotpCode = errors.New("invalid totp code")
+
+// checkTotp checks the code of the account factor and records its time step, confirmed tells which factor state is expected.
+// Confirming a pending factor activates it.
+func (a *AuthService) checkTotp(ctx context.Context, as store.AuthBaseStore, accountID uuid.UUID, code string, confirmed bool) error {
+	factor, err := as.GetTotpFactor(ctx, accountID)
+	if errors.Is(err, store.ErrTotpFactorNotFound) {
+		return status.Error(codes.FailedPrecondition, "totp is not enabled")
+	}
+	if err != nil {
+		return err
+	}
+	if factor.Confirmed != confirmed {
+		return status.Error(codes.FailedPrecondition, "totp is not enabled")
+	}
+
+	secret, err := x.OpenSecret(a.appKey, factor.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := x.ValidateTOTP(secret, strings.TrimSpace(code), time.Now(), factor.LastStep)
+	if !ok {
+		return errInvalidTotpCode
+	}
+
+	factor.LastStep = step
+	if !factor.Confirmed {
+		factor.Confirmed = true
+		factor.ConfirmedAt = time.Now()
+	}
+
+	return as.SaveTotpFactor(ctx, factor)
+}
+
+// mfaRequired tells whether the account must pass a second factor, and whether it must enroll one first
+func mfaRequired(ctx context.Context, as store.AuthBaseStore, account *model.Account) (bool, bool, error) {
+	factor, err := as.GetTotpFactor(ctx, uuid.MustParse(account.ID))
+	if err != nil && !errors.Is(err, store.ErrTotpFactorNotFound) {
+		return false, false, err
+	}
+	if factor != nil && factor.Confirmed {
+		return true, false, nil
+	}
+
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(account.PoolID))
+	if err != nil {
+		return false, false, err
+	}
+
+	return pool.MfaRequired, pool.MfaRequired, nil
+}
+
+// checkMfaNotRequired rejects the password only logins that can not return an mfa token, like the access keys
+// created with the email and password, when the account must pass a second factor
+func checkMfaNotRequired(ctx context.Context, as store.AuthBaseStore, account *model.Account) error {
+	required, _, err := mfaRequired(ctx, as, account)
+	if err != nil || !required {
+		return err
+	}
+
+	st := status.New(codes.FailedPrecondition, "second factor required, log in and use the access token")
+	detailed, err := st.WithDetails(&errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        "MFA_REQUIRED",
+			Subject:     account.ID,
+			Description: "the account must pass a second factor, the password alone is not enough",
+		}},
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// mfaAccount returns the account of the enrollment, from the mfa token when it is set, or from the access token
+func (a *AuthService) mfaAccount(ctx context.Context, mfaToken string) (uuid.UUID, *mfaChallenge, error) {
+	if mfaToken == "" {
+		accountID, err := x.GetAuthbaseAccountID(ctx)
+		return accountID, nil, err
+	}
+
+	challenge, err := a.getMfaChallenge(mfaToken)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if !challenge.Enroll {
+		return uuid.Nil, nil, status.Error(codes.PermissionDenied, "the mfa token does not allow enrollment")
+	}
+
+	return uuid.MustParse(challenge.AccountID), challenge, nil
+}
+
+// createMfaChallenge stores the login of the account until its second factor is checked and returns the mfa token
+func createMfaChallenge(cache *cache.Redis, account *model.Account, clientID string, enroll bool) (string, error) {
+	token := x.Keygen()
+	challenge := &mfaChallenge{
+		AccountID: account.ID,
+		ClientID:  clientID,
+		Enroll:    enroll,
+		ExpiresAt: time.Now().Add(mfaChallengeDuration),
+	}
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+	if err := cache.Set(mfaChallengeKey(token), string(data), mfaChallengeDuration); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (a *AuthService) getMfaChallenge(token string) (*mfaChallenge, error) {
+	data, err := a.cache.Get(mfaChallengeKey(token))
+	if token == "" || err != nil || data == "" {
+		return nil, status.Error(codes.Unauthenticated, "mfa token not found or expired")
+	}
+
+	var challenge mfaChallenge
+	if err := json.Unmarshal([]byte(data), &challenge); err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// takeMfaAttempt counts a code of the challenge before it is checked, the concurrent requests of a challenge
+// check at most mfaMaxAttempts codes and the login restarts with the password
+func (a *AuthService) takeMfaAttempt(token string) error {
+	attempts, err := a.cache.Incr(mfaAttemptsKey(token), mfaChallengeDuration)
+	if err != nil {
+		return err
+	}
+	if attempts > mfaMaxAttempts {
+		if err := a.cache.Del(mfaChallengeKey(token)); err != nil {
+			return err
+		}
+		return status.Error(codes.Unauthenticated, "too many invalid codes, login again")
+	}
+
+	return nil
+}
+
+// dropMfaChallenge removes a passed challenge with its attempts
+func (a *AuthService) dropMfaChallenge(token string) error {
+	if err := a.cache.Del(mfaChallengeKey(token)); err != nil {
+		return err
+	}
+
+	return a.cache.Del(mfaAttemptsKey(token))
+}
+
+// checkSecondFactor rejects the codes of a locked account or of an account waiting after failures,
+// it returns the lockout policy of the pool the wrong codes are recorded with
+func (a *AuthService) checkSecondFactor(ctx context.Context, as store.AuthBaseStore, account *model.Account) (model.LockoutPolicy, error) {
+	if err := a.guard.Check(ctx, account.ID); err != nil {
+		return model.LockoutPolicy{}, err
+	}
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(account.PoolID))
+	if err != nil {
+		return model.LockoutPolicy{}, err
+	}
+
+	return pool.LockoutPolicy, nil
+}
+
+// replaceRecoveryCodes generates new recovery codes for the account, only their hashes are stored
+func replaceRecoveryCodes(ctx context.Context, as store.AuthBaseStore, accountID uuid.UUID) ([]string, error) {
+	plain := make([]string, 0, recoveryCodeCount)
+	models := make([]*model.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		key := x.KeygenSize(5)
+		code := key[:5] + "-" + key[5:]
+		plain = append(plain, code)
+		models = append(models, &model.RecoveryCode{
+			ID:        uuid.New().String(),
+			AccountID: accountID.String(),
+			CodeHash:  x.HashToken(code),
+		})
+	}
+
+	if err := as.ReplaceRecoveryCodes(ctx, accountID, models); err != nil {
+		return nil, err
+	}
+
+	return plain, nil
+}
+
+// normalizeRecoveryCode accepts the recovery codes typed in upper case or with spaces
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+func mfaChallengeKey(token string) string {
+	return "mfa:challenge:" + token
+}
+
+func mfaAttemptsKey(token string) string {
+	return "mfa:attempts:" + token
+}
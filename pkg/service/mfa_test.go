@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/oauth"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMfaPassword = "mfa-password"
+
+// testCache returns the redis of the tests, the tests are skipped when no server is running
+func testCache(t *testing.T) *cache.Redis {
+	redis := tester.TestRedis()
+	if err := redis.Set("authbase:test", "ok", time.Second); err != nil {
+		t.Skipf("redis is not available: %v", err)
+	}
+
+	return redis
+}
+
+// createMfaAccount creates a project with a client and an account that confirmed a TOTP factor
+func createMfaAccount(t *testing.T, as store.AuthBaseStore) (*model.Project, *model.Client, *model.Account) {
+	ctx := context.TODO()
+	accountID := uuid.New().String()
+	project := &model.Project{ID: uuid.New().String(), Name: "mfa-" + uuid.New().String(), PoolID: uuid.New().String(), OwnerID: accountID}
+	assert.NoError(t, as.CreateProject(ctx, project))
+	assert.NoError(t, as.CreatePool(ctx, &model.Pool{ID: project.PoolID, Name: "default", ProjectID: project.ID, Default: true}))
+	client := &model.Client{ID: uuid.New().String(), PoolID: project.PoolID, Name: "default"}
+	assert.NoError(t, as.CreateClient(ctx, client))
+
+	account := &model.Account{
+		ID:           accountID,
+		Username:     "jane",
+		Email:        "jane@authbase.test",
+		PasswordHash: x.HashPassword(testMfaPassword),
+		PoolID:       project.PoolID,
+		ProjectID:    project.ID,
+		Verified:     true,
+	}
+	assert.NoError(t, as.CreateAccount(ctx, account))
+	assert.NoError(t, as.SaveTotpFactor(ctx, &model.TotpFactor{ID: uuid.New().String(), AccountID: account.ID, Secret: "sealed", Confirmed: true}))
+
+	return project, client, account
+}
+
+// TestAccessKeyMfa function to test the access keys created with the password are rejected for accounts with a second factor
+func TestAccessKeyMfa(t *testing.T) {
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	_, _, account := createMfaAccount(t, as)
+	service := NewAccessKeyService(nil, store.NewDefaultProvider(as), nil, nil, nil)
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	ctx = context.WithValue(ctx, x.AccountIDKey, uuid.MustParse(account.ID))
+	ctx = context.WithValue(ctx, x.PoolIDKey, uuid.MustParse(account.PoolID))
+	ctx = context.WithValue(ctx, x.PasswordAuthKey, true)
+
+	_, err := service.CreateAccessKey(ctx, &v1.CreateAccessKeyRequest{Email: account.Email, Password: testMfaPassword})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// TestAdminLoginMfa function to test the admin password login returns an mfa token instead of tokens
+func TestAdminLoginMfa(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	project, _, account := createMfaAccount(t, as)
+	service := NewAdminAuthService(store.NewDefaultProvider(as), &config.AdminProjectConfig{}, x.NewUnverifiedKeyProvider(), redis)
+
+	res, err := service.AdminLoginUsingPassword(metadata.NewIncomingContext(context.TODO(), metadata.MD{}), &v1.AdminLoginUsingPasswordRequest{
+		ProjectName: project.Name,
+		Email:       account.Email,
+		Password:    testMfaPassword,
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, res.MfaToken)
+	assert.Nil(t, res.Token)
+}
+
+// TestIdpLoginMfa function to test the identity provider and SAML logins return an mfa token instead of tokens
+func TestIdpLoginMfa(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	_, client, account := createMfaAccount(t, as)
+	service := NewAuthService(store.NewDefaultProvider(as), x.NewUnverifiedKeyProvider(), nil, nil, redis, nil, "", "")
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	for _, provider := range []string{"github", "saml"} {
+		res, err := service.idpLogin(ctx, as, client, provider, &oauth.User{ID: provider + "-jane", Email: account.Email, EmailVerified: true})
+		assert.NoError(t, err)
+		assert.Equal(t, account.ID, res.Account.Id)
+		assert.NotEmpty(t, res.MfaToken)
+		assert.Nil(t, res.Token)
+	}
+}
+
+// TestVerifyMfaAttempts function to test the concurrent codes of a challenge are limited and the wrong codes count against the account
+func TestVerifyMfaAttempts(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	as := store.NewGormStore(tester.TestDB())
+	_, client, account := createMfaAccount(t, as)
+	service := NewAuthService(store.NewDefaultProvider(as), x.NewUnverifiedKeyProvider(), nil, nil, redis, nil, "", "")
+	mfaToken, err := createMfaChallenge(redis, account, client.ID, false)
+	assert.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	var wg sync.WaitGroup
+	results := make(chan error, 2*mfaMaxAttempts)
+	for i := 0; i < 2*mfaMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.VerifyMfa(ctx, &v1.VerifyMfaRequest{MfaToken: mfaToken, RecoveryCode: "wrong-code"})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// the requests past the attempts find the challenge dropped, the others got through to the code or the guard
+	checked := 0
+	for err := range results {
+		assert.Error(t, err)
+		message := status.Convert(err).Message()
+		if !strings.Contains(message, "too many invalid codes") && !strings.Contains(message, "mfa token not found") {
+			checked++
+		}
+	}
+	assert.LessOrEqual(t, checked, mfaMaxAttempts)
+	_, err = service.VerifyMfa(ctx, &v1.VerifyMfaRequest{MfaToken: mfaToken, RecoveryCode: "wrong-code"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the wrong codes are failures of the account a correct password does not forget
+	lockout, err := service.guard.Lockout(account.ID)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, lockout.FailedAttempts, 1)
+	assert.NoError(t, service.guard.Succeed(account.ID))
+	after, err := service.guard.Lockout(account.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, lockout.FailedAttempts, after.FailedAttempts)
+	assert.NoError(t, service.guard.Unlock(account.ID))
+}
@@ -1,62 +1,688 @@
 package service
 
-//
-//var _ v1.OAuth2ServiceServer = new(OAuth2Service)
-//
-//// OauthService is a service for oauth
-//type OAuth2Service struct {
-//	store store.Provider
-//	cache *cache.Redis
-//	v1.UnimplementedOAuth2ServiceServer
-//}
-//
-//func NewOauthService(store store.Provider, cache *cache.Redis) *OAuth2Service {
-//	return &OAuth2Service{store: store, cache: cache}
-//}
-//
-//// OAuthLogin authorizes a request and returns a response
-//func (o *OAuth2Service) OAuth2Login(ctx context.Context, request *v1.OAuthLoginRequest) (*v1.OAuthLoginResponse, error) {
-//	// get the provider details and redirect to the provider
-//	as, err := store.GetProjectStore(ctx, o.store)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	orgID, err := uuid.Parse(request.GetProjectId())
-//
-//	provider, err := as.GetOauthProviderByName(ctx, orgID, request.Provider)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	// this payload will not reach the client
-//	// grpc interceptors will handle the redirect with additional http.Cookie with the oauthstate
-//	// ref: https://github.com/douglasmakey/oauth2-example
-//	return &v1.OAuthLoginResponse{
-//		Provider: &v1.OAuthProvider{
-//			Name:         provider.Name,
-//			ClientId:     provider.Config.ClientID,
-//			ClientSecret: provider.Config.ClientSecret,
-//			RedirectUris: nil,
-//		},
-//	}, nil
-//}
-//
-//// OAuthCallback handles the callback request after authorization
-//func (o *OAuth2Service) OAuth2Callback(ctx context.Context, request *v1.OAuthCallbackRequest) (*v1.OAuthCallbackResponse, error) {
-//	//cookie, err := x.GetOAuthState(ctx)
-//	//if err != nil {
-//	//	return nil, err
-//	//}
-//	// get the provider details and exchange the code for a token
-//	//TODO implement me
-//	panic("implement me")
-//
-//	return &v1.OAuthCallbackResponse{}, nil
-//}
-//
-//// Logout logs out a user, invalidating the token and removing the session
-//func (o *OAuth2Service) Logout(ctx context.Context, request *v1.OAuth2LogoutRequest) (*v1.OauthLogoutResponse, error) {
-//	//TODO implement me
-//	panic("implement me")
-//}
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// authorizationRequestDuration is how long the user has to log in and answer the consent prompt
+	authorizationRequestDuration = 10 * time.Minute
+	// authorizationCodeDuration is how long an authorization code can be exchanged for tokens
+	authorizationCodeDuration = 2 * time.Minute
+
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+
+	// OAuth2ErrorDomain is the ErrorInfo domain of the OAuth2 errors, the reason is the RFC 6749 error code
+	OAuth2ErrorDomain = "oauth2"
+)
+
+var (
+	// supportedGrantTypes are the grant types a client can register
+	supportedGrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken, GrantTypeClientCredentials}
+	// defaultGrantTypes are allowed for clients that did not register grant types
+	defaultGrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken}
+)
+
+var _ v1.OAuth2ServiceServer = new(OAuth2Service)
+
+// OAuth2Service lets authbase act as an OAuth2 authorization server for the pool clients.
+type OAuth2Service struct {
+	store     store.Provider
+	cache     *cache.Redis
+	issuer    *tokenIssuer
+	loginURL  string
+	publicURL string
+	v1.UnimplementedOAuth2ServiceServer
+}
+
+// NewOAuth2Service creates a new OAuth2Service, loginURL is the page that handles the login and consent hand-off.
+// publicURL is used to build the issuer of the id tokens.
+func NewOAuth2Service(store store.Provider, keyProvider x.JWTSignerVerifierProvider, cache *cache.Redis, loginURL, publicURL string) *OAuth2Service {
+	return &OAuth2Service{
+		store:     store,
+		cache:     cache,
+		issuer:    newTokenIssuer(keyProvider, cache),
+		loginURL:  loginURL,
+		publicURL: publicURL,
+	}
+}
+
+// authorizationRequest is a validated authorization request waiting for the user login and consent
+type authorizationRequest struct {
+	ClientID            string   `json:"client_id"`
+	PoolID              string   `json:"pool_id"`
+	RedirectURI         string   `json:"redirect_uri"`         // the redirect uri the response is sent to
+	RequestRedirectURI  string   `json:"request_redirect_uri"` // the redirect uri as sent by the client, may be empty
+	Scopes              []string `json:"scopes"`
+	State               string   `json:"state"`
+	CodeChallenge       string   `json:"code_challenge"`
+	CodeChallengeMethod string   `json:"code_challenge_method"`
+	Nonce               string   `json:"nonce"`
+}
+
+// OAuth2Authorize validates the authorization request and stores it for the login page.
+// Errors carry the RFC 6749 error code as ErrorInfo reason, and the redirect uri when the error can be sent to the client.
+func (o *OAuth2Service) OAuth2Authorize(ctx context.Context, request *v1.OAuth2AuthorizeRequest) (*v1.OAuth2AuthorizeResponse, error) {
+	as, err := store.GetProjectStore(ctx, o.store)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := uuid.Parse(request.GetClientId())
+	if err != nil {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_request", "invalid client_id", nil)
+	}
+	client, err := as.GetClientByID(ctx, clientID)
+	if errors.Is(err, store.ErrClientNotFound) {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_request", "unknown client_id", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the redirect uri must be trusted before any error is sent to it
+	redirectURI := request.GetRedirectUri()
+	registered := clientRedirectURIs(client)
+	if redirectURI == "" && len(registered) == 1 {
+		redirectURI = registered[0]
+	}
+	if redirectURI == "" || !slices.Contains(registered, redirectURI) {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_request", "redirect_uri is not registered for the client", nil)
+	}
+
+	redirect := map[string]string{"redirect_uri": redirectURI, "state": request.GetState()}
+	if request.GetResponseType() != "code" {
+		return nil, oauth2Error(codes.InvalidArgument, "unsupported_response_type", "only the code response type is supported", redirect)
+	}
+	if !slices.Contains(clientGrantTypes(client), GrantTypeAuthorizationCode) {
+		return nil, oauth2Error(codes.PermissionDenied, "unauthorized_client", "the client may not use the authorization code grant", redirect)
+	}
+	if request.GetCodeChallenge() == "" {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_request", "code_challenge is required", redirect)
+	}
+	if request.GetCodeChallengeMethod() != x.CodeChallengeMethodS256 {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_request", "code_challenge_method must be S256", redirect)
+	}
+
+	authRequest := &authorizationRequest{
+		ClientID:            client.ID,
+		PoolID:              client.PoolID,
+		RedirectURI:         redirectURI,
+		RequestRedirectURI:  request.GetRedirectUri(),
+		Scopes:              strings.Fields(request.GetScope()),
+		State:               request.GetState(),
+		CodeChallenge:       request.GetCodeChallenge(),
+		CodeChallengeMethod: request.GetCodeChallengeMethod(),
+		Nonce:               request.GetNonce(),
+	}
+	data, err := json.Marshal(authRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	requestID := uuid.New().String()
+	err = o.cache.Set(authorizationRequestKey(requestID), string(data), authorizationRequestDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	loginURL, err := url.Parse(o.loginURL)
+	if err != nil {
+		return nil, err
+	}
+	query := loginURL.Query()
+	query.Set("request_id", requestID)
+	loginURL.RawQuery = query.Encode()
+
+	return &v1.OAuth2AuthorizeResponse{
+		RequestId: requestID,
+		Client: &v1.Client{
+			Id:     client.ID,
+			Name:   client.Name,
+			PoolId: client.PoolID,
+		},
+		Scopes:   authRequest.Scopes,
+		LoginUrl: loginURL.String(),
+	}, nil
+}
+
+// OAuth2Auth is called by the login page with the token of the logged in user.
+// It issues the authorization code when the user approved the request, the request can be answered only once.
+func (o *OAuth2Service) OAuth2Auth(ctx context.Context, request *v1.OAuth2AuthRequest) (*v1.OAuth2AuthResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := authorizationRequestKey(request.GetRequestId())
+	data, err := o.cache.Get(key)
+	if err != nil || data == "" {
+		return nil, status.Error(codes.NotFound, "authorization request not found or expired")
+	}
+	if err := o.cache.Del(key); err != nil {
+		return nil, err
+	}
+
+	var authRequest authorizationRequest
+	if err := json.Unmarshal([]byte(data), &authRequest); err != nil {
+		return nil, err
+	}
+
+	redirectURI, err := url.Parse(authRequest.RedirectURI)
+	if err != nil {
+		return nil, err
+	}
+	query := redirectURI.Query()
+	if authRequest.State != "" {
+		query.Set("state", authRequest.State)
+	}
+
+	if !request.GetApprove() {
+		query.Set("error", "access_denied")
+		redirectURI.RawQuery = query.Encode()
+		return &v1.OAuth2AuthResponse{RedirectUri: redirectURI.String()}, nil
+	}
+
+	as, err := store.GetProjectStore(ctx, o.store)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user must have logged in to the pool of the client
+	if account.PoolID != authRequest.PoolID {
+		return nil, status.Error(codes.PermissionDenied, "account does not belong to the client pool")
+	}
+
+	// the id token auth_time is the login of the user, not the approval of the request
+	session, err := callerSession(ctx, as, account)
+	if err != nil {
+		return nil, err
+	}
+
+	code := x.Keygen()
+	err = as.CreateAuthorizationCode(ctx, &model.AuthorizationCode{
+		ID:                  uuid.New().String(),
+		CodeHash:            x.HashToken(code),
+		ClientID:            authRequest.ClientID,
+		AccountID:           account.ID,
+		PoolID:              account.PoolID,
+		ProjectID:           account.ProjectID,
+		RedirectURI:         authRequest.RequestRedirectURI,
+		Scopes:              strings.Join(authRequest.Scopes, " "),
+		CodeChallenge:       authRequest.CodeChallenge,
+		CodeChallengeMethod: authRequest.CodeChallengeMethod,
+		Nonce:               authRequest.Nonce,
+		AuthTime:            session.CreatedAt,
+		ExpiresAt:           time.Now().Add(authorizationCodeDuration),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query.Set("code", code)
+	redirectURI.RawQuery = query.Encode()
+
+	return &v1.OAuth2AuthResponse{RedirectUri: redirectURI.String()}, nil
+}
+
+// OAuth2Token exchanges an authorization code, a refresh token or the client credentials for tokens
+func (o *OAuth2Service) OAuth2Token(ctx context.Context, request *v1.OAuth2TokenRequest) (*v1.OAuth2TokenResponse, error) {
+	as, err := store.GetProjectStore(ctx, o.store)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := authenticateClient(ctx, as, request.GetClientId(), request.GetClientSecret())
+	if err != nil {
+		return nil, err
+	}
+
+	grantType := request.GetGrantType()
+	if !slices.Contains(supportedGrantTypes, grantType) {
+		return nil, oauth2Error(codes.InvalidArgument, "unsupported_grant_type", "unsupported grant_type", nil)
+	}
+	if !slices.Contains(clientGrantTypes(client), grantType) {
+		return nil, oauth2Error(codes.PermissionDenied, "unauthorized_client", "the client may not use the "+grantType+" grant", nil)
+	}
+
+	var token *issuedToken
+	switch grantType {
+	case GrantTypeClientCredentials:
+		token, err = o.clientCredentials(ctx, as, client, request)
+	case GrantTypeAuthorizationCode:
+		token, err = o.exchangeCode(ctx, as, client, request)
+	case GrantTypeRefreshToken:
+		// the client is checked before the rotation, a token presented by another client is not consumed
+		token, err = o.issuer.refresh(ctx, as, request.GetRefreshToken(), client.ID)
+		if err != nil {
+			return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "invalid refresh token", nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.OAuth2TokenResponse{
+		AccessToken:  token.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(token.ExpireAt).Seconds()),
+		RefreshToken: token.RefreshToken,
+		Scope:        strings.Join(token.Claims.Scopes, " "),
+		IdToken:      token.IDToken,
+	}, nil
+}
+
+// exchangeCode consumes the authorization code and issues tokens for the account that approved it
+func (o *OAuth2Service) exchangeCode(ctx context.Context, as store.AuthBaseStore, client *model.Client, request *v1.OAuth2TokenRequest) (*issuedToken, error) {
+	code, err := as.ConsumeAuthorizationCode(ctx, x.HashToken(request.GetCode()))
+	if errors.Is(err, store.ErrAuthorizationCodeNotFound) {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "invalid authorization code", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if code.ExpiresAt.Before(time.Now()) {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "authorization code expired", nil)
+	}
+	if code.ClientID != client.ID {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "authorization code was issued to another client", nil)
+	}
+	// the redirect uri must match when it was part of the authorization request
+	if code.RedirectURI != "" && code.RedirectURI != request.GetRedirectUri() {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "redirect_uri does not match", nil)
+	}
+	if !x.VerifyCodeChallenge(request.GetCodeVerifier(), code.CodeChallenge, code.CodeChallengeMethod) {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "invalid code_verifier", nil)
+	}
+
+	account, err := as.GetAccountByID(ctx, uuid.MustParse(code.AccountID))
+	if err != nil {
+		return nil, err
+	}
+	if account.Disabled {
+		return nil, oauth2Error(codes.InvalidArgument, "invalid_grant", "account is disabled", nil)
+	}
+
+	scopes := strings.Fields(code.Scopes)
+	token, err := o.issuer.issue(ctx, as, account, client.ID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(scopes, x.ScopeOpenID) {
+		token.IDToken, err = o.issuer.idToken(account, client.ID, x.PoolIssuer(o.publicURL, account.PoolID), code.Nonce, code.AuthTime, scopes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+// OAuth2UserInfo returns the claims of the token account, the token must have been granted the openid scope
+func (o *OAuth2Service) OAuth2UserInfo(ctx context.Context, request *v1.OAuth2UserInfoRequest) (*v1.OAuth2UserInfoResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, "the token does not belong to an account")
+	}
+	scopes, err := x.GetAuthbaseScopes(ctx)
+	if err != nil || !slices.Contains(scopes, x.ScopeOpenID) {
+		return nil, oauth2Error(codes.PermissionDenied, "insufficient_scope", "the openid scope is required", nil)
+	}
+
+	as, err := store.GetProjectStore(ctx, o.store)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := accountUserInfo(account, scopes)
+	response := &v1.OAuth2UserInfoResponse{Sub: info.Subject, EmailVerified: info.EmailVerified}
+	if info.Name != "" {
+		response.Name = &info.Name
+	}
+	if info.PreferredUsername != "" {
+		response.PreferredUsername = &info.PreferredUsername
+	}
+	if info.Email != "" {
+		response.Email = &info.Email
+	}
+
+	return response, nil
+}
+
+// clientCredentials issues a token for the client itself, only confidential clients can use the grant.
+// The requested scopes must be allowed for the client, all the client scopes are granted when none are requested.
+func (o *OAuth2Service) clientCredentials(ctx context.Context, as store.AuthBaseStore, client *model.Client, request *v1.OAuth2TokenRequest) (*issuedToken, error) {
+	if client.SecretHash == "" {
+		return nil, oauth2Error(codes.PermissionDenied, "unauthorized_client", "public clients may not use the client_credentials grant", nil)
+	}
+
+	allowed := clientScopes(client)
+	scopes := strings.Fields(request.GetScope())
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return nil, oauth2Error(codes.InvalidArgument, "invalid_scope", "scope is not allowed for the client: "+scope, nil)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = allowed
+	}
+
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(client.PoolID))
+	if err != nil {
+		return nil, err
+	}
+
+	return o.issuer.issueClient(ctx, as, client, pool, scopes)
+}
+
+// OAuth2Introspect returns the state of an access token, a refresh token or an access key (RFC 7662).
+// Tokens of other pools, unknown, expired and revoked tokens are reported inactive.
+func (o *OAuth2Service) OAuth2Introspect(ctx context.Context, request *v1.OAuth2IntrospectRequest) (*v1.OAuth2IntrospectResponse, error) {
+	as, err := store.GetProjectStore(ctx, o.store)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := authenticateClient(ctx, as, request.GetClientId(), request.GetClientSecret())
+	if err != nil {
+		return nil, err
+	}
+	if client.SecretHash == "" {
+		return nil, oauth2Error(codes.Unauthenticated, "invalid_client", "introspection requires a confidential client", nil)
+	}
+
+	inactive := &v1.OAuth2IntrospectResponse{Active: false}
+	if x.IsAccessKey(request.GetToken()) {
+		key, err := accessKeyByToken(ctx, as, request.GetToken())
+		if err != nil || key.PoolID != client.PoolID {
+			return inactive, nil
+		}
+
+		return &v1.OAuth2IntrospectResponse{
+			Active:    true,
+			Scope:     strings.Join(accessKeyScopes(key), " "),
+			TokenType: "access_key",
+			Exp:       key.ExpireAt.Unix(),
+			Iat:       key.CreatedAt.Unix(),
+			Sub:       key.AccountID,
+			Jti:       key.ID,
+		}, nil
+	}
+
+	claims, err := o.verifyToken(request.GetToken())
+	if err != nil || claims.PoolID != client.PoolID {
+		return inactive, nil
+	}
+	active, err := tokenActive(ctx, as, claims, request.GetToken())
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := o.issuer.revocations.Revoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if !active || revoked {
+		return inactive, nil
+	}
+
+	tokenType := "access_token"
+	if claims.TokenUse == x.TokenUseRefresh {
+		tokenType = "refresh_token"
+	}
+	subject := claims.Subject
+	if subject == "" {
+		subject = claims.AccountID
+	}
+
+	return &v1.OAuth2IntrospectResponse{
+		Active:    true,
+		Scope:     strings.Join(claims.Scopes, " "),
+		ClientId:  claims.ClientID,
+		Username:  claims.Username,
+		TokenType: tokenType,
+		Exp:       claims.ExpireAt.Unix(),
+		Iat:       claims.IssuedAt.Unix(),
+		Sub:       subject,
+		Iss:       x.PoolIssuer(o.publicURL, claims.PoolID),
+		Jti:       claims.Jti,
+	}, nil
+}
+
+// OAuth2Revoke revokes a token (RFC 7009). Revoking an account token ends its session,
+// so the access and refresh tokens of the session are revoked together. Access keys are deleted.
+// Invalid tokens and tokens the client may not revoke are ignored, the response does not tell them apart.
+func (o *OAuth2Service) OAuth2Revoke(ctx context.Context, request *v1.OAuth2RevokeRequest) (*v1.OAuth2RevokeResponse, error) {
+	as, err := store.GetProjectStore(ctx, o.store)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := authenticateClient(ctx, as, request.GetClientId(), request.GetClientSecret())
+	if err != nil {
+		return nil, err
+	}
+
+	if x.IsAccessKey(request.GetToken()) {
+		// access keys belong to accounts, only confidential clients of the pool can revoke them
+		key, err := accessKeyByToken(ctx, as, request.GetToken())
+		if err != nil || key.PoolID != client.PoolID || client.SecretHash == "" {
+			return &v1.OAuth2RevokeResponse{}, nil
+		}
+		if err := as.DeleteAccessKey(ctx, uuid.MustParse(key.ID)); err != nil {
+			return nil, err
+		}
+
+		return &v1.OAuth2RevokeResponse{}, nil
+	}
+
+	claims, err := o.verifyToken(request.GetToken())
+	if err != nil || claims.PoolID != client.PoolID {
+		return &v1.OAuth2RevokeResponse{}, nil
+	}
+	// public clients can revoke only their own tokens
+	if client.SecretHash == "" && claims.ClientID != client.ID {
+		return &v1.OAuth2RevokeResponse{}, nil
+	}
+	// client credentials tokens are short-lived and not tracked, they expire on their own
+	if claims.AccountID == "" {
+		return &v1.OAuth2RevokeResponse{}, nil
+	}
+
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if claims.TokenUse == x.TokenUseRefresh {
+			if err := tx.DeleteRefreshToken(ctx, request.GetToken()); err != nil {
+				return err
+			}
+		}
+
+		sessionID, err := uuid.Parse(tokenSessionID(claims))
+		if err != nil {
+			return nil
+		}
+
+		return tx.DeleteSession(ctx, sessionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the cached refresh token would let the session be refreshed without the store lookup
+	if err := o.cache.Del(claims.Jti); err != nil {
+		return nil, err
+	}
+	if err := o.issuer.revocations.RevokeSession(tokenSessionID(claims)); err != nil {
+		return nil, err
+	}
+
+	return &v1.OAuth2RevokeResponse{}, nil
+}
+
+// verifyToken verifies the signature and the expiry of a token issued by the pool keys
+func (o *OAuth2Service) verifyToken(token string) (*x.Claims, error) {
+	claims, err := x.GetTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := o.issuer.keyProvider.GetVerifier(claims.PoolID)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.VerifyJWTToken(token, verifier)
+}
+
+// tokenActive checks that a verified token was not revoked, the session must be active and a refresh token must be stored and unused
+func tokenActive(ctx context.Context, as store.AuthBaseStore, claims *x.Claims, token string) (bool, error) {
+	if claims.TokenUse == x.TokenUseRefresh {
+		stored, err := as.GetRefreshTokenByID(ctx, token)
+		if errors.Is(err, store.ErrRefreshTokenNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// a rotated token can not be used anymore
+		if stored.Consumed() {
+			return false, nil
+		}
+	}
+
+	return sessionActive(ctx, as, claims)
+}
+
+// accessKeyByToken returns the unexpired access key of the token
+func accessKeyByToken(ctx context.Context, as store.AuthBaseStore, token string) (*model.AccessKey, error) {
+	parsed, err := x.ParseAccessKey(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := as.GetAccessKeyByID(ctx, parsed.ID)
+	if err != nil {
+		return nil, err
+	}
+	if key.ExpireAt.Before(time.Now()) || subtle.ConstantTimeCompare([]byte(key.Token), []byte(parsed.Value)) != 1 {
+		return nil, errors.New("invalid access key")
+	}
+
+	return key, nil
+}
+
+// accessKeyScopes returns the scopes of an access key, they are stored comma separated
+func accessKeyScopes(key *model.AccessKey) []string {
+	if key.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(key.Scopes, ",")
+}
+
+// authenticateClient returns the client of the credentials, confidential clients must send their secret
+func authenticateClient(ctx context.Context, as store.AuthBaseStore, id, secret string) (*model.Client, error) {
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, oauth2Error(codes.Unauthenticated, "invalid_client", "invalid client_id", nil)
+	}
+	client, err := as.GetClientByID(ctx, clientID)
+	if errors.Is(err, store.ErrClientNotFound) {
+		return nil, oauth2Error(codes.Unauthenticated, "invalid_client", "unknown client_id", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.SecretHash != "" && !x.CompareHashAndPassword(secret, client.SecretSalt, client.SecretHash) {
+		return nil, oauth2Error(codes.Unauthenticated, "invalid_client", "invalid client credentials", nil)
+	}
+
+	return client, nil
+}
+
+// callerSession returns the active login session of the calling token, the access keys and passwords have none
+func callerSession(ctx context.Context, as store.AuthBaseStore, account *model.Account) (*model.Session, error) {
+	sessionID, err := x.GetAuthbaseSessionID(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "login session required")
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "login session required")
+	}
+
+	session, err := as.GetSession(ctx, sessionUUID)
+	if err != nil || session.AccountID != account.ID || !session.Active() {
+		return nil, status.Error(codes.Unauthenticated, "login session not found or expired")
+	}
+
+	return session, nil
+}
+
+func authorizationRequestKey(requestID string) string {
+	return "oauth2:request:" + requestID
+}
+
+// clientRedirectURIs returns the registered redirect uris of the client
+func clientRedirectURIs(client *model.Client) []string {
+	return strings.Fields(client.RedirectURIs)
+}
+
+// clientScopes returns the scopes the client may request for itself
+func clientScopes(client *model.Client) []string {
+	return strings.Fields(client.Scopes)
+}
+
+// clientGrantTypes returns the allowed grant types of the client
+func clientGrantTypes(client *model.Client) []string {
+	grantTypes := strings.Fields(client.GrantTypes)
+	if len(grantTypes) == 0 {
+		return defaultGrantTypes
+	}
+
+	return grantTypes
+}
+
+// oauth2Error creates a status error carrying the RFC 6749 error code.
+// metadata holds the redirect_uri and state when the error can be sent back to the client.
+func oauth2Error(code codes.Code, reason, description string, metadata map[string]string) error {
+	st, err := status.New(code, description).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   OAuth2ErrorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return status.Error(code, description)
+	}
+
+	return st.Err()
+}
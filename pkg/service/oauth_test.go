@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestOAuth2AuthTime function to test the authorization code carries the login time of the session, not the approval time
+func TestOAuth2AuthTime(t *testing.T) {
+	redis := testCache(t)
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.MD{})
+	as := store.NewGormStore(tester.TestDB())
+	_, client, account := createMfaAccount(t, as)
+	service := NewOAuth2Service(store.NewDefaultProvider(as), x.NewStaticKeyProvider("secret"), redis, "http://login.test", "")
+
+	token, err := service.issuer.issue(ctx, as, account, client.ID, nil)
+	assert.NoError(t, err)
+	claims, err := x.GetTokenClaims(token.AccessToken)
+	assert.NoError(t, err)
+	loggedInAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = tester.TestDB().Model(&model.Session{}).Where("id = ?", claims.SessionID).Update("created_at", loggedInAt).Error
+	assert.NoError(t, err)
+
+	authorize := func(ctx context.Context) (*v1.OAuth2AuthResponse, error) {
+		data, err := json.Marshal(&authorizationRequest{ClientID: client.ID, PoolID: account.PoolID, RedirectURI: "http://app.test/cb"})
+		assert.NoError(t, err)
+		requestID := uuid.New().String()
+		assert.NoError(t, redis.Set(authorizationRequestKey(requestID), string(data), time.Minute))
+		return service.OAuth2Auth(ctx, &v1.OAuth2AuthRequest{RequestId: requestID, Approve: true})
+	}
+
+	res, err := authorize(x.WithClaims(ctx, claims))
+	assert.NoError(t, err)
+	redirect, err := url.Parse(res.RedirectUri)
+	assert.NoError(t, err)
+	var code model.AuthorizationCode
+	err = tester.TestDB().Where("code_hash = ?", x.HashToken(redirect.Query().Get("code"))).First(&code).Error
+	assert.NoError(t, err)
+	assert.True(t, loggedInAt.Equal(code.AuthTime), "auth time %v, login %v", code.AuthTime, loggedInAt)
+
+	// the callers without a login session can not approve the requests
+	_, err = authorize(context.WithValue(ctx, x.AccountIDKey, uuid.MustParse(account.ID)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,468 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// passkeySessionDuration is how long the browser has to answer a passkey ceremony
+const passkeySessionDuration = 5 * time.Minute
+
+// passkeySession is a pending registration or login ceremony, it is stored under the session id
+type passkeySession struct {
+	AccountID string               `json:"account_id,omitempty"` // set for registrations
+	ClientID  string               `json:"client_id,omitempty"`  // set for logins
+	PoolID    string               `json:"pool_id"`
+	Session   webauthn.SessionData `json:"session"`
+}
+
+// BeginPasskeyRegistration returns the creation options of a new passkey for the token account
+func (a *AuthService) BeginPasskeyRegistration(ctx context.Context, request *v1.BeginPasskeyRegistrationRequest) (*v1.BeginPasskeyRegistrationResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(account.PoolID))
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := as.ListPasskeys(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := relyingParty(pool, a.publicURL)
+	if err != nil {
+		return nil, err
+	}
+	user, err := newPasskeyUser(account, passkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// the registered passkeys are excluded so the same authenticator is not registered twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err := rp.BeginRegistration(user, webauthn.WithExclusions(exclusions), webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired))
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := a.savePasskeySession(&passkeySession{AccountID: account.ID, PoolID: pool.ID, Session: *session})
+	if err != nil {
+		return nil, err
+	}
+	options, err := json.Marshal(creation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BeginPasskeyRegistrationResponse{SessionId: sessionID, Options: string(options)}, nil
+}
+
+// FinishPasskeyRegistration verifies the attestation of the created passkey and stores it
+func (a *AuthService) FinishPasskeyRegistration(ctx context.Context, request *v1.FinishPasskeyRegistrationRequest) (*v1.FinishPasskeyRegistrationResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := a.takePasskeySession(request.GetSessionId())
+	if err != nil {
+		return nil, err
+	}
+	if session.AccountID != accountID.String() {
+		return nil, status.Error(codes.PermissionDenied, "the passkey session belongs to another account")
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes([]byte(request.GetCredential()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid passkey credential: %v", err)
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(session.PoolID))
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := relyingParty(pool, a.publicURL)
+	if err != nil {
+		return nil, err
+	}
+	user, err := newPasskeyUser(account, nil)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := rp.CreateCredential(user, session.Session, parsed)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid passkey credential: %v", err)
+	}
+
+	passkey := newPasskey(account, credential, request.GetName())
+	if err := as.CreatePasskey(ctx, passkey); err != nil {
+		return nil, err
+	}
+
+	return &v1.FinishPasskeyRegistrationResponse{Passkey: passkeyProto(passkey)}, nil
+}
+
+// ListPasskeys lists the passkeys of the token account
+func (a *AuthService) ListPasskeys(ctx context.Context, request *v1.ListPasskeysRequest) (*v1.ListPasskeysResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := as.ListPasskeys(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var passkeyProtos []*v1.Passkey
+	for _, passkey := range passkeys {
+		passkeyProtos = append(passkeyProtos, passkeyProto(passkey))
+	}
+
+	return &v1.ListPasskeysResponse{Passkeys: passkeyProtos}, nil
+}
+
+// DeletePasskey deletes a passkey of the token account
+func (a *AuthService) DeletePasskey(ctx context.Context, request *v1.DeletePasskeyRequest) (*v1.DeletePasskeyResponse, error) {
+	accountID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	err = as.DeletePasskey(ctx, accountID, uuid.MustParse(request.GetId()))
+	if errors.Is(err, store.ErrPasskeyNotFound) {
+		return nil, status.Error(codes.NotFound, "passkey not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.DeletePasskeyResponse{}, nil
+}
+
+// BeginPasskeyLogin returns the request options of a discoverable login in the client pool
+func (a *AuthService) BeginPasskeyLogin(ctx context.Context, request *v1.BeginPasskeyLoginRequest) (*v1.BeginPasskeyLoginResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	client, err := as.GetClientByID(ctx, uuid.MustParse(request.GetClientId()))
+	if err != nil {
+		return nil, err
+	}
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(client.PoolID))
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := relyingParty(pool, a.publicURL)
+	if err != nil {
+		return nil, err
+	}
+	assertion, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := a.savePasskeySession(&passkeySession{ClientID: client.ID, PoolID: pool.ID, Session: *session})
+	if err != nil {
+		return nil, err
+	}
+	options, err := json.Marshal(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BeginPasskeyLoginResponse{SessionId: sessionID, Options: string(options)}, nil
+}
+
+// FinishPasskeyLogin verifies the passkey assertion and issues the tokens of its account.
+// The passkey is verified with the user presence and verification, so it also satisfies the second factor.
+func (a *AuthService) FinishPasskeyLogin(ctx context.Context, request *v1.FinishPasskeyLoginRequest) (*v1.LoginUsingPasswordResponse, error) {
+	session, err := a.takePasskeySession(request.GetSessionId())
+	if err != nil {
+		return nil, err
+	}
+	if session.ClientID == "" {
+		return nil, status.Error(codes.InvalidArgument, "the passkey session is not a login")
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes([]byte(request.GetCredential()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid passkey assertion: %v", err)
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(session.PoolID))
+	if err != nil {
+		return nil, err
+	}
+	rp, err := relyingParty(pool, a.publicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var passkey *model.Passkey
+	user, credential, err := rp.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		passkey, err = as.GetPasskeyByCredentialID(ctx, uuid.MustParse(pool.ID), base64.RawURLEncoding.EncodeToString(rawID))
+		if err != nil {
+			return nil, err
+		}
+		account, err := as.GetAccountByID(ctx, uuid.MustParse(passkey.AccountID))
+		if err != nil {
+			return nil, err
+		}
+		return newPasskeyUser(account, []*model.Passkey{passkey})
+	}, session.Session, parsed)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid passkey")
+	}
+	// a counter going backwards means the private key was copied to another authenticator
+	if credential.Authenticator.CloneWarning {
+		return nil, status.Error(codes.Unauthenticated, "the passkey counter went backwards, the passkey may be cloned")
+	}
+
+	account := user.(*passkeyUser).account
+	if account.Disabled {
+		return nil, status.Error(codes.PermissionDenied, "account is disabled")
+	}
+
+	passkey.SignCount = credential.Authenticator.SignCount
+	passkey.BackupState = credential.Flags.BackupState
+	passkey.LastUsedAt = time.Now()
+	if err := as.UpdatePasskey(ctx, passkey); err != nil {
+		return nil, err
+	}
+
+	token, err := a.issuer.issue(ctx, as, account, session.ClientID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return passwordLoginResponse(account, token), nil
+}
+
+// savePasskeySession stores the ceremony and returns its id
+func (a *AuthService) savePasskeySession(session *passkeySession) (string, error) {
+	id := x.Keygen()
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	if err := a.cache.Set(passkeySessionKey(id), string(data), passkeySessionDuration); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// takePasskeySession returns the ceremony and deletes it, a challenge can be answered only once
+func (a *AuthService) takePasskeySession(id string) (*passkeySession, error) {
+	data, err := a.cache.Get(passkeySessionKey(id))
+	if id == "" || err != nil || data == "" {
+		return nil, status.Error(codes.Unauthenticated, "passkey session not found or expired")
+	}
+	if err := a.cache.Del(passkeySessionKey(id)); err != nil {
+		return nil, err
+	}
+
+	var session passkeySession
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func passkeySessionKey(id string) string {
+	return "webauthn:session:" + id
+}
+
+// relyingParty returns the WebAuthn relying party of the pool.
+// The pool settings default to the host and the origin of the public url.
+func relyingParty(pool *model.Pool, publicURL string) (*webauthn.WebAuthn, error) {
+	rpID := pool.WebauthnRPID
+	origins := strings.Fields(pool.WebauthnOrigins)
+	if rpID == "" || len(origins) == 0 {
+		public, err := url.Parse(publicURL)
+		if err != nil || public.Host == "" {
+			return nil, status.Error(codes.FailedPrecondition, "passkeys require the public url or the pool relying party to be set")
+		}
+		if rpID == "" {
+			rpID = public.Hostname()
+		}
+		if len(origins) == 0 {
+			origins = []string{public.Scheme + "://" + public.Host}
+		}
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: pool.Name,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+}
+
+// passkeyUser adapts an account and its passkeys to the WebAuthn user, the user handle is the account id
+type passkeyUser struct {
+	account     *model.Account
+	credentials []webauthn.Credential
+}
+
+var _ webauthn.User = new(passkeyUser)
+
+func newPasskeyUser(account *model.Account, passkeys []*model.Passkey) (*passkeyUser, error) {
+	user := &passkeyUser{account: account}
+	for _, passkey := range passkeys {
+		credential, err := passkeyCredential(passkey)
+		if err != nil {
+			return nil, err
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+
+	return user, nil
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.account.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.account.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.account.VisibleName != "" {
+		return u.account.VisibleName
+	}
+	return u.account.Username
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// passkeyCredential converts a stored passkey to the WebAuthn credential
+func passkeyCredential(passkey *model.Passkey) (webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(passkey.CredentialID)
+	if err != nil {
+		return webauthn.Credential{}, err
+	}
+
+	var transports []protocol.AuthenticatorTransport
+	for _, transport := range strings.Fields(passkey.Transports) {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       passkey.PublicKey,
+		AttestationType: passkey.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    true,
+			UserVerified:   true,
+			BackupEligible: passkey.BackupEligible,
+			BackupState:    passkey.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    passkey.AAGUID,
+			SignCount: passkey.SignCount,
+		},
+	}, nil
+}
+
+// newPasskey converts a verified WebAuthn credential to a passkey of the account
+func newPasskey(account *model.Account, credential *webauthn.Credential, name string) *model.Passkey {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+
+	return &model.Passkey{
+		ID:              uuid.New().String(),
+		AccountID:       account.ID,
+		PoolID:          account.PoolID,
+		CredentialID:    base64.RawURLEncoding.EncodeToString(credential.ID),
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          bytes.Clone(credential.Authenticator.AAGUID),
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      strings.Join(transports, " "),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+	}
+}
+
+func passkeyProto(passkey *model.Passkey) *v1.Passkey {
+	res := &v1.Passkey{
+		Id:             passkey.ID,
+		Name:           passkey.Name,
+		Transports:     strings.Fields(passkey.Transports),
+		BackupEligible: passkey.BackupEligible,
+		CreatedAt:      timestamppb.New(passkey.CreatedAt),
+	}
+	if !passkey.LastUsedAt.IsZero() {
+		res.LastUsedAt = timestamppb.New(passkey.LastUsedAt)
+	}
+
+	return res
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testAuthenticator is a software authenticator holding a single P-256 credential
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	rpID      string
+	origin    string
+	signCount uint32
+}
+
+func newTestAuthenticator(t *testing.T, rpID, origin string) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	assert.NoError(t, err)
+
+	return &testAuthenticator{key: key, id: id, rpID: rpID, origin: origin}
+}
+
+// authData returns the authenticator data with the user present and verified flags
+func (a *testAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags|0x01|0x04)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *testAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	return data
+}
+
+// create answers navigator.credentials.create with a "none" attestation
+func (a *testAuthenticator) create(t *testing.T, challenge string) []byte {
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	assert.NoError(t, err)
+
+	attested := make([]byte, 16) // zero aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, publicKey...)
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(0x40, attested),
+	})
+	assert.NoError(t, err)
+
+	return a.response(t, map[string]any{
+		"clientDataJSON":    a.clientData("webauthn.create", challenge),
+		"attestationObject": attestation,
+		"transports":        []string{"internal"},
+	})
+}
+
+// get answers navigator.credentials.get, the signature counter is incremented
+func (a *testAuthenticator) get(t *testing.T, challenge string, userHandle []byte) []byte {
+	a.signCount++
+	authData := a.authData(0, nil)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.NoError(t, err)
+
+	return a.response(t, map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        userHandle,
+	})
+}
+
+// response encodes the credential json as the browsers do, the binary fields are base64url
+func (a *testAuthenticator) response(t *testing.T, response map[string]any) []byte {
+	for key, value := range response {
+		if b, ok := value.([]byte); ok {
+			response[key] = base64.RawURLEncoding.EncodeToString(b)
+		}
+	}
+	data, err := json.Marshal(map[string]any{
+		"id":       base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId":    base64.RawURLEncoding.EncodeToString(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	assert.NoError(t, err)
+	return data
+}
+
+// TestPasskeyCeremonies function to test the passkey registration and login with a software authenticator
+func TestPasskeyCeremonies(t *testing.T) {
+	pool := &model.Pool{ID: "pool", Name: "test"}
+	rp, err := relyingParty(pool, "https://authbase.test:4000")
+	assert.NoError(t, err)
+
+	account := &model.Account{ID: "3b5d2b8e-4bb1-4c39-9b4a-6a4f1c1b0f6e", PoolID: pool.ID, Email: "jane@authbase.test", Username: "jane"}
+	user, err := newPasskeyUser(account, nil)
+	assert.NoError(t, err)
+	authenticator := newTestAuthenticator(t, "authbase.test", "https://authbase.test:4000")
+
+	// registration
+	_, session, err := rp.BeginRegistration(user)
+	assert.NoError(t, err)
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(authenticator.create(t, session.Challenge))
+	assert.NoError(t, err)
+	credential, err := rp.CreateCredential(user, *session, parsed)
+	assert.NoError(t, err)
+
+	passkey := newPasskey(account, credential, "")
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(authenticator.id), passkey.CredentialID)
+	assert.Equal(t, "Passkey", passkey.Name)
+	assert.Equal(t, "internal", passkey.Transports)
+
+	// login
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		return newPasskeyUser(account, []*model.Passkey{passkey})
+	}
+	login := func() (*webauthn.Credential, error) {
+		_, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		assert.NoError(t, err)
+		parsed, err := protocol.ParseCredentialRequestResponseBytes(authenticator.get(t, session.Challenge, []byte(account.ID)))
+		assert.NoError(t, err)
+		_, credential, err := rp.ValidatePasskeyLogin(lookup, *session, parsed)
+		return credential, err
+	}
+
+	credential, err = login()
+	assert.NoError(t, err)
+	assert.False(t, credential.Authenticator.CloneWarning)
+	assert.Equal(t, uint32(1), credential.Authenticator.SignCount)
+	passkey.SignCount = credential.Authenticator.SignCount
+
+	// a counter that does not move forward is reported as a clone
+	authenticator.signCount = 0
+	credential, err = login()
+	assert.NoError(t, err)
+	assert.True(t, credential.Authenticator.CloneWarning)
+
+	// an assertion made for another origin is rejected
+	authenticator.origin = "https://evil.test"
+	_, err = login()
+	assert.Error(t, err)
+}
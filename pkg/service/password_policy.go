@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// setAccountPassword checks the password against the project password policy and sets it on the account.
+// With a password history the last passwords can not be reused, the replaced password joins the history.
+// The account is saved by the caller.
+func setAccountPassword(ctx context.Context, as store.AuthBaseStore, account *model.Account, field, password string) error {
+	project, err := as.GetProjectByID(ctx, uuid.MustParse(account.ProjectID))
+	if err != nil {
+		return err
+	}
+	policy := project.PasswordPolicy
+
+	if err := checkPassword(policy, field, password); err != nil {
+		return err
+	}
+
+	if policy.HistorySize > 0 && account.PasswordHash != "" {
+		// the current password is the first of the history
+		used := x.CompareHashAndPassword(password, account.Salt, account.PasswordHash)
+		history, err := as.ListPasswordHistory(ctx, uuid.MustParse(account.ID), policy.HistorySize-1)
+		if err != nil {
+			return err
+		}
+		for _, old := range history {
+			used = used || x.CompareHashAndPassword(password, old.Salt, old.PasswordHash)
+		}
+		if used {
+			return passwordViolation(field, "must not be one of the last %d passwords", policy.HistorySize)
+		}
+
+		err = as.AddPasswordHistory(ctx, &model.PasswordHistory{
+			ID:           uuid.New().String(),
+			AccountID:    account.ID,
+			PasswordHash: account.PasswordHash,
+			Salt:         account.Salt,
+		}, policy.HistorySize-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	account.PasswordHash = x.HashPassword(password)
+	account.Salt = ""
+	account.PasswordChangedAt = time.Now()
+
+	return nil
+}
+
+// checkPassword returns the rules of the policy the password breaks as field violations of a BadRequest
+func checkPassword(policy model.PasswordPolicy, field, password string) error {
+	violations := policy.Violations(password)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: "password " + violation,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, "password does not match the password policy: "+violations[0])
+	if detailed, err := st.WithDetails(badRequest); err == nil {
+		return detailed.Err()
+	}
+
+	return st.Err()
+}
+
+// passwordViolation returns a single field violation
+func passwordViolation(field string, format string, args ...any) error {
+	st := status.Newf(codes.InvalidArgument, "password "+format, args...)
+	detailed, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: st.Message()}},
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// checkPasswordAge rejects the login with a password older than the max age of the project policy,
+// the account changes it with ChangePassword using the old password and logs in again
+func checkPasswordAge(ctx context.Context, as store.AuthBaseStore, account *model.Account) error {
+	project, err := as.GetProjectByID(ctx, uuid.MustParse(account.ProjectID))
+	if err != nil {
+		return err
+	}
+	if !passwordExpired(project.PasswordPolicy, account) {
+		return nil
+	}
+
+	st := status.New(codes.FailedPrecondition, "password expired, change the password to log in")
+	detailed, err := st.WithDetails(&errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        "PASSWORD_EXPIRED",
+			Subject:     account.ID,
+			Description: "the password is older than the max age of the project password policy",
+		}},
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// passwordExpired reports whether the password is older than the max age, passwords set before the
+// change time was recorded count from the account creation
+func passwordExpired(policy model.PasswordPolicy, account *model.Account) bool {
+	if policy.MaxAge <= 0 {
+		return false
+	}
+
+	changedAt := account.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = account.CreatedAt
+	}
+
+	return time.Since(changedAt) > time.Duration(policy.MaxAge)*time.Second
+}
+
+// validatePasswordPolicy rejects negative values and a max length below the min length
+func validatePasswordPolicy(policy *v1.PasswordPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.GetMinLength() < 0 || policy.GetMaxLength() < 0 || policy.GetMinUpper() < 0 || policy.GetMinLower() < 0 ||
+		policy.GetMinDigit() < 0 || policy.GetMinSymbol() < 0 || policy.GetHistorySize() < 0 || policy.GetMaxAge() < 0 {
+		return status.Error(codes.InvalidArgument, "password policy values can not be negative")
+	}
+	if policy.GetMaxLength() > 0 && policy.GetMaxLength() < policy.GetMinLength() {
+		return status.Error(codes.InvalidArgument, "password policy max_length can not be less than min_length")
+	}
+
+	return nil
+}
+
+func passwordPolicyModel(policy *v1.PasswordPolicy) model.PasswordPolicy {
+	return model.PasswordPolicy{
+		MinLength:   int(policy.GetMinLength()),
+		MaxLength:   int(policy.GetMaxLength()),
+		MinUpper:    int(policy.GetMinUpper()),
+		MinLower:    int(policy.GetMinLower()),
+		MinDigit:    int(policy.GetMinDigit()),
+		MinSymbol:   int(policy.GetMinSymbol()),
+		HistorySize: int(policy.GetHistorySize()),
+		MaxAge:      policy.GetMaxAge(),
+	}
+}
+
+func passwordPolicyProto(policy model.PasswordPolicy) *v1.PasswordPolicy {
+	return &v1.PasswordPolicy{
+		MinLength:   int32(policy.MinLength),
+		MaxLength:   int32(policy.MaxLength),
+		MinUpper:    int32(policy.MinUpper),
+		MinLower:    int32(policy.MinLower),
+		MinDigit:    int32(policy.MinDigit),
+		MinSymbol:   int32(policy.MinSymbol),
+		HistorySize: int32(policy.HistorySize),
+		MaxAge:      policy.MaxAge,
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/pkg/tester"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// TestCheckPassword function to test the password policy violations returned as BadRequest field details
+func TestCheckPassword(t *testing.T) {
+	policy := model.PasswordPolicy{MinLength: 8, MinUpper: 1, MinDigit: 1, MinSymbol: 1}
+	assert.NoError(t, checkPassword(policy, "password", "Secret#42"))
+	assert.NoError(t, checkPassword(model.PasswordPolicy{}, "password", "x"))
+
+	err := checkPassword(policy, "new_password", "secret")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	details := status.Convert(err).Details()
+	if assert.Len(t, details, 1) {
+		badRequest, ok := details[0].(*errdetails.BadRequest)
+		assert.True(t, ok)
+		// too short, no uppercase letter, no digit and no symbol
+		assert.Len(t, badRequest.GetFieldViolations(), 4)
+		assert.Equal(t, "new_password", badRequest.GetFieldViolations()[0].GetField())
+	}
+
+	assert.Error(t, validatePasswordPolicy(&v1.PasswordPolicy{MinLength: 12, MaxLength: 8}))
+	assert.Error(t, validatePasswordPolicy(&v1.PasswordPolicy{HistorySize: -1}))
+}
+
+// TestPasswordExpired function to test the password max age against the last password change
+func TestPasswordExpired(t *testing.T) {
+	policy := model.PasswordPolicy{MaxAge: 3600}
+	account := &model.Account{PasswordChangedAt: time.Now().Add(-30 * time.Minute)}
+	assert.False(t, passwordExpired(policy, account))
+	assert.False(t, passwordExpired(model.PasswordPolicy{}, account))
+
+	account.PasswordChangedAt = time.Now().Add(-2 * time.Hour)
+	assert.True(t, passwordExpired(policy, account))
+
+	// the passwords set before the change time was recorded count from the account creation
+	account = &model.Account{}
+	account.CreatedAt = time.Now().Add(-2 * time.Hour)
+	assert.True(t, passwordExpired(policy, account))
+}
+
+// TestAccessKeyPasswordExpired function to test the access keys created with an expired password are rejected
+func TestAccessKeyPasswordExpired(t *testing.T) {
+	tester.RemoveDBFile()
+	tester.Setup()
+
+	ctx := context.TODO()
+	as := store.NewGormStore(tester.TestDB())
+	project, _, account := createMfaAccount(t, as)
+	project.PasswordPolicy.MaxAge = 3600
+	assert.NoError(t, as.UpdateProject(ctx, project))
+	account.PasswordChangedAt = time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, as.UpdateAccount(ctx, account))
+	service := NewAccessKeyService(nil, store.NewDefaultProvider(as), nil, nil, nil)
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.MD{})
+	ctx = context.WithValue(ctx, x.AccountIDKey, uuid.MustParse(account.ID))
+	ctx = context.WithValue(ctx, x.PoolIDKey, uuid.MustParse(account.PoolID))
+	ctx = context.WithValue(ctx, x.PasswordAuthKey, true)
+
+	_, err := service.CreateAccessKey(ctx, &v1.CreateAccessKeyRequest{Email: account.Email, Password: testMfaPassword})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "password expired")
+}
@@ -12,6 +12,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
 )
 
 // NewPoolService creates a new account pool service.
@@ -134,10 +135,17 @@ func (p *PoolService) GetPool(ctx context.Context, request *v1.GetPoolRequest) (
 
 	return &v1.GetPoolResponse{
 		Pool: &v1.Pool{
-			Id:        pool.ID,
-			Name:      pool.Name,
-			CreatedAt: timestamppb.New(pool.CreatedAt),
-			UpdatedAt: timestamppb.New(pool.UpdatedAt),
+			Id:                   pool.ID,
+			Name:                 pool.Name,
+			MfaRequired:          pool.MfaRequired,
+			WebauthnRpId:         pool.WebauthnRPID,
+			WebauthnOrigins:      strings.Fields(pool.WebauthnOrigins),
+			TokenPolicy:          tokenPolicyProto(pool.TokenPolicy),
+			MaxSessions:          int32(pool.MaxSessions),
+			SessionLimitStrategy: sessionLimitStrategyProto(pool.SessionLimitStrategy),
+			LockoutPolicy:        lockoutPolicyProto(pool.LockoutPolicy),
+			CreatedAt:            timestamppb.New(pool.CreatedAt),
+			UpdatedAt:            timestamppb.New(pool.UpdatedAt),
 		},
 	}, nil
 }
@@ -166,11 +174,18 @@ func (p *PoolService) ListPools(ctx context.Context, request *v1.ListPoolsReques
 	var poolProtos []*v1.Pool
 	for _, pool := range pools {
 		poolProtos = append(poolProtos, &v1.Pool{
-			Id:        pool.ID,
-			Name:      pool.Name,
-			ProjectId: projectID.String(),
-			CreatedAt: timestamppb.New(pool.CreatedAt),
-			UpdatedAt: timestamppb.New(pool.UpdatedAt),
+			Id:                   pool.ID,
+			Name:                 pool.Name,
+			ProjectId:            projectID.String(),
+			MfaRequired:          pool.MfaRequired,
+			WebauthnRpId:         pool.WebauthnRPID,
+			WebauthnOrigins:      strings.Fields(pool.WebauthnOrigins),
+			TokenPolicy:          tokenPolicyProto(pool.TokenPolicy),
+			MaxSessions:          int32(pool.MaxSessions),
+			SessionLimitStrategy: sessionLimitStrategyProto(pool.SessionLimitStrategy),
+			LockoutPolicy:        lockoutPolicyProto(pool.LockoutPolicy),
+			CreatedAt:            timestamppb.New(pool.CreatedAt),
+			UpdatedAt:            timestamppb.New(pool.UpdatedAt),
 		})
 	}
 
@@ -187,6 +202,12 @@ func (p *PoolService) ListPools(ctx context.Context, request *v1.ListPoolsReques
 // UpdatePool updates the pool with the given ID.
 func (p *PoolService) UpdatePool(ctx context.Context, request *v1.UpdatePoolRequest) (*v1.UpdatePoolResponse, error) {
 	poolID := uuid.MustParse(request.GetPoolId())
+	if err := validateTokenPolicy(request.GetTokenPolicy()); err != nil {
+		return nil, err
+	}
+	if err := validateLockoutPolicy(request.GetLockoutPolicy()); err != nil {
+		return nil, err
+	}
 	as, err := store.GetProjectStore(ctx, p.store)
 	if err != nil {
 		return nil, err
@@ -203,8 +224,9 @@ func (p *PoolService) UpdatePool(ctx context.Context, request *v1.UpdatePoolRequ
 		return nil, err
 	}
 
+	var pool *model.Pool
 	err = as.Transaction(func(tx store.AuthBaseStore) error {
-		pool, err := tx.GetPoolByID(ctx, poolID)
+		pool, err = tx.GetPoolByID(ctx, poolID)
 		if err != nil {
 			return err
 		}
@@ -216,7 +238,30 @@ func (p *PoolService) UpdatePool(ctx context.Context, request *v1.UpdatePoolRequ
 			return err
 		}
 
-		pool.Name = request.GetName()
+		if request.GetName() != "" {
+			pool.Name = request.GetName()
+		}
+		if request.MfaRequired != nil {
+			pool.MfaRequired = request.GetMfaRequired()
+		}
+		if request.WebauthnRpId != nil {
+			pool.WebauthnRPID = request.GetWebauthnRpId()
+		}
+		if len(request.GetWebauthnOrigins()) > 0 {
+			pool.WebauthnOrigins = strings.Join(request.GetWebauthnOrigins(), " ")
+		}
+		if request.TokenPolicy != nil {
+			pool.TokenPolicy = tokenPolicyModel(request.GetTokenPolicy())
+		}
+		if request.MaxSessions != nil {
+			pool.MaxSessions = int(request.GetMaxSessions())
+		}
+		if request.SessionLimitStrategy != nil {
+			pool.SessionLimitStrategy = sessionLimitStrategyModel(request.GetSessionLimitStrategy())
+		}
+		if request.LockoutPolicy != nil {
+			pool.LockoutPolicy = lockoutPolicyModel(request.GetLockoutPolicy())
+		}
 		err = tx.UpdatePool(ctx, pool)
 		if err != nil {
 			return err
@@ -230,8 +275,15 @@ func (p *PoolService) UpdatePool(ctx context.Context, request *v1.UpdatePoolRequ
 
 	return &v1.UpdatePoolResponse{
 		Pool: &v1.Pool{
-			Id:   poolID.String(),
-			Name: request.GetName(),
+			Id:                   poolID.String(),
+			Name:                 pool.Name,
+			MfaRequired:          pool.MfaRequired,
+			WebauthnRpId:         pool.WebauthnRPID,
+			WebauthnOrigins:      strings.Fields(pool.WebauthnOrigins),
+			TokenPolicy:          tokenPolicyProto(pool.TokenPolicy),
+			MaxSessions:          int32(pool.MaxSessions),
+			SessionLimitStrategy: sessionLimitStrategyProto(pool.SessionLimitStrategy),
+			LockoutPolicy:        lockoutPolicyProto(pool.LockoutPolicy),
 		},
 	}, nil
 }
@@ -268,3 +320,49 @@ func (p *PoolService) DeletePool(ctx context.Context, request *v1.DeletePoolRequ
 
 	return &v1.DeletePoolResponse{}, nil
 }
+
+func sessionLimitStrategyProto(strategy string) v1.SessionLimitStrategy {
+	if strategy == model.SessionLimitEvictOldest {
+		return v1.SessionLimitStrategy_SESSION_LIMIT_STRATEGY_EVICT_OLDEST
+	}
+
+	return v1.SessionLimitStrategy_SESSION_LIMIT_STRATEGY_REJECT
+}
+
+func sessionLimitStrategyModel(strategy v1.SessionLimitStrategy) string {
+	if strategy == v1.SessionLimitStrategy_SESSION_LIMIT_STRATEGY_EVICT_OLDEST {
+		return model.SessionLimitEvictOldest
+	}
+
+	return model.SessionLimitReject
+}
+
+// validateLockoutPolicy rejects negative values, zero values fall back to the defaults
+func validateLockoutPolicy(policy *v1.LockoutPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.GetMaxAttempts() < 0 || policy.GetIpMaxAttempts() < 0 || policy.GetLockoutDuration() < 0 || policy.GetBackoffDelay() < 0 {
+		return status.Error(codes.InvalidArgument, "lockout policy values can not be negative")
+	}
+
+	return nil
+}
+
+func lockoutPolicyModel(policy *v1.LockoutPolicy) model.LockoutPolicy {
+	return model.LockoutPolicy{
+		MaxAttempts:     int(policy.GetMaxAttempts()),
+		IPMaxAttempts:   int(policy.GetIpMaxAttempts()),
+		LockoutDuration: policy.GetLockoutDuration(),
+		BackoffDelay:    policy.GetBackoffDelay(),
+	}
+}
+
+func lockoutPolicyProto(policy model.LockoutPolicy) *v1.LockoutPolicy {
+	return &v1.LockoutPolicy{
+		MaxAttempts:     int32(policy.MaxAttempts),
+		IpMaxAttempts:   int32(policy.IPMaxAttempts),
+		LockoutDuration: policy.LockoutDuration,
+		BackoffDelay:    policy.BackoffDelay,
+	}
+}
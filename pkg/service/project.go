@@ -11,9 +11,12 @@ import (
 	"github.com/emrgen/authbase/pkg/permission"
 	"github.com/emrgen/authbase/pkg/store"
 	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/mail"
 	"github.com/emrgen/authbase/x/utils"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -23,12 +26,14 @@ type ProjectService struct {
 	perm  permission.MemberPermission
 	store store.Provider
 	cache *cache.Redis
+	// appKey seals the smtp passwords of the projects
+	appKey string
 	v1.UnimplementedProjectServiceServer
 }
 
 // NewProjectService creates a new project service
-func NewProjectService(perm permission.MemberPermission, store store.Provider, cache *cache.Redis) *ProjectService {
-	return &ProjectService{perm: perm, store: store, cache: cache}
+func NewProjectService(perm permission.MemberPermission, store store.Provider, cache *cache.Redis, appKey string) *ProjectService {
+	return &ProjectService{perm: perm, store: store, cache: cache, appKey: appKey}
 }
 
 // CreateProject creates a new project and the owner user
@@ -126,10 +131,7 @@ func (o *ProjectService) CreateProject(ctx context.Context, request *v1.CreatePr
 				logrus.Infof("verification code: %s", verificationCode)
 			}
 		} else if password != "" {
-			secret := x.Keygen()
-			hash := x.HashPassword(password, secret)
-			user.PasswordHash = string(hash)
-			user.Salt = secret
+			user.PasswordHash = x.HashPassword(password)
 		}
 
 		err = tx.CreateAccount(ctx, &user)
@@ -214,11 +216,17 @@ func (o *ProjectService) GetProject(ctx context.Context, request *v1.GetProjectR
 
 	return &v1.GetProjectResponse{
 		Project: &v1.Project{
-			Id:        org.ID,
-			Name:      org.Name,
-			OwnerId:   org.OwnerID,
-			CreatedAt: timestamppb.New(org.CreatedAt),
-			UpdatedAt: timestamppb.New(org.UpdatedAt),
+			Id:                org.ID,
+			Name:              org.Name,
+			OwnerId:           org.OwnerID,
+			TokenPolicy:       tokenPolicyProto(org.TokenPolicy),
+			PasswordPolicy:    passwordPolicyProto(org.PasswordPolicy),
+			EmailVerification: org.EmailVerification,
+			BaseUrl:           org.BaseURL,
+			Locale:            org.Locale,
+			MailSettings:      mailSettingsProto(org.MailSettings),
+			CreatedAt:         timestamppb.New(org.CreatedAt),
+			UpdatedAt:         timestamppb.New(org.UpdatedAt),
 		},
 		Accounts: uint64(userCount),
 		Members:  uint64(memberCount),
@@ -247,12 +255,18 @@ func (o *ProjectService) ListProjects(ctx context.Context, request *v1.ListProje
 	var organizations []*v1.Project
 	for _, org := range orgs {
 		organizations = append(organizations, &v1.Project{
-			Id:        org.ID,
-			Name:      org.Name,
-			OwnerId:   org.OwnerID,
-			Master:    org.Master,
-			CreatedAt: timestamppb.New(org.CreatedAt),
-			UpdatedAt: timestamppb.New(org.UpdatedAt),
+			Id:                org.ID,
+			Name:              org.Name,
+			OwnerId:           org.OwnerID,
+			Master:            org.Master,
+			TokenPolicy:       tokenPolicyProto(org.TokenPolicy),
+			PasswordPolicy:    passwordPolicyProto(org.PasswordPolicy),
+			EmailVerification: org.EmailVerification,
+			BaseUrl:           org.BaseURL,
+			Locale:            org.Locale,
+			MailSettings:      mailSettingsProto(org.MailSettings),
+			CreatedAt:         timestamppb.New(org.CreatedAt),
+			UpdatedAt:         timestamppb.New(org.UpdatedAt),
 		})
 	}
 
@@ -278,6 +292,26 @@ func (o *ProjectService) UpdateProject(ctx context.Context, request *v1.UpdatePr
 		return nil, err
 	}
 
+	if err := validateTokenPolicy(request.GetTokenPolicy()); err != nil {
+		return nil, err
+	}
+	if err := validatePasswordPolicy(request.GetPasswordPolicy()); err != nil {
+		return nil, err
+	}
+	if err := validateBaseURL(request.GetBaseUrl()); err != nil {
+		return nil, err
+	}
+	if err := validateMailSettings(request.GetMailSettings()); err != nil {
+		return nil, err
+	}
+	var locale string
+	if request.Locale != nil && request.GetLocale() != "" {
+		locale, err = mail.ParseLocale(request.GetLocale())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid locale: %s", request.GetLocale())
+		}
+	}
+
 	as, err := store.GetProjectStore(ctx, o.store)
 	if err != nil {
 		return nil, err
@@ -289,6 +323,27 @@ func (o *ProjectService) UpdateProject(ctx context.Context, request *v1.UpdatePr
 		}
 
 		org.Name = request.GetName()
+		if request.TokenPolicy != nil {
+			org.TokenPolicy = tokenPolicyModel(request.GetTokenPolicy())
+		}
+		if request.PasswordPolicy != nil {
+			org.PasswordPolicy = passwordPolicyModel(request.GetPasswordPolicy())
+		}
+		if request.EmailVerification != nil {
+			org.EmailVerification = request.GetEmailVerification()
+		}
+		if request.BaseUrl != nil {
+			org.BaseURL = request.GetBaseUrl()
+		}
+		if request.Locale != nil {
+			org.Locale = locale
+		}
+		if request.MailSettings != nil {
+			org.MailSettings, err = mailSettingsModel(request.GetMailSettings(), org.MailSettings, o.appKey)
+			if err != nil {
+				return err
+			}
+		}
 
 		err = tx.UpdateProject(ctx, org)
 		if err != nil {
@@ -370,7 +425,14 @@ func (o *ProjectService) AddOauthProvider(ctx context.Context, request *v1.AddOa
 		Provider:     provider.GetProvider().String(),
 		ClientID:     provider.GetClientId(),
 		ClientSecret: provider.GetClientSecret(),
-		Scopes:       "openid profile email",
+		Scopes:       provider.GetScopes(),
+		Issuer:       provider.GetIssuer(),
+		EmailClaim:   provider.GetEmailClaim(),
+		NameClaim:    provider.GetNameClaim(),
+		GroupsClaim:  provider.GetGroupsClaim(),
+	}
+	if provider.GetProvider() == v1.Idp_IDP_OIDC && provider.GetIssuer() == "" {
+		return nil, status.Error(codes.InvalidArgument, "oidc providers require an issuer")
 	}
 
 	providerModel := model.OauthProvider{
@@ -406,7 +468,13 @@ func (o *ProjectService) GetOauthProvider(ctx context.Context, request *v1.GetOa
 		return nil, err
 	}
 
-	provider, err := as.GetOauthProviderByName(ctx, orgID, request.GetProvider())
+	// the project providers are the providers of its master pool
+	pool, err := as.GetMasterPool(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := as.GetOauthProviderByName(ctx, uuid.MustParse(pool.ID), request.GetProvider())
 	if err != nil {
 		return nil, err
 	}
@@ -422,6 +490,12 @@ func (o *ProjectService) GetOauthProvider(ctx context.Context, request *v1.GetOa
 			Provider:     v1.Idp(idpProvider),
 			ClientId:     provider.Config.ClientID,
 			ClientSecret: provider.Config.ClientSecret,
+			PoolId:       provider.PoolID,
+			Issuer:       provider.Config.Issuer,
+			EmailClaim:   provider.Config.EmailClaim,
+			NameClaim:    provider.Config.NameClaim,
+			GroupsClaim:  provider.Config.GroupsClaim,
+			Scopes:       provider.Config.Scopes,
 		},
 	}, nil
 }
@@ -444,7 +518,12 @@ func (o *ProjectService) ListOauthProviders(ctx context.Context, request *v1.Lis
 
 	page := utils.GetPage(request)
 
-	providers, total, err := as.ListOauthProviders(ctx, orgID, int(page.Page), int(page.Size))
+	pool, err := as.GetMasterPool(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	providers, total, err := as.ListOauthProviders(ctx, uuid.MustParse(pool.ID), int(page.Page), int(page.Size))
 	if err != nil {
 		return nil, err
 	}
@@ -459,6 +538,8 @@ func (o *ProjectService) ListOauthProviders(ctx context.Context, request *v1.Lis
 			Id:       provider.ID,
 			Provider: v1.Idp(idp),
 			ClientId: provider.Config.ClientID,
+			PoolId:   provider.PoolID,
+			Issuer:   provider.Config.Issuer,
 		})
 	}
 
@@ -17,7 +17,7 @@ func createProject(t *testing.T, name, visibleName, email, pass string) (*authba
 	ab := createAdminProject(t)
 
 	// create a project
-	projectService := NewProjectService(ab.perm, ab.provider, ab.redis)
+	projectService := NewProjectService(ab.perm, ab.provider, ab.redis, "")
 	res, err := projectService.CreateProject(ab.ctx, &v1.CreateProjectRequest{
 		Name:        name,
 		VisibleName: visibleName,
@@ -41,7 +41,7 @@ func TestProjectService_GetProject(t *testing.T) {
 	ab := createAdminProject(t)
 
 	// create a project
-	projectService := NewProjectService(ab.perm, ab.provider, ab.redis)
+	projectService := NewProjectService(ab.perm, ab.provider, ab.redis, "")
 	pass := "password"
 	res, err := projectService.CreateProject(ab.ctx, &v1.CreateProjectRequest{
 		Name:        "test-project-2",
@@ -66,7 +66,7 @@ func TestProjectService_ListProjects(t *testing.T) {
 	ab := createAdminProject(t)
 
 	// create a project
-	projectService := NewProjectService(ab.perm, ab.provider, ab.redis)
+	projectService := NewProjectService(ab.perm, ab.provider, ab.redis, "")
 	pass := "password"
 	_, err := projectService.CreateProject(ab.ctx, &v1.CreateProjectRequest{
 		Name:        "test-project-3",
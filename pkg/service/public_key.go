@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+var _ v1.PublicKeyServiceServer = (*PublicKeyService)(nil)
+
+// PublicKeyService serves the public keys used to verify the pool tokens.
+type PublicKeyService struct {
+	store store.Provider
+	v1.UnimplementedPublicKeyServiceServer
+}
+
+// NewPublicKeyService creates a new public key service.
+func NewPublicKeyService(store store.Provider) *PublicKeyService {
+	return &PublicKeyService{
+		store: store,
+	}
+}
+
+// GetPublicKey returns the public key with the given key id, the key id is the kid header of the token
+func (p *PublicKeyService) GetPublicKey(ctx context.Context, request *v1.GetPublicKeyRequest) (*v1.GetPublicKeyResponse, error) {
+	// keypairs are always kept in the default store
+	as := p.store.Default()
+
+	keypair, err := as.GetKeypair(ctx, uuid.MustParse(request.GetId()))
+	if errors.Is(err, store.ErrKeypairNotFound) {
+		return nil, status.Error(codes.NotFound, "public key not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if keypair.RetiresAt.Before(time.Now()) {
+		return nil, status.Error(codes.NotFound, "public key not found")
+	}
+
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(keypair.PoolID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetPublicKeyResponse{
+		Public: &v1.PublicKey{
+			Id:        keypair.ID,
+			Key:       keypair.PublicKey,
+			ProjectId: pool.ProjectID,
+			PoolId:    keypair.PoolID,
+			Algorithm: keypair.Algorithm,
+			CreatedAt: timestamppb.New(keypair.CreatedAt),
+		},
+		ExpireAt: timestamppb.New(keypair.RetiresAt),
+	}, nil
+}
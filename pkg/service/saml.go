@@ -0,0 +1,418 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/crewjam/saml"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/emrgen/authbase/x/oauth"
+	"github.com/google/uuid"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/url"
+	"time"
+)
+
+const (
+	// samlStateDuration is how long the user has to log in at the SAML identity provider
+	samlStateDuration = 10 * time.Minute
+	// samlCertificateDuration is the validity of the self signed service provider certificate
+	samlCertificateDuration = 10 * 365 * 24 * time.Hour
+)
+
+// samlLoginRequest is a pending SAML login, it is stored under the relay state
+type samlLoginRequest struct {
+	ClientID  string `json:"client_id"`
+	RequestID string `json:"request_id"` // the response must be in response to this authentication request
+}
+
+var _ v1.SamlServiceServer = new(SamlService)
+
+// NewSamlService creates a new SAML provider service.
+func NewSamlService(store store.Provider, publicURL string) *SamlService {
+	return &SamlService{store: store, publicURL: publicURL}
+}
+
+// SamlService manages the SAML identity providers of the pools
+type SamlService struct {
+	store     store.Provider
+	publicURL string
+	v1.UnimplementedSamlServiceServer
+}
+
+// CreateSamlProvider sets the identity provider metadata of the pool.
+// The service provider key is generated with the first provider and kept when the identity provider is replaced.
+func (s *SamlService) CreateSamlProvider(ctx context.Context, request *v1.CreateSamlProviderRequest) (*v1.CreateSamlProviderResponse, error) {
+	poolID, err := uuid.Parse(request.GetPoolId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid pool id")
+	}
+
+	entity, err := parseSamlMetadata([]byte(request.GetIdpMetadata()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid identity provider metadata: %v", err)
+	}
+
+	as, err := store.GetProjectStore(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := as.GetPoolByID(ctx, poolID); err != nil {
+		return nil, err
+	}
+
+	provider, err := as.GetSamlProvider(ctx, poolID)
+	if errors.Is(err, store.ErrSamlProviderNotFound) {
+		key, _, err := x.GenerateKeyPair(2048)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x.GenerateCertificate(key, samlURL(s.publicURL, poolID.String(), "metadata"), samlCertificateDuration)
+		if err != nil {
+			return nil, err
+		}
+		provider = &model.SamlProvider{
+			ID:          uuid.New().String(),
+			PoolID:      poolID.String(),
+			PrivateKey:  string(x.EncodePrivateKeyToPEM(key)),
+			Certificate: string(cert),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	provider.IdpEntityID = entity.EntityID
+	provider.IdpMetadata = request.GetIdpMetadata()
+	provider.EmailAttribute = valueOr(request.GetEmailAttribute(), "email")
+	provider.NameAttribute = valueOr(request.GetNameAttribute(), "name")
+	provider.GroupsAttribute = valueOr(request.GetGroupsAttribute(), "groups")
+	if err := as.SaveSamlProvider(ctx, provider); err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateSamlProviderResponse{Provider: samlProviderProto(provider, s.publicURL)}, nil
+}
+
+// GetSamlProvider returns the SAML identity provider of the pool
+func (s *SamlService) GetSamlProvider(ctx context.Context, request *v1.GetSamlProviderRequest) (*v1.GetSamlProviderResponse, error) {
+	as, err := store.GetProjectStore(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := samlProvider(ctx, as, request.GetPoolId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetSamlProviderResponse{Provider: samlProviderProto(provider, s.publicURL)}, nil
+}
+
+// DeleteSamlProvider deletes the SAML identity provider of the pool, the linked identities are kept
+func (s *SamlService) DeleteSamlProvider(ctx context.Context, request *v1.DeleteSamlProviderRequest) (*v1.DeleteSamlProviderResponse, error) {
+	as, err := store.GetProjectStore(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := samlProvider(ctx, as, request.GetPoolId())
+	if err != nil {
+		return nil, err
+	}
+	if err := as.DeleteSamlProvider(ctx, uuid.MustParse(provider.PoolID)); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteSamlProviderResponse{}, nil
+}
+
+// GetSamlMetadata returns the service provider metadata xml, it is uploaded to the identity provider
+func (s *SamlService) GetSamlMetadata(ctx context.Context, request *v1.GetSamlMetadataRequest) (*v1.GetSamlMetadataResponse, error) {
+	as, err := store.GetProjectStore(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := samlProvider(ctx, as, request.GetPoolId())
+	if err != nil {
+		return nil, err
+	}
+	sp, err := samlServiceProvider(provider, s.publicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetSamlMetadataResponse{Metadata: string(data)}, nil
+}
+
+// LoginUsingSaml starts the SAML login of the client pool and returns the identity provider url with the authentication request
+func (a *AuthService) LoginUsingSaml(ctx context.Context, request *v1.LoginUsingSamlRequest) (*v1.LoginUsingSamlResponse, error) {
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := uuid.Parse(request.GetClientId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid client id")
+	}
+	client, err := as.GetClientByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := samlProvider(ctx, as, client.PoolID)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := samlServiceProvider(provider, a.publicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	location := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if location == "" {
+		return nil, status.Error(codes.FailedPrecondition, "identity provider has no redirect binding")
+	}
+	authnRequest, err := sp.MakeAuthenticationRequest(location, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return nil, err
+	}
+
+	relayState := x.Keygen()
+	data, err := json.Marshal(&samlLoginRequest{ClientID: client.ID, RequestID: authnRequest.ID})
+	if err != nil {
+		return nil, err
+	}
+	err = a.cache.Set(samlStateKey(relayState), string(data), samlStateDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	redirect, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.LoginUsingSamlResponse{
+		RedirectUrl: redirect.String(),
+		RelayState:  relayState,
+	}, nil
+}
+
+// LoginUsingSamlCallback validates the signed assertion of the identity provider, finds or creates the account
+// of the subject and issues tokens. Identity provider initiated logins are rejected, the relay state can be used only once.
+func (a *AuthService) LoginUsingSamlCallback(ctx context.Context, request *v1.LoginUsingSamlCallbackRequest) (*v1.LoginUsingIdpCallbackResponse, error) {
+	key := samlStateKey(request.GetRelayState())
+	data, err := a.cache.Get(key)
+	if err != nil || data == "" {
+		return nil, status.Error(codes.InvalidArgument, "login state not found or expired")
+	}
+	if err := a.cache.Del(key); err != nil {
+		return nil, err
+	}
+
+	var loginRequest samlLoginRequest
+	if err := json.Unmarshal([]byte(data), &loginRequest); err != nil {
+		return nil, err
+	}
+
+	as, err := store.GetProjectStore(ctx, a.store)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := as.GetClientByID(ctx, uuid.MustParse(loginRequest.ClientID))
+	if err != nil {
+		return nil, err
+	}
+	if client.PoolID != request.GetPoolId() {
+		return nil, status.Error(codes.InvalidArgument, "login state belongs to another pool")
+	}
+
+	provider, err := samlProvider(ctx, as, client.PoolID)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := samlServiceProvider(provider, a.publicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := base64.StdEncoding.DecodeString(request.GetSamlResponse())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid saml response encoding")
+	}
+	assertion, err := sp.ParseXMLResponse(response, []string{loginRequest.RequestID})
+	if err != nil {
+		// the reason is logged only, it may help an attacker
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		logrus.Warnf("saml: rejected response for pool %s: %v", client.PoolID, err)
+		return nil, status.Error(codes.Unauthenticated, "invalid saml response")
+	}
+
+	user := samlUser(provider, assertion)
+	if user.ID == "" {
+		return nil, status.Error(codes.Unauthenticated, "saml assertion without subject")
+	}
+
+	return a.idpLogin(ctx, as, client, "saml", user)
+}
+
+// samlProvider returns the SAML identity provider of the pool
+func samlProvider(ctx context.Context, as store.AuthBaseStore, poolID string) (*model.SamlProvider, error) {
+	id, err := uuid.Parse(poolID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid pool id")
+	}
+
+	provider, err := as.GetSamlProvider(ctx, id)
+	if errors.Is(err, store.ErrSamlProviderNotFound) {
+		return nil, status.Error(codes.NotFound, "saml is not configured for the pool")
+	}
+
+	return provider, err
+}
+
+// samlServiceProvider builds the authbase service provider of the pool, the metadata url is its entity id
+func samlServiceProvider(provider *model.SamlProvider, publicURL string) (*saml.ServiceProvider, error) {
+	key, err := x.DecodeRSAPrivateKey([]byte(provider.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x.DecodeCertificate([]byte(provider.Certificate))
+	if err != nil {
+		return nil, err
+	}
+	entity, err := parseSamlMetadata([]byte(provider.IdpMetadata))
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL, err := url.Parse(samlURL(publicURL, provider.PoolID, "metadata"))
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(samlURL(publicURL, provider.PoolID, "acs"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       entity,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}, nil
+}
+
+// parseSamlMetadata parses the identity provider metadata, it must describe an identity provider
+func parseSamlMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err != nil {
+		return nil, err
+	}
+	if entity.EntityID == "" || len(entity.IDPSSODescriptors) == 0 {
+		return nil, errors.New("missing identity provider descriptor")
+	}
+
+	return &entity, nil
+}
+
+// samlUser maps the assertion subject and attributes onto the provider user.
+// Attributes are matched by name or friendly name. The email is trusted as verified,
+// the assertion is signed by the identity provider the pool administrators configured.
+func samlUser(provider *model.SamlProvider, assertion *saml.Assertion) *oauth.User {
+	attributes := make(map[string][]string)
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			var values []string
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+			attributes[attribute.Name] = append(attributes[attribute.Name], values...)
+			if attribute.FriendlyName != "" && attribute.FriendlyName != attribute.Name {
+				attributes[attribute.FriendlyName] = append(attributes[attribute.FriendlyName], values...)
+			}
+		}
+	}
+	first := func(name string) string {
+		if values := attributes[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	user := &oauth.User{
+		Email:  first(provider.EmailAttribute),
+		Name:   first(provider.NameAttribute),
+		Groups: attributes[provider.GroupsAttribute],
+	}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		nameID := assertion.Subject.NameID
+		user.ID = nameID.Value
+		if user.Email == "" && nameID.Format == string(saml.EmailAddressNameIDFormat) {
+			user.Email = nameID.Value
+		}
+	}
+	user.EmailVerified = user.Email != ""
+
+	return user
+}
+
+// samlProviderProto converts the provider, the service provider urls are derived from the public url
+func samlProviderProto(provider *model.SamlProvider, publicURL string) *v1.SamlProvider {
+	return &v1.SamlProvider{
+		Id:              provider.ID,
+		PoolId:          provider.PoolID,
+		IdpEntityId:     provider.IdpEntityID,
+		EntityId:        samlURL(publicURL, provider.PoolID, "metadata"),
+		AcsUrl:          samlURL(publicURL, provider.PoolID, "acs"),
+		MetadataUrl:     samlURL(publicURL, provider.PoolID, "metadata"),
+		EmailAttribute:  provider.EmailAttribute,
+		NameAttribute:   provider.NameAttribute,
+		GroupsAttribute: provider.GroupsAttribute,
+		CreatedAt:       timestamppb.New(provider.CreatedAt),
+		UpdatedAt:       timestamppb.New(provider.UpdatedAt),
+	}
+}
+
+// samlURL returns the url of a pool SAML endpoint served by the SamlHandler
+func samlURL(publicURL, poolID, endpoint string) string {
+	return publicURL + "/auth/saml/" + poolID + "/" + endpoint
+}
+
+func samlStateKey(relayState string) string {
+	return "saml:state:" + relayState
+}
+
+// valueOr returns the value, or the fallback when it is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/xml"
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/x"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testSamlIdp creates an identity provider that signs the assertions with its own key
+func testSamlIdp(t *testing.T) *saml.IdentityProvider {
+	key, _, err := x.GenerateKeyPair(2048)
+	assert.NoError(t, err)
+	certPEM, err := x.GenerateCertificate(key, "idp.test", time.Hour)
+	assert.NoError(t, err)
+	cert, err := x.DecodeCertificate(certPEM)
+	assert.NoError(t, err)
+
+	metadataURL, _ := url.Parse("https://idp.test/metadata")
+	ssoURL, _ := url.Parse("https://idp.test/sso")
+	return &saml.IdentityProvider{
+		Key:         key,
+		Certificate: cert,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}
+}
+
+// testSamlResponse answers the authentication request as the identity provider would
+func testSamlResponse(t *testing.T, idp *saml.IdentityProvider, sp *saml.ServiceProvider, requestID string) []byte {
+	req := &saml.IdpAuthnRequest{
+		IDP:                     idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, "https://idp.test/sso", nil),
+		Request:                 saml.AuthnRequest{ID: requestID},
+		ServiceProviderMetadata: sp.Metadata(),
+		SPSSODescriptor:         &sp.Metadata().SPSSODescriptors[0],
+		ACSEndpoint:             &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: sp.AcsURL.String()},
+		Now:                     saml.TimeNow(),
+	}
+	err := saml.DefaultAssertionMaker{}.MakeAssertion(req, &saml.Session{
+		ID:           "session",
+		NameID:       "jane@idp.test",
+		NameIDFormat: string(saml.EmailAddressNameIDFormat),
+		CustomAttributes: []saml.Attribute{
+			{Name: "displayName", Values: []saml.AttributeValue{{Value: "Jane"}}},
+			{Name: "memberOf", Values: []saml.AttributeValue{{Value: "admins"}, {Value: "developers"}}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, req.MakeResponse())
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+	data, err := doc.WriteToBytes()
+	assert.NoError(t, err)
+	return data
+}
+
+// TestSamlAssertion function to test the signed assertion validation and the attribute mapping
+func TestSamlAssertion(t *testing.T) {
+	idp := testSamlIdp(t)
+	idpMetadata, err := xml.Marshal(idp.Metadata())
+	assert.NoError(t, err)
+
+	key, _, err := x.GenerateKeyPair(2048)
+	assert.NoError(t, err)
+	cert, err := x.GenerateCertificate(key, "sp.test", time.Hour)
+	assert.NoError(t, err)
+	provider := &model.SamlProvider{
+		PoolID:          "pool",
+		IdpMetadata:     string(idpMetadata),
+		PrivateKey:      string(x.EncodePrivateKeyToPEM(key)),
+		Certificate:     string(cert),
+		EmailAttribute:  "email",
+		NameAttribute:   "displayName",
+		GroupsAttribute: "memberOf",
+	}
+	sp, err := samlServiceProvider(provider, "https://authbase.test")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://authbase.test/auth/saml/pool/acs", sp.AcsURL.String())
+
+	authnRequest, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	assert.NoError(t, err)
+	response := testSamlResponse(t, idp, sp, authnRequest.ID)
+
+	assertion, err := sp.ParseXMLResponse(response, []string{authnRequest.ID})
+	assert.NoError(t, err)
+	user := samlUser(provider, assertion)
+	assert.Equal(t, "jane@idp.test", user.ID)
+	assert.Equal(t, "jane@idp.test", user.Email)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, "Jane", user.Name)
+	assert.Equal(t, []string{"admins", "developers"}, user.Groups)
+
+	// the response must answer a pending request
+	_, err = sp.ParseXMLResponse(response, []string{"other-request"})
+	assert.Error(t, err)
+
+	// an assertion signed by another identity provider is rejected
+	forged := testSamlResponse(t, testSamlIdp(t), sp, authnRequest.ID)
+	_, err = sp.ParseXMLResponse(forged, []string{authnRequest.ID})
+	assert.Error(t, err)
+}
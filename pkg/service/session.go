@@ -3,90 +3,227 @@ package service
 import (
 	"context"
 	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/model"
 	"github.com/emrgen/authbase/pkg/permission"
 	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func NewSessionService(store store.Provider, perm permission.AuthBasePermission) *SessionService {
-	return &SessionService{store: store, perm: perm}
+func NewSessionService(store store.Provider, perm permission.AuthBasePermission, cache *cache.Redis) *SessionService {
+	return &SessionService{store: store, perm: perm, cache: cache, revocations: x.NewCacheRevocationList(cache)}
 }
 
 var _ v1.SessionServiceServer = (*SessionService)(nil)
 
 // SessionService implements the v1.SessionServiceServer interface.
 type SessionService struct {
-	store store.Provider
-	perm  permission.AuthBasePermission
+	store       store.Provider
+	perm        permission.AuthBasePermission
+	cache       *cache.Redis
+	revocations x.RevocationList
 	v1.UnimplementedSessionServiceServer
 }
 
-func (s *SessionService) ListUserSession(ctx context.Context, request *v1.ListAccountSessionRequest) (*v1.ListAccountSessionResponse, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-// DeleteSession logs out a user from a session
-func (s *SessionService) DeleteSession(ctx context.Context, request *v1.DeleteSessionsRequest) (*v1.DeleteSessionsResponse, error) {
+// ListAccountSession lists the active sessions of an account, one for every device the account is signed in on
+func (s *SessionService) ListAccountSession(ctx context.Context, request *v1.ListAccountSessionRequest) (*v1.ListAccountSessionResponse, error) {
 	as, err := store.GetProjectStore(ctx, s.store)
 	if err != nil {
 		return nil, err
 	}
 
-	sessionID, err := uuid.Parse(request.GetSessionId())
+	account, err := s.sessionAccount(ctx, as, request.GetAccountId(), permission.ProjectPermissionRead)
 	if err != nil {
 		return nil, err
 	}
+	if request.GetPoolId() != "" && request.GetPoolId() != account.PoolID {
+		return nil, status.Error(codes.InvalidArgument, "account is not in the pool")
+	}
 
-	user, err := as.GetAccountByID(ctx, sessionID)
+	page := x.GetPageFromRequest(request)
+	sessions, total, err := as.ListActiveSessions(ctx, uuid.MustParse(account.ID), int(page.Page), int(page.Size))
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.perm.CheckProjectPermission(ctx, uuid.MustParse(user.ProjectID), "write")
+	current, _ := x.GetAuthbaseSessionID(ctx)
+	var sessionProtos []*v1.Session
+	for _, session := range sessions {
+		sessionProto := &v1.Session{
+			Id:           session.ID,
+			AccountId:    session.AccountID,
+			ProjectId:    session.ProjectID,
+			PoolId:       session.PoolID,
+			ClientId:     session.ClientID,
+			UserAgent:    session.UserAgent,
+			IpAddress:    session.IPAddress,
+			Current:      session.ID == current,
+			CreatedAt:    timestamppb.New(session.CreatedAt),
+			LastActiveAt: timestamppb.New(session.LastActiveAt),
+		}
+		if !session.ExpiredAt.IsZero() {
+			sessionProto.ExpiresAt = timestamppb.New(session.ExpiredAt)
+		}
+		sessionProtos = append(sessionProtos, sessionProto)
+	}
+
+	return &v1.ListAccountSessionResponse{
+		Sessions: sessionProtos,
+		Meta: &v1.Meta{
+			Total: int32(total),
+			Page:  page.Page,
+			Size:  page.Size,
+		},
+	}, nil
+}
+
+// DeleteSessions logs out the account from the sessions
+func (s *SessionService) DeleteSessions(ctx context.Context, request *v1.DeleteSessionsRequest) (*v1.DeleteSessionsResponse, error) {
+	as, err := store.GetProjectStore(ctx, s.store)
 	if err != nil {
 		return nil, err
 	}
 
-	err = as.DeleteSession(ctx, sessionID)
-	if err != nil {
+	sessionIDs := request.GetSessionIds()
+	if request.GetSessionId() != "" {
+		sessionIDs = append(sessionIDs, request.GetSessionId())
+	}
+	if len(sessionIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing session id")
+	}
+
+	var sessions []*model.Session
+	for _, id := range sessionIDs {
+		sessionID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		session, err := as.GetSession(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if len(sessions) > 0 && session.AccountID != sessions[0].AccountID {
+			return nil, status.Error(codes.InvalidArgument, "sessions must belong to the same account")
+		}
+		sessions = append(sessions, session)
+	}
+
+	if _, err := s.sessionAccount(ctx, as, sessions[0].AccountID, permission.ProjectPermissionWrite); err != nil {
+		return nil, err
+	}
+
+	if err := endSessions(ctx, as, s.revocations, s.cache, sessions); err != nil {
 		return nil, err
 	}
 
 	return &v1.DeleteSessionsResponse{
-		Message: "logged out",
+		SessionCount: uint64(len(sessions)),
+		Message:      "logged out",
 	}, nil
 }
 
-// DeleteAllSessions logs out all sessions of a user
+// DeleteAllSessions logs out all sessions of an account, the session of the caller is kept on request
 func (s *SessionService) DeleteAllSessions(ctx context.Context, request *v1.DeleteAllSessionsRequest) (*v1.DeleteAllSessionsResponse, error) {
-	var err error
-
-	userID := uuid.MustParse(request.GetAccountId())
 	as, err := store.GetProjectStore(ctx, s.store)
 	if err != nil {
 		return nil, err
 	}
 
-	// user project id
-	user, err := as.GetAccountByID(ctx, userID)
+	account, err := s.sessionAccount(ctx, as, request.GetAccountId(), permission.ProjectPermissionWrite)
 	if err != nil {
 		return nil, err
 	}
 
-	orgID := uuid.MustParse(user.ProjectID)
-
-	err = s.perm.CheckProjectPermission(ctx, orgID, "write")
+	sessions, err := as.ListAccountSessions(ctx, uuid.MustParse(account.ID))
 	if err != nil {
 		return nil, err
 	}
 
-	err = as.DeleteSessionByAccountID(ctx, userID)
-	if err != nil {
+	// signing out the other devices keeps the session of the calling token
+	if request.GetKeepCurrent() {
+		current, _ := x.GetAuthbaseSessionID(ctx)
+		others := make([]*model.Session, 0, len(sessions))
+		for _, session := range sessions {
+			if session.ID != current {
+				others = append(others, session)
+			}
+		}
+		sessions = others
+	}
+
+	if err := endSessions(ctx, as, s.revocations, s.cache, sessions); err != nil {
 		return nil, err
 	}
 
 	return &v1.DeleteAllSessionsResponse{
-		Message: "logged out of all sessions",
+		Message:      "logged out of all sessions",
+		SessionCount: uint64(len(sessions)),
 	}, nil
 }
+
+// sessionAccount returns the account whose sessions are managed. Accounts manage their own sessions,
+// the sessions of other accounts need the project permission. An empty id or "-" is the caller.
+func (s *SessionService) sessionAccount(ctx context.Context, as store.AuthBaseStore, id string, perm permission.ProjectPermission) (*model.Account, error) {
+	callerID, err := x.GetAuthbaseAccountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	accountID := callerID
+	if id != "" && id != "-" {
+		accountID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	account, err := as.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if accountID == callerID {
+		return account, nil
+	}
+
+	err = s.perm.CheckProjectPermission(ctx, uuid.MustParse(account.ProjectID), perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// endSessions deletes the sessions with their refresh tokens and revokes the access tokens issued for them
+func endSessions(ctx context.Context, as store.AuthBaseStore, revocations x.RevocationList, cache *cache.Redis, sessions []*model.Session) error {
+	err := as.Transaction(func(tx store.AuthBaseStore) error {
+		for _, session := range sessions {
+			if err := tx.DeleteRefreshTokenFamily(ctx, session.ID); err != nil {
+				return err
+			}
+			if err := tx.DeleteSession(ctx, uuid.MustParse(session.ID)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := revocations.RevokeSession(session.ID); err != nil {
+			return err
+		}
+		// the refresh token of the login is cached under its jti, the session id
+		if err := cache.Del(session.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
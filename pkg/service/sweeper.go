@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// SessionSweeper removes the expired sessions and refresh tokens from the default store.
+// The store keeps them forever otherwise, the expiry is only checked when a token is used.
+type SessionSweeper struct {
+	provider store.Provider
+	interval time.Duration
+}
+
+func NewSessionSweeper(provider store.Provider, interval time.Duration) *SessionSweeper {
+	return &SessionSweeper{provider: provider, interval: interval}
+}
+
+// Run sweeps on every interval until the context is done
+func (s *SessionSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sweep(ctx); err != nil {
+				logrus.Errorf("failed to sweep the expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+// Sweep removes the refresh tokens expired by now, then the sessions that ended or can not be refreshed anymore
+func (s *SessionSweeper) Sweep(ctx context.Context) error {
+	as := s.provider.Default()
+	now := time.Now()
+
+	tokens, err := as.DeleteExpiredRefreshTokens(ctx, now)
+	if err != nil {
+		return err
+	}
+	sessions, err := as.DeleteExpiredSessions(ctx, now)
+	if err != nil {
+		return err
+	}
+	if tokens > 0 || sessions > 0 {
+		logrus.Infof("swept %d expired sessions and %d expired refresh tokens", sessions, tokens)
+	}
+
+	return nil
+}
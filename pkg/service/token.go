@@ -49,7 +49,7 @@ func (t TokenService) VerifyToken(ctx context.Context, request *v1.VerifyTokenRe
 			return nil, err
 		}
 
-		return &v1.VerifyTokenResponse{Valid: true, UserId: res.AccountID, ProjectId: res.ProjectID, PoolId: res.ProjectID}, nil
+		return &v1.VerifyTokenResponse{Valid: true, UserId: res.AccountID, ProjectId: res.ProjectID, PoolId: res.PoolID}, nil
 	}
 
 	return &v1.VerifyTokenResponse{Valid: false}, nil
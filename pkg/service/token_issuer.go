@@ -0,0 +1,520 @@
+package service
+
+import (
+	"context"
+	"errors"
+	goset "github.com/deckarep/golang-set/v2"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"time"
+)
+
+// tokenIssuer signs the account tokens and records the refresh token and the session.
+// It is shared by the password login and the OAuth2 grants so that every flow issues the same tokens.
+type tokenIssuer struct {
+	keyProvider x.JWTSignerVerifierProvider
+	cache       *cache.Redis
+	revocations x.RevocationList
+}
+
+func newTokenIssuer(keyProvider x.JWTSignerVerifierProvider, cache *cache.Redis) *tokenIssuer {
+	return &tokenIssuer{keyProvider: keyProvider, cache: cache, revocations: x.NewCacheRevocationList(cache)}
+}
+
+// issuedToken is a signed token pair with its session
+type issuedToken struct {
+	*x.JWTToken
+	Claims          *x.Claims
+	RefreshExpireAt time.Time
+	IDToken         string // set when the openid scope was granted
+}
+
+// accountRoles returns the role names of the account from its group memberships
+func accountRoles(ctx context.Context, as store.AuthBaseStore, accountID uuid.UUID) ([]string, error) {
+	memberships, err := as.ListGroupMemberByAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := goset.NewSet[string]()
+	for _, member := range memberships {
+		for _, role := range member.Group.Roles {
+			set.Add(role.Name)
+		}
+	}
+
+	return set.ToSlice(), nil
+}
+
+// issue generates tokens for the account and starts a new session.
+// When scopes is nil the account roles are used as scopes.
+func (t *tokenIssuer) issue(ctx context.Context, as store.AuthBaseStore, account *model.Account, clientID string, scopes []string) (*issuedToken, error) {
+	// every login ends here, the unverified accounts get no tokens when the project requires verification
+	if err := checkEmailVerified(ctx, as, account); err != nil {
+		return nil, err
+	}
+
+	roleNames, err := accountRoles(ctx, as, uuid.MustParse(account.ID))
+	if err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = roleNames // internal roles
+	}
+
+	signer, err := t.keyProvider.GetSigner(account.PoolID)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetimes, err := resolveTokenLifetimes(ctx, as, account.PoolID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.limitSessions(ctx, as, account, lifetimes); err != nil {
+		return nil, err
+	}
+	// the tokens never outlive a session with an absolute lifetime
+	now := time.Now()
+	var sessionEnd time.Time
+	if lifetimes.session > 0 {
+		sessionEnd = now.Add(lifetimes.session)
+	}
+
+	// generate tokens for the account
+	jti := uuid.New().String() // unique id for the token
+	claims := &x.Claims{
+		Username:  account.Username,
+		Email:     account.Email,
+		ClientID:  clientID,
+		ProjectID: account.ProjectID,
+		PoolID:    account.PoolID,
+		AccountID: account.ID,
+		Audience:  "", // TODO: the target website or app that will use the token
+		Jti:       jti,
+		SessionID: jti,
+		ExpireAt:  capExpiry(now.Add(lifetimes.accessToken), sessionEnd),
+		IssuedAt:  now,
+		Provider:  "authbase", // TODO: what should this be?
+		Scopes:    scopes,
+		Roles:     roleNames,
+	}
+	refreshExpireAt := capExpiry(now.Add(lifetimes.refreshToken), sessionEnd)
+	token, err := x.GenerateJWTToken(claims, signer, refreshExpireAt)
+	if err != nil {
+		return nil, err
+	}
+	token.IssuedAt = claims.IssuedAt
+
+	// save refresh token to cache, it will be used to validate the refresh token request
+	// on cache miss, it will check the provider for the refresh token
+	err = t.cache.Set(jti, token.RefreshToken, time.Until(refreshExpireAt))
+	if err != nil {
+		return nil, err
+	}
+
+	// save the token to the provider
+	// TODO: save the token to the provider in encrypted form
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		err := tx.CreateRefreshToken(ctx, &model.RefreshToken{
+			Token:     token.RefreshToken,
+			ProjectID: account.ProjectID,
+			AccountID: account.ID,
+			ExpireAt:  refreshExpireAt,
+			IssuedAt:  token.IssuedAt,
+			FamilyID:  jti,
+		})
+		if err != nil {
+			return err
+		}
+
+		// create a new session
+		// use the jti as the session id
+		// this will allow multiple sessions for a account at the same time, up to the pool limit
+		return tx.CreateSession(ctx, &model.Session{
+			ID:           jti,
+			PoolID:       account.PoolID,
+			AccountID:    account.ID,
+			ProjectID:    account.ProjectID,
+			ClientID:     clientID,
+			UserAgent:    x.RequestUserAgent(ctx),
+			IPAddress:    x.RequestIP(ctx),
+			ExpiredAt:    sessionEnd,
+			LastActiveAt: now,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &issuedToken{JWTToken: token, Claims: claims, RefreshExpireAt: refreshExpireAt}, nil
+}
+
+// errRefreshTokenReused is returned when a consumed refresh token is presented again
+var errRefreshTokenReused = errors.New("refresh token was already used, the session is revoked")
+
+// refresh rotates a valid refresh token: the token is consumed and a new token pair of the same family is issued.
+// A consumed token presented again means it was stolen, the whole family and its session are revoked.
+// When clientID is set the token must have been issued to the client.
+func (t *tokenIssuer) refresh(ctx context.Context, as store.AuthBaseStore, refreshToken, clientID string) (*issuedToken, error) {
+	oldClaims, err := x.GetTokenClaims(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := t.keyProvider.GetVerifier(oldClaims.PoolID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := x.VerifyJWTToken(refreshToken, verifier)
+	if err != nil {
+		return nil, err
+	}
+	// tokens issued before the sid claim carry no token use, they are accepted as before
+	if claims.TokenUse != x.TokenUseRefresh && claims.SessionID != "" {
+		return nil, errors.New("not a refresh token")
+	}
+	if clientID != "" && claims.ClientID != clientID {
+		return nil, errors.New("refresh token was issued to another client")
+	}
+
+	// a revoked, logged out, expired or idle session can not be refreshed
+	lifetimes, err := resolveTokenLifetimes(ctx, as, claims.PoolID, claims.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	session, err := t.activeSession(ctx, as, claims, lifetimes)
+	if err != nil {
+		return nil, err
+	}
+	// the refresh tokens issued before a password change or an account disable are revoked
+	revoked, err := t.revocations.Revoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token was revoked, need to login again")
+	}
+
+	stored, err := as.GetRefreshTokenByID(ctx, refreshToken)
+	if errors.Is(err, store.ErrRefreshTokenNotFound) {
+		return nil, errors.New("token not found, need to login again")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Consumed() {
+		return nil, t.revokeFamily(ctx, as, stored, claims)
+	}
+
+	account, err := as.GetAccountByID(ctx, uuid.MustParse(stored.AccountID))
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Disabled {
+		return nil, errors.New("account is disabled")
+	}
+
+	now := time.Now()
+	newClaims := &x.Claims{
+		ProjectID: stored.ProjectID,
+		PoolID:    claims.PoolID,
+		ClientID:  claims.ClientID,
+		AccountID: account.ID,
+		Username:  claims.Username,
+		Email:     claims.Email,
+		Audience:  claims.Audience,
+		Jti:       uuid.New().String(),
+		SessionID: tokenSessionID(claims),
+		ExpireAt:  capExpiry(now.Add(lifetimes.accessToken), session.ExpiredAt),
+		IssuedAt:  now,
+		Provider:  "authbase",
+		Scopes:    claims.Scopes,
+		Roles:     claims.Roles,
+	}
+
+	signer, err := t.keyProvider.GetSigner(newClaims.PoolID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpireAt := capExpiry(now.Add(lifetimes.refreshToken), session.ExpiredAt)
+	token, err := x.GenerateJWTToken(newClaims, signer, refreshExpireAt)
+	if err != nil {
+		return nil, err
+	}
+	token.IssuedAt = newClaims.IssuedAt
+
+	err = as.Transaction(func(tx store.AuthBaseStore) error {
+		if err := tx.ConsumeRefreshToken(ctx, refreshToken); err != nil {
+			return err
+		}
+		session.LastActiveAt = now
+		if err := tx.UpdateSession(ctx, session); err != nil {
+			return err
+		}
+
+		return tx.CreateRefreshToken(ctx, &model.RefreshToken{
+			Token:     token.RefreshToken,
+			ProjectID: stored.ProjectID,
+			AccountID: account.ID,
+			ExpireAt:  refreshExpireAt,
+			IssuedAt:  token.IssuedAt,
+			FamilyID:  refreshTokenFamily(stored, claims),
+		})
+	})
+	// a concurrent refresh consumed the token first, only one of them can be the owner
+	if errors.Is(err, store.ErrRefreshTokenConsumed) {
+		return nil, t.revokeFamily(ctx, as, stored, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.cache.Set(newClaims.Jti, token.RefreshToken, time.Until(refreshExpireAt)); err != nil {
+		return nil, err
+	}
+
+	return &issuedToken{JWTToken: token, Claims: newClaims, RefreshExpireAt: refreshExpireAt}, nil
+}
+
+// revokeFamily deletes the refresh tokens of the family and ends their session
+func (t *tokenIssuer) revokeFamily(ctx context.Context, as store.AuthBaseStore, stored *model.RefreshToken, claims *x.Claims) error {
+	logrus.Warnf("authbase: refresh token reuse detected for account %s, revoking session %s", stored.AccountID, tokenSessionID(claims))
+
+	err := as.Transaction(func(tx store.AuthBaseStore) error {
+		if err := tx.DeleteRefreshTokenFamily(ctx, refreshTokenFamily(stored, claims)); err != nil {
+			return err
+		}
+
+		sessionID, err := uuid.Parse(tokenSessionID(claims))
+		if err != nil {
+			return nil
+		}
+		return tx.DeleteSession(ctx, sessionID)
+	})
+	if err != nil {
+		return err
+	}
+	// the access tokens of the thief are rejected too
+	if err := t.revocations.RevokeSession(tokenSessionID(claims)); err != nil {
+		return err
+	}
+
+	return errRefreshTokenReused
+}
+
+// activeSession returns the session of the refresh token, a session past its idle timeout is ended
+func (t *tokenIssuer) activeSession(ctx context.Context, as store.AuthBaseStore, claims *x.Claims, lifetimes *tokenLifetimes) (*model.Session, error) {
+	errSessionEnded := errors.New("session ended, need to login again")
+	sessionID, err := uuid.Parse(tokenSessionID(claims))
+	if err != nil {
+		return nil, errSessionEnded
+	}
+	session, err := as.GetSession(ctx, sessionID)
+	if errors.Is(err, store.ErrSessionNotFound) {
+		return nil, errSessionEnded
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.Active() {
+		return nil, errSessionEnded
+	}
+
+	if sessionIdle(session, lifetimes) {
+		if err := endSessions(ctx, as, t.revocations, t.cache, []*model.Session{session}); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("session was idle for too long, need to login again")
+	}
+
+	return session, nil
+}
+
+// sessionIdle reports whether the session was not refreshed within the idle timeout
+func sessionIdle(session *model.Session, lifetimes *tokenLifetimes) bool {
+	lastActiveAt := session.LastActiveAt
+	if lastActiveAt.IsZero() {
+		lastActiveAt = session.CreatedAt
+	}
+
+	return lifetimes.sessionIdle > 0 && time.Since(lastActiveAt) > lifetimes.sessionIdle
+}
+
+// limitSessions makes room for a new session when the pool limits the concurrent sessions of an account.
+// Over the limit the login is rejected or the oldest sessions are ended, by the strategy of the pool.
+func (t *tokenIssuer) limitSessions(ctx context.Context, as store.AuthBaseStore, account *model.Account, lifetimes *tokenLifetimes) error {
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(account.PoolID))
+	if err != nil {
+		return err
+	}
+	if pool.MaxSessions <= 0 {
+		return nil
+	}
+
+	sessions, err := as.ListAccountSessions(ctx, uuid.MustParse(account.ID))
+	if err != nil {
+		return err
+	}
+	// idle sessions can not be refreshed anymore, they do not take a place
+	live := make([]*model.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if !sessionIdle(session, lifetimes) {
+			live = append(live, session)
+		}
+	}
+
+	excess := len(live) - pool.MaxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+	if pool.SessionLimitStrategy != model.SessionLimitEvictOldest {
+		return status.Errorf(codes.ResourceExhausted, "account has reached the limit of %d active sessions", pool.MaxSessions)
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].CreatedAt.Before(live[j].CreatedAt)
+	})
+
+	return endSessions(ctx, as, t.revocations, t.cache, live[:excess])
+}
+
+// refreshTokenFamily returns the family of the stored token, tokens stored before the rotation use their session
+func refreshTokenFamily(stored *model.RefreshToken, claims *x.Claims) string {
+	if stored.FamilyID != "" {
+		return stored.FamilyID
+	}
+
+	return tokenSessionID(claims)
+}
+
+// clientRoles returns the role names of the client from its group memberships
+func clientRoles(ctx context.Context, as store.AuthBaseStore, clientID uuid.UUID) ([]string, error) {
+	memberships, err := as.ListGroupMemberByClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := goset.NewSet[string]()
+	for _, member := range memberships {
+		for _, role := range member.Group.Roles {
+			set.Add(role.Name)
+		}
+	}
+
+	return set.ToSlice(), nil
+}
+
+// issueClient generates a short-lived access token for the client itself, the subject of the token is the client.
+// No refresh token or session is created, the client authenticates again when the token expires.
+func (t *tokenIssuer) issueClient(ctx context.Context, as store.AuthBaseStore, client *model.Client, pool *model.Pool, scopes []string) (*issuedToken, error) {
+	roleNames, err := clientRoles(ctx, as, uuid.MustParse(client.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := t.keyProvider.GetSigner(client.PoolID)
+	if err != nil {
+		return nil, err
+	}
+
+	// client tokens are shorter than the account tokens unless a policy sets their lifetime
+	policy, err := mergedTokenPolicy(ctx, as, client.PoolID, client.ID)
+	if err != nil {
+		return nil, err
+	}
+	expireIn := x.ClientTokenDuration
+	if policy.AccessTokenTTL != 0 {
+		expireIn = time.Duration(policy.AccessTokenTTL) * time.Second
+	}
+
+	claims := &x.Claims{
+		Subject:   client.ID,
+		ClientID:  client.ID,
+		ProjectID: pool.ProjectID,
+		PoolID:    client.PoolID,
+		Jti:       uuid.New().String(),
+		ExpireAt:  time.Now().Add(expireIn),
+		IssuedAt:  time.Now(),
+		Provider:  "authbase",
+		Scopes:    scopes,
+		Roles:     roleNames,
+	}
+	accessToken, err := x.GenerateAccessToken(claims, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &issuedToken{
+		JWTToken: &x.JWTToken{
+			AccessToken: accessToken,
+			ExpireAt:    claims.ExpireAt,
+			IssuedAt:    claims.IssuedAt,
+		},
+		Claims: claims,
+	}, nil
+}
+
+// idToken generates an OpenID Connect id token of the account for the client
+func (t *tokenIssuer) idToken(account *model.Account, clientID, issuer, nonce string, authTime time.Time, scopes []string) (string, error) {
+	signer, err := t.keyProvider.GetSigner(account.PoolID)
+	if err != nil {
+		return "", err
+	}
+
+	return x.GenerateIDToken(&x.IDTokenClaims{
+		UserInfo: accountUserInfo(account, scopes),
+		Issuer:   issuer,
+		Audience: clientID,
+		Nonce:    nonce,
+		AuthTime: authTime,
+		ExpireAt: time.Now().Add(x.IDTokenDuration),
+		IssuedAt: time.Now(),
+	}, signer)
+}
+
+// accountUserInfo returns the standard claims of the account allowed by the scopes
+func accountUserInfo(account *model.Account, scopes []string) *x.UserInfo {
+	return x.NewUserInfo(account.ID, account.VisibleName, account.Username, account.Email, account.Verified, scopes)
+}
+
+// tokenSessionID returns the session of the token, tokens issued before the sid claim use the jti of the login
+func tokenSessionID(claims *x.Claims) string {
+	if claims.SessionID != "" {
+		return claims.SessionID
+	}
+
+	return claims.Jti
+}
+
+// sessionActive reports whether the session of an account token is still active.
+// Client credentials tokens have no session and are always active.
+func sessionActive(ctx context.Context, as store.AuthBaseStore, claims *x.Claims) (bool, error) {
+	if claims.AccountID == "" {
+		return true, nil
+	}
+
+	sessionID, err := uuid.Parse(tokenSessionID(claims))
+	if err != nil {
+		return false, nil
+	}
+	session, err := as.GetSession(ctx, sessionID)
+	if errors.Is(err, store.ErrSessionNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return session.Active(), nil
+}
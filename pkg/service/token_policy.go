@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/emrgen/authbase/x"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// defaultTokenPolicy is used for the values no project, pool or client policy sets
+var defaultTokenPolicy = model.TokenPolicy{
+	AccessTokenTTL:  int64(x.AccessTokenDuration / time.Second),
+	RefreshTokenTTL: int64(x.RefreshTokenDuration / time.Second),
+	AccessKeyMaxTTL: int64(defaultAccessKeyExpireIn / time.Second),
+}
+
+// tokenLifetimes is a resolved token policy, zero session values mean the session does not end on its own
+type tokenLifetimes struct {
+	accessToken  time.Duration
+	refreshToken time.Duration
+	session      time.Duration
+	sessionIdle  time.Duration
+	accessKeyMax time.Duration
+}
+
+// mergedTokenPolicy returns the policy of the project overridden by the pool and the client policy.
+// The client is skipped when clientID is empty, unset values stay zero.
+func mergedTokenPolicy(ctx context.Context, as store.AuthBaseStore, poolID, clientID string) (model.TokenPolicy, error) {
+	pool, err := as.GetPoolByID(ctx, uuid.MustParse(poolID))
+	if err != nil {
+		return model.TokenPolicy{}, err
+	}
+	project, err := as.GetProjectByID(ctx, uuid.MustParse(pool.ProjectID))
+	if err != nil {
+		return model.TokenPolicy{}, err
+	}
+
+	policy := project.TokenPolicy.Override(pool.TokenPolicy)
+	if id, err := uuid.Parse(clientID); err == nil {
+		client, err := as.GetClientByID(ctx, id)
+		if err != nil {
+			return model.TokenPolicy{}, err
+		}
+		policy = policy.Override(client.TokenPolicy)
+	}
+
+	return policy, nil
+}
+
+// resolveTokenLifetimes returns the token lifetimes of the pool and the client, falling back to the defaults
+func resolveTokenLifetimes(ctx context.Context, as store.AuthBaseStore, poolID, clientID string) (*tokenLifetimes, error) {
+	policy, err := mergedTokenPolicy(ctx, as, poolID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	policy = defaultTokenPolicy.Override(policy)
+
+	return &tokenLifetimes{
+		accessToken:  time.Duration(policy.AccessTokenTTL) * time.Second,
+		refreshToken: time.Duration(policy.RefreshTokenTTL) * time.Second,
+		session:      time.Duration(policy.SessionLifetime) * time.Second,
+		sessionIdle:  time.Duration(policy.SessionIdleTimeout) * time.Second,
+		accessKeyMax: time.Duration(policy.AccessKeyMaxTTL) * time.Second,
+	}, nil
+}
+
+// capExpiry returns the expiry bounded by the end, a zero end does not bound it
+func capExpiry(expireAt, end time.Time) time.Time {
+	if !end.IsZero() && expireAt.After(end) {
+		return end
+	}
+
+	return expireAt
+}
+
+// validateTokenPolicy rejects negative lifetimes and tokens living longer than the revocation list remembers them
+func validateTokenPolicy(policy *v1.TokenPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.GetAccessTokenTtl() < 0 || policy.GetRefreshTokenTtl() < 0 || policy.GetSessionLifetime() < 0 ||
+		policy.GetSessionIdleTimeout() < 0 || policy.GetAccessKeyMaxTtl() < 0 {
+		return status.Error(codes.InvalidArgument, "token policy values can not be negative")
+	}
+	if policy.GetAccessTokenTtl() > int64(x.MaxAccessTokenDuration/time.Second) {
+		return status.Errorf(codes.InvalidArgument, "access_token_ttl can not be more than %d seconds", int64(x.MaxAccessTokenDuration/time.Second))
+	}
+	if policy.GetRefreshTokenTtl() > int64(x.MaxRefreshTokenDuration/time.Second) {
+		return status.Errorf(codes.InvalidArgument, "refresh_token_ttl can not be more than %d seconds", int64(x.MaxRefreshTokenDuration/time.Second))
+	}
+
+	return nil
+}
+
+func tokenPolicyModel(policy *v1.TokenPolicy) model.TokenPolicy {
+	return model.TokenPolicy{
+		AccessTokenTTL:     policy.GetAccessTokenTtl(),
+		RefreshTokenTTL:    policy.GetRefreshTokenTtl(),
+		SessionLifetime:    policy.GetSessionLifetime(),
+		SessionIdleTimeout: policy.GetSessionIdleTimeout(),
+		AccessKeyMaxTTL:    policy.GetAccessKeyMaxTtl(),
+	}
+}
+
+func tokenPolicyProto(policy model.TokenPolicy) *v1.TokenPolicy {
+	return &v1.TokenPolicy{
+		AccessTokenTtl:     policy.AccessTokenTTL,
+		RefreshTokenTtl:    policy.RefreshTokenTTL,
+		SessionLifetime:    policy.SessionLifetime,
+		SessionIdleTimeout: policy.SessionIdleTimeout,
+		AccessKeyMaxTtl:    policy.AccessKeyMaxTTL,
+	}
+}
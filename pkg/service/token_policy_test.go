@@ -0,0 +1,48 @@
+package service
+
+import (
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestTokenPolicyOverride function to test the client policy overriding the pool and the project policy
+func TestTokenPolicyOverride(t *testing.T) {
+	project := model.TokenPolicy{AccessTokenTTL: 600, RefreshTokenTTL: 3600, SessionIdleTimeout: 1800}
+	pool := model.TokenPolicy{RefreshTokenTTL: 7200}
+	client := model.TokenPolicy{AccessTokenTTL: 60}
+
+	policy := defaultTokenPolicy.Override(project.Override(pool).Override(client))
+	assert.Equal(t, int64(60), policy.AccessTokenTTL)
+	assert.Equal(t, int64(7200), policy.RefreshTokenTTL)
+	assert.Equal(t, int64(1800), policy.SessionIdleTimeout)
+	assert.Equal(t, int64(0), policy.SessionLifetime)
+	assert.Equal(t, defaultTokenPolicy.AccessKeyMaxTTL, policy.AccessKeyMaxTTL)
+
+	// the tokens never outlive the session
+	now := time.Now()
+	assert.Equal(t, now.Add(time.Hour), capExpiry(now.Add(time.Hour), time.Time{}))
+	assert.Equal(t, now.Add(time.Minute), capExpiry(now.Add(time.Hour), now.Add(time.Minute)))
+
+	assert.NoError(t, validateTokenPolicy(&v1.TokenPolicy{AccessTokenTtl: 60, SessionLifetime: 86400}))
+	assert.Error(t, validateTokenPolicy(&v1.TokenPolicy{RefreshTokenTtl: -1}))
+	assert.Error(t, validateTokenPolicy(&v1.TokenPolicy{AccessTokenTtl: 7 * 24 * 3600}))
+}
+
+// TestSessionIdle function to test the idle timeout against the last refresh of a session
+func TestSessionIdle(t *testing.T) {
+	lifetimes := &tokenLifetimes{sessionIdle: time.Hour}
+	session := &model.Session{LastActiveAt: time.Now().Add(-30 * time.Minute)}
+	assert.False(t, sessionIdle(session, lifetimes))
+
+	session.LastActiveAt = time.Now().Add(-2 * time.Hour)
+	assert.True(t, sessionIdle(session, lifetimes))
+	assert.False(t, sessionIdle(session, &tokenLifetimes{}))
+
+	// sessions started before the activity was recorded use their creation time
+	session = &model.Session{}
+	session.CreatedAt = time.Now().Add(-2 * time.Hour)
+	assert.True(t, sessionIdle(session, lifetimes))
+}
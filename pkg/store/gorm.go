@@ -107,6 +107,15 @@ func (g *GormStore) GetGroup(ctx context.Context, id uuid.UUID) (*model.Group, e
 	return &group, err
 }
 
+func (g *GormStore) GetGroupByName(ctx context.Context, poolID uuid.UUID, name string) (*model.Group, error) {
+	var group model.Group
+	err := g.db.Where("pool_id = ? AND name = ?", poolID.String(), name).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGroupNotFound
+	}
+	return &group, err
+}
+
 func (g *GormStore) ListGroups(ctx context.Context, poolID uuid.UUID, page, perPage int) ([]*model.Group, int, error) {
 	var groups []*model.Group
 	var total int64
@@ -164,6 +173,21 @@ func (g *GormStore) RemoveGroupMember(ctx context.Context, groupID, accountID uu
 	return g.db.Delete(&member).Error
 }
 
+func (g *GormStore) AddGroupMemberClient(ctx context.Context, member *model.GroupMemberClient) error {
+	return g.db.Create(member).Error
+}
+
+func (g *GormStore) ListGroupMemberByClient(ctx context.Context, clientID uuid.UUID) ([]*model.GroupMemberClient, error) {
+	var groups []*model.GroupMemberClient
+	err := g.db.Where("client_id = ?", clientID.String()).Preload("Group.Roles").Find(&groups).Error
+	return groups, err
+}
+
+func (g *GormStore) RemoveGroupMemberClient(ctx context.Context, groupID, clientID uuid.UUID) error {
+	member := model.GroupMemberClient{GroupID: groupID.String(), ClientID: clientID.String()}
+	return g.db.Delete(&member).Error
+}
+
 func (g *GormStore) ListGroupMembers(ctx context.Context, groupID uuid.UUID, page, perPage int) ([]*model.GroupMemberAccount, int, error) {
 	var members []*model.GroupMemberAccount
 	var total int64
@@ -257,6 +281,24 @@ func (g *GormStore) GetAccountCount(ctx context.Context, projectID uuid.UUID) (u
 	return uint32(count), nil
 }
 
+func (g *GormStore) AddPasswordHistory(ctx context.Context, history *model.PasswordHistory, keep int) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(history).Error; err != nil {
+			return err
+		}
+
+		latest := tx.Model(&model.PasswordHistory{}).Select("id").
+			Where("account_id = ?", history.AccountID).Order("created_at desc").Limit(keep)
+		return tx.Unscoped().Where("account_id = ? AND id NOT IN (?)", history.AccountID, latest).Delete(&model.PasswordHistory{}).Error
+	})
+}
+
+func (g *GormStore) ListPasswordHistory(ctx context.Context, accountID uuid.UUID, limit int) ([]*model.PasswordHistory, error) {
+	var history []*model.PasswordHistory
+	err := g.db.Where("account_id = ?", accountID.String()).Order("created_at desc").Limit(limit).Find(&history).Error
+	return history, err
+}
+
 func (g *GormStore) GetMemberCount(ctx context.Context, projectID uuid.UUID) (uint32, error) {
 	var count int64
 	g.db.Model(&model.ProjectMember{}).Where("project_id = ?", projectID).Count(&count)
@@ -315,10 +357,43 @@ func (g *GormStore) DeleteClient(ctx context.Context, id uuid.UUID) error {
 	return g.db.WithContext(ctx).Unscoped().Delete(&model.Client{ID: id.String()}).Error
 }
 
+func (g *GormStore) CreateAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error {
+	return g.db.Create(code).Error
+}
+
+// ConsumeAuthorizationCode deletes the code while reading it, concurrent exchanges of the same code see no rows affected
+func (g *GormStore) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*model.AuthorizationCode, error) {
+	var code model.AuthorizationCode
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("code_hash = ?", codeHash).First(&code).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAuthorizationCodeNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		res := tx.Unscoped().Where("id = ?", code.ID).Delete(&model.AuthorizationCode{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAuthorizationCodeNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
 // DeleteSessionByAccountID expire and delete all sessions for a user which not deleted or expired already
 func (g *GormStore) DeleteSessionByAccountID(ctx context.Context, userID uuid.UUID) error {
 	return g.db.Model(&model.Session{}).
-		Where("account_id = ? AND expired_at > ?", userID, time.Now()).
+		Where("account_id = ? AND (expired_at IS NULL OR expired_at > ?)", userID, time.Now()).
 		Update("expired_at", time.Now()).
 		Error
 }
@@ -329,16 +404,54 @@ func (g *GormStore) ListActiveAccounts(ctx context.Context, poolID uuid.UUID, pa
 	return sessions, err
 }
 
-func (g *GormStore) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
+func (g *GormStore) ListActiveSessions(ctx context.Context, accountID uuid.UUID, page, perPage int) ([]*model.Session, int, error) {
+	var sessions []*model.Session
+	var total int64
+
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		active := "account_id = ? AND (expired_at IS NULL OR expired_at > ?)"
+		if err := tx.Model(&model.Session{}).Where(active, accountID.String(), time.Now()).Count(&total).Error; err != nil {
+			return err
+		}
+		return tx.Order("last_active_at DESC").Limit(perPage).Offset(page*perPage).Find(&sessions, active, accountID.String(), time.Now()).Error
+	})
+
+	return sessions, int(total), err
+}
+
+func (g *GormStore) ListAccountSessions(ctx context.Context, accountID uuid.UUID) ([]*model.Session, error) {
 	var sessions []*model.Session
-	err := g.db.Find(&sessions, "user_id = ? AND expired_at > ?)", userID, time.Now()).Error
+	err := g.db.Find(&sessions, "account_id = ? AND (expired_at IS NULL OR expired_at > ?)", accountID.String(), time.Now()).Error
 	return sessions, err
 }
 
+func (g *GormStore) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	sessions := model.Session{}.TableName()
+	refreshable := g.db.Model(&model.RefreshToken{}).Select("1").Where("family_id = "+sessions+".id AND expire_at >= ?", before)
+	res := g.db.Unscoped().
+		Where("(expired_at IS NOT NULL AND expired_at < ?) OR deleted_at < ? OR NOT EXISTS (?)", before, before, refreshable).
+		Delete(&model.Session{})
+	return res.RowsAffected, res.Error
+}
+
 func (g *GormStore) CreateSession(ctx context.Context, session *model.Session) error {
 	return g.db.Create(session).Error
 }
 
+func (g *GormStore) GetSession(ctx context.Context, id uuid.UUID) (*model.Session, error) {
+	var session model.Session
+	err := g.db.Where("id = ?", id.String()).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+
+	return &session, err
+}
+
+func (g *GormStore) UpdateSession(ctx context.Context, session *model.Session) error {
+	return g.db.Save(session).Error
+}
+
 func (g *GormStore) DeleteSession(ctx context.Context, id uuid.UUID) error {
 	session := model.Session{ID: id.String()}
 	return g.db.Delete(&session).Error
@@ -359,6 +472,44 @@ func (g *GormStore) DeleteVerificationCode(ctx context.Context, code string) err
 	return g.db.Delete(&model.VerificationCode{Code: code}).Error
 }
 
+func (g *GormStore) GetLoginCode(ctx context.Context, poolID uuid.UUID, email, codeHash string) (*model.VerificationCode, error) {
+	var vc model.VerificationCode
+	err := g.db.Where("pool_id = ? AND email = ? AND code = ? AND purpose = ?", poolID.String(), email, codeHash, model.VerificationPurposeLogin).First(&vc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVerificationCodeNotFound
+	}
+	return &vc, err
+}
+
+func (g *GormStore) DeleteLoginCodes(ctx context.Context, poolID uuid.UUID, email string) error {
+	return g.db.Unscoped().Where("pool_id = ? AND email = ? AND purpose = ?", poolID.String(), email, model.VerificationPurposeLogin).Delete(&model.VerificationCode{}).Error
+}
+
+func (g *GormStore) GetPurposeCode(ctx context.Context, purpose, codeHash string) (*model.VerificationCode, error) {
+	var vc model.VerificationCode
+	err := g.db.Where("code = ? AND purpose = ?", codeHash, purpose).First(&vc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVerificationCodeNotFound
+	}
+	return &vc, err
+}
+
+func (g *GormStore) DeletePurposeCodes(ctx context.Context, accountID uuid.UUID, purpose string) error {
+	return g.db.Unscoped().Where("account_id = ? AND purpose = ?", accountID.String(), purpose).Delete(&model.VerificationCode{}).Error
+}
+
+func (g *GormStore) UseVerificationCode(ctx context.Context, id uuid.UUID) error {
+	// the delete is the single use check, a concurrent use deletes nothing
+	res := g.db.Unscoped().Where("id = ?", id.String()).Delete(&model.VerificationCode{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrVerificationCodeNotFound
+	}
+	return nil
+}
+
 func (g *GormStore) CreateAccessKey(ctx context.Context, token *model.AccessKey) error {
 	return g.db.Create(token).Error
 }
@@ -393,6 +544,18 @@ func (g *GormStore) CreateAccount(ctx context.Context, user *model.Account) erro
 	return g.db.Create(user).Error
 }
 
+func (g *GormStore) CreateAccounts(ctx context.Context, users []*model.Account) error {
+	// the users are created one by one, a multi row insert can not mix the null and set times of the accounts
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range users {
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (g *GormStore) GetAccountByEmail(ctx context.Context, poolID uuid.UUID, email string) (*model.Account, error) {
 	var user model.Account
 	err := g.db.Find(&user, "pool_id = ? AND email = ?", poolID, email).Error
@@ -549,26 +712,30 @@ func (g *GormStore) DeleteProject(ctx context.Context, id uuid.UUID) error {
 	return g.db.Delete(&org).Error
 }
 
+// CreateKeypair creates a new keypair, the older keypairs of the pool are kept to verify the tokens they signed
 func (g *GormStore) CreateKeypair(ctx context.Context, keypair *model.Keypair) error {
-	// NOTE: we should only have one keypair per project, so we can safely delete all existing keypairs and create a new one
-	// this will cause all the existing tokens to be invalidated and the users will have to re-authenticate
-	err := g.Transaction(func(tx AuthBaseStore) error {
-		if err := tx.(*GormStore).db.Where("client_id = ?", keypair.ClientID).Delete(&model.Keypair{}).Error; err != nil {
-			return err
-		}
-
-		return tx.(*GormStore).db.Create(keypair).Error
-	})
-
-	return err
+	return g.db.Create(keypair).Error
 }
 
 func (g *GormStore) GetKeypair(ctx context.Context, id uuid.UUID) (*model.Keypair, error) {
 	var keypair model.Keypair
-	err := g.db.Where("id = ?", id).First(&keypair).Error
+	err := g.db.Where("id = ?", id.String()).First(&keypair).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrKeypairNotFound
+	}
 	return &keypair, err
 }
 
+func (g *GormStore) ListPoolKeypairs(ctx context.Context, poolID uuid.UUID) ([]*model.Keypair, error) {
+	var keypairs []*model.Keypair
+	err := g.db.Where("pool_id = ? AND retires_at > ?", poolID.String(), time.Now()).Order("created_at desc").Find(&keypairs).Error
+	return keypairs, err
+}
+
+func (g *GormStore) DeleteRetiredKeypairs(ctx context.Context) error {
+	return g.db.Unscoped().Where("retires_at <= ?", time.Now()).Delete(&model.Keypair{}).Error
+}
+
 func (g *GormStore) CreateProjectMember(ctx context.Context, permission *model.ProjectMember) error {
 	return g.db.Create(permission).Error
 }
@@ -609,21 +776,24 @@ func (g *GormStore) GetOauthProviderByID(ctx context.Context, id uuid.UUID) (*mo
 }
 
 // GetOauthProviderByName implements AuthBaseStore.
-func (g *GormStore) GetOauthProviderByName(ctx context.Context, orgID uuid.UUID, provider string) (*model.OauthProvider, error) {
+func (g *GormStore) GetOauthProviderByName(ctx context.Context, poolID uuid.UUID, provider string) (*model.OauthProvider, error) {
 	var oauthProvider model.OauthProvider
-	err := g.db.Where("project_id = ? AND provider = ?", orgID, provider).First(&oauthProvider).Error
+	err := g.db.Where("pool_id = ? AND provider = ?", poolID.String(), provider).First(&oauthProvider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOauthProviderNotFound
+	}
 	return &oauthProvider, err
 }
 
-func (g *GormStore) ListOauthProviders(ctx context.Context, orgID uuid.UUID, page, perPage int) ([]*model.OauthProvider, uint32, error) {
+func (g *GormStore) ListOauthProviders(ctx context.Context, poolID uuid.UUID, page, perPage int) ([]*model.OauthProvider, uint32, error) {
 	var providers []*model.OauthProvider
-	err := g.db.Limit(perPage).Offset(page*perPage).Find(&providers, "project_id = ?", orgID).Error
+	err := g.db.Limit(perPage).Offset(page*perPage).Find(&providers, "pool_id = ?", poolID.String()).Error
 	if err != nil {
 		return providers, 0, err
 	}
 
 	var total int64
-	if err := g.db.Model(&model.OauthProvider{}).Where("project_id = ?", orgID).Count(&total).Error; err != nil {
+	if err := g.db.Model(&model.OauthProvider{}).Where("pool_id = ?", poolID.String()).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -639,6 +809,160 @@ func (g *GormStore) DeleteOauthProvider(ctx context.Context, id uuid.UUID) error
 	return g.db.Delete(&provider).Error
 }
 
+func (g *GormStore) CreateIdentity(ctx context.Context, identity *model.Identity) error {
+	return g.db.Create(identity).Error
+}
+
+func (g *GormStore) GetIdentity(ctx context.Context, poolID uuid.UUID, provider, subject string) (*model.Identity, error) {
+	var identity model.Identity
+	err := g.db.Where("pool_id = ? AND provider = ? AND subject = ?", poolID.String(), provider, subject).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrIdentityNotFound
+	}
+	return &identity, err
+}
+
+func (g *GormStore) ListAccountIdentities(ctx context.Context, accountID uuid.UUID) ([]*model.Identity, error) {
+	var identities []*model.Identity
+	err := g.db.Where("account_id = ?", accountID.String()).Find(&identities).Error
+	return identities, err
+}
+
+func (g *GormStore) SaveSamlProvider(ctx context.Context, provider *model.SamlProvider) error {
+	return g.db.Save(provider).Error
+}
+
+func (g *GormStore) GetSamlProvider(ctx context.Context, poolID uuid.UUID) (*model.SamlProvider, error) {
+	var provider model.SamlProvider
+	err := g.db.Where("pool_id = ?", poolID.String()).First(&provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSamlProviderNotFound
+	}
+	return &provider, err
+}
+
+func (g *GormStore) DeleteSamlProvider(ctx context.Context, poolID uuid.UUID) error {
+	return g.db.Unscoped().Where("pool_id = ?", poolID.String()).Delete(&model.SamlProvider{}).Error
+}
+
+func (g *GormStore) SaveEmailTemplate(ctx context.Context, template *model.EmailTemplate) error {
+	var current model.EmailTemplate
+	err := g.db.Where("project_id = ? AND kind = ? AND locale = ?", template.ProjectID, template.Kind, template.Locale).First(&current).Error
+	if err == nil {
+		template.ID = current.ID
+		template.CreatedAt = current.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return g.db.Save(template).Error
+}
+
+func (g *GormStore) ListEmailTemplates(ctx context.Context, projectID uuid.UUID, kind string) ([]*model.EmailTemplate, error) {
+	var templates []*model.EmailTemplate
+	query := g.db.Where("project_id = ?", projectID.String())
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	err := query.Order("kind, locale").Find(&templates).Error
+	return templates, err
+}
+
+func (g *GormStore) DeleteEmailTemplates(ctx context.Context, projectID uuid.UUID, kind, locale string) error {
+	query := g.db.Unscoped().Where("project_id = ? AND kind = ?", projectID.String(), kind)
+	if locale != "" {
+		query = query.Where("locale = ?", locale)
+	}
+	res := query.Delete(&model.EmailTemplate{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrEmailTemplateNotFound
+	}
+
+	return nil
+}
+
+func (g *GormStore) SaveTotpFactor(ctx context.Context, factor *model.TotpFactor) error {
+	return g.db.Save(factor).Error
+}
+
+func (g *GormStore) GetTotpFactor(ctx context.Context, accountID uuid.UUID) (*model.TotpFactor, error) {
+	var factor model.TotpFactor
+	err := g.db.Where("account_id = ?", accountID.String()).First(&factor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTotpFactorNotFound
+	}
+	return &factor, err
+}
+
+func (g *GormStore) DeleteTotpFactor(ctx context.Context, accountID uuid.UUID) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("account_id = ?", accountID.String()).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("account_id = ?", accountID.String()).Delete(&model.TotpFactor{}).Error
+	})
+}
+
+func (g *GormStore) ReplaceRecoveryCodes(ctx context.Context, accountID uuid.UUID, codes []*model.RecoveryCode) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("account_id = ?", accountID.String()).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+func (g *GormStore) UseRecoveryCode(ctx context.Context, accountID uuid.UUID, codeHash string) error {
+	// the used_at check makes concurrent uses of the same code fail
+	res := g.db.Model(&model.RecoveryCode{}).
+		Where("account_id = ? AND code_hash = ? AND used_at IS NULL", accountID.String(), codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+func (g *GormStore) CreatePasskey(ctx context.Context, passkey *model.Passkey) error {
+	return g.db.Create(passkey).Error
+}
+
+func (g *GormStore) GetPasskeyByCredentialID(ctx context.Context, poolID uuid.UUID, credentialID string) (*model.Passkey, error) {
+	var passkey model.Passkey
+	err := g.db.Where("pool_id = ? AND credential_id = ?", poolID.String(), credentialID).First(&passkey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPasskeyNotFound
+	}
+	return &passkey, err
+}
+
+func (g *GormStore) ListPasskeys(ctx context.Context, accountID uuid.UUID) ([]*model.Passkey, error) {
+	var passkeys []*model.Passkey
+	err := g.db.Where("account_id = ?", accountID.String()).Order("created_at").Find(&passkeys).Error
+	return passkeys, err
+}
+
+func (g *GormStore) UpdatePasskey(ctx context.Context, passkey *model.Passkey) error {
+	return g.db.Save(passkey).Error
+}
+
+func (g *GormStore) DeletePasskey(ctx context.Context, accountID, id uuid.UUID) error {
+	res := g.db.Unscoped().Where("account_id = ? AND id = ?", accountID.String(), id.String()).Delete(&model.Passkey{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
 func (g *GormStore) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
 	return g.db.Create(token).Error
 }
@@ -646,6 +970,9 @@ func (g *GormStore) CreateRefreshToken(ctx context.Context, token *model.Refresh
 func (g *GormStore) GetRefreshTokenByID(ctx context.Context, refreshToken string) (*model.RefreshToken, error) {
 	var token model.RefreshToken
 	err := g.db.Where("token = ?", refreshToken).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRefreshTokenNotFound
+	}
 	return &token, err
 }
 
@@ -663,6 +990,29 @@ func (g *GormStore) DeleteRefreshToken(ctx context.Context, token string) error
 	return g.db.Delete(&model.RefreshToken{Token: token}).Error
 }
 
+func (g *GormStore) ConsumeRefreshToken(ctx context.Context, token string) error {
+	// the consumed_at check makes concurrent refreshes with the same token fail
+	res := g.db.Model(&model.RefreshToken{}).
+		Where("token = ? AND consumed_at IS NULL", token).
+		Update("consumed_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRefreshTokenConsumed
+	}
+	return nil
+}
+
+func (g *GormStore) DeleteRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return g.db.Unscoped().Where("family_id = ?", familyID).Delete(&model.RefreshToken{}).Error
+}
+
+func (g *GormStore) DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	res := g.db.Unscoped().Where("expire_at < ?", before).Delete(&model.RefreshToken{})
+	return res.RowsAffected, res.Error
+}
+
 func (g *GormStore) Migrate() error {
 	return model.Migrate(g.db)
 }
@@ -5,16 +5,31 @@ import (
 	"errors"
 	"github.com/emrgen/authbase/pkg/model"
 	"github.com/google/uuid"
+	"time"
 )
 
 var (
-	ErrProjectExists           = errors.New("project already exists")
-	ErrMasterProjectNotFound   = errors.New("master project not found")
-	ErrProjectNotFound         = errors.New("project not found")
-	ErrPermissionNotFound      = errors.New("permission not found")
-	ErrPermissionAlreadyExists = errors.New("permission already exists")
-	ErrRoleNotFound            = errors.New("role not found")
-	ErrClientNotFound          = errors.New("client not found")
+	ErrProjectExists             = errors.New("project already exists")
+	ErrMasterProjectNotFound     = errors.New("master project not found")
+	ErrProjectNotFound           = errors.New("project not found")
+	ErrPermissionNotFound        = errors.New("permission not found")
+	ErrPermissionAlreadyExists   = errors.New("permission already exists")
+	ErrRoleNotFound              = errors.New("role not found")
+	ErrClientNotFound            = errors.New("client not found")
+	ErrKeypairNotFound           = errors.New("keypair not found")
+	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrSessionNotFound           = errors.New("session not found")
+	ErrRefreshTokenNotFound      = errors.New("refresh token not found")
+	ErrRefreshTokenConsumed      = errors.New("refresh token already used")
+	ErrOauthProviderNotFound     = errors.New("oauth provider not found")
+	ErrIdentityNotFound          = errors.New("identity not found")
+	ErrGroupNotFound             = errors.New("group not found")
+	ErrSamlProviderNotFound      = errors.New("saml provider not found")
+	ErrTotpFactorNotFound        = errors.New("totp factor not found")
+	ErrRecoveryCodeNotFound      = errors.New("recovery code not found")
+	ErrPasskeyNotFound           = errors.New("passkey not found")
+	ErrVerificationCodeNotFound  = errors.New("verification code not found")
+	ErrEmailTemplateNotFound     = errors.New("email template not found")
 )
 
 // AuthBaseStore is the interface for interacting with the database.
@@ -22,12 +37,19 @@ type AuthBaseStore interface {
 	AccountStore
 	SessionStore
 	ProjectStore
+	KeypairStore
 	ProjectMemberStore
 	ProviderStore
+	IdentityStore
+	SamlProviderStore
+	EmailTemplateStore
+	MfaStore
+	PasskeyStore
 	RefreshTokenStore
 	AccessKeyStore
 	VerificationCodeStore
 	ClientStore
+	AuthorizationCodeStore
 	PoolStore
 	PoolMemberStore
 	GroupStore
@@ -53,16 +75,26 @@ type ProjectStore interface {
 	UpdateProject(ctx context.Context, org *model.Project) error
 	// DeleteProject deletes an project from the database.
 	DeleteProject(ctx context.Context, id uuid.UUID) error
+}
+
+// KeypairStore is the interface for interacting with the pool signing keys.
+type KeypairStore interface {
 	// CreateKeypair creates a new keypair in the database.
 	CreateKeypair(ctx context.Context, keypair *model.Keypair) error
 	// GetKeypair retrieves a keypair by its ID.
 	GetKeypair(ctx context.Context, id uuid.UUID) (*model.Keypair, error)
+	// ListPoolKeypairs retrieves the unretired keypairs of a pool, newest first.
+	ListPoolKeypairs(ctx context.Context, poolID uuid.UUID) ([]*model.Keypair, error)
+	// DeleteRetiredKeypairs deletes the keypairs that no longer verify tokens.
+	DeleteRetiredKeypairs(ctx context.Context) error
 }
 
 // AccountStore is the interface for interacting with the user database.
 type AccountStore interface {
 	// CreateAccount creates a new user in the database.
 	CreateAccount(ctx context.Context, user *model.Account) error
+	// CreateAccounts creates the users in one transaction.
+	CreateAccounts(ctx context.Context, users []*model.Account) error
 	// GetAccountByEmail retrieves a user by their email address.
 	GetAccountByEmail(ctx context.Context, poolID uuid.UUID, email string) (*model.Account, error)
 	// GetAccountByID retrieves a user by their ID.
@@ -85,20 +117,33 @@ type AccountStore interface {
 	AccountExists(ctx context.Context, projectID uuid.UUID, username, email string) ([]*model.Account, error)
 	// GetAccountCount retrieves the number of users in a project.
 	GetAccountCount(ctx context.Context, projectID uuid.UUID) (uint32, error)
+	// AddPasswordHistory records a replaced password of an account, only the latest keep passwords are kept.
+	AddPasswordHistory(ctx context.Context, history *model.PasswordHistory, keep int) error
+	// ListPasswordHistory retrieves the latest replaced passwords of an account, newest first.
+	ListPasswordHistory(ctx context.Context, accountID uuid.UUID, limit int) ([]*model.PasswordHistory, error)
 }
 
 // SessionStore is the interface for interacting with the session database.
 type SessionStore interface {
 	// CreateSession creates a new session in the database.
 	CreateSession(ctx context.Context, session *model.Session) error
+	// GetSession retrieves a session by ID, deleted sessions are not found.
+	GetSession(ctx context.Context, sessionID uuid.UUID) (*model.Session, error)
 	// ListActiveAccounts retrieves a list of sessions.
 	ListActiveAccounts(ctx context.Context, poolID uuid.UUID, page, perPage int) ([]*model.Session, error)
-	// ListActiveSessions retrieves a list of active sessions.
-	ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error)
+	// ListActiveSessions retrieves a page of the active sessions of an account, the recently used first.
+	ListActiveSessions(ctx context.Context, accountID uuid.UUID, page, perPage int) ([]*model.Session, int, error)
+	// ListAccountSessions retrieves all the active sessions of an account.
+	ListAccountSessions(ctx context.Context, accountID uuid.UUID) ([]*model.Session, error)
+	// UpdateSession updates a session in the database.
+	UpdateSession(ctx context.Context, session *model.Session) error
 	// DeleteSession deletes a session from the database.
 	DeleteSession(ctx context.Context, sessionID uuid.UUID) error
 	// DeleteSessionByAccountID deletes a session from the database by user ID.
 	DeleteSessionByAccountID(ctx context.Context, userID uuid.UUID) error
+	// DeleteExpiredSessions removes the sessions expired or deleted before the time and the sessions without
+	// an unexpired refresh token, returns the removed count.
+	DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error)
 }
 
 // ProjectMemberStore is the interface for interacting with the permission database.
@@ -125,21 +170,80 @@ type ProviderStore interface {
 	CreateOauthProvider(ctx context.Context, provider *model.OauthProvider) error
 	// GetOauthProviderByID retrieves a provider by its ID.
 	GetOauthProviderByID(ctx context.Context, id uuid.UUID) (*model.OauthProvider, error)
-	// GetOauthProviderByName retrieves a provider by its name.
-	GetOauthProviderByName(ctx context.Context, orgID uuid.UUID, provider string) (*model.OauthProvider, error)
-	// ListOauthProviders retrieves a list of providers.
-	ListOauthProviders(ctx context.Context, orgID uuid.UUID, page, perPage int) ([]*model.OauthProvider, uint32, error)
+	// GetOauthProviderByName retrieves a provider of the pool by its name, returns ErrOauthProviderNotFound if missing.
+	GetOauthProviderByName(ctx context.Context, poolID uuid.UUID, provider string) (*model.OauthProvider, error)
+	// ListOauthProviders retrieves a list of the pool providers.
+	ListOauthProviders(ctx context.Context, poolID uuid.UUID, page, perPage int) ([]*model.OauthProvider, uint32, error)
 	// UpdateOauthProvider updates a provider in the database.
 	UpdateOauthProvider(ctx context.Context, provider *model.OauthProvider) error
 	// DeleteOauthProvider deletes a provider from the database.
 	DeleteOauthProvider(ctx context.Context, id uuid.UUID) error
 }
 
+// SamlProviderStore is the interface for interacting with the pool SAML providers database.
+type SamlProviderStore interface {
+	// SaveSamlProvider creates or replaces the SAML provider of the pool.
+	SaveSamlProvider(ctx context.Context, provider *model.SamlProvider) error
+	// GetSamlProvider retrieves the SAML provider of the pool, returns ErrSamlProviderNotFound if missing.
+	GetSamlProvider(ctx context.Context, poolID uuid.UUID) (*model.SamlProvider, error)
+	// DeleteSamlProvider deletes the SAML provider of the pool.
+	DeleteSamlProvider(ctx context.Context, poolID uuid.UUID) error
+}
+
+// EmailTemplateStore is the interface for interacting with the project email templates database.
+type EmailTemplateStore interface {
+	// SaveEmailTemplate creates or replaces the template of the project kind and locale.
+	SaveEmailTemplate(ctx context.Context, template *model.EmailTemplate) error
+	// ListEmailTemplates retrieves the templates of the project, the templates of all the kinds when kind is empty.
+	ListEmailTemplates(ctx context.Context, projectID uuid.UUID, kind string) ([]*model.EmailTemplate, error)
+	// DeleteEmailTemplates deletes the templates of the project kind, all the locales when locale is empty.
+	// Returns ErrEmailTemplateNotFound when there is no template to delete.
+	DeleteEmailTemplates(ctx context.Context, projectID uuid.UUID, kind, locale string) error
+}
+
+// MfaStore is the interface for interacting with the account second factors database.
+type MfaStore interface {
+	// SaveTotpFactor creates or updates the TOTP factor of an account.
+	SaveTotpFactor(ctx context.Context, factor *model.TotpFactor) error
+	// GetTotpFactor retrieves the TOTP factor of an account, returns ErrTotpFactorNotFound if missing.
+	GetTotpFactor(ctx context.Context, accountID uuid.UUID) (*model.TotpFactor, error)
+	// DeleteTotpFactor deletes the TOTP factor and the recovery codes of an account.
+	DeleteTotpFactor(ctx context.Context, accountID uuid.UUID) error
+	// ReplaceRecoveryCodes replaces the recovery codes of an account.
+	ReplaceRecoveryCodes(ctx context.Context, accountID uuid.UUID, codes []*model.RecoveryCode) error
+	// UseRecoveryCode marks the unused recovery code as used, returns ErrRecoveryCodeNotFound if there is none.
+	UseRecoveryCode(ctx context.Context, accountID uuid.UUID, codeHash string) error
+}
+
+// PasskeyStore is the interface for interacting with the account passkeys database.
+type PasskeyStore interface {
+	// CreatePasskey stores a registered passkey.
+	CreatePasskey(ctx context.Context, passkey *model.Passkey) error
+	// GetPasskeyByCredentialID retrieves a passkey of the pool by its credential id, returns ErrPasskeyNotFound if missing.
+	GetPasskeyByCredentialID(ctx context.Context, poolID uuid.UUID, credentialID string) (*model.Passkey, error)
+	// ListPasskeys lists the passkeys of an account.
+	ListPasskeys(ctx context.Context, accountID uuid.UUID) ([]*model.Passkey, error)
+	// UpdatePasskey updates a passkey after a login.
+	UpdatePasskey(ctx context.Context, passkey *model.Passkey) error
+	// DeletePasskey deletes a passkey of an account, returns ErrPasskeyNotFound if missing.
+	DeletePasskey(ctx context.Context, accountID, id uuid.UUID) error
+}
+
+// IdentityStore is the interface for interacting with the linked identities database.
+type IdentityStore interface {
+	// CreateIdentity links an identity to an account.
+	CreateIdentity(ctx context.Context, identity *model.Identity) error
+	// GetIdentity retrieves the identity of a provider user in the pool, returns ErrIdentityNotFound if not linked.
+	GetIdentity(ctx context.Context, poolID uuid.UUID, provider, subject string) (*model.Identity, error)
+	// ListAccountIdentities retrieves the identities linked to an account.
+	ListAccountIdentities(ctx context.Context, accountID uuid.UUID) ([]*model.Identity, error)
+}
+
 // RefreshTokenStore is the interface for interacting with the refresh token database.
 type RefreshTokenStore interface {
 	// CreateRefreshToken creates a new refresh token in the database.
 	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
-	// GetRefreshTokenByID retrieves a
+	// GetRefreshTokenByID retrieves a refresh token by the token string, returns ErrRefreshTokenNotFound if it was deleted
 	GetRefreshTokenByID(ctx context.Context, token string) (*model.RefreshToken, error)
 	// ListRefreshTokens retrieves a list of refresh tokens.
 	ListRefreshTokens(ctx context.Context, page, perPage int) ([]*model.RefreshToken, error)
@@ -147,6 +251,12 @@ type RefreshTokenStore interface {
 	UpdateRefreshToken(ctx context.Context, token *model.RefreshToken) error
 	// DeleteRefreshToken deletes a refresh token from the database.
 	DeleteRefreshToken(ctx context.Context, token string) error
+	// ConsumeRefreshToken marks the unused refresh token as used, returns ErrRefreshTokenConsumed if it was already used.
+	ConsumeRefreshToken(ctx context.Context, token string) error
+	// DeleteRefreshTokenFamily deletes the refresh tokens rotated from the same login.
+	DeleteRefreshTokenFamily(ctx context.Context, familyID string) error
+	// DeleteExpiredRefreshTokens removes the refresh tokens expired before the time, returns the removed count.
+	DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error)
 }
 
 // AccessKeyStore is the interface for interacting with the token database.
@@ -169,6 +279,16 @@ type VerificationCodeStore interface {
 	GetVerificationCode(ctx context.Context, code string) (*model.VerificationCode, error)
 	// DeleteVerificationCode deletes the code
 	DeleteVerificationCode(ctx context.Context, code string) error
+	// GetLoginCode retrieves the login code of the email in the pool by its hash, returns ErrVerificationCodeNotFound if missing.
+	GetLoginCode(ctx context.Context, poolID uuid.UUID, email, codeHash string) (*model.VerificationCode, error)
+	// DeleteLoginCodes deletes the pending login codes of the email in the pool.
+	DeleteLoginCodes(ctx context.Context, poolID uuid.UUID, email string) error
+	// GetPurposeCode retrieves a code of the purpose by its hash, returns ErrVerificationCodeNotFound if missing.
+	GetPurposeCode(ctx context.Context, purpose, codeHash string) (*model.VerificationCode, error)
+	// DeletePurposeCodes deletes the pending codes of the purpose of the account.
+	DeletePurposeCodes(ctx context.Context, accountID uuid.UUID, purpose string) error
+	// UseVerificationCode deletes the code by id, returns ErrVerificationCodeNotFound if it was already used.
+	UseVerificationCode(ctx context.Context, id uuid.UUID) error
 }
 
 // ClientStore is the interface for interacting with the client database.
@@ -185,6 +305,14 @@ type ClientStore interface {
 	DeleteClient(ctx context.Context, id uuid.UUID) error
 }
 
+// AuthorizationCodeStore is the interface for interacting with the OAuth2 authorization codes.
+type AuthorizationCodeStore interface {
+	// CreateAuthorizationCode creates a new authorization code in the database.
+	CreateAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error
+	// ConsumeAuthorizationCode retrieves and deletes an authorization code by its hash, a code can be consumed only once.
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*model.AuthorizationCode, error)
+}
+
 type PoolStore interface {
 	// CreatePool creates a new pool in the database.
 	CreatePool(ctx context.Context, pool *model.Pool) error
@@ -218,6 +346,8 @@ type GroupStore interface {
 	CreateGroup(ctx context.Context, group *model.Group) error
 	// GetGroup retrieves a group by its ID.
 	GetGroup(ctx context.Context, id uuid.UUID) (*model.Group, error)
+	// GetGroupByName retrieves a group of the pool by its name, returns ErrGroupNotFound if missing.
+	GetGroupByName(ctx context.Context, poolID uuid.UUID, name string) (*model.Group, error)
 	// ListGroups retrieves a list of groups.
 	ListGroups(ctx context.Context, projectID uuid.UUID, page, perPage int) ([]*model.Group, int, error)
 	// UpdateGroup updates a group in the database.
@@ -234,6 +364,12 @@ type GroupStore interface {
 	ListGroupMemberByAccessKey(ctx context.Context, accountID uuid.UUID) ([]*model.GroupMemberAccessKey, error)
 	// RemoveGroupMember deletes a group member from the database.
 	RemoveGroupMember(ctx context.Context, groupID, accountID uuid.UUID) error
+	// AddGroupMemberClient adds a client to a group.
+	AddGroupMemberClient(ctx context.Context, member *model.GroupMemberClient) error
+	// ListGroupMemberByClient retrieves the group memberships of a client.
+	ListGroupMemberByClient(ctx context.Context, clientID uuid.UUID) ([]*model.GroupMemberClient, error)
+	// RemoveGroupMemberClient removes a client from a group.
+	RemoveGroupMemberClient(ctx context.Context, groupID, clientID uuid.UUID) error
 	// ListGroupMembers retrieves a list of group members.
 	ListGroupMembers(ctx context.Context, groupID uuid.UUID, page, perPage int) ([]*model.GroupMemberAccount, int, error)
 }
@@ -6,6 +6,7 @@ import (
 	v1 "github.com/emrgen/authbase/apis/v1"
 	"github.com/emrgen/authbase/pkg/store"
 	"github.com/google/uuid"
+	grpcmiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -20,7 +21,7 @@ var (
 
 // AuthInterceptor authenticates the request using the provided verifier.
 // on success, it sets the accountID and projectID and account permission in the context.
-func AuthInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvider, provider store.Provider) grpc.UnaryServerInterceptor {
+func AuthInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvider, revocations RevocationList, provider store.Provider) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		logrus.Debugf("authbase: interceptor method: %s", info.FullMethod)
 		switch info.FullMethod {
@@ -28,8 +29,27 @@ func AuthInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvid
 			v1.AdminAuthService_AdminLoginUsingPassword_FullMethodName,
 			v1.AuthService_LoginUsingPassword_FullMethodName,
 			v1.AuthService_Refresh_FullMethodName,
+			v1.AuthService_LoginUsingIdp_FullMethodName,
+			v1.AuthService_LoginUsingIdpCallback_FullMethodName,
+			v1.AuthService_RequestLoginCode_FullMethodName,
+			v1.AuthService_LoginUsingCode_FullMethodName,
+			v1.AuthService_VerifyEmail_FullMethodName,
+			v1.AuthService_ResendVerificationEmail_FullMethodName,
+			v1.AuthService_ForgotPassword_FullMethodName,
+			v1.AuthService_ResetPassword_FullMethodName,
+			v1.AuthService_VerifyMfa_FullMethodName,
+			v1.AuthService_LoginUsingSaml_FullMethodName,
+			v1.AuthService_LoginUsingSamlCallback_FullMethodName,
+			v1.AuthService_BeginPasskeyLogin_FullMethodName,
+			v1.AuthService_FinishPasskeyLogin_FullMethodName,
+			v1.SamlService_GetSamlMetadata_FullMethodName,
 			v1.AccessKeyService_GetTokenFromAccessKey_FullMethodName,
-			v1.TokenService_VerifyToken_FullMethodName:
+			v1.TokenService_VerifyToken_FullMethodName,
+			v1.PublicKeyService_GetPublicKey_FullMethodName,
+			v1.OAuth2Service_OAuth2Authorize_FullMethodName,
+			v1.OAuth2Service_OAuth2Token_FullMethodName,
+			v1.OAuth2Service_OAuth2Introspect_FullMethodName,
+			v1.OAuth2Service_OAuth2Revoke_FullMethodName:
 			break
 		case v1.AccessKeyService_CreateAccessKey_FullMethodName:
 			logrus.Infof("authbase: interceptor create access key")
@@ -51,8 +71,8 @@ func AuthInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvid
 						return nil, err
 					}
 				} else {
-					ctx, _, err = VerifyJwtToken(ctx, keyProvider, token)
-					if err == nil {
+					ctx, _, err = VerifyJwtToken(ctx, keyProvider, revocations, token)
+					if err != nil {
 						return nil, err
 					}
 				}
@@ -104,26 +124,18 @@ func AuthInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvid
 				}
 			}
 
-			// TODO: if http cookie is present use that
-			// user Bearer token for authentication
-			token, err := TokenFromHeader(ctx, "Bearer")
-
-			accessKey, err := ParseAccessKey(token)
-			if !errors.Is(err, ErrInvalidToken) && err != nil {
-				logrus.Errorf("authbase: interceptor error parsing access key: %v", err)
-				return nil, err
+			// accounts enrolling a second factor to finish their login present the mfa token instead of an access token
+			if info.FullMethod == v1.AuthService_EnrollTotp_FullMethodName || info.FullMethod == v1.AuthService_ConfirmTotp_FullMethodName {
+				request, ok := req.(interface{ GetMfaToken() string })
+				if ok && request.GetMfaToken() != "" {
+					return handler(ctx, req)
+				}
 			}
 
-			if accessKey != nil {
-				ctx, _, err = VerifyAccessKey(ctx, verifier, accessKey)
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				ctx, _, err = VerifyJwtToken(ctx, keyProvider, token)
-				if err != nil {
-					return nil, err
-				}
+			var err error
+			ctx, err = authenticate(ctx, verifier, keyProvider, revocations)
+			if err != nil {
+				return nil, err
 			}
 		}
 
@@ -131,15 +143,63 @@ func AuthInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvid
 	}
 }
 
+// AuthStreamInterceptor authenticates the streaming requests with the bearer token or access key,
+// the stream handlers read the account and project from the stream context.
+func AuthStreamInterceptor(verifier TokenVerifier, keyProvider JWTSignerVerifierProvider, revocations RevocationList) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		logrus.Debugf("authbase: stream interceptor method: %s", info.FullMethod)
+		ctx, err := authenticate(ss.Context(), verifier, keyProvider, revocations)
+		if err != nil {
+			return err
+		}
+
+		stream := grpcmiddleware.WrapServerStream(ss)
+		stream.WrappedContext = ctx
+		return handler(srv, stream)
+	}
+}
+
+// authenticate verifies the bearer token of the request, an access key or a jwt
+func authenticate(ctx context.Context, verifier TokenVerifier, keyProvider JWTSignerVerifierProvider, revocations RevocationList) (context.Context, error) {
+	// TODO: if http cookie is present use that
+	// user Bearer token for authentication
+	token, err := TokenFromHeader(ctx, "Bearer")
+
+	accessKey, err := ParseAccessKey(token)
+	if !errors.Is(err, ErrInvalidToken) && err != nil {
+		logrus.Errorf("authbase: interceptor error parsing access key: %v", err)
+		return nil, err
+	}
+
+	if accessKey != nil {
+		ctx, _, err = VerifyAccessKey(ctx, verifier, accessKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ctx, _, err = VerifyJwtToken(ctx, keyProvider, revocations, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ctx, nil
+}
+
 func verifyPassword(ctx context.Context, verifier TokenVerifier, poolID uuid.UUID, email, password string) (context.Context, *Claims, error) {
 	user, err := verifier.VerifyEmailPassword(ctx, poolID, email, password)
+	// the lockout errors keep their codes, the client backs off on ResourceExhausted
+	if _, ok := status.FromError(err); ok && err != nil {
+		return ctx, nil, err
+	}
 	if err != nil {
-		return ctx, nil, status.Error(codes.PermissionDenied, "old password is invalid")
+		return ctx, nil, status.Error(codes.PermissionDenied, "invalid email or password")
 	}
 
 	ctx = context.WithValue(ctx, AccountIDKey, uuid.MustParse(user.ID))
 	ctx = context.WithValue(ctx, ProjectIDKey, uuid.MustParse(user.ProjectID))
 	ctx = context.WithValue(ctx, PoolIDKey, poolID)
+	ctx = context.WithValue(ctx, PasswordAuthKey, true)
 
 	return ctx, nil, nil
 }
@@ -160,7 +220,8 @@ func VerifyAccessKey(ctx context.Context, verifier TokenVerifier, accessKey *Acc
 	return ctx, nil, nil
 }
 
-func VerifyJwtToken(ctx context.Context, keyProvider JWTSignerVerifierProvider, token string) (context.Context, *Claims, error) {
+// VerifyJwtToken verifies the signature of the token and checks that it was not revoked
+func VerifyJwtToken(ctx context.Context, keyProvider JWTSignerVerifierProvider, revocations RevocationList, token string) (context.Context, *Claims, error) {
 	claims, err := GetTokenClaims(token)
 	if err != nil {
 		return ctx, nil, err
@@ -175,19 +236,24 @@ func VerifyJwtToken(ctx context.Context, keyProvider JWTSignerVerifierProvider,
 	if err != nil {
 		return ctx, nil, err
 	}
+	if err := CheckAccessTokenUse(claims); err != nil {
+		return ctx, nil, err
+	}
 
-	poolID, err := uuid.Parse(claims.PoolID)
+	revoked, err := revocations.Revoked(claims)
 	if err != nil {
-		logrus.Errorf("authbase: parse pool id failed: %v", err)
 		return ctx, nil, err
 	}
+	if revoked {
+		return ctx, nil, status.Error(codes.Unauthenticated, "token was revoked")
+	}
 
-	ctx = context.WithValue(ctx, AccountIDKey, uuid.MustParse(claims.AccountID))
-	ctx = context.WithValue(ctx, ProjectIDKey, uuid.MustParse(claims.ProjectID))
-	ctx = context.WithValue(ctx, PoolIDKey, poolID)
-	ctx = context.WithValue(ctx, ScopesKey, claims.Scopes)
+	if _, err := uuid.Parse(claims.PoolID); err != nil {
+		logrus.Errorf("authbase: parse pool id failed: %v", err)
+		return ctx, nil, err
+	}
 
-	return ctx, claims, nil
+	return WithClaims(ctx, claims), claims, nil
 }
 
 func TokenFromHeader(ctx context.Context, expectedScheme string) (string, error) {
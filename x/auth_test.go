@@ -0,0 +1,54 @@
+package x
+
+import (
+	"context"
+	v1 "github.com/emrgen/authbase/apis/v1"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// noRevocations is a revocation list that revoked nothing
+type noRevocations struct{}
+
+func (noRevocations) RevokeToken(jti string, expireAt time.Time) error { return nil }
+
+func (noRevocations) RevokeSession(sessionID string) error { return nil }
+
+func (noRevocations) RevokeAccount(accountID string) error { return nil }
+
+func (noRevocations) Revoked(claims *Claims) (bool, error) { return false, nil }
+
+// TestAuthInterceptorRefreshToken function to test the refresh tokens do not authenticate the protected methods
+func TestAuthInterceptorRefreshToken(t *testing.T) {
+	keys := NewStaticKeyProvider("secret")
+	signer, err := keys.GetSigner("")
+	assert.NoError(t, err)
+	token, err := GenerateJWTToken(&Claims{
+		Jti:       uuid.New().String(),
+		SessionID: uuid.New().String(),
+		AccountID: uuid.New().String(),
+		ProjectID: uuid.New().String(),
+		PoolID:    uuid.New().String(),
+		ExpireAt:  time.Now().Add(time.Minute),
+		IssuedAt:  time.Now(),
+	}, signer, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	interceptor := AuthInterceptor(nil, keys, noRevocations{}, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: v1.AccountService_GetCurrentAccount_FullMethodName}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(bearer string) error {
+		ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer "+bearer))
+		_, err := interceptor(ctx, &v1.GetCurrentAccountRequest{}, info, handler)
+		return err
+	}
+
+	assert.NoError(t, call(token.AccessToken))
+	assert.Equal(t, codes.Unauthenticated, status.Code(call(token.RefreshToken)))
+}
@@ -0,0 +1,65 @@
+package x
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrMissingAppKey is returned when secrets are sealed without an application key
+var ErrMissingAppKey = errors.New("APP_KEY is not set")
+
+// SealSecret encrypts the secret with AES-GCM under a key derived from the application key
+func SealSecret(appKey, secret string) (string, error) {
+	aead, err := secretAEAD(appKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenSecret decrypts a secret sealed with SealSecret
+func OpenSecret(appKey, sealed string) (string, error) {
+	aead, err := secretAEAD(appKey)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed secret is too short")
+	}
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+func secretAEAD(appKey string) (cipher.AEAD, error) {
+	if appKey == "" {
+		return nil, ErrMissingAppKey
+	}
+
+	key := sha256.Sum256([]byte("authbase-secret:" + appKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -1,5 +1,11 @@
 package x
 
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
 // GenerateVerificationCode generates a verification code
 func GenerateVerificationCode() string {
 	return verificationToken()
@@ -9,3 +15,13 @@ func GenerateVerificationCode() string {
 func GeneratePasswordResetCode() string {
 	return verificationToken()
 }
+
+// GenerateLoginCode generates a 6 digit passwordless login code
+func GenerateLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
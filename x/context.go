@@ -2,6 +2,11 @@ package x
 
 import (
 	"context"
+	"github.com/emrgen/authbase/pkg/config"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strings"
 )
 
 func GetOAuth2State(ctx context.Context) (string, error) {
@@ -12,3 +17,71 @@ func GetOAuth2State(ctx context.Context) (string, error) {
 
 	return state, nil
 }
+
+// RequestUserAgent returns the user agent of the caller, the gateway forwards the one of the http request
+func RequestUserAgent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"grpcgateway-user-agent", "user-agent"} {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// RequestLanguage returns the Accept-Language of the caller, the gateway forwards the one of the http request
+func RequestLanguage(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"grpcgateway-accept-language", "accept-language"} {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// RequestIP returns the address of the caller. The forwarded addresses are honoured only when the request came
+// from a trusted proxy, the right-most forwarded address that is not a trusted proxy is the caller, the ones left
+// of it are set by the client and can not be trusted.
+func RequestIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(ip) {
+		return host
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	hops := strings.Split(strings.Join(md.Get("x-forwarded-for"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+// trustedProxy reports whether the address is in the trusted proxy networks of the config
+func trustedProxy(ip net.IP) bool {
+	for _, network := range config.GetConfig().TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
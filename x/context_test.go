@@ -0,0 +1,48 @@
+package x
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+// TestRequestDevice function to test the user agent and the address recorded for a session
+func TestRequestDevice(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 52100}})
+	assert.Equal(t, "10.0.0.7", RequestIP(ctx))
+	assert.Equal(t, "", RequestUserAgent(ctx))
+
+	// the forwarded addresses of untrusted peers are ignored
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"grpcgateway-user-agent", "Mozilla/5.0",
+		"user-agent", "grpc-go/1.64.0",
+		"x-forwarded-for", "203.0.113.9, 10.0.0.1",
+	))
+	assert.Equal(t, "10.0.0.7", RequestIP(ctx))
+	assert.Equal(t, "Mozilla/5.0", RequestUserAgent(ctx))
+}
+
+// TestRequestIPTrustedProxies function to test the caller is the right-most forwarded address that is not a trusted proxy
+func TestRequestIPTrustedProxies(t *testing.T) {
+	proxies := config.GetConfig().TrustedProxies
+	t.Cleanup(func() { config.GetConfig().TrustedProxies = proxies })
+
+	// the gateway forwards through the loopback and appends the address of the http client
+	gateway := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 52100}})
+	ctx := metadata.NewIncomingContext(gateway, metadata.Pairs("x-forwarded-for", "203.0.113.9, 10.0.0.1"))
+	assert.Equal(t, "10.0.0.1", RequestIP(ctx))
+
+	// the addresses forwarded by the trusted load balancer are skipped, the spoofed ones left of the client are ignored
+	config.GetConfig().TrustedProxies, _ = config.ParseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	assert.Equal(t, "203.0.113.9", RequestIP(ctx))
+	ctx = metadata.NewIncomingContext(gateway, metadata.Pairs("x-forwarded-for", "198.51.100.1", "x-forwarded-for", "203.0.113.9, 10.0.0.1"))
+	assert.Equal(t, "203.0.113.9", RequestIP(ctx))
+	ctx = metadata.NewIncomingContext(gateway, metadata.Pairs("x-forwarded-for", "10.0.0.2, 10.0.0.1"))
+	assert.Equal(t, "10.0.0.2", RequestIP(ctx))
+	ctx = metadata.NewIncomingContext(gateway, metadata.Pairs("x-forwarded-for", "not-an-ip, 10.0.0.1"))
+	assert.Equal(t, "10.0.0.1", RequestIP(ctx))
+}
@@ -1,16 +1,410 @@
 package x
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"hash"
+	"strconv"
+	"strings"
 )
 
-// HashPassword hashes the password with the salt
-func HashPassword(password, salt string) []byte {
-	return argon2.IDKey([]byte(password), []byte(salt), 1, 64*1024, 4, 32)
+// Argon2Params are the argon2id parameters of a password hash, the memory is in KiB
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
 }
 
-// CompareHashAndPassword compares the hash with the password and salt.
-// Returns true if they match, false otherwise.
+// DefaultArgon2Params hash the new passwords. Raising them upgrades the stored hashes on the next login of each account,
+// they must stay within the argon2 cost limits.
+var DefaultArgon2Params = Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32, SaltLen: 16}
+
+// the cost limits of the password hashes. The imported hashes carry their own parameters,
+// a hash above the limits would make every login attempt of the account a denial of service.
+const (
+	maxArgon2Time       = 16
+	maxArgon2Memory     = 256 * 1024
+	maxArgon2Threads    = 16
+	maxBcryptCost       = 16
+	maxScryptLogN       = 20
+	maxScryptR          = 16
+	maxScryptP          = 16
+	maxPBKDF2Iterations = 2_000_000
+	maxHashKeyLen       = 128
+)
+
+var (
+	ErrUnsupportedPasswordHash = errors.New("unsupported password hash format")
+	ErrPasswordHashCost        = errors.New("password hash parameters out of range")
+)
+
+// HashPassword hashes the password with argon2id and the default parameters.
+// The hash is PHC encoded, $argon2id$v=19$m=65536,t=1,p=4$salt$hash, it carries its own salt and parameters.
+func HashPassword(password string) string {
+	return hashArgon2id(password, DefaultArgon2Params)
+}
+
+// CompareHashAndPassword compares the password with the hash in constant time.
+// Besides the PHC argon2 hashes it verifies the bcrypt, scrypt and PBKDF2 hashes of imported accounts,
+// and the raw argon2id hashes stored before the PHC encoding with their separate salt.
 func CompareHashAndPassword(password, salt, hash string) bool {
-	return string(argon2.IDKey([]byte(password), []byte(salt), 1, 64*1024, 4, 32)) == hash
+	switch {
+	case strings.HasPrefix(hash, "$argon2"):
+		return compareArgon2(password, hash)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return checkBcryptCost(hash) == nil && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return compareScrypt(password, hash)
+	case strings.HasPrefix(hash, "$firebase-scrypt$"):
+		return compareFirebaseScrypt(password, hash)
+	case strings.HasPrefix(hash, "$pbkdf2"), strings.HasPrefix(hash, "pbkdf2_"):
+		return comparePBKDF2(password, hash)
+	default:
+		key := argon2.IDKey([]byte(password), []byte(salt), 1, 64*1024, 4, 32)
+		return subtle.ConstantTimeCompare(key, []byte(hash)) == 1
+	}
+}
+
+// SupportedPasswordHash reports whether the hash is of an encoding CompareHashAndPassword verifies,
+// the raw hashes of the old format are not accepted from outside
+func SupportedPasswordHash(hash string) bool {
+	for _, prefix := range []string{"$argon2id$", "$argon2i$", "$2a$", "$2b$", "$2y$", "$scrypt$", "$firebase-scrypt$", "$pbkdf2-", "pbkdf2_"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckPasswordHash returns an error when the hash is not of a supported encoding or its parameters are out of
+// the cost limits, CompareHashAndPassword rejects such a hash without computing the password hash
+func CheckPasswordHash(hash string) error {
+	if !SupportedPasswordHash(hash) {
+		return ErrUnsupportedPasswordHash
+	}
+
+	var err error
+	switch {
+	case strings.HasPrefix(hash, "$argon2"):
+		_, _, _, _, err = parseArgon2(hash)
+	case strings.HasPrefix(hash, "$2"):
+		err = checkBcryptCost(hash)
+	case strings.HasPrefix(hash, "$scrypt$"):
+		_, err = parseScrypt(hash)
+	case strings.HasPrefix(hash, "$firebase-scrypt$"):
+		_, err = parseFirebaseScrypt(hash)
+	default:
+		_, err = parsePBKDF2(hash)
+	}
+	if err != nil && !errors.Is(err, ErrPasswordHashCost) {
+		return fmt.Errorf("invalid password hash: %w", err)
+	}
+
+	return err
+}
+
+// EncodedPasswordHash returns the hash with its salt in a supported encoding,
+// the raw argon2id hashes of the old format are PHC encoded with their separate salt
+func EncodedPasswordHash(salt, hash string) string {
+	if hash == "" || SupportedPasswordHash(hash) {
+		return hash
+	}
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 64*1024, 1, 4,
+		base64.RawStdEncoding.EncodeToString([]byte(salt)), base64.RawStdEncoding.EncodeToString([]byte(hash)))
+}
+
+// FirebaseScryptHash encodes a password hash of a firebase auth export with the scrypt parameters of the project,
+// all the values are base64 as firebase exports them
+func FirebaseScryptHash(hash, salt, saltSeparator, signerKey string, rounds, memCost int) string {
+	return fmt.Sprintf("$firebase-scrypt$m=%d,r=%d$%s$%s$%s$%s", memCost, rounds, salt, saltSeparator, signerKey, hash)
+}
+
+// NeedsRehash reports whether the hash is not an argon2id hash of the default parameters,
+// after a successful login such a hash is replaced by a new HashPassword hash
+func NeedsRehash(hash string) bool {
+	variant, params, _, key, err := parseArgon2(hash)
+	if err != nil || variant != "argon2id" {
+		return true
+	}
+
+	return params.Time != DefaultArgon2Params.Time || params.Memory != DefaultArgon2Params.Memory ||
+		params.Threads != DefaultArgon2Params.Threads || uint32(len(key)) != DefaultArgon2Params.KeyLen
+}
+
+// HashToken hashes a high entropy token, such as an authorization code, for storage and lookup.
+// Unlike passwords these tokens are random enough to not need a salt or a slow hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashArgon2id(password string, params Argon2Params) string {
+	salt := make([]byte, params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func compareArgon2(password, hash string) bool {
+	variant, params, salt, key, err := parseArgon2(hash)
+	if err != nil {
+		return false
+	}
+
+	var other []byte
+	switch variant {
+	case "argon2id":
+		other = argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	case "argon2i":
+		other = argon2.Key([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// parseArgon2 parses $argon2id$v=19$m=65536,t=1,p=4$salt$hash
+func parseArgon2(hash string) (string, *Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return "", nil, nil, nil, fmt.Errorf("invalid argon2 hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return "", nil, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+	params := &Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return "", nil, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	if params.Time == 0 || params.Time > maxArgon2Time || params.Memory > maxArgon2Memory ||
+		params.Threads == 0 || params.Threads > maxArgon2Threads || len(key) == 0 || len(key) > maxHashKeyLen {
+		return "", nil, nil, nil, ErrPasswordHashCost
+	}
+
+	return parts[1], params, salt, key, nil
+}
+
+// checkBcryptCost returns an error when the bcrypt hash is invalid or of a cost above the limit
+func checkBcryptCost(hash string) error {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return err
+	}
+	if cost > maxBcryptCost {
+		return ErrPasswordHashCost
+	}
+
+	return nil
+}
+
+// scryptHash is a parsed scrypt hash, the firebase hashes also carry the salt separator and the signer key
+type scryptHash struct {
+	logN      int
+	r         int
+	p         int
+	salt      []byte
+	separator []byte
+	signerKey []byte
+	key       []byte
+}
+
+// compareScrypt verifies $scrypt$ln=15,r=8,p=1$salt$hash, the cost is 2^ln
+func compareScrypt(password, hash string) bool {
+	h, err := parseScrypt(hash)
+	if err != nil {
+		return false
+	}
+
+	other, err := scrypt.Key([]byte(password), h.salt, 1<<h.logN, h.r, h.p, len(h.key))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(h.key, other) == 1
+}
+
+func parseScrypt(hash string) (*scryptHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid scrypt hash")
+	}
+	h := &scryptHash{}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &h.logN, &h.r, &h.p); err != nil {
+		return nil, err
+	}
+	var err error
+	if h.salt, err = decodePHCBase64(parts[3]); err != nil {
+		return nil, err
+	}
+	if h.key, err = decodePHCBase64(parts[4]); err != nil {
+		return nil, err
+	}
+	if err := checkScryptCost(h.logN, h.r, h.p, len(h.key)); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// compareFirebaseScrypt verifies $firebase-scrypt$m=14,r=8$salt$separator$signerkey$hash, firebase derives a key
+// with scrypt from the password and the salt joined with the separator, and encrypts the signer key with it
+func compareFirebaseScrypt(password, hash string) bool {
+	h, err := parseFirebaseScrypt(hash)
+	if err != nil {
+		return false
+	}
+
+	derived, err := scrypt.Key([]byte(password), append(h.salt, h.separator...), 1<<h.logN, h.r, h.p, 32)
+	if err != nil {
+		return false
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return false
+	}
+	other := make([]byte, len(h.signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(other, h.signerKey)
+
+	return subtle.ConstantTimeCompare(h.key, other) == 1
+}
+
+func parseFirebaseScrypt(hash string) (*scryptHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 7 {
+		return nil, fmt.Errorf("invalid firebase scrypt hash")
+	}
+	h := &scryptHash{p: 1}
+	if _, err := fmt.Sscanf(parts[2], "m=%d,r=%d", &h.logN, &h.r); err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 4)
+	for i := range values {
+		value, err := decodePHCBase64(parts[i+3])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	h.salt, h.separator, h.signerKey, h.key = values[0], values[1], values[2], values[3]
+	if err := checkScryptCost(h.logN, h.r, h.p, len(h.key)); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// checkScryptCost returns an error when the scrypt parameters are out of the cost limits
+func checkScryptCost(logN, r, p, keyLen int) error {
+	if logN <= 0 || logN > maxScryptLogN || r <= 0 || r > maxScryptR || p <= 0 || p > maxScryptP ||
+		keyLen == 0 || keyLen > maxHashKeyLen {
+		return ErrPasswordHashCost
+	}
+
+	return nil
+}
+
+// pbkdf2Hash is a parsed PBKDF2 hash
+type pbkdf2Hash struct {
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// comparePBKDF2 verifies the PHC $pbkdf2-sha256$i=600000$salt$hash hashes, with the passlib
+// $pbkdf2-sha256$29000$salt$hash variant, and the django pbkdf2_sha256$600000$salt$hash hashes
+func comparePBKDF2(password, encoded string) bool {
+	h, err := parsePBKDF2(encoded)
+	if err != nil {
+		return false
+	}
+
+	other := pbkdf2.Key([]byte(password), h.salt, h.iterations, len(h.key), h.digest)
+	return subtle.ConstantTimeCompare(h.key, other) == 1
+}
+
+func parsePBKDF2(encoded string) (*pbkdf2Hash, error) {
+	var digest, rounds, salt, key64 string
+	var decode func(string) ([]byte, error)
+	if strings.HasPrefix(encoded, "$") {
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("invalid pbkdf2 hash")
+		}
+		digest, rounds, salt, key64 = strings.TrimPrefix(parts[1], "pbkdf2-"), strings.TrimPrefix(parts[2], "i="), parts[3], parts[4]
+		decodedSalt, err := decodePHCBase64(salt)
+		if err != nil {
+			return nil, err
+		}
+		salt = string(decodedSalt)
+		decode = decodePHCBase64
+	} else {
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid pbkdf2 hash")
+		}
+		// django keeps the salt as is and pads the hash
+		digest, rounds, salt, key64 = strings.TrimPrefix(parts[0], "pbkdf2_"), parts[1], parts[2], parts[3]
+		decode = base64.StdEncoding.DecodeString
+	}
+
+	h := &pbkdf2Hash{salt: []byte(salt)}
+	switch digest {
+	case "sha1":
+		h.digest = sha1.New
+	case "sha256":
+		h.digest = sha256.New
+	case "sha512":
+		h.digest = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported pbkdf2 digest: %s", digest)
+	}
+	var err error
+	if h.iterations, err = strconv.Atoi(rounds); err != nil {
+		return nil, err
+	}
+	if h.key, err = decode(key64); err != nil {
+		return nil, err
+	}
+	if h.iterations <= 0 || h.iterations > maxPBKDF2Iterations || len(h.key) == 0 || len(h.key) > maxHashKeyLen {
+		return nil, ErrPasswordHashCost
+	}
+
+	return h, nil
+}
+
+// decodePHCBase64 decodes the unpadded base64 of the PHC strings, passlib writes . in place of +
+func decodePHCBase64(value string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(strings.TrimRight(value, "="), ".", "+"))
 }
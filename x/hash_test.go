@@ -2,25 +2,118 @@ package x
 
 import (
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"testing"
 )
 
 func TestCompareHashAndPassword(t *testing.T) {
 	password := "password"
-	salt := "salt"
-	hash := HashPassword(password, salt)
-	if !CompareHashAndPassword(password, salt, string(hash)) {
+	hash := HashPassword(password)
+	if !CompareHashAndPassword(password, "", hash) {
 		t.Errorf("CompareHashAndPassword() = false; want true")
 	}
+	if CompareHashAndPassword("wrong", "", hash) {
+		t.Errorf("CompareHashAndPassword() = true; want false")
+	}
 }
 
 func TestHashPassword(t *testing.T) {
 	password := "bd6b1652fd6d8e6120f660a28e28f3563aeeec1e63cb0665af1735208a21f408af8e4408b"
-	salt := "0172eefdb0b3cfeb55b4081e3f9bfe8cd127f2bf390c60847c9e96ab9e2157d9"
-	hash := HashPassword(password, salt)
-	logrus.Infof("hash: %v", string(hash))
+	hash := HashPassword(password)
 	logrus.Infof("hash: %v", hash)
-	if !CompareHashAndPassword(password, salt, string(hash)) {
+	if !CompareHashAndPassword(password, "", hash) {
 		t.Errorf("CompareHashAndPassword() = false; want true")
 	}
 }
+
+// TestImportedPasswordHashes function to test the verification of the hash formats of imported accounts
+func TestImportedPasswordHashes(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	hashes := []string{
+		string(bcryptHash),
+		"pbkdf2_sha256$1000$seasalt$YIWkt6M1JFXrHg5s0jZjBSc7C2Cz6QvchSJ0h8Y+i7c=",
+		"$pbkdf2-sha512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$38DzhdBT7fPaUGBlsh42VTuuKSFAIYGZJ7l6feCDLIl+K3hdPFgxxu7xuUi4gIuH6cEIoODn18xH9Ig2ryNgUw",
+		"$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+	}
+	for _, hash := range hashes {
+		assert.True(t, CompareHashAndPassword("password", "", hash), hash)
+		assert.False(t, CompareHashAndPassword("wrong", "", hash), hash)
+		assert.True(t, NeedsRehash(hash), hash)
+	}
+
+	// the raw hashes stored before the PHC encoding use the separate salt
+	legacy := string(argon2.IDKey([]byte("password"), []byte("salt"), 1, 64*1024, 4, 32))
+	assert.True(t, CompareHashAndPassword("password", "salt", legacy))
+	assert.False(t, CompareHashAndPassword("password", "other", legacy))
+	assert.True(t, NeedsRehash(legacy))
+}
+
+// TestNeedsRehash function to test the upgrade of the hashes of older parameters
+func TestNeedsRehash(t *testing.T) {
+	hash := HashPassword("password")
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=1,p=4$"))
+	assert.False(t, NeedsRehash(hash))
+
+	older := hashArgon2id("password", Argon2Params{Time: 1, Memory: 32 * 1024, Threads: 2, KeyLen: 32, SaltLen: 16})
+	assert.True(t, CompareHashAndPassword("password", "", older))
+	assert.True(t, NeedsRehash(older))
+}
+
+// TestFirebaseScryptHash function to test the verification of the password hashes of a firebase auth export
+func TestFirebaseScryptHash(t *testing.T) {
+	hash := FirebaseScryptHash(
+		"lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==",
+		"42xEC+ixf3L2lw==",
+		"Bw==",
+		"jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==",
+		8, 14)
+	assert.True(t, SupportedPasswordHash(hash))
+	assert.True(t, CompareHashAndPassword("user1password", "", hash))
+	assert.False(t, CompareHashAndPassword("user2password", "", hash))
+
+	// the raw hashes are exported PHC encoded
+	legacy := string(argon2.IDKey([]byte("password"), []byte("salt"), 1, 64*1024, 4, 32))
+	assert.False(t, SupportedPasswordHash(legacy))
+	assert.True(t, CompareHashAndPassword("password", "", EncodedPasswordHash("salt", legacy)))
+}
+
+// TestPasswordHashCost function to test the hashes above the cost limits are rejected without computing them
+func TestPasswordHashCost(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	valid := []string{
+		HashPassword("password"),
+		string(bcryptHash),
+		"pbkdf2_sha256$1000$seasalt$YIWkt6M1JFXrHg5s0jZjBSc7C2Cz6QvchSJ0h8Y+i7c=",
+		"$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+		FirebaseScryptHash("aGFzaA==", "c2FsdA==", "Bw==", "a2V5", 8, 14),
+	}
+	for _, hash := range valid {
+		assert.NoError(t, CheckPasswordHash(hash), hash)
+	}
+
+	expensive := []string{
+		"$argon2id$v=19$m=4194304,t=1,p=4$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+		"$argon2id$v=19$m=65536,t=1000,p=4$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+		"$argon2id$v=19$m=65536,t=1,p=0$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+		"$2a$31$" + string(bcryptHash[7:]),
+		"$scrypt$ln=30,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+		"$scrypt$ln=10,r=8,p=1000$MDEyMzQ1Njc4OWFiY2RlZg$ZEBCzLptWM7dhpNJDU2HbQ945ovKHmVEozHkePPbSqw",
+		FirebaseScryptHash("aGFzaA==", "c2FsdA==", "Bw==", "a2V5", 1000, 14),
+		"pbkdf2_sha256$1000000000$seasalt$YIWkt6M1JFXrHg5s0jZjBSc7C2Cz6QvchSJ0h8Y+i7c=",
+		"$pbkdf2-sha512$i=1000$MDEyMzQ1Njc4OWFiY2RlZg$" + strings.Repeat("A", 1024),
+	}
+	for _, hash := range expensive {
+		assert.ErrorIs(t, CheckPasswordHash(hash), ErrPasswordHashCost, hash)
+		assert.False(t, CompareHashAndPassword("password", "", hash), hash)
+	}
+
+	assert.ErrorIs(t, CheckPasswordHash("md5$salt$hash"), ErrUnsupportedPasswordHash)
+	assert.Error(t, CheckPasswordHash("$scrypt$ln=10$salt$hash"))
+}
@@ -21,10 +21,16 @@ const (
 	PoolIDKey = "authbase_pool_id"
 	// AccountIDKey is the key to store the user id in the context
 	AccountIDKey = "authbase_account_id"
+	// ClientIDKey is the key to store the client id in the context
+	ClientIDKey = "authbase_client_id"
+	// SessionIDKey is the key to store the login session id in the context
+	SessionIDKey = "authbase_session_id"
 	// ScopesKey is the key to store the scopes in the context
 	ScopesKey = "authbase_scopes"
 	// TokenMissingKey is the key to store the token in the context
 	TokenMissingKey = "authbase_token_missing"
+	// PasswordAuthKey is the key to mark the requests authenticated with the email and password instead of a token
+	PasswordAuthKey = "authbase_password_auth"
 	RolesKey        = "authbase_roles"
 )
 
@@ -112,10 +118,7 @@ func VerifyTokenInterceptor(keyProvider VerifierProvider, accessKeyService v1.Ac
 				return nil, err
 			}
 
-			ctx = context.WithValue(ctx, AccountIDKey, uuid.MustParse(claims.AccountID))
-			ctx = context.WithValue(ctx, ProjectIDKey, uuid.MustParse(claims.ProjectID))
-			ctx = context.WithValue(ctx, PoolIDKey, uuid.MustParse(claims.PoolID))
-			ctx = context.WithValue(ctx, ScopesKey, claims.Scopes)
+			ctx = WithClaims(ctx, claims)
 		} else {
 			if err != nil {
 				return nil, err
@@ -135,17 +138,36 @@ func VerifyTokenInterceptor(keyProvider VerifierProvider, accessKeyService v1.Ac
 			if err != nil {
 				return nil, err
 			}
+			if err := CheckAccessTokenUse(claims); err != nil {
+				return nil, err
+			}
 
-			ctx = context.WithValue(ctx, AccountIDKey, uuid.MustParse(claims.AccountID))
-			ctx = context.WithValue(ctx, ProjectIDKey, uuid.MustParse(claims.ProjectID))
-			ctx = context.WithValue(ctx, PoolIDKey, uuid.MustParse(claims.PoolID))
-			ctx = context.WithValue(ctx, ScopesKey, claims.Scopes)
+			ctx = WithClaims(ctx, claims)
 		}
 
 		return handler(ctx, req)
 	}
 }
 
+// WithClaims stores the verified token claims in the context.
+// Client credentials tokens have no account, only the client id is set for them.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	if claims.AccountID != "" {
+		ctx = context.WithValue(ctx, AccountIDKey, uuid.MustParse(claims.AccountID))
+	}
+	if claims.ClientID != "" {
+		ctx = context.WithValue(ctx, ClientIDKey, uuid.MustParse(claims.ClientID))
+	}
+	if claims.SessionID != "" {
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+	}
+	ctx = context.WithValue(ctx, ProjectIDKey, uuid.MustParse(claims.ProjectID))
+	ctx = context.WithValue(ctx, PoolIDKey, uuid.MustParse(claims.PoolID))
+	ctx = context.WithValue(ctx, ScopesKey, claims.Scopes)
+
+	return ctx
+}
+
 func GetAuthbaseProjectID(ctx context.Context) (uuid.UUID, error) {
 	pid, ok := ctx.Value(ProjectIDKey).(uuid.UUID)
 	if !ok {
@@ -173,6 +195,25 @@ func GetAuthbaseAccountID(ctx context.Context) (uuid.UUID, error) {
 	return accountID, nil
 }
 
+func GetAuthbaseClientID(ctx context.Context) (uuid.UUID, error) {
+	clientID, ok := ctx.Value(ClientIDKey).(uuid.UUID)
+	if !ok {
+		return uuid.UUID{}, errors.New("clientID not found in context")
+	}
+
+	return clientID, nil
+}
+
+// GetAuthbaseSessionID returns the session of the calling token, tokens without a session return an error
+func GetAuthbaseSessionID(ctx context.Context) (string, error) {
+	sessionID, ok := ctx.Value(SessionIDKey).(string)
+	if !ok {
+		return "", errors.New("sessionID not found in context")
+	}
+
+	return sessionID, nil
+}
+
 func IsAuthbaseTokenMissing(ctx context.Context) bool {
 	missing, ok := ctx.Value(TokenMissingKey).(bool)
 	if !ok {
@@ -182,6 +223,13 @@ func IsAuthbaseTokenMissing(ctx context.Context) bool {
 	return missing
 }
 
+// IsAuthbasePasswordAuth reports whether the request was authenticated with the email and password,
+// such requests have not passed the second factor of the account
+func IsAuthbasePasswordAuth(ctx context.Context) bool {
+	password, ok := ctx.Value(PasswordAuthKey).(bool)
+	return ok && password
+}
+
 func GetAuthbaseProjectPermission(ctx context.Context) (v1.Permission, error) {
 	permission, ok := ctx.Value(ProjectPermissionKey).(v1.Permission)
 	if !ok {
@@ -0,0 +1,127 @@
+package x
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+var ellipticCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC public key parameters
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// NewJWK converts a RSA or EC public key into a signature JWK
+func NewJWK(kid, algorithm string, key crypto.PublicKey) (*JWK, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: kid,
+			Alg: algorithm,
+			N:   encodeJWKInt(key.N, 0),
+			E:   encodeJWKInt(big.NewInt(int64(key.E)), 0),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			Kty: "EC",
+			Use: "sig",
+			Kid: kid,
+			Alg: algorithm,
+			Crv: key.Curve.Params().Name,
+			X:   encodeJWKInt(key.X, size),
+			Y:   encodeJWKInt(key.Y, size),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", key)
+	}
+}
+
+// PublicKey converts the JWK back into a RSA or EC public key
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := ellipticCurves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+// Key returns the key with the given id from the set
+func (s *JWKS) Key(kid string) *JWK {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// encodeJWKInt encodes a big integer as unpadded base64url, left padded to size bytes
+func encodeJWKInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,33 @@
+package x
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestJWKRoundTrip function to test that RSA and EC keys survive the JWK encoding
+func TestJWKRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "ES256"} {
+		key, err := GenerateSigningKey(algorithm)
+		assert.NoError(t, err)
+
+		jwk, err := NewJWK("kid-1", algorithm, key.Public())
+		assert.NoError(t, err)
+
+		data, err := json.Marshal(&JWKS{Keys: []*JWK{jwk}})
+		assert.NoError(t, err)
+
+		var set JWKS
+		assert.NoError(t, json.Unmarshal(data, &set))
+		assert.Nil(t, set.Key("kid-2"))
+
+		decoded := set.Key("kid-1")
+		assert.NotNil(t, decoded)
+		assert.Equal(t, algorithm, decoded.Alg)
+
+		public, err := decoded.PublicKey()
+		assert.NoError(t, err)
+		assert.Equal(t, key.Public(), public)
+	}
+}
@@ -4,19 +4,25 @@ import (
 	"fmt"
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"os"
 	"time"
 )
 
 const (
-	// RefreshTokenDuration is the duration for the refresh token
-	// TODO: this should be configurable in the future
+	// RefreshTokenDuration is the default duration for the refresh token, token policies override it
 	RefreshTokenDuration = 7 * 24 * time.Hour
-	// AccessTokenDuration is the duration for the access token
-	// TODO: this should be configurable in the future
+	// AccessTokenDuration is the default duration for the access token, token policies override it
 	AccessTokenDuration = 24 * 60 * time.Minute
-	// ScheduleRefreshTokenExpiry is the duration to schedule the refresh token expiry
+	// MaxAccessTokenDuration is the longest access token a token policy may set, revocations are kept as long
+	MaxAccessTokenDuration = 24 * time.Hour
+	// MaxRefreshTokenDuration is the longest refresh token a token policy may set
+	MaxRefreshTokenDuration = 90 * 24 * time.Hour
+	// ScheduleRefreshTokenExpiry is the interval of the sweep of the expired sessions and refresh tokens
 	ScheduleRefreshTokenExpiry = 5 * time.Minute
+	// ClientTokenDuration is the duration for the client credentials access token
+	ClientTokenDuration = time.Hour
 )
 
 func JWTSecretFromEnv() string {
@@ -64,6 +70,7 @@ type JWTVerifier interface {
 // Claims is the claims for the JWT token.
 type Claims struct {
 	KeyID     string    `json:"key_id"` // public key id
+	Subject   string    `json:"sub"`    // account id, or client id for client credentials tokens
 	Username  string    `json:"username"`
 	Email     string    `json:"email"`
 	ProjectID string    `json:"project_id"`
@@ -72,6 +79,8 @@ type Claims struct {
 	AccountID string    `json:"account_id"`
 	Audience  string    `json:"aud"`
 	Jti       string    `json:"jti"`
+	SessionID string    `json:"sid"`       // the login session, shared by the tokens refreshed from it
+	TokenUse  string    `json:"token_use"` // access or refresh
 	ExpireAt  time.Time `json:"exp"`
 	IssuedAt  time.Time `json:"iat"`
 	Provider  string    `json:"provider"` // google, github, etc
@@ -79,6 +88,23 @@ type Claims struct {
 	Roles     []string  `json:"roles"`
 }
 
+const (
+	// TokenUseAccess marks access tokens, tokens issued before the claim existed are access tokens too
+	TokenUseAccess = "access"
+	// TokenUseRefresh marks refresh tokens
+	TokenUseRefresh = "refresh"
+)
+
+// CheckAccessTokenUse rejects the refresh tokens sent as bearer tokens, they carry the claims of an access token
+// but live much longer and only the refresh endpoints accept them
+func CheckAccessTokenUse(claims *Claims) error {
+	if claims.TokenUse == TokenUseRefresh {
+		return status.Error(codes.Unauthenticated, "refresh token can not be used as an access token")
+	}
+
+	return nil
+}
+
 // JWTToken is combination of access token and refresh token
 type JWTToken struct {
 	AccessToken  string
@@ -87,30 +113,17 @@ type JWTToken struct {
 	IssuedAt     time.Time
 }
 
-// GenerateJWTToken generates a JWT token for the user
-func GenerateJWTToken(claims *Claims, signer JWTSigner) (*JWTToken, error) {
-	claim := jwt.MapClaims{
-		"username":   claims.Username,
-		"email":      claims.Email,
-		"account_id": claims.AccountID,
-		"project_id": claims.ProjectID,
-		"client_id":  claims.ClientID,
-		"pool_id":    claims.PoolID,
-		"exp":        claims.ExpireAt.Unix(),
-		"iat":        time.Now().Unix(),
-		"jti":        claims.Jti,
-		"provider":   "authbase",
-		"scopes":     claims.Scopes,
-		"roles":      claims.Roles,
-	}
-
+// GenerateJWTToken generates an access token from the claims and a refresh token expiring at refreshExpireAt
+func GenerateJWTToken(claims *Claims, signer JWTSigner, refreshExpireAt time.Time) (*JWTToken, error) {
+	claim := mapClaims(claims)
 	tokenString, err := signer.Sign(claim)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate the refresh token
-	claim["exp"] = time.Now().Add(RefreshTokenDuration).Unix()
+	claim["token_use"] = TokenUseRefresh
+	claim["exp"] = refreshExpireAt.Unix()
 	claim["iat"] = time.Now().Unix()
 	//token = jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
 	//refreshToken, err := token.SignedString(singKey)
@@ -126,6 +139,36 @@ func GenerateJWTToken(claims *Claims, signer JWTSigner) (*JWTToken, error) {
 	}, nil
 }
 
+// GenerateAccessToken generates an access token without a refresh token, used by the client credentials grant
+func GenerateAccessToken(claims *Claims, signer JWTSigner) (string, error) {
+	return signer.Sign(mapClaims(claims))
+}
+
+func mapClaims(claims *Claims) jwt.MapClaims {
+	subject := claims.Subject
+	if subject == "" {
+		subject = claims.AccountID
+	}
+
+	return jwt.MapClaims{
+		"sub":        subject,
+		"username":   claims.Username,
+		"email":      claims.Email,
+		"account_id": claims.AccountID,
+		"project_id": claims.ProjectID,
+		"client_id":  claims.ClientID,
+		"pool_id":    claims.PoolID,
+		"exp":        claims.ExpireAt.Unix(),
+		"iat":        time.Now().Unix(),
+		"jti":        claims.Jti,
+		"sid":        claims.SessionID,
+		"token_use":  TokenUseAccess,
+		"provider":   "authbase",
+		"scopes":     claims.Scopes,
+		"roles":      claims.Roles,
+	}
+}
+
 // VerifyJWTToken verifies the JWT token
 func VerifyJWTToken(tokenString string, verifier JWTVerifier) (*Claims, error) {
 	claims, err := verifier.Verify(tokenString)
@@ -194,22 +237,28 @@ func intoClaim(claims jwt.MapClaims) (*Claims, error) {
 		return nil, fmt.Errorf("provider not found")
 	}
 
-	scopes, ok := claims["scopes"].([]string)
-	if !ok {
-		scopes = []string{}
-	}
-
-	roles, ok := claims["roles"].([]string)
-	if !ok {
-		roles = []string{}
+	scopes := claimStrings(claims["scopes"])
+	roles := claimStrings(claims["roles"])
+	subject, _ := claims["sub"].(string)
+	username, _ := claims["username"].(string)
+	email, _ := claims["email"].(string)
+	sessionID, _ := claims["sid"].(string)
+	tokenUse, _ := claims["token_use"].(string)
+	if tokenUse == "" {
+		tokenUse = TokenUseAccess
 	}
 
 	return &Claims{
+		Subject:   subject,
+		Username:  username,
+		Email:     email,
 		AccountID: accountID,
 		ProjectID: projectID,
 		ClientID:  clientID,
 		PoolID:    poolID,
 		Jti:       jti,
+		SessionID: sessionID,
+		TokenUse:  tokenUse,
 		Provider:  provider,
 		ExpireAt:  expireAt.Time,
 		IssuedAt:  issuedAt.Time,
@@ -217,3 +266,26 @@ func intoClaim(claims jwt.MapClaims) (*Claims, error) {
 		Roles:     roles,
 	}, nil
 }
+
+// claimStrings reads a string list claim, decoded json arrays hold interface values
+func claimStrings(value interface{}) []string {
+	switch value := value.(type) {
+	case []string:
+		return value
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return []string{}
+	}
+}
+
+// PoolIssuer returns the issuer url of the pool tokens, the discovery documents are served below it
+func PoolIssuer(publicURL, poolID string) string {
+	return publicURL + "/pools/" + poolID
+}
@@ -2,7 +2,9 @@ package x
 
 import (
 	"context"
+	"crypto"
 	"errors"
+	"fmt"
 	"github.com/emrgen/authbase/pkg/model"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -70,6 +72,82 @@ func (r *StaticKeyProvider) GetVerifier(id string) (JWTVerifier, error) {
 	return newStaticVerifier(r.key), nil
 }
 
+// keySigner signs tokens with an asymmetric private key and stamps the key id into the kid header.
+type keySigner struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+// NewKeySigner creates a signer for the given key id, algorithm and private key.
+func NewKeySigner(kid, algorithm string, key crypto.Signer) (JWTSigner, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+
+	return &keySigner{kid: kid, method: method, key: key}, nil
+}
+
+func (s *keySigner) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.kid
+	tokenString, err := token.SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// KeyLookup returns the public key and the signing algorithm of a key id.
+type KeyLookup func(kid string) (crypto.PublicKey, string, error)
+
+// keySetVerifier verifies tokens against the key named by the kid header.
+type keySetVerifier struct {
+	lookup KeyLookup
+}
+
+// NewKeySetVerifier creates a verifier that resolves the verification key by the token kid.
+func NewKeySetVerifier(lookup KeyLookup) JWTVerifier {
+	return &keySetVerifier{lookup: lookup}
+}
+
+func (v *keySetVerifier) Verify(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("token kid header is missing")
+		}
+
+		key, algorithm, err := v.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// the algorithm is pinned by the key, never by the token header
+		if token.Method.Alg() != algorithm {
+			return nil, fmt.Errorf("unexpected signing algorithm: %s", token.Method.Alg())
+		}
+
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("token is invalid")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("failed to get claims")
+	}
+
+	return claims, nil
+}
+
 // UnverifiedKeyProvider is a key provider that does not verify the key.
 type UnverifiedKeyProvider struct{}
 
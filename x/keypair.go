@@ -1,11 +1,18 @@
 package x
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"time"
 )
 
 // ref: https://gist.github.com/goliatone/e9c13e5f046e34cef6e150d06f20a34c
@@ -107,3 +114,117 @@ func DecodeRSAPublicKey(key []byte) (*rsa.PublicKey, error) {
 	}
 	return rsaPubKey, nil
 }
+
+// GenerateSigningKey generates a private key for the given JWT signing algorithm.
+// RS256 keys are 2048 bit RSA keys and ES256 keys use the P-256 curve.
+func GenerateSigningKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, _, err := GenerateKeyPair(2048)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	case jwt.SigningMethodES256.Alg():
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+}
+
+// EncodeSigningKeyToPEM encodes a RSA or EC private key to PKCS8 PEM format
+func EncodeSigningKeyToPEM(privateKey crypto.Signer) ([]byte, error) {
+	privDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: privDER,
+	}), nil
+}
+
+// EncodeVerifyKeyToPEM encodes a RSA or EC public key to PKIX PEM format
+func EncodeVerifyKeyToPEM(publicKey crypto.PublicKey) ([]byte, error) {
+	pubDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubDER,
+	}), nil
+}
+
+// DecodeSigningKey decodes a PKCS8 or PKCS1 encoded RSA or EC private key
+func DecodeSigningKey(key []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing private key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("not a signing key")
+	}
+
+	return signer, nil
+}
+
+// DecodeVerifyKey decodes a PKIX encoded RSA or EC public key
+func DecodeVerifyKey(key []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// GenerateCertificate creates a self signed certificate of the RSA key in PEM format
+func GenerateCertificate(key *rsa.PrivateKey, commonName string, validity time.Duration) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// DecodeCertificate decodes a PEM encoded certificate
+func DecodeCertificate(cert []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(cert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode PEM block containing certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -1,6 +1,8 @@
 package x
 
 import (
+	"crypto"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
@@ -31,3 +33,49 @@ func TestResJwt(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+// TestKeySignerVerifier function to test kid based signing with persisted RSA and EC keys
+func TestKeySignerVerifier(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "ES256"} {
+		key, err := GenerateSigningKey(algorithm)
+		assert.NoError(t, err)
+
+		privatePem, err := EncodeSigningKeyToPEM(key)
+		assert.NoError(t, err)
+		publicPem, err := EncodeVerifyKeyToPEM(key.Public())
+		assert.NoError(t, err)
+
+		private, err := DecodeSigningKey(privatePem)
+		assert.NoError(t, err)
+		public, err := DecodeVerifyKey(publicPem)
+		assert.NoError(t, err)
+
+		signer, err := NewKeySigner("kid-1", algorithm, private)
+		assert.NoError(t, err)
+		tokenString, err := signer.Sign(jwt.MapClaims{"sub": "test"})
+		assert.NoError(t, err)
+
+		verifier := NewKeySetVerifier(func(kid string) (crypto.PublicKey, string, error) {
+			if kid != "kid-1" {
+				return nil, "", errors.New("unknown key")
+			}
+			return public, algorithm, nil
+		})
+		claims, err := verifier.Verify(tokenString)
+		assert.NoError(t, err)
+		assert.Equal(t, "test", claims["sub"])
+
+		unknown := NewKeySetVerifier(func(kid string) (crypto.PublicKey, string, error) {
+			return nil, "", errors.New("unknown key")
+		})
+		_, err = unknown.Verify(tokenString)
+		assert.Error(t, err)
+
+		// a key must not verify tokens signed with a different algorithm
+		mismatch := NewKeySetVerifier(func(kid string) (crypto.PublicKey, string, error) {
+			return public, "PS256", nil
+		})
+		_, err = mismatch.Verify(tokenString)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,216 @@
+package x
+
+import (
+	"context"
+	"errors"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/emrgen/authbase/pkg/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+// ErrIncorrectPassword is returned for a wrong password, or an email without an account
+var ErrIncorrectPassword = errors.New("incorrect password")
+
+// LoginGuard protects the password checks against brute force. The failed attempts are counted per account
+// and per client address, a failure makes the account wait before the next attempt and too many lock it out.
+type LoginGuard interface {
+	// Check rejects an attempt of a locked account with PermissionDenied,
+	// and an attempt during the backoff or from a blocked address with ResourceExhausted
+	Check(ctx context.Context, accountID string) error
+	// Fail records a failed attempt, accountID is empty when the email has no account
+	Fail(ctx context.Context, accountID string, policy model.LockoutPolicy) error
+	// FailSecondFactor records a wrong second factor code of the account, these failures are not forgotten
+	// on the next correct password and lock the account like the password failures
+	FailSecondFactor(ctx context.Context, accountID string, policy model.LockoutPolicy) error
+	// Succeed forgets the failed password attempts of the account
+	Succeed(accountID string) error
+	// Lockout returns the failed attempts state of the account
+	Lockout(accountID string) (*Lockout, error)
+	// Unlock forgets the failed attempts and lifts the lockout of the account
+	Unlock(accountID string) error
+}
+
+// Lockout is the failed attempts state of an account
+type Lockout struct {
+	FailedAttempts int
+	LockedUntil    time.Time
+	RetryAfter     time.Time
+}
+
+// Locked reports whether the account is locked out
+func (l *Lockout) Locked() bool {
+	return l.LockedUntil.After(time.Now())
+}
+
+// CacheLoginGuard is a login guard backed by the cache, the counters expire a lockout duration after the last failure
+type CacheLoginGuard struct {
+	cache *cache.Redis
+}
+
+var _ LoginGuard = new(CacheLoginGuard)
+
+// NewCacheLoginGuard creates a new login guard backed by the cache.
+func NewCacheLoginGuard(cache *cache.Redis) *CacheLoginGuard {
+	return &CacheLoginGuard{cache: cache}
+}
+
+func (g *CacheLoginGuard) Check(ctx context.Context, accountID string) error {
+	values, err := g.cache.MGet(lockedKey(accountID), backoffKey("account", accountID), backoffKey("ip", RequestIP(ctx)))
+	if err != nil {
+		return err
+	}
+
+	if accountID != "" && values[0] != "" {
+		return status.Errorf(codes.PermissionDenied, "account is locked after too many failed attempts, retry after %s", unixTime(values[0]).Format(time.RFC3339))
+	}
+	for _, value := range values[1:] {
+		if value != "" {
+			return status.Errorf(codes.ResourceExhausted, "too many failed attempts, retry after %s", unixTime(value).Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+func (g *CacheLoginGuard) Fail(ctx context.Context, accountID string, policy model.LockoutPolicy) error {
+	policy = policy.WithDefaults()
+	lockout := time.Duration(policy.LockoutDuration) * time.Second
+
+	// an address failing on many accounts is blocked as a whole
+	if ip := RequestIP(ctx); ip != "" {
+		failures, err := g.cache.Incr(failuresKey("ip", ip), lockout)
+		if err != nil {
+			return err
+		}
+		if failures >= int64(policy.IPMaxAttempts) {
+			if err := g.wait(backoffKey("ip", ip), lockout); err != nil {
+				return err
+			}
+		}
+	}
+	if accountID == "" {
+		return nil
+	}
+
+	failures, err := g.cache.Incr(failuresKey("account", accountID), lockout)
+	if err != nil {
+		return err
+	}
+	if failures >= int64(policy.MaxAttempts) {
+		return g.wait(lockedKey(accountID), lockout)
+	}
+
+	return g.wait(backoffKey("account", accountID), policy.Backoff(int(failures)))
+}
+
+func (g *CacheLoginGuard) FailSecondFactor(ctx context.Context, accountID string, policy model.LockoutPolicy) error {
+	policy = policy.WithDefaults()
+	lockout := time.Duration(policy.LockoutDuration) * time.Second
+
+	failures, err := g.cache.Incr(failuresKey("factor", accountID), lockout)
+	if err != nil {
+		return err
+	}
+	if failures >= int64(policy.MaxAttempts) {
+		return g.wait(lockedKey(accountID), lockout)
+	}
+
+	return g.wait(backoffKey("account", accountID), policy.Backoff(int(failures)))
+}
+
+func (g *CacheLoginGuard) Succeed(accountID string) error {
+	if err := g.cache.Del(failuresKey("account", accountID)); err != nil {
+		return err
+	}
+
+	return g.cache.Del(backoffKey("account", accountID))
+}
+
+func (g *CacheLoginGuard) Lockout(accountID string) (*Lockout, error) {
+	values, err := g.cache.MGet(failuresKey("account", accountID), failuresKey("factor", accountID), lockedKey(accountID), backoffKey("account", accountID))
+	if err != nil {
+		return nil, err
+	}
+
+	failures, _ := strconv.Atoi(values[0])
+	factorFailures, _ := strconv.Atoi(values[1])
+	lockout := &Lockout{FailedAttempts: failures + factorFailures}
+	if values[2] != "" {
+		lockout.LockedUntil = unixTime(values[2])
+	}
+	if values[3] != "" {
+		lockout.RetryAfter = unixTime(values[3])
+	}
+
+	return lockout, nil
+}
+
+func (g *CacheLoginGuard) Unlock(accountID string) error {
+	for _, key := range []string{failuresKey("account", accountID), failuresKey("factor", accountID), lockedKey(accountID), backoffKey("account", accountID)} {
+		if err := g.cache.Del(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VerifyPassword checks the password of the account under the guard. The password is not compared while the
+// account or the address waits after failures, a wrong password is recorded as a failure with the pool policy.
+// An empty account stands for an unknown email, it fails like a wrong password.
+func VerifyPassword(ctx context.Context, guard LoginGuard, account *model.Account, policy model.LockoutPolicy, password string) error {
+	if err := guard.Check(ctx, account.ID); err != nil {
+		return err
+	}
+
+	if account.ID == "" || !CompareHashAndPassword(password, account.Salt, account.PasswordHash) {
+		if err := guard.Fail(ctx, account.ID, policy); err != nil {
+			return err
+		}
+		return ErrIncorrectPassword
+	}
+
+	return guard.Succeed(account.ID)
+}
+
+// UpgradePasswordHash replaces the hash of a verified password when it is of an imported format or of older
+// parameters, the accounts migrate on their next login. A failed upgrade does not fail the login.
+func UpgradePasswordHash(ctx context.Context, as store.AuthBaseStore, account *model.Account, password string) {
+	if !NeedsRehash(account.PasswordHash) {
+		return
+	}
+
+	account.PasswordHash = HashPassword(password)
+	account.Salt = ""
+	if err := as.UpdateAccount(ctx, account); err != nil {
+		logrus.Errorf("authbase: failed to upgrade the password hash of account %s: %v", account.ID, err)
+	}
+}
+
+// wait records the end of the wait under the key, the key expires with the wait
+func (g *CacheLoginGuard) wait(key string, duration time.Duration) error {
+	until := time.Now().Add(duration)
+	return g.cache.Set(key, strconv.FormatInt(until.Unix(), 10), duration)
+}
+
+func unixTime(value string) time.Time {
+	seconds, _ := strconv.ParseInt(value, 10, 64)
+	return time.Unix(seconds, 0)
+}
+
+func failuresKey(kind, id string) string {
+	return "login:failures:" + kind + ":" + id
+}
+
+func backoffKey(kind, id string) string {
+	return "login:backoff:" + kind + ":" + id
+}
+
+func lockedKey(accountID string) string {
+	return "login:locked:account:" + accountID
+}
@@ -0,0 +1,85 @@
+package x
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// memoryLoginGuard counts the failures in memory and locks at the policy max attempts
+type memoryLoginGuard struct {
+	failures map[string]int
+	locked   map[string]bool
+}
+
+func (g *memoryLoginGuard) Check(ctx context.Context, accountID string) error {
+	if g.locked[accountID] {
+		return status.Error(codes.PermissionDenied, "account is locked")
+	}
+	return nil
+}
+
+func (g *memoryLoginGuard) Fail(ctx context.Context, accountID string, policy model.LockoutPolicy) error {
+	g.failures[accountID]++
+	if g.failures[accountID] >= policy.WithDefaults().MaxAttempts {
+		g.locked[accountID] = true
+	}
+	return nil
+}
+
+func (g *memoryLoginGuard) FailSecondFactor(ctx context.Context, accountID string, policy model.LockoutPolicy) error {
+	return g.Fail(ctx, accountID, policy)
+}
+
+func (g *memoryLoginGuard) Succeed(accountID string) error {
+	delete(g.failures, accountID)
+	return nil
+}
+
+func (g *memoryLoginGuard) Lockout(accountID string) (*Lockout, error) {
+	return &Lockout{FailedAttempts: g.failures[accountID]}, nil
+}
+
+func (g *memoryLoginGuard) Unlock(accountID string) error {
+	delete(g.failures, accountID)
+	delete(g.locked, accountID)
+	return nil
+}
+
+// TestVerifyPassword function to test the lockout after the failed password attempts
+func TestVerifyPassword(t *testing.T) {
+	guard := &memoryLoginGuard{failures: map[string]int{}, locked: map[string]bool{}}
+	account := &model.Account{ID: "account", PasswordHash: HashPassword("password")}
+	policy := model.LockoutPolicy{MaxAttempts: 3}
+	ctx := context.Background()
+
+	assert.ErrorIs(t, VerifyPassword(ctx, guard, account, policy, "wrong"), ErrIncorrectPassword)
+	assert.NoError(t, VerifyPassword(ctx, guard, account, policy, "password"))
+	assert.Equal(t, 0, guard.failures["account"])
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, VerifyPassword(ctx, guard, account, policy, "wrong"), ErrIncorrectPassword)
+	}
+	// the right password does not get through a locked account
+	assert.Equal(t, codes.PermissionDenied, status.Code(VerifyPassword(ctx, guard, account, policy, "password")))
+
+	assert.NoError(t, guard.Unlock("account"))
+	assert.NoError(t, VerifyPassword(ctx, guard, account, policy, "password"))
+
+	// an unknown email fails like a wrong password
+	assert.ErrorIs(t, VerifyPassword(ctx, guard, &model.Account{}, policy, "password"), ErrIncorrectPassword)
+}
+
+// TestLockoutPolicyBackoff function to test the doubling wait bounded by the lockout duration
+func TestLockoutPolicyBackoff(t *testing.T) {
+	policy := model.LockoutPolicy{LockoutDuration: 10}.WithDefaults()
+	assert.Equal(t, model.DefaultLockoutPolicy.MaxAttempts, policy.MaxAttempts)
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 8*time.Second, policy.Backoff(4))
+	assert.Equal(t, 10*time.Second, policy.Backoff(5))
+}
@@ -1,9 +1,11 @@
 package mail
 
 import (
+	"fmt"
+	"github.com/emrgen/authbase/pkg/config"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	gomail "gopkg.in/mail.v2"
-	"os"
 )
 
 // MailerProvider provides a mailer for a given project.
@@ -11,61 +13,119 @@ type MailerProvider interface {
 	Provide(projectID uuid.UUID) Mailer
 }
 
-// Mailer is an interface for sending emails.
+// Mailer is the transport of the emails, the smtp, file and memory mailers deliver the messages.
 type Mailer interface {
-	SendMail(from, to, subject, body string) error
+	Send(message *Message) error
 }
 
-// SimpleMailer is a mailer provider.
-type SimpleMailer struct {
-	host string
-	port int
-	user string
-	pass string
+// Sender is the sender of the emails of a project, the empty values use the server defaults.
+// The smtp server of the project replaces the server one when the emails are sent over smtp.
+type Sender struct {
+	From    string
+	ReplyTo string
+	SMTP    *config.SMTPConfig
 }
 
-func NewMailerProvider(host string, port int, user, pass string) *SimpleMailer {
-	return &SimpleMailer{
-		host: host,
-		port: port,
-		user: user,
-		pass: pass,
+// SenderLoader returns the sender of the project, nil when the project has none
+type SenderLoader func(projectID uuid.UUID) (*Sender, error)
+
+// DefaultMailerProvider provides the mailers with the sender of the project over the server transport
+type DefaultMailerProvider struct {
+	config    *config.MailConfig
+	transport Mailer
+	senders   SenderLoader
+}
+
+var _ MailerProvider = new(DefaultMailerProvider)
+
+// NewMailerProvider creates the mailer provider of the mail config, the senders loads the project senders
+func NewMailerProvider(cfg *config.MailConfig, senders SenderLoader) (*DefaultMailerProvider, error) {
+	var transport Mailer
+	switch cfg.Transport {
+	case "smtp":
+		transport = NewSMTPMailer(cfg.SMTP)
+	case "file":
+		transport = NewFileMailer(cfg.Dir)
+	case "memory":
+		transport = NewMemoryMailer()
+	default:
+		return nil, fmt.Errorf("unsupported mail transport: %s", cfg.Transport)
 	}
+
+	return &DefaultMailerProvider{config: cfg, transport: transport, senders: senders}, nil
 }
 
-func (m *SimpleMailer) Provide(orgID uuid.UUID) Mailer {
-	return &MailerImpl{
-		host: m.host,
-		port: m.port,
-		user: m.user,
-		pass: m.pass,
+// Transport returns the server transport, the memory mailer of the tests reads the sent messages from it
+func (p *DefaultMailerProvider) Transport() Mailer {
+	return p.transport
+}
+
+func (p *DefaultMailerProvider) Provide(projectID uuid.UUID) Mailer {
+	mailer := &senderMailer{transport: p.transport, from: p.config.From, replyTo: p.config.ReplyTo}
+	if p.senders == nil {
+		return mailer
+	}
+
+	sender, err := p.senders(projectID)
+	if err != nil {
+		logrus.Errorf("failed to load the mail sender of project %s: %v", projectID, err)
+		return mailer
+	}
+	if sender == nil {
+		return mailer
+	}
+
+	if sender.From != "" {
+		mailer.from = sender.From
+	}
+	if sender.ReplyTo != "" {
+		mailer.replyTo = sender.ReplyTo
 	}
+	if sender.SMTP != nil && sender.SMTP.Host != "" && p.config.Transport == "smtp" {
+		mailer.transport = NewSMTPMailer(*sender.SMTP)
+	}
+
+	return mailer
 }
 
-type MailerImpl struct {
-	host string
-	port int
-	user string
-	pass string
+// senderMailer sets the sender of the messages without one
+type senderMailer struct {
+	transport Mailer
+	from      string
+	replyTo   string
 }
 
-func (m *MailerImpl) SendMail(from, to, subject, body string) error {
-	return SendMail(from, to, subject, body)
+func (m *senderMailer) Send(message *Message) error {
+	sent := *message
+	if sent.From == "" {
+		sent.From = m.from
+	}
+	if sent.ReplyTo == "" {
+		sent.ReplyTo = m.replyTo
+	}
+
+	return m.transport.Send(&sent)
 }
 
-func SendMail(from, to, subject, body string) error {
+// compose builds the mime message, the text body with the html body as its alternative
+func compose(message *Message) *gomail.Message {
 	m := gomail.NewMessage()
-	m.SetHeader("From", from)
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", subject)
-	m.SetBody("text/html", body)
-
-	pass := os.Getenv("EMAIL_API_KEY")
+	m.SetHeader("From", message.From)
+	m.SetHeader("To", message.To)
+	if message.ReplyTo != "" {
+		m.SetHeader("Reply-To", message.ReplyTo)
+	}
+	m.SetHeader("Subject", message.Subject)
 
-	d := gomail.NewDialer("live.smtp.mailtrap.io", 587, "api", pass)
-	if err := d.DialAndSend(m); err != nil {
-		return err
+	switch {
+	case message.Text != "" && message.HTML != "":
+		m.SetBody("text/plain", message.Text)
+		m.AddAlternative("text/html", message.HTML)
+	case message.HTML != "":
+		m.SetBody("text/html", message.HTML)
+	default:
+		m.SetBody("text/plain", message.Text)
 	}
 
-	return nil
+	return m
 }
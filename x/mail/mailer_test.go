@@ -1,8 +1,60 @@
 package mail
 
-//func Test_SendMail(t *testing.T) {
-//	from := os.Getenv("EMAIL_FROM")
-//
-//	err := SendMail(from, "minorblocker@gmail.com", "subject", "body")
-//	assert.NoError(t, err)
-//}
+import (
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMailerProvider function to test the project sender replaces the default sender
+func TestMailerProvider(t *testing.T) {
+	projectID := uuid.New()
+	cfg := &config.MailConfig{Transport: "memory", From: "authbase@mail.test"}
+	provider, err := NewMailerProvider(cfg, func(id uuid.UUID) (*Sender, error) {
+		if id == projectID {
+			return &Sender{From: "hello@tenant.test", ReplyTo: "support@tenant.test"}, nil
+		}
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.Provide(uuid.New()).Send(&Message{To: "jane@mail.test", Subject: "default"}))
+	assert.NoError(t, provider.Provide(projectID).Send(&Message{To: "jane@mail.test", Subject: "project"}))
+
+	messages := provider.Transport().(*MemoryMailer).Messages()
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "authbase@mail.test", messages[0].From)
+		assert.Equal(t, "", messages[0].ReplyTo)
+		assert.Equal(t, "hello@tenant.test", messages[1].From)
+		assert.Equal(t, "support@tenant.test", messages[1].ReplyTo)
+	}
+
+	_, err = NewMailerProvider(&config.MailConfig{Transport: "pigeon"}, nil)
+	assert.Error(t, err)
+}
+
+// TestFileMailer function to test the messages are written to the new directory of the maildir
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := NewFileMailer(dir)
+	err := mailer.Send(&Message{From: "authbase@mail.test", To: "jane@mail.test", Subject: "Hello", HTML: "<p>hi</p>", Text: "hi"})
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+		assert.NoError(t, err)
+		assert.True(t, strings.Contains(string(data), "Subject: Hello"))
+		assert.True(t, strings.Contains(string(data), "text/plain"))
+		assert.True(t, strings.Contains(string(data), "text/html"))
+	}
+
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	assert.NoError(t, err)
+	assert.Empty(t, tmp)
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// Message is an email, the text body is the fallback of the mail clients without html.
+// The mailer of the project sets the sender and the reply-to address when they are empty.
+type Message struct {
+	From    string
+	ReplyTo string
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// LinkData is the data of the emails carrying a code and the link with the code, the templates of every kind render it
+type LinkData struct {
+	Email     string
+	Code      string
+	Link      string
+	ExpiresIn string
+	Project   string
+}
+
+// NewLinkData returns the template data of an email with a code valid for the duration
+func NewLinkData(email, code, link string, expiresIn time.Duration) LinkData {
+	return LinkData{Email: email, Code: code, Link: link, ExpiresIn: formatDuration(expiresIn)}
+}
+
+// formatDuration writes the duration in the largest whole unit, 24h is 24 hours and 10m is 10 minutes
+func formatDuration(d time.Duration) string {
+	unit, size := "minute", time.Minute
+	if d >= time.Hour && d%time.Hour == 0 {
+		unit, size = "hour", time.Hour
+	}
+
+	n := int(d / size)
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
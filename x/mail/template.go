@@ -0,0 +1,264 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"golang.org/x/text/language"
+	"html/template"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	text "text/template"
+)
+
+// templates are the default templates, one directory per locale with the subject, the html and the text of each kind
+//
+//go:embed templates
+var templates embed.FS
+
+const (
+	KindVerification = "verification"
+	KindReset        = "reset"
+	KindMagicLink    = "magic-link"
+	KindInvitation   = "invitation"
+
+	// DefaultLocale is the locale every lookup falls back to, the embedded templates have all the kinds in it
+	DefaultLocale = "en"
+)
+
+// Kinds are the kinds of the templated emails
+var Kinds = []string{KindVerification, KindReset, KindMagicLink, KindInvitation}
+
+var (
+	ErrTemplateNotFound = errors.New("email template not found")
+	ErrUnknownKind      = errors.New("unknown email template kind")
+)
+
+// Template is the email template of a kind in a locale. The subject and the text are text templates,
+// the html is a html template, all of them render a LinkData.
+type Template struct {
+	Kind    string
+	Locale  string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// ValidKind reports whether the kind is one of the Kinds
+func ValidKind(kind string) bool {
+	return slices.Contains(Kinds, kind)
+}
+
+// ParseLocale returns the canonical form of a BCP 47 locale, pt-br is pt-BR
+func ParseLocale(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", err
+	}
+
+	return tag.String(), nil
+}
+
+// AcceptLocales returns the locales of an Accept-Language header from the most preferred one
+func AcceptLocales(header string) []string {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		return nil
+	}
+
+	locales := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		// the * wildcard is parsed as mul, any locale is served by the default one
+		if !tag.IsRoot() && tag.String() != "mul" {
+			locales = append(locales, tag.String())
+		}
+	}
+
+	return locales
+}
+
+// FallbackLocales returns the locales in the order the templates are looked up,
+// each locale is followed by its parents, pt-BR by pt, and the default locale comes last.
+func FallbackLocales(locales ...string) []string {
+	var chain []string
+	add := func(locale string) {
+		if !slices.Contains(chain, locale) {
+			chain = append(chain, locale)
+		}
+	}
+
+	for _, locale := range locales {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			continue
+		}
+		for ; !tag.IsRoot(); tag = tag.Parent() {
+			add(tag.String())
+		}
+	}
+	add(DefaultLocale)
+
+	return chain
+}
+
+// DefaultTemplate returns the embedded template of the kind in the locale
+func DefaultTemplate(kind, locale string) (*Template, error) {
+	if !ValidKind(kind) {
+		return nil, ErrUnknownKind
+	}
+
+	read := func(ext string) (string, error) {
+		data, err := templates.ReadFile(path.Join("templates", locale, kind+ext))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return string(data), err
+	}
+
+	subject, err := read(".subject")
+	if err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		return nil, ErrTemplateNotFound
+	}
+	html, err := read(".html")
+	if err != nil {
+		return nil, err
+	}
+	plain, err := read(".txt")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{Kind: kind, Locale: locale, Subject: subject, HTML: html, Text: plain}, nil
+}
+
+// Resolve returns the template of the kind in the first of the fallback locales that has one.
+// The project templates come before the embedded ones of the same locale, a project template without
+// a subject or a text gets them from the embedded template of its fallback locales.
+func Resolve(kind string, locales []string, project []*Template) (*Template, error) {
+	if !ValidKind(kind) {
+		return nil, ErrUnknownKind
+	}
+
+	custom := make(map[string]*Template)
+	for _, t := range project {
+		if t.Kind == kind {
+			custom[t.Locale] = t
+		}
+	}
+
+	chain := FallbackLocales(locales...)
+	for i, locale := range chain {
+		if t, ok := custom[locale]; ok {
+			return withDefaults(t, chain[i:])
+		}
+		t, err := DefaultTemplate(kind, locale)
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, ErrTemplateNotFound) {
+			return nil, err
+		}
+	}
+
+	return nil, ErrTemplateNotFound
+}
+
+// withDefaults fills the empty subject and text of the template from the first embedded template of the locales.
+// A template without html stays a text only email.
+func withDefaults(t *Template, locales []string) (*Template, error) {
+	if t.Subject != "" && t.Text != "" {
+		return t, nil
+	}
+
+	for _, locale := range locales {
+		def, err := DefaultTemplate(t.Kind, locale)
+		if errors.Is(err, ErrTemplateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		filled := *t
+		if filled.Subject == "" {
+			filled.Subject = def.Subject
+		}
+		if filled.Text == "" {
+			filled.Text = def.Text
+		}
+		return &filled, nil
+	}
+
+	return t, nil
+}
+
+// Render executes the template with the data, the message goes to the email of the data
+func Render(t *Template, data LinkData) (*Message, error) {
+	message := &Message{To: data.Email}
+
+	subject, err := executeText(t.Kind+".subject", t.Subject, data)
+	if err != nil {
+		return nil, err
+	}
+	// the subject is a single header line
+	message.Subject = strings.Join(strings.Fields(subject), " ")
+
+	if t.HTML != "" {
+		h, err := template.New(t.Kind + ".html").Parse(t.HTML)
+		if err != nil {
+			return nil, err
+		}
+		var html bytes.Buffer
+		if err := h.Execute(&html, data); err != nil {
+			return nil, err
+		}
+		message.HTML = html.String()
+	}
+
+	if t.Text != "" {
+		message.Text, err = executeText(t.Kind+".txt", t.Text, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return message, nil
+}
+
+func executeText(name, source string, data LinkData) (string, error) {
+	t, err := text.New(name).Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// SampleData returns the data the previews of the kind are rendered with
+func SampleData(kind string) LinkData {
+	data := LinkData{Email: "jane@example.com", Code: "123456", ExpiresIn: "24 hours", Project: "Example"}
+	switch kind {
+	case KindVerification:
+		data.Link = "https://example.com/verify-email?code=123456"
+	case KindReset:
+		data.Link = "https://example.com/reset-password?code=123456"
+	case KindMagicLink:
+		data.Link = "https://example.com/login?code=123456&email=jane%40example.com"
+		data.ExpiresIn = "10 minutes"
+	case KindInvitation:
+		data.Link = "https://example.com/invitation?code=123456"
+		data.ExpiresIn = "7 days"
+	}
+
+	return data
+}
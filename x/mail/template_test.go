@@ -0,0 +1,107 @@
+package mail
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_VerifyMail(t *testing.T) {
+	template, err := DefaultTemplate(KindVerification, DefaultLocale)
+	assert.NoError(t, err)
+	message, err := Render(template, NewLinkData("minorblocker@gmail.com", "1234", "http://localhost:4001/verify/1234", 24*time.Hour))
+	assert.NoError(t, err)
+
+	mailer := NewMemoryMailer()
+	err = mailer.Send(message)
+	assert.NoError(t, err)
+	if assert.Len(t, mailer.Messages(), 1) {
+		assert.Equal(t, "minorblocker@gmail.com", mailer.Messages()[0].To)
+	}
+}
+
+// TestVerifyEmailMessage function to test the verification email renders the code and the escaped link in both bodies
+func TestVerifyEmailMessage(t *testing.T) {
+	template, err := Resolve(KindVerification, nil, nil)
+	assert.NoError(t, err)
+	message, err := Render(template, NewLinkData("jane@authbase.test", "c0de", "https://app.test/verify-email?code=c0de&email=jane", 24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "Verify your email", message.Subject)
+	assert.Contains(t, message.HTML, `href="https://app.test/verify-email?code=c0de&amp;email=jane"`)
+	assert.Contains(t, message.HTML, "24 hours")
+	assert.Contains(t, message.Text, "https://app.test/verify-email?code=c0de&email=jane")
+	assert.Contains(t, message.Text, "c0de")
+
+	template, err = Resolve(KindReset, nil, nil)
+	assert.NoError(t, err)
+	message, err = Render(template, NewLinkData("jane@authbase.test", "c0de", "https://app.test/reset-password?code=c0de", time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "Reset your password", message.Subject)
+	assert.Contains(t, message.Text, "expires in 1 hour.")
+}
+
+// TestDefaultTemplates function to test every kind has an embedded template rendering the sample data
+func TestDefaultTemplates(t *testing.T) {
+	for _, kind := range Kinds {
+		template, err := DefaultTemplate(kind, DefaultLocale)
+		if !assert.NoError(t, err, kind) {
+			continue
+		}
+		message, err := Render(template, SampleData(kind))
+		assert.NoError(t, err, kind)
+		assert.NotEmpty(t, message.Subject, kind)
+		assert.NotEmpty(t, message.HTML, kind)
+		assert.NotEmpty(t, message.Text, kind)
+	}
+
+	_, err := DefaultTemplate("postcard", DefaultLocale)
+	assert.ErrorIs(t, err, ErrUnknownKind)
+	_, err = DefaultTemplate(KindReset, "xx")
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+}
+
+// TestResolveTemplate function to test the templates fall back by locale and the project templates come first
+func TestResolveTemplate(t *testing.T) {
+	assert.Equal(t, []string{"pt-BR", "pt", "de", "en"}, FallbackLocales("pt-BR", "de", "pt"))
+	assert.Equal(t, []string{"fr-CH", "fr", "en"}, AcceptLocales("fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5"))
+	locale, err := ParseLocale("pt-br")
+	assert.NoError(t, err)
+	assert.Equal(t, "pt-BR", locale)
+
+	project := []*Template{
+		{Kind: KindReset, Locale: "pt", Subject: "Redefina sua senha", HTML: "<p>{{ .Code }}</p>"},
+		{Kind: KindReset, Locale: "en", Subject: "Reset your {{ .Project }} password", HTML: "<p>{{ .Code }}</p>", Text: "{{ .Code }}"},
+		{Kind: KindVerification, Locale: "pt", Subject: "Verifique seu email", Text: "{{ .Code }}"},
+	}
+
+	// pt-BR falls back to the pt template, the missing text is the embedded one
+	template, err := Resolve(KindReset, []string{"pt-BR"}, project)
+	assert.NoError(t, err)
+	assert.Equal(t, "pt", template.Locale)
+	assert.Equal(t, "Redefina sua senha", template.Subject)
+	assert.Contains(t, template.Text, "Reset Password")
+
+	// the project template replaces the embedded one of the default locale
+	template, err = Resolve(KindReset, []string{"de"}, project)
+	assert.NoError(t, err)
+	message, err := Render(template, SampleData(KindReset))
+	assert.NoError(t, err)
+	assert.Equal(t, "Reset your Example password", message.Subject)
+	assert.Equal(t, "123456", message.Text)
+
+	// a text only template stays without html
+	template, err = Resolve(KindVerification, []string{"pt"}, project)
+	assert.NoError(t, err)
+	message, err = Render(template, SampleData(KindVerification))
+	assert.NoError(t, err)
+	assert.Empty(t, message.HTML)
+	assert.Equal(t, "123456", message.Text)
+
+	// kinds without a project template use the embedded ones
+	template, err = Resolve(KindMagicLink, []string{"pt"}, project)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLocale, template.Locale)
+
+	_, err = Render(&Template{Kind: KindReset, Subject: "{{ .Missing }}"}, SampleData(KindReset))
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/emrgen/authbase/pkg/config"
+	gomail "gopkg.in/mail.v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SMTPMailer sends the messages to a smtp server
+type SMTPMailer struct {
+	config config.SMTPConfig
+}
+
+var _ Mailer = new(SMTPMailer)
+
+// NewSMTPMailer creates a new smtp mailer.
+func NewSMTPMailer(cfg config.SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{config: cfg}
+}
+
+func (m *SMTPMailer) Send(message *Message) error {
+	return m.dialer().DialAndSend(compose(message))
+}
+
+// dialer connects with implicit tls, upgrades the connection with starttls, or stays in plain text by the tls mode
+func (m *SMTPMailer) dialer() *gomail.Dialer {
+	d := gomail.NewDialer(m.config.Host, m.config.Port, m.config.Username, m.config.Password)
+	d.TLSConfig = &tls.Config{ServerName: m.config.Host, MinVersion: tls.VersionTLS12}
+	switch m.config.TLS {
+	case "tls":
+		d.SSL = true
+	case "none":
+		d.SSL = false
+		d.StartTLSPolicy = gomail.NoStartTLS
+	default:
+		d.SSL = false
+		d.StartTLSPolicy = gomail.MandatoryStartTLS
+	}
+
+	return d
+}
+
+// FileMailer writes the messages to a maildir for development, the mail clients read them from the new directory
+type FileMailer struct {
+	dir   string
+	count atomic.Uint64
+}
+
+var _ Mailer = new(FileMailer)
+
+// NewFileMailer creates a new maildir mailer.
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{dir: dir}
+}
+
+// Send writes the message to the tmp directory and moves it to the new directory, the maildir readers never see a partial message
+func (m *FileMailer) Send(message *Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.dir, sub), 0o700); err != nil {
+			return err
+		}
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().UnixNano(), os.Getpid(), m.count.Add(1), host)
+	tmp := filepath.Join(m.dir, "tmp", name)
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := compose(message).WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+// MemoryMailer keeps the sent messages in memory, the tests read them back
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+var _ Mailer = new(MemoryMailer)
+
+// NewMemoryMailer creates a new in memory mailer.
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := *message
+	m.messages = append(m.messages, &sent)
+	return nil
+}
+
+// Messages returns the sent messages in the order they were sent
+func (m *MemoryMailer) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
+
+// Reset drops the sent messages
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -1 +1,90 @@
 package oauth
+
+import (
+	"context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+	"strconv"
+)
+
+var _ Provider = new(GitHubProvider)
+
+const githubAPIURL = "https://api.github.com"
+
+// GitHubProvider is an implementation of the Provider interface for GitHub OAuth apps
+type GitHubProvider struct {
+	config oauth2.Config
+	apiURL string
+}
+
+// NewGitHubProvider creates a new GitHubProvider instance, the endpoint and the scopes default to the GitHub ones
+func NewGitHubProvider(config oauth2.Config) *GitHubProvider {
+	if config.Endpoint.AuthURL == "" {
+		config.Endpoint = endpoints.GitHub
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"read:user", "user:email"}
+	}
+
+	return &GitHubProvider{
+		config: config,
+		apiURL: githubAPIURL,
+	}
+}
+
+func (g *GitHubProvider) GetName() string {
+	return "github"
+}
+
+func (g *GitHubProvider) GetType() string {
+	return "oauth2"
+}
+
+func (g *GitHubProvider) AuthCodeURL(state, verifier string) string {
+	return authCodeURL(&g.config, state, verifier)
+}
+
+func (g *GitHubProvider) GetToken(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	return exchange(ctx, &g.config, code, verifier)
+}
+
+// GetUser fetches the GitHub user and its primary email.
+// The profile email is public and unverified, the verified primary email is read from the emails api.
+func (g *GitHubProvider) GetUser(ctx context.Context, token *oauth2.Token) (*User, error) {
+	client := g.config.Client(ctx, token)
+
+	var profile struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(ctx, client, g.apiURL+"/user", &profile); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:       strconv.FormatInt(profile.ID, 10),
+		Email:    profile.Email,
+		Name:     profile.Name,
+		Username: profile.Login,
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, g.apiURL+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		if email.Primary {
+			user.Email = email.Email
+			user.EmailVerified = email.Verified
+			break
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,44 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestGitHubGetUser function to test that the verified primary email replaces the public profile email
+func TestGitHubGetUser(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": 42, "login": "octocat", "name": "Octo Cat", "email": "public@example.com"})
+	})
+	mux.HandleFunc("GET /user/emails", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"email": "other@example.com", "primary": false, "verified": true},
+			{"email": "octo@example.com", "primary": true, "verified": true},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := NewGitHubProvider(oauth2.Config{ClientID: "client"})
+	provider.apiURL = server.URL
+
+	user, err := provider.GetUser(context.Background(), &oauth2.Token{AccessToken: "token", TokenType: "Bearer"})
+	assert.NoError(t, err)
+	assert.Equal(t, "42", user.ID)
+	assert.Equal(t, "octocat", user.Username)
+	assert.Equal(t, "octo@example.com", user.Email)
+	assert.True(t, user.EmailVerified)
+
+	// the consent url carries the state and the PKCE challenge
+	url := provider.AuthCodeURL("state", oauth2.GenerateVerifier())
+	assert.Contains(t, url, "https://github.com/login/oauth/authorize")
+	assert.Contains(t, url, "state=state")
+	assert.Contains(t, url, "code_challenge_method=S256")
+}
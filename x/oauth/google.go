@@ -2,20 +2,33 @@ package oauth
 
 import (
 	"context"
+	"errors"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
 )
 
 var _ Provider = new(GoogleProvider)
 
+const googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
 // GoogleProvider is an implementation of the Provider interface for Google OAuth2
 type GoogleProvider struct {
-	config oauth2.Config
+	config      oauth2.Config
+	userInfoURL string
 }
 
-// NewGoogleProvider creates a new GoogleProvider instance
+// NewGoogleProvider creates a new GoogleProvider instance, the endpoint and the scopes default to the Google ones
 func NewGoogleProvider(config oauth2.Config) *GoogleProvider {
+	if config.Endpoint.AuthURL == "" {
+		config.Endpoint = endpoints.Google
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+
 	return &GoogleProvider{
-		config: config,
+		config:      config,
+		userInfoURL: googleUserInfoURL,
 	}
 }
 
@@ -27,6 +40,33 @@ func (g *GoogleProvider) GetType() string {
 	return "oauth2"
 }
 
-func (g *GoogleProvider) GetToken(ctx context.Context, code string) (*oauth2.Token, error) {
-	return g.config.Exchange(ctx, code)
+func (g *GoogleProvider) AuthCodeURL(state, verifier string) string {
+	return authCodeURL(&g.config, state, verifier)
+}
+
+func (g *GoogleProvider) GetToken(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	return exchange(ctx, &g.config, code, verifier)
+}
+
+// GetUser fetches the OpenID Connect userinfo of the token
+func (g *GoogleProvider) GetUser(ctx context.Context, token *oauth2.Token) (*User, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := getJSON(ctx, g.config.Client(ctx, token), g.userInfoURL, &info); err != nil {
+		return nil, err
+	}
+	if info.Sub == "" {
+		return nil, errors.New("oauth: google userinfo has no subject")
+	}
+
+	return &User{
+		ID:            info.Sub,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Name:          info.Name,
+	}, nil
 }
@@ -0,0 +1,363 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/emrgen/authbase/x"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Provider = new(OIDCProvider)
+
+// idTokenAlgorithms are the accepted upstream id token signature algorithms
+var idTokenAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+const (
+	// oidcCacheDuration is how long the discovery document and the key set of an issuer are reused
+	oidcCacheDuration = time.Hour
+	// oidcKeysRefreshInterval is the least time between two key set fetches for unknown key ids
+	oidcKeysRefreshInterval = time.Minute
+	// oidcTimeout bounds every request to an issuer
+	oidcTimeout = 10 * time.Second
+)
+
+// oidcClient makes the requests to the issuers, a slow issuer does not hold the logins forever
+var oidcClient = &http.Client{Timeout: oidcTimeout}
+
+// oidcIssuers caches the discovery documents and the key sets by issuer, the providers of a login share them
+var oidcIssuers = struct {
+	sync.Mutex
+	issuers map[string]*oidcIssuer
+}{issuers: make(map[string]*oidcIssuer)}
+
+// oidcIssuer is the cached discovery document and key set of an issuer.
+// The lock is held during the fetches so that the concurrent logins wait for one fetch.
+type oidcIssuer struct {
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	keys         *x.JWKS
+	keysAt       time.Time
+}
+
+// OIDCConfig configures a generic OpenID Connect provider.
+// The claim names map the upstream id token claims onto the user, nested claims are separated by dots.
+type OIDCConfig struct {
+	Issuer      string
+	EmailClaim  string
+	NameClaim   string
+	GroupsClaim string
+}
+
+// oidcDiscovery is the part of the upstream discovery document used by the provider
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider is an implementation of the Provider interface for OpenID Connect providers (Okta, Azure AD, Keycloak, Auth0...).
+// The endpoints are read from the issuer discovery document and the user is read from the verified id token.
+type OIDCProvider struct {
+	config    oauth2.Config
+	oidc      OIDCConfig
+	discovery *oidcDiscovery
+	issuer    *oidcIssuer
+}
+
+// NewOIDCProvider fetches the issuer discovery document and creates a new OIDCProvider instance.
+// The scopes default to openid, profile and email, the claims default to the standard ones.
+func NewOIDCProvider(ctx context.Context, config oauth2.Config, oidc OIDCConfig) (*OIDCProvider, error) {
+	if oidc.Issuer == "" {
+		return nil, errors.New("oidc: missing issuer")
+	}
+	if oidc.EmailClaim == "" {
+		oidc.EmailClaim = "email"
+	}
+	if oidc.NameClaim == "" {
+		oidc.NameClaim = "name"
+	}
+	if oidc.GroupsClaim == "" {
+		oidc.GroupsClaim = "groups"
+	}
+
+	issuer := strings.TrimSuffix(oidc.Issuer, "/")
+	cached := cachedIssuer(issuer)
+	discovery, err := cached.getDiscovery(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Endpoint = oauth2.Endpoint{
+		AuthURL:  discovery.AuthorizationEndpoint,
+		TokenURL: discovery.TokenEndpoint,
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	return &OIDCProvider{
+		config:    config,
+		oidc:      oidc,
+		discovery: discovery,
+		issuer:    cached,
+	}, nil
+}
+
+// cachedIssuer returns the cache entry of the issuer, creating it on first use
+func cachedIssuer(issuer string) *oidcIssuer {
+	oidcIssuers.Lock()
+	defer oidcIssuers.Unlock()
+
+	cached, ok := oidcIssuers.issuers[issuer]
+	if !ok {
+		cached = &oidcIssuer{}
+		oidcIssuers.issuers[issuer] = cached
+	}
+
+	return cached
+}
+
+// getDiscovery returns the discovery document of the issuer, it is fetched again after the cache duration
+func (i *oidcIssuer) getDiscovery(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.discovery != nil && time.Since(i.discoveredAt) < oidcCacheDuration {
+		return i.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := getJSON(ctx, oidcClient, issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	// the discovery document must belong to the issuer, the id tokens are checked against it
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %s does not match %s", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	i.discovery, i.discoveredAt = &discovery, time.Now()
+
+	return i.discovery, nil
+}
+
+// key returns the signing key of the id tokens with the kid. The key set is fetched again after the cache
+// duration, or for an unknown kid after a rotation of the issuer keys, at most once per refresh interval.
+func (i *oidcIssuer) key(ctx context.Context, jwksURI, kid string) (*x.JWK, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.keys == nil || time.Since(i.keysAt) >= oidcCacheDuration {
+		if err := i.fetchKeys(ctx, jwksURI); err != nil {
+			return nil, err
+		}
+	}
+	if key := findJWK(i.keys, kid); key != nil {
+		return key, nil
+	}
+	if time.Since(i.keysAt) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	if err := i.fetchKeys(ctx, jwksURI); err != nil {
+		return nil, err
+	}
+	if key := findJWK(i.keys, kid); key != nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+func (i *oidcIssuer) fetchKeys(ctx context.Context, jwksURI string) error {
+	var keys x.JWKS
+	if err := getJSON(ctx, oidcClient, jwksURI, &keys); err != nil {
+		return err
+	}
+	i.keys, i.keysAt = &keys, time.Now()
+
+	return nil
+}
+
+// findJWK returns the key with the kid, a key set with a single key may omit the key ids
+func findJWK(keys *x.JWKS, kid string) *x.JWK {
+	key := keys.Key(kid)
+	if key == nil && kid == "" && len(keys.Keys) == 1 {
+		key = keys.Keys[0]
+	}
+
+	return key
+}
+
+func (o *OIDCProvider) GetName() string {
+	return "oidc"
+}
+
+func (o *OIDCProvider) GetType() string {
+	return "oidc"
+}
+
+// AuthCodeURL returns the consent page url, the nonce is derived from the verifier so that it is not stored separately
+func (o *OIDCProvider) AuthCodeURL(state, verifier string) string {
+	if verifier == "" {
+		return o.config.AuthCodeURL(state)
+	}
+
+	return o.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", oidcNonce(verifier)))
+}
+
+// GetToken exchanges the code and verifies the returned id token and its nonce
+func (o *OIDCProvider) GetToken(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, oidcClient)
+	token, err := exchange(ctx, &o.config, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := ""
+	if verifier != "" {
+		nonce = oidcNonce(verifier)
+	}
+	if _, err := o.verifyIDToken(ctx, token, nonce); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetUser maps the id token claims onto the user.
+// The userinfo endpoint fills in the claims missing from the id token.
+func (o *OIDCProvider) GetUser(ctx context.Context, token *oauth2.Token) (*User, error) {
+	claims, err := o.verifyIDToken(ctx, token, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if o.discovery.UserInfoEndpoint != "" && (claimValue(claims, o.oidc.EmailClaim) == nil || claimValue(claims, o.oidc.NameClaim) == nil) {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, oidcClient)
+		var info map[string]interface{}
+		if err := getJSON(ctx, o.config.Client(ctx, token), o.discovery.UserInfoEndpoint, &info); err != nil {
+			return nil, err
+		}
+		// the userinfo response must be about the id token subject
+		if info["sub"] != claims["sub"] {
+			return nil, errors.New("oidc: userinfo subject does not match the id token")
+		}
+		for name, value := range info {
+			if _, ok := claims[name]; !ok {
+				claims[name] = value
+			}
+		}
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("oidc: id token without subject")
+	}
+	email, _ := claimValue(claims, o.oidc.EmailClaim).(string)
+	name, _ := claimValue(claims, o.oidc.NameClaim).(string)
+	username, _ := claims["preferred_username"].(string)
+
+	return &User{
+		ID:            subject,
+		Email:         email,
+		EmailVerified: claimBool(claims["email_verified"]),
+		Name:          name,
+		Username:      username,
+		Groups:        claimStrings(claimValue(claims, o.oidc.GroupsClaim)),
+	}, nil
+}
+
+// verifyIDToken checks the id token signature against the upstream key set, its issuer, audience, expiry and nonce
+func (o *OIDCProvider) verifyIDToken(ctx context.Context, token *oauth2.Token, nonce string) (jwt.MapClaims, error) {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return nil, errors.New("oidc: token response without id token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := o.issuer.key(ctx, o.discovery.JwksURI, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		return key.PublicKey()
+	},
+		jwt.WithValidMethods(idTokenAlgorithms),
+		jwt.WithIssuer(o.discovery.Issuer),
+		jwt.WithAudience(o.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid id token: %w", err)
+	}
+	if nonce != "" && claims["nonce"] != nonce {
+		return nil, errors.New("oidc: id token nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// oidcNonce derives the id token nonce from the PKCE verifier.
+// It is salted so that it differs from the S256 code challenge.
+func oidcNonce(verifier string) string {
+	sum := sha256.Sum256([]byte("nonce:" + verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// claimValue returns the claim at the dot separated path, e.g. realm_access.roles
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+
+	return value
+}
+
+// claimBool reads a boolean claim, some providers send it as a string
+func claimBool(value interface{}) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	default:
+		return false
+	}
+}
+
+// claimStrings reads a list claim, a single string is a list of one
+func claimStrings(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
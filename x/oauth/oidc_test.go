@@ -0,0 +1,199 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/emrgen/authbase/x"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOIDCProvider function to test the code login against a mock oidc server with a keycloak style groups claim
+func TestOIDCProvider(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	jwk, err := x.NewJWK("kid-1", "ES256", &key.PublicKey)
+	assert.NoError(t, err)
+
+	var server *httptest.Server
+	var nonce string
+	idToken := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "kid-1"
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&x.JWKS{Keys: []*x.JWK{jwk}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.NotEmpty(t, r.PostForm.Get("code_verifier"))
+		audience := "client"
+		if r.PostForm.Get("code") == "other-audience" {
+			audience = "other"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"id_token": idToken(jwt.MapClaims{
+				"iss":            server.URL,
+				"sub":            "user-1",
+				"aud":            audience,
+				"exp":            time.Now().Add(time.Minute).Unix(),
+				"nonce":          nonce,
+				"mail":           "jane@example.com",
+				"email_verified": "true",
+				"name":           "Jane",
+				"realm_access":   map[string]interface{}{"roles": []string{"admins", "developers"}},
+			}),
+		})
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := NewOIDCProvider(context.Background(), oauth2.Config{ClientID: "client"}, OIDCConfig{
+		Issuer:      server.URL + "/",
+		EmailClaim:  "mail",
+		GroupsClaim: "realm_access.roles",
+	})
+	assert.NoError(t, err)
+
+	// the consent url carries the nonce derived from the verifier
+	verifier := oauth2.GenerateVerifier()
+	consent, err := url.Parse(provider.AuthCodeURL("state", verifier))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/authorize", consent.Scheme+"://"+consent.Host+consent.Path)
+	nonce = consent.Query().Get("nonce")
+	assert.Equal(t, oidcNonce(verifier), nonce)
+	assert.Equal(t, "openid profile email", consent.Query().Get("scope"))
+
+	token, err := provider.GetToken(context.Background(), "code", verifier)
+	assert.NoError(t, err)
+	user, err := provider.GetUser(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", user.ID)
+	assert.Equal(t, "jane@example.com", user.Email)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, "Jane", user.Name)
+	assert.Equal(t, []string{"admins", "developers"}, user.Groups)
+
+	// the id token must be issued for the client and the login
+	_, err = provider.GetToken(context.Background(), "other-audience", verifier)
+	assert.Error(t, err)
+	_, err = provider.GetToken(context.Background(), "code", oauth2.GenerateVerifier())
+	assert.Error(t, err)
+
+	// an id token signed by another key is rejected
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": server.URL, "sub": "user-1", "aud": "client", "exp": time.Now().Add(time.Minute).Unix()})
+	forged.Header["kid"] = "kid-1"
+	signed, err := forged.SignedString(other)
+	assert.NoError(t, err)
+	_, err = provider.GetUser(context.Background(), (&oauth2.Token{AccessToken: "token"}).WithExtra(map[string]interface{}{"id_token": signed}))
+	assert.Error(t, err)
+}
+
+// TestOIDCProviderCache function to test that the discovery document and the key set are fetched once per issuer
+func TestOIDCProviderCache(t *testing.T) {
+	var server *httptest.Server
+	var discoveries, fetches int
+	var mu sync.Mutex
+	keys := map[string]*ecdsa.PrivateKey{}
+	jwks := &x.JWKS{}
+	addKey := func(kid string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		jwk, err := x.NewJWK(kid, "ES256", &key.PublicKey)
+		assert.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		keys[kid] = key
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	addKey("kid-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		discoveries++
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	idToken := func(kid string) *oauth2.Token {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": server.URL, "sub": "user-1", "aud": "client", "exp": time.Now().Add(time.Minute).Unix()})
+		token.Header["kid"] = kid
+		mu.Lock()
+		signed, err := token.SignedString(keys[kid])
+		mu.Unlock()
+		assert.NoError(t, err)
+		return (&oauth2.Token{AccessToken: "token"}).WithExtra(map[string]interface{}{"id_token": signed})
+	}
+
+	// every login creates a provider, they share the cached issuer
+	for i := 0; i < 3; i++ {
+		provider, err := NewOIDCProvider(context.Background(), oauth2.Config{ClientID: "client"}, OIDCConfig{Issuer: server.URL})
+		assert.NoError(t, err)
+		_, err = provider.GetUser(context.Background(), idToken("kid-1"))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, discoveries)
+	assert.Equal(t, 1, fetches)
+
+	provider, err := NewOIDCProvider(context.Background(), oauth2.Config{ClientID: "client"}, OIDCConfig{Issuer: server.URL})
+	assert.NoError(t, err)
+
+	// an unknown kid does not refetch the key set within the refresh interval
+	addKey("kid-2")
+	_, err = provider.GetUser(context.Background(), idToken("kid-2"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, fetches)
+
+	// after the refresh interval the rotated key set is fetched once
+	cached := cachedIssuer(server.URL)
+	cached.mu.Lock()
+	cached.keysAt = time.Now().Add(-oidcKeysRefreshInterval)
+	cached.mu.Unlock()
+	_, err = provider.GetUser(context.Background(), idToken("kid-2"))
+	assert.NoError(t, err)
+	_, err = provider.GetUser(context.Background(), idToken("kid-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+}
@@ -2,8 +2,11 @@ package oauth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"golang.org/x/oauth2"
+	"net/http"
 )
 
 // Provider is an interface for OAuth providers
@@ -12,8 +15,24 @@ type Provider interface {
 	GetName() string
 	// GetType returns the type of the provider
 	GetType() string
-	// GetToken returns the token from the provider
-	GetToken(ctx context.Context, code string) (*oauth2.Token, error)
+	// AuthCodeURL returns the consent page url of the provider, the verifier is the PKCE code verifier
+	AuthCodeURL(state, verifier string) string
+	// GetToken returns the token from the provider, the verifier must match the one of AuthCodeURL
+	GetToken(ctx context.Context, code, verifier string) (*oauth2.Token, error)
+	// GetUser returns the profile of the user that authorized the token
+	GetUser(ctx context.Context, token *oauth2.Token) (*User, error)
+}
+
+// User is the profile of a user at the identity provider
+type User struct {
+	// ID is the stable id of the user at the provider
+	ID            string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+	// Groups are the upstream group names, the pool groups of the same name are synced to the account
+	Groups []string
 }
 
 // GetProvider returns the provider based on the name
@@ -21,6 +40,8 @@ func GetProvider(name string, config oauth2.Config) (Provider, error) {
 	switch name {
 	case "google":
 		return NewGoogleProvider(config), nil
+	case "github":
+		return NewGitHubProvider(config), nil
 	default:
 		return nil, ErrUnsupportedProvider
 	}
@@ -28,3 +49,42 @@ func GetProvider(name string, config oauth2.Config) (Provider, error) {
 
 // ErrUnsupportedProvider is returned when the provider is not supported
 var ErrUnsupportedProvider = errors.New("unsupported provider")
+
+// authCodeURL returns the consent page url with the PKCE challenge of the verifier
+func authCodeURL(config *oauth2.Config, state, verifier string) string {
+	if verifier == "" {
+		return config.AuthCodeURL(state)
+	}
+
+	return config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+// exchange exchanges the code for a token with the PKCE verifier
+func exchange(ctx context.Context, config *oauth2.Config, code, verifier string) (*oauth2.Token, error) {
+	if verifier == "" {
+		return config.Exchange(ctx, code)
+	}
+
+	return config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+}
+
+// getJSON fetches a provider api resource with the token client
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth: %s returned %s", url, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,75 @@
+package x
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"time"
+)
+
+// IDTokenDuration is the lifetime of an OpenID Connect id token
+const IDTokenDuration = time.Hour
+
+// UserInfo holds the standard claims of an account (OpenID Connect Core 5.1).
+// The claims are filtered by the granted profile and email scopes.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// NewUserInfo creates the user info of an account, only the claims allowed by the scopes are set
+func NewUserInfo(subject, name, username, email string, emailVerified bool, scopes []string) *UserInfo {
+	info := &UserInfo{Subject: subject}
+	if slices.Contains(scopes, ScopeProfile) {
+		info.Name = name
+		info.PreferredUsername = username
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		info.Email = email
+		info.EmailVerified = &emailVerified
+	}
+
+	return info
+}
+
+// IDTokenClaims are the claims of an OpenID Connect id token (OpenID Connect Core 2)
+type IDTokenClaims struct {
+	*UserInfo
+	Issuer   string
+	Audience string // the client id
+	Nonce    string
+	AuthTime time.Time
+	ExpireAt time.Time
+	IssuedAt time.Time
+}
+
+// GenerateIDToken signs the id token claims
+func GenerateIDToken(claims *IDTokenClaims, signer JWTSigner) (string, error) {
+	claim := jwt.MapClaims{
+		"iss":       claims.Issuer,
+		"sub":       claims.Subject,
+		"aud":       claims.Audience,
+		"exp":       claims.ExpireAt.Unix(),
+		"iat":       claims.IssuedAt.Unix(),
+		"auth_time": claims.AuthTime.Unix(),
+	}
+	if claims.Nonce != "" {
+		claim["nonce"] = claims.Nonce
+	}
+	if claims.Name != "" {
+		claim["name"] = claims.Name
+	}
+	if claims.PreferredUsername != "" {
+		claim["preferred_username"] = claims.PreferredUsername
+	}
+	if claims.Email != "" {
+		claim["email"] = claims.Email
+	}
+	if claims.EmailVerified != nil {
+		claim["email_verified"] = *claims.EmailVerified
+	}
+
+	return signer.Sign(claim)
+}
@@ -0,0 +1,44 @@
+package x
+
+import (
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestGenerateIDToken function to test the id token claims and the scope filtering
+func TestGenerateIDToken(t *testing.T) {
+	key, err := GenerateSigningKey("ES256")
+	assert.NoError(t, err)
+	signer, err := NewKeySigner("kid-1", "ES256", key)
+	assert.NoError(t, err)
+	verifier := NewKeySetVerifier(func(kid string) (crypto.PublicKey, string, error) {
+		return key.Public(), "ES256", nil
+	})
+
+	authTime := time.Now().Add(-time.Minute)
+	tokenString, err := GenerateIDToken(&IDTokenClaims{
+		UserInfo: NewUserInfo("account", "Jane Doe", "jane", "jane@example.com", true, []string{ScopeOpenID, ScopeEmail}),
+		Issuer:   "http://localhost:4001/pools/pool",
+		Audience: "client",
+		Nonce:    "n-0S6_WzA2Mj",
+		AuthTime: authTime,
+		ExpireAt: time.Now().Add(IDTokenDuration),
+		IssuedAt: time.Now(),
+	}, signer)
+	assert.NoError(t, err)
+
+	claims, err := verifier.Verify(tokenString)
+	assert.NoError(t, err)
+	assert.Equal(t, "account", claims["sub"])
+	assert.Equal(t, "http://localhost:4001/pools/pool", claims["iss"])
+	assert.Equal(t, "client", claims["aud"])
+	assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(t, float64(authTime.Unix()), claims["auth_time"])
+	assert.Equal(t, "jane@example.com", claims["email"])
+	assert.Equal(t, true, claims["email_verified"])
+	// the profile claims need the profile scope
+	assert.NotContains(t, claims, "name")
+	assert.NotContains(t, claims, "preferred_username")
+}
@@ -0,0 +1,45 @@
+package x
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// PKCE code challenge methods (RFC 7636), only S256 is accepted
+const (
+	CodeChallengeMethodS256 = "S256"
+)
+
+// ValidCodeVerifier checks the length and the character set of a PKCE code verifier
+func ValidCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	for _, c := range verifier {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '.' || c == '_' || c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// S256CodeChallenge derives the S256 code challenge of a code verifier
+func S256CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCodeChallenge checks the code verifier against the challenge of the authorization request
+func VerifyCodeChallenge(verifier, challenge, method string) bool {
+	if method != CodeChallengeMethodS256 || !ValidCodeVerifier(verifier) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(S256CodeChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,18 @@
+package x
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestVerifyCodeChallenge function to test the PKCE S256 check with the RFC 7636 appendix B example
+func TestVerifyCodeChallenge(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.Equal(t, challenge, S256CodeChallenge(verifier))
+	assert.True(t, VerifyCodeChallenge(verifier, challenge, CodeChallengeMethodS256))
+	assert.False(t, VerifyCodeChallenge(verifier, challenge, "plain"))
+	assert.False(t, VerifyCodeChallenge(verifier+"x", challenge, CodeChallengeMethodS256))
+	assert.False(t, VerifyCodeChallenge("short", S256CodeChallenge("short"), CodeChallengeMethodS256))
+}
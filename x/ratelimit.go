@@ -0,0 +1,157 @@
+package x
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/cache"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter counts the requests of a key against a quota
+type RateLimiter interface {
+	// Allow takes a request from the quota of the key, a used up quota returns false with the wait for the next request
+	Allow(key string, quota config.RateQuota) (bool, time.Duration, error)
+}
+
+// MemoryRateLimiter keeps a token bucket per key in memory, the quotas are per replica
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	sweptAt time.Time
+}
+
+var _ RateLimiter = new(MemoryRateLimiter)
+
+// NewMemoryRateLimiter creates a new in memory rate limiter.
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*tokenBucket), sweptAt: time.Now()}
+}
+
+func (l *MemoryRateLimiter) Allow(key string, quota config.RateQuota) (bool, time.Duration, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// full buckets are dropped, they are the same as new ones
+	if now.Sub(l.sweptAt) > time.Minute {
+		for k, bucket := range l.buckets {
+			if now.Sub(bucket.updatedAt) > bucket.period {
+				delete(l.buckets, k)
+			}
+		}
+		l.sweptAt = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(quota.Requests), updatedAt: now}
+		l.buckets[key] = bucket
+	}
+	allowed, wait := bucket.take(now, quota)
+
+	return allowed, wait, nil
+}
+
+// tokenBucket holds up to the quota requests and refills them over the quota period
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	period    time.Duration
+}
+
+func (b *tokenBucket) take(now time.Time, quota config.RateQuota) (bool, time.Duration) {
+	rate := float64(quota.Requests) / quota.Period.Seconds()
+	b.tokens = math.Min(float64(quota.Requests), b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+	b.period = quota.Period
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// CacheRateLimiter keeps the token buckets in the cache, the quotas are shared by the replicas
+type CacheRateLimiter struct {
+	cache *cache.Redis
+}
+
+var _ RateLimiter = new(CacheRateLimiter)
+
+// NewCacheRateLimiter creates a new rate limiter backed by the cache.
+func NewCacheRateLimiter(cache *cache.Redis) *CacheRateLimiter {
+	return &CacheRateLimiter{cache: cache}
+}
+
+func (l *CacheRateLimiter) Allow(key string, quota config.RateQuota) (bool, time.Duration, error) {
+	return l.cache.TakeToken("ratelimit:"+key, quota.Requests, quota.Period)
+}
+
+// RateLimitInterceptor rejects the requests over the quota of the method with ResourceExhausted and a RetryInfo,
+// the gateway answers them with 429. It runs after the AuthInterceptor to count the requests per caller.
+func RateLimitInterceptor(limiter RateLimiter, limits *config.RateLimitConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		quota, ok := limits.Quota(info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		allowed, wait, err := limiter.Allow(info.FullMethod+":"+RateLimitKey(ctx), quota)
+		if err != nil {
+			// an unavailable backend does not take the api down
+			logrus.Errorf("authbase: rate limit: %v", err)
+			return handler(ctx, req)
+		}
+		if !allowed {
+			return nil, RateLimitError(ctx, wait)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitKey returns the caller the requests are counted for: the access key, the account or the client
+// of an authenticated request, else the address of the caller, forwarded addresses count only from trusted proxies.
+func RateLimitKey(ctx context.Context) string {
+	if accountID, ok := ctx.Value(AccountIDKey).(uuid.UUID); ok {
+		if token, err := TokenFromHeader(ctx, "Bearer"); err == nil {
+			if accessKey, err := ParseAccessKey(token); err == nil && accessKey != nil {
+				return "access_key:" + accessKey.ID.String()
+			}
+		}
+		return "account:" + accountID.String()
+	}
+	if clientID, ok := ctx.Value(ClientIDKey).(uuid.UUID); ok {
+		return "client:" + clientID.String()
+	}
+
+	return "ip:" + RequestIP(ctx)
+}
+
+// RateLimitError returns the rejection with the wait as a RetryInfo detail and a retry-after header
+func RateLimitError(ctx context.Context, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+
+	st := status.Newf(codes.ResourceExhausted, "rate limit exceeded, retry after %d seconds", seconds)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
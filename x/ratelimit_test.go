@@ -0,0 +1,104 @@
+package x
+
+import (
+	"context"
+	"github.com/emrgen/authbase/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestMemoryRateLimiter function to test the token bucket refilling over the quota period
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	quota := config.RateQuota{Requests: 2, Period: 100 * time.Millisecond}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := limiter.Allow("key", quota)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, wait, err := limiter.Allow("key", quota)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Greater(t, wait, time.Duration(0))
+	assert.LessOrEqual(t, wait, 50*time.Millisecond)
+
+	// the other keys have their own bucket
+	allowed, _, _ = limiter.Allow("other", quota)
+	assert.True(t, allowed)
+
+	time.Sleep(wait)
+	allowed, _, _ = limiter.Allow("key", quota)
+	assert.True(t, allowed)
+}
+
+// TestRateLimitInterceptor function to test the rejection of the requests over the method quota
+func TestRateLimitInterceptor(t *testing.T) {
+	limits := &config.RateLimitConfig{
+		Methods: []config.MethodQuota{{Name: "AccountService/ListAccounts", Quota: config.RateQuota{Requests: 1, Period: time.Minute}}},
+	}
+	interceptor := RateLimitInterceptor(NewMemoryRateLimiter(), limits)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 52100}})
+
+	list := &grpc.UnaryServerInfo{FullMethod: "/authbase.apis.v1.AccountService/ListAccounts"}
+	_, err := interceptor(ctx, nil, list, handler)
+	assert.NoError(t, err)
+	_, err = interceptor(ctx, nil, list, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	details := status.Convert(err).Details()
+	if assert.Len(t, details, 1) {
+		retry, ok := details[0].(*errdetails.RetryInfo)
+		assert.True(t, ok)
+		assert.Greater(t, retry.GetRetryDelay().AsDuration(), time.Duration(0))
+	}
+
+	// another address has its own quota, the methods without a quota are not limited
+	other := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 52100}})
+	_, err = interceptor(other, nil, list, handler)
+	assert.NoError(t, err)
+	get := &grpc.UnaryServerInfo{FullMethod: "/authbase.apis.v1.AccountService/GetAccount"}
+	for i := 0; i < 3; i++ {
+		_, err = interceptor(ctx, nil, get, handler)
+		assert.NoError(t, err)
+	}
+}
+
+// TestRateLimitQuota function to test the most specific quota of a method is used, whatever the configured order
+func TestRateLimitQuota(t *testing.T) {
+	service := config.RateQuota{Requests: 10, Period: time.Second}
+	method := config.RateQuota{Requests: 1, Period: time.Second}
+	route := config.RateQuota{Requests: 5, Period: time.Minute}
+	limits := &config.RateLimitConfig{
+		Default: config.RateQuota{Requests: 100, Period: time.Second},
+		Methods: []config.MethodQuota{
+			{Name: "/authbase.apis.v1.AccountService/", Quota: service},
+			{Name: "AccountService/ListAccounts", Quota: method},
+			{Name: "/auth/idp/", Quota: route},
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		quota, ok := limits.Quota("/authbase.apis.v1.AccountService/ListAccounts")
+		assert.True(t, ok)
+		assert.Equal(t, method, quota)
+	}
+	quota, _ := limits.Quota("/authbase.apis.v1.AccountService/GetAccount")
+	assert.Equal(t, service, quota)
+	quota, _ = limits.Quota("/auth/idp/{provider}/callback")
+	assert.Equal(t, route, quota)
+	quota, _ = limits.Quota("/oauth2/token")
+	assert.Equal(t, limits.Default, quota)
+
+	limits.Default = config.RateQuota{}
+	_, ok := limits.Quota("/oauth2/token")
+	assert.False(t, ok)
+}
@@ -0,0 +1,86 @@
+package x
+
+import (
+	"github.com/emrgen/authbase/pkg/cache"
+	"strconv"
+	"time"
+)
+
+// RevocationList records the revoked tokens until they expire on their own.
+// The token signature stays valid after a logout or an account disable, the list is checked after the signature.
+type RevocationList interface {
+	// RevokeToken revokes the token with the jti until its expiry
+	RevokeToken(jti string, expireAt time.Time) error
+	// RevokeSession revokes the tokens of the session
+	RevokeSession(sessionID string) error
+	// RevokeAccount revokes the tokens of the account issued up to now
+	RevokeAccount(accountID string) error
+	// Revoked reports whether the token was revoked
+	Revoked(claims *Claims) (bool, error)
+}
+
+// CacheRevocationList is a revocation list backed by the cache, the entries expire with the tokens they revoke
+type CacheRevocationList struct {
+	cache *cache.Redis
+}
+
+var _ RevocationList = new(CacheRevocationList)
+
+// NewCacheRevocationList creates a new revocation list backed by the cache.
+func NewCacheRevocationList(cache *cache.Redis) *CacheRevocationList {
+	return &CacheRevocationList{cache: cache}
+}
+
+func (r *CacheRevocationList) RevokeToken(jti string, expireAt time.Time) error {
+	remaining := time.Until(expireAt)
+	if remaining <= 0 {
+		return nil
+	}
+
+	return r.cache.Set(revokedTokenKey(jti), "1", remaining)
+}
+
+// RevokeSession revokes the session for the longest life of its access tokens
+func (r *CacheRevocationList) RevokeSession(sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	return r.cache.Set(revokedSessionKey(sessionID), "1", MaxAccessTokenDuration)
+}
+
+// RevokeAccount records the revocation time of the account, the tokens issued in the same second are revoked too
+func (r *CacheRevocationList) RevokeAccount(accountID string) error {
+	return r.cache.Set(revokedAccountKey(accountID), strconv.FormatInt(time.Now().Unix(), 10), MaxRefreshTokenDuration)
+}
+
+func (r *CacheRevocationList) Revoked(claims *Claims) (bool, error) {
+	values, err := r.cache.MGet(revokedTokenKey(claims.Jti), revokedSessionKey(claims.SessionID), revokedAccountKey(claims.AccountID))
+	if err != nil {
+		return false, err
+	}
+	if values[0] != "" || (claims.SessionID != "" && values[1] != "") {
+		return true, nil
+	}
+	if values[2] != "" {
+		revokedAt, err := strconv.ParseInt(values[2], 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return claims.IssuedAt.Unix() <= revokedAt, nil
+	}
+
+	return false, nil
+}
+
+func revokedTokenKey(jti string) string {
+	return "revoked:token:" + jti
+}
+
+func revokedSessionKey(sessionID string) string {
+	return "revoked:session:" + sessionID
+}
+
+func revokedAccountKey(accountID string) string {
+	return "revoked:account:" + accountID
+}
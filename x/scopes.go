@@ -1,6 +1,11 @@
 package x
 
 const (
+	// OpenID Connect scopes, openid requests an id token, profile and email select the user claims
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+
 	ScopeProjectRead   = "project:read"
 	ScopeProjectCreate = "project:create"
 	ScopeProjectUpdate = "project:update"
@@ -0,0 +1,45 @@
+package x
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestTOTP function to test the codes with the RFC 6238 appendix B SHA1 examples
+func TestTOTP(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+	code, err := TOTPCode(secret, TOTPStep(time.Unix(59, 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, "287082", code)
+	code, err = TOTPCode(secret, TOTPStep(time.Unix(1111111109, 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, "081804", code)
+
+	// the previous step is accepted for clock skew, a used step is not
+	now := time.Unix(1111111109+30, 0)
+	step, ok := ValidateTOTP(secret, "081804", now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(time.Unix(1111111109, 0)), step)
+	_, ok = ValidateTOTP(secret, "081804", now, step)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(secret, "081804", now.Add(time.Minute), 0)
+	assert.False(t, ok)
+}
+
+// TestSealSecret function to test that sealed secrets open only with the same application key
+func TestSealSecret(t *testing.T) {
+	sealed, err := SealSecret("app-key", "secret")
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "secret")
+
+	secret, err := OpenSecret("app-key", sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", secret)
+
+	_, err = OpenSecret("other-key", sealed)
+	assert.Error(t, err)
+	_, err = SealSecret("", "secret")
+	assert.ErrorIs(t, err, ErrMissingAppKey)
+}
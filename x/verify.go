@@ -27,13 +27,18 @@ type StoreBasedUserVerifier struct {
 	store       store.Provider
 	redis       *cache.Redis
 	keyProvider JWTSignerVerifierProvider
+	revocations RevocationList
+	guard       LoginGuard
 }
 
 // NewStoreBasedTokenVerifier creates a new StoreBasedUserVerifier.
-func NewStoreBasedTokenVerifier(store store.Provider, redis *cache.Redis) *StoreBasedUserVerifier {
+func NewStoreBasedTokenVerifier(store store.Provider, redis *cache.Redis, keyProvider JWTSignerVerifierProvider) *StoreBasedUserVerifier {
 	return &StoreBasedUserVerifier{
-		store: store,
-		redis: redis,
+		store:       store,
+		redis:       redis,
+		keyProvider: keyProvider,
+		revocations: NewCacheRevocationList(redis),
+		guard:       NewCacheLoginGuard(redis),
 	}
 }
 
@@ -49,20 +54,24 @@ func (v *StoreBasedUserVerifier) VerifyEmailPassword(ctx context.Context, poolID
 	if err != nil {
 		return nil, err
 	}
+	pool, err := as.GetPoolByID(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
 
 	if user.Disabled {
 		return nil, errors.New("user account is disabled")
 	}
 
-	ok := CompareHashAndPassword(password, user.Salt, user.PasswordHash)
-	if !ok {
-		return nil, errors.New("invalid password")
+	if err := VerifyPassword(ctx, v.guard, user, pool.LockoutPolicy, password); err != nil {
+		return nil, err
 	}
+	UpgradePasswordHash(ctx, as, user, password)
 
 	return user, nil
 }
 
-// VerifyToken verifies the token.
+// VerifyToken verifies the token and checks that it was not revoked.
 func (v *StoreBasedUserVerifier) VerifyToken(ctx context.Context, token string, poolID string) (*Claims, error) {
 	verifier, err := v.keyProvider.GetVerifier(poolID)
 	if err != nil {
@@ -73,6 +82,17 @@ func (v *StoreBasedUserVerifier) VerifyToken(ctx context.Context, token string,
 	if err != nil {
 		return nil, err
 	}
+	if err := CheckAccessTokenUse(claims); err != nil {
+		return nil, err
+	}
+
+	revoked, err := v.revocations.Revoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token was revoked")
+	}
 
 	return claims, nil
 }
@@ -98,6 +118,22 @@ func (v *StoreBasedUserVerifier) VerifyAccessKey(ctx context.Context, accessKeyI
 		return nil, errors.New("invalid access key")
 	}
 
+	// the keys of a disabled account, or created before the tokens of the account were revoked, stop working
+	account, err := as.GetAccountByID(ctx, uuid.MustParse(accessKey.AccountID))
+	if err != nil {
+		return nil, err
+	}
+	if account.Disabled {
+		return nil, errors.New("account is disabled")
+	}
+	revoked, err := v.revocations.Revoked(&Claims{Jti: accessKey.ID, AccountID: accessKey.AccountID, IssuedAt: accessKey.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("access key was revoked")
+	}
+
 	memberships, err := as.ListGroupMemberByAccessKey(ctx, accessKeyID)
 	if err != nil {
 		return nil, err